@@ -1,11 +1,15 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/G-Node/gin-repo/git"
 	"github.com/docopt/docopt-go"
@@ -18,15 +22,40 @@ Usage:
   gin-git show-pack <pack>
   gin-git show-delta <pack> <sha1>
   gin-git cat-file <sha1>
-  gin-git rev-parse <ref>
+  gin-git rev-parse <rev>
+  gin-git show-ref [<prefix>]
+  gin-git pack-objects [--window=<n>] [--depth=<n>]
+  gin-git index-pack
+  gin-git fsck
+  gin-git stats [--top=<n>] [--large-file=<n>]
+  gin-git maintenance [--dry-run] [--wait] [--grace=<age>]
+  gin-git commit-graph write
+  gin-git diff [--histogram] [--unified=<n>] <from> <to>
   gin-git graph-common <base> <ref>
+  gin-git merge-base <base> <ref>
+  gin-git merge-tree [--write-tree] <ours> <theirs>
+  gin-git archive [--format=<fmt>] [--prefix=<prefix>] [--annex-content] <rev>
+  gin-git check-attr <rev> <path>...
  
   gin-git -h | --help
   gin-git --version
 
 Options:
-  -h --help     Show this screen.
-  --version     Show version.
+  -h --help          Show this screen.
+  --version          Show version.
+  --window=<n>       Number of objects to try as delta base [default: 10].
+  --depth=<n>        Maximum delta chain length [default: 50].
+  --top=<n>          Number of objects to list [default: 10].
+  --large-file=<n>   Size in bytes of large files [default: 10485760].
+  --dry-run          Only report what would be removed.
+  --wait             Wait for pushes to finish.
+  --grace=<age>      Minimum age of pruned objects [default: 336h].
+  --histogram        Use the histogram diff algorithm.
+  --unified=<n>      Number of context lines [default: 3].
+  --write-tree       Write the merged tree to the repository.
+  --format=<fmt>     Archive format (tar, tar.gz, zip) [default: tar].
+  --prefix=<prefix>  Prepend prefix to each path in the archive.
+  --annex-content    Include the content of annexed files.
 `
 	args, _ := docopt.Parse(usage, nil, true, "gin-git 0.1", false)
 	//fmt.Fprintf(os.Stderr, "%#v\n", args)
@@ -38,7 +67,27 @@ Options:
 	}
 
 	if val, ok := args["rev-parse"].(bool); ok && val {
-		revParse(repo, args["<ref>"].(string))
+		revParse(repo, args["<rev>"].(string))
+	} else if val, ok := args["show-ref"].(bool); ok && val {
+		prefix, _ := args["<prefix>"].(string)
+		showRef(repo, prefix)
+	} else if val, ok := args["pack-objects"].(bool); ok && val {
+		packObjects(repo, args["--window"].(string), args["--depth"].(string))
+	} else if val, ok := args["index-pack"].(bool); ok && val {
+		indexPack(repo)
+	} else if val, ok := args["fsck"].(bool); ok && val {
+		fsck(repo)
+	} else if val, ok := args["stats"].(bool); ok && val {
+		stats(repo, args["--top"].(string), args["--large-file"].(string))
+	} else if val, ok := args["maintenance"].(bool); ok && val {
+		dryRun, _ := args["--dry-run"].(bool)
+		wait, _ := args["--wait"].(bool)
+		maintenance(repo, dryRun, wait, args["--grace"].(string))
+	} else if val, ok := args["commit-graph"].(bool); ok && val {
+		commitGraph(repo)
+	} else if val, ok := args["diff"].(bool); ok && val {
+		histogram, _ := args["--histogram"].(bool)
+		diff(repo, args["<from>"].(string), args["<to>"].(string), histogram, args["--unified"].(string))
 	} else if val, ok := args["show-pack"].(bool); ok && val {
 		showPack(repo, args["<pack>"].(string))
 	} else if val, ok := args["show-delta"].(bool); ok && val {
@@ -47,32 +96,335 @@ Options:
 		catFile(repo, oid)
 	} else if val, ok := args["graph-common"].(bool); ok && val {
 		graphCommon(repo, args["<base>"].(string), args["<ref>"].(string))
+	} else if val, ok := args["merge-base"].(bool); ok && val {
+		mergeBase(repo, args["<base>"].(string), args["<ref>"].(string))
+	} else if val, ok := args["merge-tree"].(bool); ok && val {
+		write, _ := args["--write-tree"].(bool)
+		mergeTree(repo, args["<ours>"].(string), args["<theirs>"].(string), write)
+	} else if val, ok := args["archive"].(bool); ok && val {
+		prefix, _ := args["--prefix"].(string)
+		annex, _ := args["--annex-content"].(bool)
+		archive(repo, args["<rev>"].(string), args["--format"].(string), prefix, annex)
+	} else if val, ok := args["check-attr"].(bool); ok && val {
+		checkAttr(repo, args["<rev>"].(string), args["<path>"].([]string))
 	}
 }
 
-func revParse(repo *git.Repository, refstr string) {
-	ref, err := repo.OpenRef(refstr)
+func revParse(repo *git.Repository, spec string) {
+	rev, err := repo.ParseRevision(spec)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%v", err)
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		if amb, ok := err.(*git.AmbiguousObjectError); ok {
+			for _, c := range amb.Candidates {
+				fmt.Fprintf(os.Stderr, "  %s\n", c)
+			}
+		}
 		os.Exit(3)
 	}
 
-	id, err := ref.Resolve()
-	var idstr string
+	fmt.Printf("%s\n", spec)
+	if ref, err := repo.OpenRef(spec); err == nil {
+		fmt.Printf(" ├─ name: %s\n", ref.Name())
+		fmt.Printf(" ├─ full: %s\n", git.RefPath(ref))
+	}
+
+	if rev.IsRange {
+		fmt.Printf(" ├─ from: %s\n", rev.Exclude)
+	}
+	fmt.Printf(" └─ id: %s\n", rev.ID)
+}
+
+func showRef(repo *git.Repository, prefix string) {
+	if prefix == "" {
+		prefix = "refs/"
+	}
+
+	refs, err := repo.ListRefs(prefix)
 	if err != nil {
-		idstr = fmt.Sprintf("ERROR: %v", err)
-	} else {
-		idstr = fmt.Sprintf("%s", id)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	for _, ref := range refs {
+		id, err := ref.Resolve()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: ERROR: %v\n", git.RefPath(ref), err)
+			continue
+		}
+
+		fmt.Printf("%s %s\n", id, git.RefPath(ref))
+
+		if idref, ok := ref.(*git.IDRef); ok {
+			if peeled, ok := idref.Peeled(); ok {
+				fmt.Printf("%s %s^{}\n", peeled, git.RefPath(ref))
+			}
+		}
+	}
+}
+
+//packObjects reads object ids from stdin, one per line,
+//and writes them into a new pack in the repository.
+func packObjects(repo *git.Repository, window, depth string) {
+	cfg := git.DefaultPackConfig
+
+	var err error
+	if cfg.Window, err = strconv.Atoi(window); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid window: %v\n", err)
+		os.Exit(3)
+	}
+
+	if cfg.Depth, err = strconv.Atoi(depth); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid depth: %v\n", err)
+		os.Exit(3)
 	}
 
-	fmt.Printf("%s\n", refstr)
-	fmt.Printf(" └┬─ name: %s\n", ref.Name())
-	fmt.Printf("  ├─ full: %s\n", ref.Fullname())
-	fmt.Printf("  └─ SHA1: %s\n", idstr)
+	var ids []git.ObjectID
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		//allow "git rev-list --objects" output
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		id, err := git.ParseObjectID(fields[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid object id: %v\n", err)
+			os.Exit(3)
+		}
+		ids = append(ids, id)
+	}
+
+	if err = scanner.Err(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	packsum, err := repo.CreatePack(ids, cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%s\n", packsum)
+}
+
+//indexPack reads a pack from stdin and stores it, with
+//its index, in the repository.
+func indexPack(repo *git.Repository) {
+	cfg := git.IndexPackConfig{Progress: func(p git.IndexPackProgress) {
+		if p.Objects < p.Total {
+			fmt.Fprintf(os.Stderr, "\rReceiving objects: %d/%d, %d bytes", p.Objects, p.Total, p.Bytes)
+		} else if p.Objects == p.Total && p.Resolved == 0 {
+			fmt.Fprintf(os.Stderr, "\rReceiving objects: %d/%d, %d bytes, done.\n", p.Objects, p.Total, p.Bytes)
+		} else {
+			fmt.Fprintf(os.Stderr, "\rResolving deltas: %d/%d", p.Resolved, p.Deltas)
+		}
+	}}
+
+	packsum, err := repo.IndexPack(os.Stdin, cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "\n%v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Fprintln(os.Stderr)
+	fmt.Printf("pack\t%s\n", packsum)
+}
+
+func fsck(repo *git.Repository) {
+	report, err := repo.Fsck()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	for _, p := range report.Problems {
+		fmt.Printf("error: %s\n", p)
+	}
+
+	fmt.Printf("%d packs, %d objects checked, %d reachable, %d unreachable\n",
+		report.Packs, report.Objects, report.Reachable, report.Unreachable)
+
+	if !report.OK() {
+		fmt.Printf("%d problems found\n", len(report.Problems))
+		os.Exit(1)
+	}
+}
+
+//stats prints how much space the objects take up and
+//which are the largest.
+func stats(repo *git.Repository, top, largeFile string) {
+	cfg := git.DefaultSizeConfig
+
+	var err error
+	if cfg.Top, err = strconv.Atoi(top); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid top: %v\n", err)
+		os.Exit(3)
+	}
+
+	if cfg.LargeFile, err = strconv.ParseInt(largeFile, 10, 64); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid large file size: %v\n", err)
+		os.Exit(3)
+	}
+
+	report, err := repo.SizeReport(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	fmt.Printf("%-8s %10s %12s %12s\n", "type", "count", "size", "disk")
+	for _, c := range []struct {
+		name  string
+		count git.ObjectCount
+	}{
+		{"commits", report.Commits},
+		{"trees", report.Trees},
+		{"blobs", report.Blobs},
+		{"tags", report.Tags},
+		{"total", report.Total()},
+	} {
+		fmt.Printf("%-8s %10d %12s %12s\n", c.name, c.count.Count, byteSize(c.count.Size), byteSize(c.count.DiskSize))
+	}
+
+	if report.Duplicates > 0 {
+		fmt.Printf("%d duplicate objects\n", report.Duplicates)
+	}
+
+	fmt.Printf("\n%d files larger than %s (%s) in git, not in the annex\n",
+		report.LargeFiles.Count, byteSize(cfg.LargeFile), byteSize(report.LargeFiles.Size))
+
+	fmt.Printf("\nLargest blobs:\n")
+	for _, b := range report.LargestBlobs {
+		fmt.Printf("  %s %10s %10s %s\n", b.ID, byteSize(b.Size), byteSize(b.DiskSize), strings.Join(b.Paths, ", "))
+	}
+
+	fmt.Printf("\nDeepest trees:\n")
+	for _, t := range report.DeepestTrees {
+		fmt.Printf("  %s %3d %s\n", t.ID, t.Depth, t.Path)
+	}
+
+	fmt.Printf("\nLongest delta chains:\n")
+	for _, d := range report.LongestDeltaChains {
+		fmt.Printf("  %s %4d %s\n", d.ID, d.Depth, d.Type)
+	}
+}
+
+//byteSize formats n with a binary unit.
+func byteSize(n int64) string {
+	const units = "KMGTPE"
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	v, i := float64(n)/1024, 0
+	for ; v >= 1024 && i < len(units)-1; i++ {
+		v /= 1024
+	}
+	return fmt.Sprintf("%.1f %ciB", v, units[i])
+}
+
+//maintenance packs all objects and refs and prunes
+//unreachable objects.
+func maintenance(repo *git.Repository, dryRun, wait bool, grace string) {
+	opts := git.DefaultMaintenanceOptions
+	opts.DryRun = dryRun
+	opts.Wait = wait
+
+	var err error
+	if opts.GracePeriod, err = time.ParseDuration(grace); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid grace period: %v\n", err)
+		os.Exit(3)
+	}
+
+	report, err := repo.Maintain(opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	verb := "removed"
+	if dryRun {
+		verb = "would be removed"
+	}
+
+	for _, info := range report.Pruned {
+		where := info.Pack
+		if where == "" {
+			where = "loose"
+		}
+		fmt.Printf("%s %-6s %10d %s %s\n", info.ID, info.Type, info.Size, info.ModTime.Format(time.RFC3339), where)
+	}
+
+	fmt.Printf("%d objects, %d reachable, %d unreachable kept, %d %s\n",
+		report.Objects, report.Reachable, report.Kept, len(report.Pruned), verb)
+	fmt.Printf("%d packs and %d loose objects %s, %d refs packed\n",
+		len(report.RemovedPacks), report.RemovedLoose, verb, report.PackedRefs)
+
+	if !report.Pack.IsZero() {
+		fmt.Printf("pack\t%s\n", report.Pack)
+	}
+}
+
+func commitGraph(repo *git.Repository) {
+	err := repo.WriteCommitGraph()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+}
+
+func diff(repo *git.Repository, from, to string, histogram bool, context string) {
+	opts := git.DefaultDiffOptions
+	if histogram {
+		opts.Algorithm = git.DiffHistogram
+	}
+
+	n, err := strconv.Atoi(context)
+	if err != nil || n < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid number of context lines: %q\n", context)
+		os.Exit(2)
+	}
+	opts.Context = n
+
+	var trees [2]*git.Tree
+	for i, rev := range []string{from, to} {
+		id, err := repo.ResolveRevision(rev + "^{tree}")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(3)
+		}
+
+		obj, err := repo.OpenObject(id)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		trees[i] = obj.(*git.Tree)
+
+		if opts.Attributes, err = repo.NewAttrReader(id); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	diffs, err := repo.DiffFiles(trees[0], trees[1], opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	for _, fd := range diffs {
+		if err = fd.WriteUnified(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
 }
 
 func catFile(repo *git.Repository, idstr string) {
-	id, err := git.ParseSHA1(idstr)
+	id, err := git.ParseObjectID(idstr)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Invalid object id: %v", err)
 		os.Exit(3)
@@ -90,7 +442,7 @@ func catFile(repo *git.Repository, idstr string) {
 }
 
 func showDelta(repo *git.Repository, packid string, idstr string) {
-	oid, err := git.ParseSHA1(idstr)
+	oid, err := git.ParseObjectID(idstr)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Invalid object id: %v", err)
 		os.Exit(3)
@@ -113,6 +465,7 @@ func showDelta(repo *git.Repository, packid string, idstr string) {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
+	defer obj.Close()
 
 	delta, ok := obj.(*git.Delta)
 	if !ok {
@@ -214,16 +567,16 @@ func showPack(repo *git.Repository, packid string) {
 		os.Exit(1)
 	}
 
-	for i := byte(0); i < 255; i++ {
+	for i := 0; i < 256; i++ {
 		lead, prefix := "├─", "│"
-		if i == 254 {
+		if i == 255 {
 			lead, prefix = "└─", " "
 		}
 		fmt.Printf("%s[%02x]\n", lead, i)
 
-		var oid git.SHA1
+		var oid git.ObjectID
 
-		s, e := idx.FO.Bounds(i)
+		s, e := idx.FO.Bounds(byte(i))
 		for k := s; k < e; k++ {
 			lead := "├─"
 			pf := prefix + " │"
@@ -233,7 +586,7 @@ func showPack(repo *git.Repository, packid string) {
 			}
 
 			fmt.Printf("%s %s", prefix, lead)
-			err := idx.ReadSHA1(&oid, k)
+			err := idx.ReadObjectID(&oid, k)
 			if err != nil {
 				fmt.Printf(" ERROR: %v\n", err)
 				continue
@@ -287,15 +640,15 @@ func showPack(repo *git.Repository, packid string) {
 }
 
 func graphCommon(repo *git.Repository, basestr, refstr string) {
-	baseid, err := git.ParseSHA1(basestr)
+	baseid, err := repo.ResolveRevision(basestr)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Invalid object id: %v", err)
+		fmt.Fprintf(os.Stderr, "Invalid revision: %v", err)
 		os.Exit(1)
 	}
 
-	refid, err := git.ParseSHA1(refstr)
+	refid, err := repo.ResolveRevision(refstr)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Invalid object id: %v", err)
+		fmt.Fprintf(os.Stderr, "Invalid revision: %v", err)
 		os.Exit(1)
 	}
 
@@ -323,15 +676,162 @@ func graphCommon(repo *git.Repository, basestr, refstr string) {
 
 	fmt.Printf("digraph g1 {\n")
 
-	cg.VisitCommits(func(node *git.CommitNode) bool {
+	err = cg.VisitCommits(func(node *git.CommitNode) bool {
+		//only the painted part of the graph is of interest
+		if node.Flags&git.NodeColorWhite == 0 {
+			return false
+		}
+
 		fmt.Printf("%q [label=\"%.7[1]s (%d)\"];\n",
 			node.ID, node.Flags&git.NodeColorWhite)
 
 		for _, parent := range node.Parents() {
-			fmt.Printf("%q -> %q;\n", node.ID, parent.ID)
+			if parent.Flags&git.NodeColorWhite != 0 {
+				fmt.Printf("%q -> %q;\n", node.ID, parent.ID)
+			}
 		}
 		return false
 	})
 
 	fmt.Printf("}\n")
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error walking graph: %v", err)
+		os.Exit(10)
+	}
+}
+
+func mergeBase(repo *git.Repository, basestr, refstr string) {
+	baseid, err := repo.ResolveRevision(basestr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid revision: %v\n", err)
+		os.Exit(1)
+	}
+
+	refid, err := repo.ResolveRevision(refstr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid revision: %v\n", err)
+		os.Exit(1)
+	}
+
+	cg := git.NewCommitGraph(repo)
+	bases, err := cg.MergeBase(baseid, refid)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error building graph: %v\n", err)
+		os.Exit(10)
+	}
+
+	ahead, behind, err := cg.AheadBehind(refid, baseid)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error building graph: %v\n", err)
+		os.Exit(10)
+	}
+
+	for _, node := range bases {
+		fmt.Printf("%s\n", node.ID)
+	}
+	fmt.Printf("%s is %d ahead, %d behind %s\n", refstr, ahead, behind, basestr)
+
+	if len(bases) == 0 {
+		os.Exit(1)
+	}
+}
+
+func mergeTree(repo *git.Repository, oursstr, theirsstr string, write bool) {
+	ours, err := repo.ResolveRevision(oursstr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid revision: %v\n", err)
+		os.Exit(1)
+	}
+
+	theirs, err := repo.ResolveRevision(theirsstr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid revision: %v\n", err)
+		os.Exit(1)
+	}
+
+	opts := git.DefaultMergeOptions
+	opts.OursLabel, opts.TheirsLabel = oursstr, theirsstr
+	res, err := repo.MergeCommits(ours, theirs, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error merging: %v\n", err)
+		os.Exit(10)
+	}
+
+	if write {
+		tree, err := res.Write()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing tree: %v\n", err)
+			os.Exit(10)
+		}
+		fmt.Printf("%s\n", tree)
+	}
+
+	for _, c := range res.Conflicts {
+		fmt.Printf("%s\t%s\n", c.Type, c.Path)
+	}
+
+	if !res.Clean() {
+		os.Exit(1)
+	}
+}
+
+//archive writes the tree of rev as archive to stdout.
+func archive(repo *git.Repository, rev, format, prefix string, annex bool) {
+	f, err := git.ParseArchiveFormat(format)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	id, err := repo.ResolveRevision(rev)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid revision: %v\n", err)
+		os.Exit(3)
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	opts := git.ArchiveOptions{Format: f, Prefix: prefix, AnnexContent: annex}
+	if err = repo.WriteArchive(out, id, opts); err == nil {
+		err = out.Flush()
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+//checkAttr prints the attributes of the paths in the tree of rev,
+//like git check-attr -a.
+func checkAttr(repo *git.Repository, rev string, paths []string) {
+	id, err := repo.ResolveRevision(rev)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid revision: %v\n", err)
+		os.Exit(3)
+	}
+
+	r, err := repo.NewAttrReader(id)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	for _, p := range paths {
+		attrs, err := r.Lookup(p)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		names := make([]string, 0, len(attrs))
+		for name := range attrs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			fmt.Printf("%s: %s: %s\n", p, name, attrs[name])
+		}
+	}
 }
@@ -51,6 +51,7 @@ func (s *Server) repoAccess(w http.ResponseWriter, r *http.Request) {
 
 		return
 	}
+	defer s.repos.ReleaseGitRepo(repo)
 
 	access := wire.RepoAccessInfo{Path: repo.Path, Push: true}
 
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/G-Node/gin-repo/auth"
+	"github.com/G-Node/gin-repo/git"
+	"github.com/G-Node/gin-repo/ssh"
+	"github.com/G-Node/gin-repo/store"
+	"github.com/G-Node/gin-repo/wire"
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/openpgp"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+//checkUser checks that the request is authenticated as the user
+//uid and writes the status code otherwise.
+func (s *Server) checkUser(w http.ResponseWriter, r *http.Request, uid string) bool {
+	user, err := s.users.UserForRequest(r)
+
+	if err == auth.ErrNoAuth || (err == nil && user == nil) {
+		http.Error(w, "Authentication missing", http.StatusUnauthorized)
+		return false
+	} else if err != nil {
+		http.Error(w, "Authorization error!", http.StatusForbidden)
+		s.log(DEBUG, "Auth error: %v", err)
+		return false
+	} else if user.Uid != uid {
+		http.Error(w, "No access", http.StatusForbidden)
+		return false
+	}
+
+	return true
+}
+
+//sshKeyOwner returns the user of the user store that has the key.
+func (s *Server) sshKeyOwner(pub gossh.PublicKey) (git.KeyOwner, bool) {
+	key := ssh.Key{Type: pub.Type(), Keydata: pub.Marshal()}
+	fingerprint, err := key.Fingerprint()
+	if err != nil {
+		return git.KeyOwner{}, false
+	}
+
+	user, err := s.users.LookupUserBySSH(fingerprint)
+	if err != nil || user == nil {
+		return git.KeyOwner{}, false
+	}
+
+	for _, k := range user.Keys {
+		if bytes.Equal(k.Keydata, key.Keydata) {
+			return git.KeyOwner{User: user.Uid, Email: user.Email}, true
+		}
+	}
+
+	return git.KeyOwner{}, false
+}
+
+//signingKeys returns the keys signatures are verified with: the
+//uploaded OpenPGP keys and the SSH keys of the user store.
+func (s *Server) signingKeys() (*git.SigningKeys, error) {
+	keyring, owners, err := s.gpgkeys.Keyring()
+	if err != nil {
+		return nil, err
+	}
+
+	return &git.SigningKeys{
+		OpenPGP:       keyring,
+		OpenPGPOwners: owners,
+		SSHKeyOwner:   s.sshKeyOwner,
+	}, nil
+}
+
+//verifySignature verifies the signature of the commit or tag id, it
+//returns nil for objects that are not signed.
+func (s *Server) verifySignature(repo *git.Repository, id git.ObjectID, keys *git.SigningKeys) *wire.Verification {
+	v, err := repo.VerifySignature(id, keys)
+	if err != nil {
+		s.log(WARN, "could not verify signature of %s: %v", id, err)
+		return nil
+	} else if v.Status == git.SignatureNone {
+		return nil
+	}
+
+	return &wire.Verification{
+		Status: v.Status.String(),
+		Format: v.Format,
+		Key:    v.Key,
+		Signer: v.Signer,
+	}
+}
+
+func gpgKeysToWire(keys openpgp.EntityList) []wire.GPGKey {
+	res := make([]wire.GPGKey, len(keys))
+	for i, key := range keys {
+		res[i] = wire.GPGKey{
+			Fingerprint: store.KeyFingerprint(key),
+			KeyID:       key.PrimaryKey.KeyIdString(),
+			Identities:  []string{},
+			Created:     key.PrimaryKey.CreationTime.Format(time.RFC3339),
+		}
+
+		for name := range key.Identities {
+			res[i].Identities = append(res[i].Identities, name)
+		}
+		sort.Strings(res[i].Identities)
+	}
+
+	return res
+}
+
+func (s *Server) writeGPGKeys(w http.ResponseWriter, status int, keys openpgp.EntityList) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(gpgKeysToWire(keys))
+	if err != nil {
+		s.log(WARN, "error after status ok sent [%v]", err)
+	}
+}
+
+//listGPGKeys lists the OpenPGP public keys of a user, which are
+//public.
+func (s *Server) listGPGKeys(w http.ResponseWriter, r *http.Request) {
+	uid := mux.Vars(r)["user"]
+
+	keys, err := s.gpgkeys.ListKeys(uid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.writeGPGKeys(w, http.StatusOK, keys)
+}
+
+//gpgKeyChallenge returns the text a user has to sign with a key to
+//add it, see addGPGKey.
+func (s *Server) gpgKeyChallenge(w http.ResponseWriter, r *http.Request) {
+	uid := mux.Vars(r)["user"]
+	if !s.checkUser(w, r, uid) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(store.KeyChallenge(uid)))
+}
+
+//addGPGKey adds an armored OpenPGP public key to the keys of the
+//authenticated user, if it comes with a signature of the challenge
+//of the user made with the key.
+func (s *Server) addGPGKey(w http.ResponseWriter, r *http.Request) {
+	uid := mux.Vars(r)["user"]
+	if !s.checkUser(w, r, uid) {
+		return
+	}
+
+	var upload wire.GPGKeyUpload
+	if err := json.NewDecoder(r.Body).Decode(&upload); err != nil {
+		http.Error(w, "invalid key upload", http.StatusBadRequest)
+		return
+	}
+
+	key, err := s.gpgkeys.AddKey(uid, strings.NewReader(upload.Key), strings.NewReader(upload.Signature))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.writeGPGKeys(w, http.StatusCreated, openpgp.EntityList{key})
+}
+
+//deleteGPGKey removes an OpenPGP key of the authenticated user.
+func (s *Server) deleteGPGKey(w http.ResponseWriter, r *http.Request) {
+	ivars := mux.Vars(r)
+	uid := ivars["user"]
+	if !s.checkUser(w, r, uid) {
+		return
+	}
+
+	err := s.gpgkeys.DeleteKey(uid, ivars["fingerprint"])
+	if os.IsNotExist(err) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
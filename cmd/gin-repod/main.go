@@ -24,8 +24,9 @@ type Server struct {
 
 	srvKey []byte
 
-	users store.UserStore
-	repos *store.RepoStore
+	users   store.UserStore
+	repos   *store.RepoStore
+	gpgkeys *store.GPGKeyStore
 }
 
 type LogLevel int
@@ -181,6 +182,13 @@ func (s *Server) SetupStores() {
 		os.Exit(12)
 	}
 
+	s.gpgkeys, err = store.NewGPGKeyStore(dir)
+
+	if err != nil {
+		s.log(PANIC, "Could not setup gpg key store: %v", err)
+		os.Exit(14)
+	}
+
 	repos, err := s.repos.ListRepos()
 	if err != nil {
 		s.log(PANIC, "Could not read repo store: %v", err)
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
@@ -11,6 +12,7 @@ import (
 
 	"github.com/G-Node/gin-repo/internal/testbed"
 	"github.com/G-Node/gin-repo/store"
+	"github.com/G-Node/gin-repo/wire"
 )
 
 var server *Server
@@ -112,12 +114,64 @@ func TestBranchAccess(t *testing.T) {
 	}
 }
 
+func TestBranchList(t *testing.T) {
+	req := NewGet(t, "/users/alice/repos/exrepo/branches", "")
+	_, err := makeRequest(req, http.StatusNotFound)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req = NewGet(t, "/users/alice/repos/exrepo/branches", "alice")
+	rr, err := makeRequest(req, http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var branches []wire.Branch
+	err = json.NewDecoder(rr.Body).Decode(&branches)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := false
+	for _, b := range branches {
+		found = found || b.Name == "master"
+	}
+
+	if !found {
+		t.Fatalf("branch master not in branch list: %v", branches)
+	}
+
+	req = NewGet(t, "/users/alice/repos/exrepo/branches/master?base=master", "alice")
+	rr, err = makeRequest(req, http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var branch wire.Branch
+	err = json.NewDecoder(rr.Body).Decode(&branch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if base := branch.Base; base == nil || base.Commit != branch.Commit || base.Ahead != 0 || base.Behind != 0 {
+		t.Fatalf("expected master to be even with itself, got %+v", branch)
+	}
+
+	req = NewGet(t, "/users/alice/repos/exrepo/branches?base=iDoNotExist", "alice")
+	_, err = makeRequest(req, http.StatusNotFound)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestObjectAccess(t *testing.T) {
 	//first find the commit id
 	repo, err := server.repos.OpenGitRepo(store.RepoId{Name: "exrepo", Owner: "alice"})
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer server.repos.ReleaseGitRepo(repo)
 
 	ref, err := repo.OpenRef("master")
 	if err != nil {
@@ -142,4 +196,20 @@ func TestObjectAccess(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+
+	//abbreviated ids and other revisions
+	for _, rev := range []string{id.String()[:7], "master", "master^{tree}"} {
+		url = fmt.Sprintf("/users/alice/repos/exrepo/objects/%s", rev)
+		req = NewGet(t, url, "alice")
+		_, err = makeRequest(req, http.StatusOK)
+		if err != nil {
+			t.Fatalf("%s: %v", rev, err)
+		}
+	}
+
+	req = NewGet(t, "/users/alice/repos/exrepo/objects/master...", "alice")
+	_, err = makeRequest(req, http.StatusBadRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
 }
@@ -8,9 +8,16 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
+	"mime"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/G-Node/gin-repo/git"
 	"github.com/G-Node/gin-repo/store"
@@ -49,6 +56,15 @@ func (s *Server) createRepo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	format := git.FormatSHA1
+	if creat.ObjectFormat != "" {
+		format, err = git.ParseObjectFormat(creat.ObjectFormat)
+		if err != nil {
+			http.Error(w, "Invalid object format", http.StatusBadRequest)
+			return
+		}
+	}
+
 	vars := mux.Vars(r)
 	owner := vars["user"]
 
@@ -72,7 +88,7 @@ func (s *Server) createRepo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	repo, err := s.repos.CreateRepo(rid)
+	repo, err := s.repos.CreateRepo(rid, format)
 	if err != nil {
 		if os.IsExist(err) {
 			w.WriteHeader(http.StatusConflict)
@@ -96,7 +112,12 @@ func (s *Server) createRepo(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintf(os.Stderr, "Error setting repository visibility: %v", err)
 	}
 
-	wr := wire.Repo{Name: creat.Name, Description: repo.ReadDescription(), Public: creat.Public}
+	wr := wire.Repo{
+		Name:         creat.Name,
+		Description:  repo.ReadDescription(),
+		Public:       creat.Public,
+		ObjectFormat: repo.ObjectFormat().String(),
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -117,12 +138,13 @@ func (s *Server) repoToWire(id store.RepoId, repo *git.Repository) (wire.Repo, e
 	shared := s.repos.RepoShared(id)
 
 	wr := wire.Repo{
-		Name:        id.Name,
-		Owner:       id.Owner,
-		Description: repo.ReadDescription(),
-		Head:        "master",
-		Public:      public,
-		Shared:      shared,
+		Name:         id.Name,
+		Owner:        id.Owner,
+		Description:  repo.ReadDescription(),
+		Head:         "master",
+		Public:       public,
+		Shared:       shared,
+		ObjectFormat: repo.ObjectFormat().String(),
 	}
 
 	return wr, nil
@@ -175,6 +197,7 @@ func (s *Server) listRepos(w http.ResponseWriter, r *http.Request) {
 		}
 
 		wr, err := s.repoToWire(p, repo)
+		s.repos.ReleaseGitRepo(repo)
 
 		if err != nil {
 			s.log(WARN, "repo serialization error for %q [%v]", p, err)
@@ -227,6 +250,7 @@ func (s *Server) listSharedRepos(w http.ResponseWriter, r *http.Request) {
 		}
 
 		wr, err := s.repoToWire(p, repo)
+		s.repos.ReleaseGitRepo(repo)
 
 		if err != nil {
 			s.log(WARN, "repo serialization error for %q [%v]", p, err)
@@ -273,6 +297,7 @@ func (s *Server) listPublicRepos(w http.ResponseWriter, r *http.Request) {
 		}
 
 		wr, err := s.repoToWire(p, repo)
+		s.repos.ReleaseGitRepo(repo)
 
 		if err != nil {
 			s.log(WARN, "repo serialization error for %q [%v]", p, err)
@@ -419,22 +444,187 @@ func (s *Server) getBranch(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
+	defer s.repos.ReleaseGitRepo(repo)
+
+	id, ok := s.resolveRevision(w, repo, ibranch)
+	if !ok {
+		return
+	}
+
+	base, ok := s.branchBase(w, r, repo)
+	if !ok {
+		return
+	}
+
+	name := ibranch
+	if ref, err := repo.OpenRef(ibranch); err == nil {
+		name = ref.Name()
+	}
+
+	branch := wire.Branch{Name: name, Commit: id.String()}
+	if base != nil {
+		branch.Base, err = compareBranch(git.NewCommitGraph(repo), id, *base)
+		if err != nil {
+			s.log(WARN, "could not compare branch %q: %v", name, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	js := json.NewEncoder(w)
+	err = js.Encode(branch)
+
+	if err != nil {
+		s.log(WARN, "Error while encoding, status already sent. oh oh.")
+	}
+}
+
+//branchBase resolves the commit of the "base" query parameter, if
+//present, and writes the appropriate status code if that fails.
+func (s *Server) branchBase(w http.ResponseWriter, r *http.Request, repo *git.Repository) (*git.ObjectID, bool) {
+	rev := r.URL.Query().Get("base")
+	if rev == "" {
+		return nil, true
+	}
+
+	id, ok := s.resolveRevision(w, repo, rev+"^{commit}")
+	return &id, ok
+}
+
+//compareBranch compares the branch commit id to the base commit.
+func compareBranch(cg *git.CommitGraph, id, base git.ObjectID) (*wire.BranchBase, error) {
+	ahead, behind, err := cg.AheadBehind(id, base)
+	if err != nil {
+		return nil, err
+	}
+
+	return &wire.BranchBase{Commit: base.String(), Ahead: ahead, Behind: behind}, nil
+}
+
+func (s *Server) listBranches(w http.ResponseWriter, r *http.Request) {
+	ivars := mux.Vars(r)
+
+	rid, err := s.varsToRepoID(ivars)
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	_, ok := s.checkAccess(w, r, rid, store.PullAccess)
+	if !ok {
+		return
+	}
+
+	repo, err := s.repos.OpenGitRepo(rid)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	defer s.repos.ReleaseGitRepo(repo)
+
+	base, ok := s.branchBase(w, r, repo)
+	if !ok {
+		return
+	}
 
-	ref, err := repo.OpenRef(ibranch)
+	refs, err := repo.ListRefs("refs/heads/")
+	if err != nil {
+		s.log(WARN, "error listing branches: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// one graph for all branches, they share most of their history
+	cg := git.NewCommitGraph(repo)
+
+	branches := []wire.Branch{}
+	for _, ref := range refs {
+		id, err := ref.Resolve()
+		if err != nil {
+			s.log(WARN, "could not resolve branch %q: %v", ref.Name(), err)
+			continue
+		}
+
+		branch := wire.Branch{Name: ref.Name(), Commit: id.String()}
+		if base != nil {
+			branch.Base, err = compareBranch(cg, id, *base)
+			if err != nil {
+				s.log(WARN, "could not compare branch %q: %v", ref.Name(), err)
+			}
+		}
+
+		branches = append(branches, branch)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	js := json.NewEncoder(w)
+	err = js.Encode(branches)
+
+	if err != nil {
+		s.log(WARN, "Error while encoding, status already sent. oh oh.")
+	}
+}
+
+func (s *Server) listTags(w http.ResponseWriter, r *http.Request) {
+	ivars := mux.Vars(r)
+
+	rid, err := s.varsToRepoID(ivars)
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	_, ok := s.checkAccess(w, r, rid, store.PullAccess)
+	if !ok {
+		return
+	}
 
+	repo, err := s.repos.OpenGitRepo(rid)
 	if err != nil {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
+	defer s.repos.ReleaseGitRepo(repo)
+
+	refs, err := repo.ListRefs("refs/tags/")
+	if err != nil {
+		s.log(WARN, "error listing tags: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 
-	id, err := ref.Resolve()
+	keys, err := s.signingKeys()
 	if err != nil {
-		panic("Could not resolve ref")
+		s.log(WARN, "could not read signing keys: %v", err)
+	}
+
+	tags := []wire.Tag{}
+	for _, ref := range refs {
+		id, err := ref.Resolve()
+		if err != nil {
+			s.log(WARN, "could not resolve tag %q: %v", ref.Name(), err)
+			continue
+		}
+
+		peeled, err := repo.PeelRef(ref)
+		if err != nil {
+			s.log(WARN, "could not peel tag %q: %v", ref.Name(), err)
+			continue
+		}
+
+		tag := wire.Tag{Name: ref.Name(), Object: id.String(), Commit: peeled.String()}
+		if id != peeled {
+			tag.Signature = s.verifySignature(repo, id, keys)
+		}
+
+		tags = append(tags, tag)
 	}
 
-	branch := wire.Branch{Name: ref.Name(), Commit: id.String()}
+	w.Header().Set("Content-Type", "application/json")
 	js := json.NewEncoder(w)
-	err = js.Encode(branch)
+	err = js.Encode(tags)
 
 	if err != nil {
 		s.log(WARN, "Error while encoding, status already sent. oh oh.")
@@ -462,10 +652,10 @@ func (s *Server) getObject(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
+	defer s.repos.ReleaseGitRepo(repo)
 
-	oid, err := git.ParseSHA1(isha1)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+	oid, ok := s.resolveRevision(w, repo, isha1)
+	if !ok {
 		return
 	}
 
@@ -475,10 +665,109 @@ func (s *Server) getObject(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	s.objectToWire(w, repo, obj)
+	s.objectToWire(w, repo, obj, nil)
+}
+
+//revisionErrorStatus maps errors from parsing revisions to
+//status codes: BadRequest for malformed or ambiguous revisions,
+//NotFound otherwise.
+func revisionErrorStatus(err error) int {
+	switch err.(type) {
+	case *git.RevisionSyntaxError, *git.AmbiguousObjectError:
+		return http.StatusBadRequest
+	}
+	return http.StatusNotFound
+}
+
+//resolveRevision resolves the revision expression and writes
+//the appropriate status code if that fails.
+func (s *Server) resolveRevision(w http.ResponseWriter, repo *git.Repository, rev string) (git.ObjectID, bool) {
+	id, err := repo.ResolveRevision(rev)
+	if err != nil {
+		w.WriteHeader(revisionErrorStatus(err))
+		return id, false
+	}
+
+	return id, true
+}
+
+//localHost returns true if host is the host of the request or one
+//of the comma-separated hosts in GIN_REPO_HOSTS, i.e. if URLs with
+//the host point to repositories of this server.
+func localHost(r *http.Request, host string) bool {
+	reqhost := r.Host
+	if h, _, err := net.SplitHostPort(r.Host); err == nil {
+		reqhost = h
+	}
+
+	hosts := append(strings.Split(os.Getenv("GIN_REPO_HOSTS"), ","), reqhost)
+	for _, h := range hosts {
+		if h = strings.TrimSpace(h); h != "" && strings.EqualFold(h, host) {
+			return true
+		}
+	}
+
+	return false
+}
+
+//submoduleToWire converts the submodule of the repository rid. Repo
+//is only set if the submodule URL points to a repository on this
+//server that the user of the request can pull.
+func (s *Server) submoduleToWire(r *http.Request, rid store.RepoId, mod git.Submodule) wire.Submodule {
+	res := wire.Submodule{
+		Name:   mod.Name,
+		Path:   mod.Path,
+		URL:    mod.URL,
+		Branch: mod.Branch,
+		Commit: mod.Commit.String(),
+	}
+
+	sid, host, err := store.RepoIdFromURL(mod.URL, rid)
+	if err != nil || (host != "" && !localHost(r, host)) {
+		return res
+	}
+
+	uid := ""
+	if user, err := s.users.UserForRequest(r); err == nil && user != nil {
+		uid = user.Uid
+	}
+
+	level, err := s.repos.GetAccessLevel(sid, uid)
+	if err != nil || level < store.PullAccess {
+		return res
+	}
+
+	if exists, err := s.repos.RepoExists(sid); err == nil && exists {
+		res.Repo = sid.String()
+	}
+
+	return res
+}
+
+//treeSubmodules returns the submodules in the directory dir of the
+//tree of id, by name.
+func (s *Server) treeSubmodules(r *http.Request, rid store.RepoId, repo *git.Repository, id git.ObjectID, dir string) map[string]wire.Submodule {
+	mods, err := repo.Submodules(id)
+	if err != nil {
+		s.log(WARN, "could not read submodules of %s: %v", id, err)
+		return nil
+	}
+
+	res := make(map[string]wire.Submodule)
+	for _, mod := range mods {
+		parent, name := path.Split(mod.Path)
+		if strings.TrimSuffix(parent, "/") == dir {
+			res[name] = s.submoduleToWire(r, rid, mod)
+		}
+	}
+
+	return res
 }
 
-func (s *Server) objectToWire(w http.ResponseWriter, repo *git.Repository, obj git.Object) {
+//objectToWire writes the object. Gitlink entries of trees are
+//reported as submodules, with the details in submods, by name,
+//if there are any.
+func (s *Server) objectToWire(w http.ResponseWriter, repo *git.Repository, obj git.Object, submods map[string]wire.Submodule) {
 	out := bufio.NewWriter(w)
 	switch obj := obj.(type) {
 	case *git.Commit:
@@ -535,7 +824,20 @@ func (s *Server) objectToWire(w http.ResponseWriter, repo *git.Repository, obj g
 				}
 			}
 
-			if !symlink {
+			gitlink := entry.Mode == 0160000
+			if gitlink {
+				out.WriteString(fmt.Sprintf("%q: %q,\n", "type", "submodule"))
+				if mod, ok := submods[entry.Name]; ok {
+					if mod.URL != "" {
+						out.WriteString(fmt.Sprintf("%q: %q,\n", "url", mod.URL))
+					}
+					if mod.Repo != "" {
+						out.WriteString(fmt.Sprintf("%q: %q,\n", "repo", mod.Repo))
+					}
+				}
+			}
+
+			if !symlink && !gitlink {
 				out.WriteString(fmt.Sprintf("%q: %q,\n", "type", entry.Type))
 			}
 			out.WriteString(fmt.Sprintf("%q: %q,\n", "id", entry.ID))
@@ -584,8 +886,7 @@ func (s *Server) browseRepo(w http.ResponseWriter, r *http.Request) {
 	ibranch := ivars["branch"]
 	ipath := ivars["path"]
 
-	//for now we only support master :(
-	if err != nil || ibranch != "master" {
+	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
@@ -602,16 +903,10 @@ func (s *Server) browseRepo(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
+	defer s.repos.ReleaseGitRepo(repo)
 
-	ref, err := repo.OpenRef(ibranch)
-	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		return
-	}
-
-	id, err := ref.Resolve()
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+	id, ok := s.resolveRevision(w, repo, ibranch)
+	if !ok {
 		return
 	}
 
@@ -622,7 +917,23 @@ func (s *Server) browseRepo(w http.ResponseWriter, r *http.Request) {
 	}
 
 	obj, err := repo.ObjectForPath(root, ipath)
-	if err != nil {
+	if serr, ok := err.(*git.SubmoduleError); ok {
+		mod, err := repo.SubmoduleForPath(id, serr.Path)
+		if err != nil {
+			s.log(WARN, "could not read submodule %s: %v", serr.Path, err)
+			mod = git.Submodule{Path: serr.Path, Commit: serr.Commit}
+		}
+
+		data, err := json.Marshal(s.submoduleToWire(r, rid, mod))
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+		return
+	} else if err != nil {
 		if os.IsNotExist(err) {
 			w.WriteHeader(http.StatusNotFound)
 		} else {
@@ -631,94 +942,266 @@ func (s *Server) browseRepo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	s.objectToWire(w, repo, obj)
+	var submods map[string]wire.Submodule
+	if _, ok := obj.(*git.Tree); ok {
+		dir := strings.Trim(path.Clean("/"+ipath), "/")
+		submods = s.treeSubmodules(r, rid, repo, id, dir)
+	}
+
+	s.objectToWire(w, repo, obj, submods)
 }
 
-// patchRepoSettings patches repository description and public status.
-// The request header has to contain an authorization header with a valid token.
-// The request body has to contain valid JSON containing "description" as key
-// and a string as value, "public" as key and a boolean value as value or both.
-// A request that does not contain any of these two keys will result in a BadRequest status.
-func (s *Server) patchRepoSettings(w http.ResponseWriter, r *http.Request) {
+//archiveContentTypes maps archive formats to their content type.
+var archiveContentTypes = map[git.ArchiveFormat]string{
+	git.ArchiveTar:   "application/x-tar",
+	git.ArchiveTarGz: "application/gzip",
+	git.ArchiveZip:   "application/zip",
+}
+
+//getArchive streams the tree of a revision as tar, tar.gz or zip
+//archive, with all files in a directory named after the repository.
+//If the query has "annex=1", annexed files are replaced with their
+//content, where it is present.
+func (s *Server) getArchive(w http.ResponseWriter, r *http.Request) {
 	ivars := mux.Vars(r)
-	rid, err := s.varsToRepoID(ivars)
 
+	rid, err := s.varsToRepoID(ivars)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	_, ok := s.checkAccess(w, r, rid, store.AdminAccess)
-	if !ok {
+	opts := git.ArchiveOptions{Prefix: rid.Name + "/"}
+	if opts.Format, err = git.ParseArchiveFormat(ivars["format"]); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Pointer fields are used to make sure, that boolean values are
-	// not updated, when the corresponding field was not
-	// included in the submitted JSON.
-	var patch struct {
-		Description *string `json:"description,omitempty"`
-		Public      *bool   `json:"public,omitempty"`
+	if opts.AnnexContent, err = queryBool(r.URL.Query(), "annex"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	if r.Body == nil {
-		w.WriteHeader(http.StatusBadRequest)
+	_, ok := s.checkAccess(w, r, rid, store.PullAccess)
+	if !ok {
 		return
 	}
 
-	b, err := ioutil.ReadAll(r.Body)
+	repo, err := s.repos.OpenGitRepo(rid)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		w.WriteHeader(http.StatusNotFound)
 		return
 	}
+	defer s.repos.ReleaseGitRepo(repo)
 
-	err = json.Unmarshal(b, &patch)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+	id, ok := s.resolveRevision(w, repo, ivars["branch"])
+	if !ok {
 		return
 	}
 
-	// TODO The following code currently does not deal with the problem
-	// if an error occurs, after part of the patch has already
-	// been applied.
-	responseCode := http.StatusOK
+	filename := fmt.Sprintf("%s-%s.%s", rid.Name, ivars["branch"], opts.Format)
+	w.Header().Set("Content-Type", archiveContentTypes[opts.Format])
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	w.WriteHeader(http.StatusOK)
 
-	// The following code deviates from normal style of
-	// return on error, since we always want to return
-	// the latest state if the repository settings,
-	// even if an error occurs.
-	if patch.Description == nil && patch.Public == nil {
-		responseCode = http.StatusBadRequest
-	}
-	repository, err := git.OpenRepository(s.repos.IdToPath(rid))
+	err = repo.WriteArchive(w, id, opts)
 	if err != nil {
-		responseCode = http.StatusInternalServerError
+		s.log(WARN, "error after status ok sent [%v]", err)
 	}
+}
 
-	if patch.Description != nil {
-		err = repository.WriteDescription(*patch.Description)
-		if err != nil {
-			responseCode = http.StatusInternalServerError
+//statsMaxTop limits the lists of getRepoStats
+const statsMaxTop = 1000
+
+//sizeConfig reads the options of getRepoStats from the query:
+//"top" (number of objects listed) and "large-file" (bytes).
+func sizeConfig(r *http.Request) (git.SizeConfig, error) {
+	cfg := git.DefaultSizeConfig
+	query := r.URL.Query()
+
+	if v := query.Get("top"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > statsMaxTop {
+			return cfg, fmt.Errorf("invalid top %q", v)
 		}
+		cfg.Top = n
 	}
 
-	if patch.Public != nil {
-		err = s.repos.SetRepoVisibility(rid, *patch.Public)
-		if err != nil {
-			responseCode = http.StatusInternalServerError
+	if v := query.Get("large-file"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("invalid large-file %q", v)
 		}
+		cfg.LargeFile = n
 	}
 
-	var resp struct {
-		Public      bool
-		Description string
-	}
+	return cfg, nil
+}
 
-	resp.Description = repository.ReadDescription()
-	resp.Public, err = s.repos.GetRepoVisibility(rid)
-	if err != nil {
-		responseCode = http.StatusInternalServerError
-	}
+func objectCountToWire(c git.ObjectCount) wire.ObjectCount {
+	return wire.ObjectCount{Count: c.Count, Size: c.Size, DiskSize: c.DiskSize}
+}
+
+//getRepoStats reports which objects take up the space of the
+//repository, e.g. to find large files that were committed to git
+//instead of the annex. It reads all objects, so it requires
+//AdminAccess. The lists are configured via sizeConfig.
+func (s *Server) getRepoStats(w http.ResponseWriter, r *http.Request) {
+	ivars := mux.Vars(r)
+	rid, err := s.varsToRepoID(ivars)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	cfg, err := sizeConfig(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, ok := s.checkAccess(w, r, rid, store.AdminAccess)
+	if !ok {
+		return
+	}
+
+	repo, err := s.repos.OpenGitRepo(rid)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	defer s.repos.ReleaseGitRepo(repo)
+
+	report, err := repo.SizeReport(cfg)
+	if err != nil {
+		s.log(WARN, "could not create size report of %s: %v", rid, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	res := wire.RepoStats{
+		Commits:            objectCountToWire(report.Commits),
+		Trees:              objectCountToWire(report.Trees),
+		Blobs:              objectCountToWire(report.Blobs),
+		Tags:               objectCountToWire(report.Tags),
+		Total:              objectCountToWire(report.Total()),
+		Duplicates:         report.Duplicates,
+		LargeFileSize:      cfg.LargeFile,
+		LargeFiles:         objectCountToWire(report.LargeFiles),
+		LargestBlobs:       []wire.BlobSize{},
+		DeepestTrees:       []wire.TreeDepth{},
+		LongestDeltaChains: []wire.DeltaChain{},
+	}
+
+	for _, b := range report.LargestBlobs {
+		paths := b.Paths
+		if paths == nil {
+			paths = []string{}
+		}
+		res.LargestBlobs = append(res.LargestBlobs, wire.BlobSize{ID: b.ID.String(), Size: b.Size, DiskSize: b.DiskSize, Paths: paths})
+	}
+
+	for _, t := range report.DeepestTrees {
+		res.DeepestTrees = append(res.DeepestTrees, wire.TreeDepth{ID: t.ID.String(), Depth: t.Depth, Path: t.Path})
+	}
+
+	for _, d := range report.LongestDeltaChains {
+		res.LongestDeltaChains = append(res.LongestDeltaChains, wire.DeltaChain{ID: d.ID.String(), Type: d.Type.String(), Depth: d.Depth})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(res)
+	if err != nil {
+		s.log(WARN, "error after status ok sent [%v]", err)
+	}
+}
+
+// patchRepoSettings patches repository description and public status.
+// The request header has to contain an authorization header with a valid token.
+// The request body has to contain valid JSON containing "description" as key
+// and a string as value, "public" as key and a boolean value as value or both.
+// A request that does not contain any of these two keys will result in a BadRequest status.
+func (s *Server) patchRepoSettings(w http.ResponseWriter, r *http.Request) {
+	ivars := mux.Vars(r)
+	rid, err := s.varsToRepoID(ivars)
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	_, ok := s.checkAccess(w, r, rid, store.AdminAccess)
+	if !ok {
+		return
+	}
+
+	// Pointer fields are used to make sure, that boolean values are
+	// not updated, when the corresponding field was not
+	// included in the submitted JSON.
+	var patch struct {
+		Description *string `json:"description,omitempty"`
+		Public      *bool   `json:"public,omitempty"`
+	}
+
+	if r.Body == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = json.Unmarshal(b, &patch)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// TODO The following code currently does not deal with the problem
+	// if an error occurs, after part of the patch has already
+	// been applied.
+	responseCode := http.StatusOK
+
+	// The following code deviates from normal style of
+	// return on error, since we always want to return
+	// the latest state if the repository settings,
+	// even if an error occurs.
+	if patch.Description == nil && patch.Public == nil {
+		responseCode = http.StatusBadRequest
+	}
+	repository, err := git.OpenRepository(s.repos.IdToPath(rid))
+	if err != nil {
+		responseCode = http.StatusInternalServerError
+	}
+
+	if patch.Description != nil {
+		err = repository.WriteDescription(*patch.Description)
+		if err != nil {
+			responseCode = http.StatusInternalServerError
+		}
+	}
+
+	if patch.Public != nil {
+		err = s.repos.SetRepoVisibility(rid, *patch.Public)
+		if err != nil {
+			responseCode = http.StatusInternalServerError
+		}
+	}
+
+	var resp struct {
+		Public      bool
+		Description string
+	}
+
+	resp.Description = repository.ReadDescription()
+	resp.Public, err = s.repos.GetRepoVisibility(rid)
+	if err != nil {
+		responseCode = http.StatusInternalServerError
+	}
 
 	respBody, err := json.Marshal(resp)
 	if err != nil {
@@ -897,6 +1380,7 @@ func (s *Server) repoDescription(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
+	defer s.repos.ReleaseGitRepo(repo)
 
 	desc, err := s.repoToWire(rid, repo)
 	if err != nil {
@@ -914,7 +1398,142 @@ func (s *Server) repoDescription(w http.ResponseWriter, r *http.Request) {
 	w.Write(body)
 }
 
-// listRepoCommits returns a list of all commits from the branch of a specified repository as json.
+//commit pages returned by listRepoCommits
+const (
+	commitsPageSize    = 100
+	commitsMaxPageSize = 1000
+)
+
+//logOptions parses the query parameters of listRepoCommits.
+func logOptions(r *http.Request) (git.LogOptions, error) {
+	opts := git.LogOptions{Limit: commitsPageSize}
+	query := r.URL.Query()
+
+	for _, key := range []string{"limit", "skip"} {
+		v := query.Get(key)
+		if v == "" {
+			continue
+		}
+
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || key == "limit" && (n == 0 || n > commitsMaxPageSize) {
+			return opts, fmt.Errorf("invalid %s %q", key, v)
+		}
+
+		if key == "limit" {
+			opts.Limit = n
+		} else {
+			opts.Skip = n
+		}
+	}
+
+	opts.Paths = query["path"]
+
+	var err error
+	if opts.Author, err = queryRegexp(query, "author"); err != nil {
+		return opts, err
+	}
+	if opts.Committer, err = queryRegexp(query, "committer"); err != nil {
+		return opts, err
+	}
+	if opts.Since, err = queryDate(query, "since"); err != nil {
+		return opts, err
+	}
+	if opts.Until, err = queryDate(query, "until"); err != nil {
+		return opts, err
+	}
+
+	if opts.FirstParent, err = queryBool(query, "first-parent"); err != nil {
+		return opts, err
+	}
+
+	switch v := query.Get("order"); v {
+	case "", "date":
+	case "topo":
+		opts.Order = git.LogTopoOrder
+	default:
+		return opts, fmt.Errorf("unknown order %q", v)
+	}
+
+	return opts, nil
+}
+
+//queryBool parses the query parameter key as boolean, which is
+//false if it is missing.
+func queryBool(query url.Values, key string) (bool, error) {
+	switch v := query.Get(key); v {
+	case "", "0", "false":
+		return false, nil
+	case "1", "true":
+		return true, nil
+	default:
+		return false, fmt.Errorf("invalid %s %q", key, v)
+	}
+}
+
+//queryMailmap returns the mailmap of HEAD if the query parameter
+//"mailmap" is true (or, if it is missing, if def is true), nil
+//otherwise. On errors the status code is written.
+func (s *Server) queryMailmap(w http.ResponseWriter, r *http.Request, repo *git.Repository, def bool) (*git.Mailmap, bool) {
+	use := def
+	if r.URL.Query().Get("mailmap") != "" {
+		var err error
+		if use, err = queryBool(r.URL.Query(), "mailmap"); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return nil, false
+		}
+	}
+
+	if !use {
+		return nil, true
+	}
+
+	mailmap, err := repo.Mailmap()
+	if err != nil {
+		s.log(WARN, "could not read mailmap: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return mailmap, true
+}
+
+//queryRegexp compiles the query parameter key, if present.
+func queryRegexp(query url.Values, key string) (*regexp.Regexp, error) {
+	v := query.Get(key)
+	if v == "" {
+		return nil, nil
+	}
+
+	re, err := regexp.Compile(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s pattern %q", key, v)
+	}
+	return re, nil
+}
+
+//queryDate parses the query parameter key, if present, as
+//RFC 3339 date and time or as plain date (UTC).
+func queryDate(query url.Values, key string) (time.Time, error) {
+	v := query.Get(key)
+	if v == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		t, err = time.Parse("2006-01-02", v)
+	}
+	if err != nil {
+		return t, fmt.Errorf("invalid %s date %q", key, v)
+	}
+	return t, nil
+}
+
+// listRepoCommits returns a page of commits from the branch of a specified repository as json.
+// The commits can be filtered and ordered via query parameters (see logOptions); if there are
+// more commits, the "Link" header points to the next page. With "mailmap=1" authors and
+// committers are mapped with the .mailmap file of HEAD.
 // Required access level is PullAccess.
 func (s *Server) listRepoCommits(w http.ResponseWriter, r *http.Request) {
 
@@ -932,28 +1551,49 @@ func (s *Server) listRepoCommits(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	opts, err := logOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	repo, err := s.repos.OpenGitRepo(rid)
 	if err != nil {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
+	defer s.repos.ReleaseGitRepo(repo)
 
-	ok, err = repo.BranchExists(ibranch)
+	rev, err := repo.ParseRevision(ibranch)
 	if err != nil {
-		s.log(WARN, "error checking branch %q [%v]", ibranch, err)
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(revisionErrorStatus(err))
 		return
-	} else if !ok {
-		w.WriteHeader(http.StatusNotFound)
+	}
+
+	if opts.Mailmap, ok = s.queryMailmap(w, r, repo, false); !ok {
 		return
 	}
 
-	comList, err := repo.CommitsForRef(ibranch)
+	// one more than requested, to know if there is a next page
+	opts.Limit++
+	comList, err := repo.LogSummaries(rev, opts)
 	if err != nil {
 		s.log(WARN, "error fetching commits [%v]", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	opts.Limit--
+
+	if len(comList) > opts.Limit {
+		comList = comList[:opts.Limit]
+
+		next := *r.URL
+		query := next.Query()
+		query.Set("skip", strconv.Itoa(opts.Skip+opts.Limit))
+		query.Set("limit", strconv.Itoa(opts.Limit))
+		next.RawQuery = query.Encode()
+		w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", next.RequestURI()))
+	}
 
 	// Convert []git.CommitSummary to []wire.CommitSummary
 	res := make([]wire.CommitSummary, len(comList))
@@ -967,7 +1607,7 @@ func (s *Server) listRepoCommits(w http.ResponseWriter, r *http.Request) {
 		res[i].DateIso = v.DateIso
 		res[i].DateRelative = v.DateRelative
 		res[i].Subject = v.Subject
-		res[i].Changes = v.Changes
+		res[i].Changes = changesToWire(v.Changes)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -978,3 +1618,426 @@ func (s *Server) listRepoCommits(w http.ResponseWriter, r *http.Request) {
 		s.log(WARN, "error after status ok sent [%v]", err)
 	}
 }
+
+//listContributors returns the authors of the commits of a branch
+//with their number of commits, most active first. Identities are
+//merged with the mailmap of HEAD, unless the query has "mailmap=0".
+//Like for listRepoCommits, the query can select commits by "path",
+//"since" and "until".
+func (s *Server) listContributors(w http.ResponseWriter, r *http.Request) {
+	ivars := mux.Vars(r)
+	rid, err := s.varsToRepoID(ivars)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	_, ok := s.checkAccess(w, r, rid, store.PullAccess)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	opts := git.LogOptions{Paths: query["path"]}
+	if opts.Since, err = queryDate(query, "since"); err == nil {
+		opts.Until, err = queryDate(query, "until")
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	repo, err := s.repos.OpenGitRepo(rid)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	defer s.repos.ReleaseGitRepo(repo)
+
+	rev, err := repo.ParseRevision(ivars["branch"])
+	if err != nil {
+		w.WriteHeader(revisionErrorStatus(err))
+		return
+	}
+
+	if opts.Mailmap, ok = s.queryMailmap(w, r, repo, true); !ok {
+		return
+	}
+
+	contributors, err := repo.Contributors(rev, opts)
+	if err != nil {
+		s.log(WARN, "error fetching contributors [%v]", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	res := make([]wire.Contributor, len(contributors))
+	for i, c := range contributors {
+		res[i] = wire.Contributor{
+			Name:    c.Name,
+			Email:   c.Email,
+			Commits: c.Commits,
+			First:   c.First.In(time.UTC).Format(time.RFC3339),
+			Last:    c.Last.In(time.UTC).Format(time.RFC3339),
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	err = enc.Encode(res)
+	if err != nil {
+		s.log(WARN, "error after status ok sent [%v]", err)
+	}
+}
+
+func changesToWire(changes []git.TreeChange) []wire.Change {
+	res := make([]wire.Change, len(changes))
+
+	for i, c := range changes {
+		res[i].Type = c.Type.String()
+		res[i].Path = c.Path
+		res[i].OldPath = c.OldPath
+		res[i].Similarity = c.Similarity
+
+		if !c.OldID.IsZero() {
+			res[i].OldMode = fmt.Sprintf("%06o", uint32(c.OldMode))
+			res[i].OldID = c.OldID.String()
+		}
+
+		if !c.NewID.IsZero() {
+			res[i].NewMode = fmt.Sprintf("%06o", uint32(c.NewMode))
+			res[i].NewID = c.NewID.String()
+		}
+	}
+
+	return res
+}
+
+func (s *Server) getCommit(w http.ResponseWriter, r *http.Request) {
+	ivars := mux.Vars(r)
+
+	rid, err := s.varsToRepoID(ivars)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	_, ok := s.checkAccess(w, r, rid, store.PullAccess)
+	if !ok {
+		return
+	}
+
+	repo, err := s.repos.OpenGitRepo(rid)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	defer s.repos.ReleaseGitRepo(repo)
+
+	id, ok := s.resolveRevision(w, repo, ivars["commit"]+"^{commit}")
+	if !ok {
+		return
+	}
+
+	obj, err := repo.OpenObject(id)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	obj.Close()
+
+	mailmap, ok := s.queryMailmap(w, r, repo, false)
+	if !ok {
+		return
+	}
+
+	commit := obj.(*git.Commit)
+	author, committer := mailmap.Map(commit.Author), mailmap.Map(commit.Committer)
+
+	changes, err := repo.CommitChanges(commit)
+	if err == nil {
+		changes, err = repo.DetectRenames(changes, git.DefaultRenameOptions)
+	}
+	if err != nil {
+		s.log(WARN, "could not diff commit %s: %v", id, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	res := wire.Commit{
+		Commit:    id.String(),
+		Tree:      commit.Tree.String(),
+		Parents:   make([]string, len(commit.Parent)),
+		Author:    fmt.Sprintf("%s <%s>", author.Name, author.Email),
+		Committer: fmt.Sprintf("%s <%s>", committer.Name, committer.Email),
+		DateIso:   commit.Author.Date.In(commit.Author.Offset).Format("2006-01-02 15:04:05 -0700"),
+		Message:   commit.Message,
+		Changes:   changesToWire(changes),
+	}
+
+	for i, parent := range commit.Parent {
+		res.Parents[i] = parent.String()
+	}
+
+	if commit.GPGSig != "" {
+		keys, err := s.signingKeys()
+		if err != nil {
+			s.log(WARN, "could not read signing keys: %v", err)
+		}
+		res.Signature = s.verifySignature(repo, id, keys)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	err = enc.Encode(res)
+	if err != nil {
+		s.log(WARN, "error after status ok sent [%v]", err)
+	}
+}
+
+func diffToWire(diffs []*git.FileDiff) []wire.FileDiff {
+	res := make([]wire.FileDiff, len(diffs))
+	for i, fd := range diffs {
+		res[i].Change = changesToWire([]git.TreeChange{fd.Change})[0]
+		res[i].Binary = fd.Binary
+		res[i].Added = fd.Added
+		res[i].Deleted = fd.Deleted
+
+		if fd.Annex != nil {
+			res[i].Annex = &wire.AnnexChange{}
+			if k := fd.Annex.Old; k != nil {
+				res[i].Annex.Old = &wire.AnnexKey{Key: k.Key, Size: k.Bytesize}
+			}
+			if k := fd.Annex.New; k != nil {
+				res[i].Annex.New = &wire.AnnexKey{Key: k.Key, Size: k.Bytesize}
+			}
+		}
+
+		for _, h := range fd.Hunks {
+			wh := wire.Hunk{
+				OldStart: h.OldStart,
+				OldLines: h.OldLines,
+				NewStart: h.NewStart,
+				NewLines: h.NewLines,
+				Section:  h.Section,
+				Lines:    make([]wire.DiffLine, len(h.Lines)),
+			}
+
+			for j, l := range h.Lines {
+				wh.Lines[j] = wire.DiffLine{Op: string(l.Op), Text: l.Text, NoNewline: l.NoNewline}
+			}
+
+			res[i].Hunks = append(res[i].Hunks, wh)
+		}
+	}
+
+	return res
+}
+
+//diffOptions reads the options of the diff endpoints from the query:
+//"context" (number of lines) and "algorithm" ("myers", "histogram").
+func diffOptions(r *http.Request) (git.DiffOptions, error) {
+	opts := git.DefaultDiffOptions
+	query := r.URL.Query()
+
+	if c := query.Get("context"); c != "" {
+		n, err := strconv.Atoi(c)
+		if err != nil || n < 0 {
+			return opts, fmt.Errorf("invalid context %q", c)
+		}
+		opts.Context = n
+	}
+
+	switch query.Get("algorithm") {
+	case "", "myers":
+	case "histogram":
+		opts.Algorithm = git.DiffHistogram
+	default:
+		return opts, fmt.Errorf("unknown algorithm %q", query.Get("algorithm"))
+	}
+
+	return opts, nil
+}
+
+//writeDiff writes the diff of the trees with the ids a and b, the
+//former of which may be zero for the empty tree, as JSON or, if the
+//query has "format=patch", as unified diff.
+func (s *Server) writeDiff(w http.ResponseWriter, r *http.Request, repo *git.Repository, a, b git.ObjectID) {
+	opts, err := diffOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var ta, tb *git.Tree
+	if !a.IsZero() {
+		obj, err := repo.OpenObject(a)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		ta = obj.(*git.Tree)
+	}
+
+	obj, err := repo.OpenObject(b)
+	if err != nil {
+		if ta != nil {
+			ta.Close()
+		}
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	tb = obj.(*git.Tree)
+
+	//the attributes of the new tree decide which files are binary
+	if opts.Attributes, err = repo.NewAttrReader(b); err != nil {
+		if ta != nil {
+			ta.Close()
+		}
+		tb.Close()
+		s.log(WARN, "could not read attributes of %s: %v", b, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	diffs, err := repo.DiffFiles(ta, tb, opts)
+	if err != nil {
+		s.log(WARN, "could not diff trees %s, %s: %v", a, b, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "patch" {
+		w.Header().Set("Content-Type", "text/x-diff; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		for _, fd := range diffs {
+			if err = fd.WriteUnified(w); err != nil {
+				s.log(WARN, "error after status ok sent [%v]", err)
+				return
+			}
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	err = enc.Encode(diffToWire(diffs))
+	if err != nil {
+		s.log(WARN, "error after status ok sent [%v]", err)
+	}
+}
+
+func (s *Server) getCommitDiff(w http.ResponseWriter, r *http.Request) {
+	ivars := mux.Vars(r)
+
+	rid, err := s.varsToRepoID(ivars)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	_, ok := s.checkAccess(w, r, rid, store.PullAccess)
+	if !ok {
+		return
+	}
+
+	repo, err := s.repos.OpenGitRepo(rid)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	defer s.repos.ReleaseGitRepo(repo)
+
+	id, ok := s.resolveRevision(w, repo, ivars["commit"]+"^{commit}")
+	if !ok {
+		return
+	}
+
+	tree, ok := s.resolveRevision(w, repo, id.String()+"^{tree}")
+	if !ok {
+		return
+	}
+
+	obj, err := repo.OpenObject(id)
+	if err != nil {
+		s.log(WARN, "could not open commit %s: %v", id, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	obj.Close()
+	commit := obj.(*git.Commit)
+
+	// root commits are compared to the empty tree
+	var parent git.ObjectID
+	if len(commit.Parent) > 0 {
+		parent, err = repo.ResolveRevision(commit.Parent[0].String() + "^{tree}")
+		if err != nil {
+			s.log(WARN, "could not resolve the parent of %s: %v", id, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	s.writeDiff(w, r, repo, parent, tree)
+}
+
+func (s *Server) compareRevisions(w http.ResponseWriter, r *http.Request) {
+	ivars := mux.Vars(r)
+
+	rid, err := s.varsToRepoID(ivars)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	_, ok := s.checkAccess(w, r, rid, store.PullAccess)
+	if !ok {
+		return
+	}
+
+	repo, err := s.repos.OpenGitRepo(rid)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	defer s.repos.ReleaseGitRepo(repo)
+
+	base, ok := s.resolveRevision(w, repo, ivars["base"]+"^{commit}")
+	if !ok {
+		return
+	}
+
+	head, ok := s.resolveRevision(w, repo, ivars["head"]+"^{commit}")
+	if !ok {
+		return
+	}
+
+	// like git diff base...head, the changes of head since
+	// it forked off base
+	bases, err := git.NewCommitGraph(repo).MergeBase(base, head)
+	if err != nil {
+		s.log(WARN, "could not find merge base of %s and %s: %v", base, head, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if len(bases) == 0 {
+		http.Error(w, "no common history", http.StatusUnprocessableEntity)
+		return
+	}
+
+	from, err := repo.ResolveRevision(bases[0].ID.String() + "^{tree}")
+	if err != nil {
+		s.log(WARN, "could not resolve merge base %s: %v", bases[0].ID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	to, ok := s.resolveRevision(w, repo, head.String()+"^{tree}")
+	if !ok {
+		return
+	}
+
+	s.writeDiff(w, r, repo, from, to)
+}
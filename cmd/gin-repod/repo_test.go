@@ -9,6 +9,7 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/G-Node/gin-repo/git"
 	"github.com/G-Node/gin-repo/store"
@@ -27,6 +28,7 @@ func TestRepoToWire(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Error fetching repository %v: %v\n", id, err)
 	}
+	defer server.repos.ReleaseGitRepo(repo)
 
 	wired, err := server.repoToWire(id, repo)
 	if err != nil {
@@ -1000,4 +1002,415 @@ func Test_listRepoCommits(t *testing.T) {
 	if len(result) == 0 {
 		t.Fatal("Expected a list of commits, but got none")
 	}
+
+	for _, c := range result {
+		for _, change := range c.Changes {
+			if change.Type == "" || change.Path == "" {
+				t.Fatalf("Expected typed changes with paths, got %+v", change)
+			}
+		}
+	}
+
+	// test pagination
+	resp, err = RunRequest(method, url+"?limit=1", nil, headerMap, http.StatusOK)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	page := []wire.CommitSummary{}
+	err = json.Unmarshal(resp.Body.Bytes(), &page)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if len(page) != 1 || page[0].Commit != result[0].Commit {
+		t.Fatalf("Expected the first commit only, got %+v", page)
+	}
+
+	link := resp.Header().Get("Link")
+	if (len(result) > 1) != (link != "") {
+		t.Fatalf("Unexpected Link header %q for %d commits", link, len(result))
+	} else if link != "" {
+		next := strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`)
+		resp, err = RunRequest(method, next, nil, headerMap, http.StatusOK)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+
+		err = json.Unmarshal(resp.Body.Bytes(), &page)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+		if len(page) != 1 || page[0].Commit != result[1].Commit {
+			t.Fatalf("Expected the second commit only, got %+v", page)
+		}
+	}
+
+	// test invalid filters
+	for _, query := range []string{"limit=0", "skip=-1", "author=(", "since=yesterday", "order=random"} {
+		_, err = RunRequest(method, url+"?"+query, nil, headerMap, http.StatusBadRequest)
+		if err != nil {
+			t.Fatalf("%s: %v\n", query, err)
+		}
+	}
+}
+
+func Test_getCommit(t *testing.T) {
+	const urlTemplate = "/users/%s/repos/%s/commit/%s"
+	const validUser = "bob"
+	const validRepo = "repod"
+
+	headerMap := make(map[string]string)
+	token, err := server.users.TokenForUser(validUser)
+	if err != nil {
+		t.Fatalf("Could not make token for %q: %v, %v", validUser, token, err)
+	}
+	headerMap["Authorization"] = "Bearer " + token
+
+	url := fmt.Sprintf(urlTemplate, validUser, validRepo, "master")
+	_, err = RunRequest("GET", url, nil, nil, http.StatusNotFound)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	url = fmt.Sprintf(urlTemplate, validUser, validRepo, "iDoNotExist")
+	_, err = RunRequest("GET", url, nil, headerMap, http.StatusNotFound)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	url = fmt.Sprintf(urlTemplate, validUser, validRepo, "master")
+	resp, err := RunRequest("GET", url, nil, headerMap, http.StatusOK)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	result := wire.Commit{}
+	err = json.Unmarshal(resp.Body.Bytes(), &result)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if len(result.Commit) != 40 || len(result.Tree) != 40 {
+		t.Fatalf("Expected commit and tree ids, got %+v", result)
+	}
+
+	for _, change := range result.Changes {
+		if change.Type == "" || change.Path == "" {
+			t.Fatalf("Expected typed changes with paths, got %+v", change)
+		}
+	}
+}
+
+func Test_getCommitDiff(t *testing.T) {
+	const urlTemplate = "/users/%s/repos/%s/commit/%s/diff"
+	const validUser = "bob"
+	const validRepo = "repod"
+
+	headerMap := make(map[string]string)
+	token, err := server.users.TokenForUser(validUser)
+	if err != nil {
+		t.Fatalf("Could not make token for %q: %v, %v", validUser, token, err)
+	}
+	headerMap["Authorization"] = "Bearer " + token
+
+	url := fmt.Sprintf(urlTemplate, validUser, validRepo, "master")
+	_, err = RunRequest("GET", url, nil, nil, http.StatusNotFound)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	_, err = RunRequest("GET", url+"?algorithm=iDoNotExist", nil, headerMap, http.StatusBadRequest)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	resp, err := RunRequest("GET", url+"?context=1", nil, headerMap, http.StatusOK)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	var result []wire.FileDiff
+	err = json.Unmarshal(resp.Body.Bytes(), &result)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	for _, fd := range result {
+		if fd.Type == "" || fd.Path == "" {
+			t.Fatalf("Expected typed changes with paths, got %+v", fd)
+		}
+
+		for _, h := range fd.Hunks {
+			if len(h.Lines) == 0 {
+				t.Fatalf("Expected hunks with lines, got %+v", h)
+			}
+		}
+	}
+
+	resp, err = RunRequest("GET", url+"?format=patch", nil, headerMap, http.StatusOK)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if len(result) > 0 && !strings.HasPrefix(resp.Body.String(), "diff --git ") {
+		t.Fatalf("Expected a unified diff, got %q", resp.Body.String())
+	}
+
+	url = fmt.Sprintf("/users/%s/repos/%s/compare/%s...%s", validUser, validRepo, "master", "master")
+	resp, err = RunRequest("GET", url, nil, headerMap, http.StatusOK)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if body := strings.TrimSpace(resp.Body.String()); body != "[]" {
+		t.Fatalf("Expected no differences, got %s", body)
+	}
+}
+
+// mkTestCommit commits a file with the content on top of parent, or
+// as root commit if parent is zero, and points the branch to it.
+func mkTestCommit(t *testing.T, repo *git.Repository, branch string, parent git.ObjectID, path, content string) git.ObjectID {
+	blob, err := repo.WriteObject(git.ObjBlob, int64(len(content)), strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("could not write blob: %v", err)
+	}
+
+	tb := repo.NewTreeBuilder()
+	var parents []git.ObjectID
+	if !parent.IsZero() {
+		tree, err := repo.ResolveRevision(parent.String() + "^{tree}")
+		if err == nil {
+			tb, err = repo.EditTree(tree)
+		}
+		if err != nil {
+			t.Fatalf("could not edit tree of %s: %v", parent, err)
+		}
+		parents = append(parents, parent)
+	}
+
+	if err = tb.Set(path, 0100644, blob); err != nil {
+		t.Fatalf("could not add %q: %v", path, err)
+	}
+
+	tree, err := tb.Write()
+	if err != nil {
+		t.Fatalf("could not write tree: %v", err)
+	}
+
+	who := git.NewSignature("A U Thor", "author@example.com", time.Now())
+	cb := repo.NewCommitBuilder(tree, parents...)
+	cb.Author, cb.Message = who, "add "+path+"\n"
+
+	id, err := cb.Write()
+	if err != nil {
+		t.Fatalf("could not write commit: %v", err)
+	}
+
+	if err = repo.UpdateRef("refs/heads/"+branch, git.ObjectID{}, id, who, "test"); err != nil {
+		t.Fatalf("could not create branch %q: %v", branch, err)
+	}
+
+	return id
+}
+
+func Test_compareRevisions(t *testing.T) {
+	const urlTemplate = "/users/%s/repos/%s/compare/%s...%s"
+	const validUser = "bob"
+	const validRepo = "repod"
+
+	headerMap := make(map[string]string)
+	token, err := server.users.TokenForUser(validUser)
+	if err != nil {
+		t.Fatalf("Could not make token for %q: %v, %v", validUser, token, err)
+	}
+	headerMap["Authorization"] = "Bearer " + token
+
+	repo, err := server.repos.OpenGitRepo(store.RepoId{Owner: validUser, Name: validRepo})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.repos.ReleaseGitRepo(repo)
+
+	master, err := repo.ResolveRevision("master")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// both branches forked off master
+	base := mkTestCommit(t, repo, "compare-base", master, "base.txt", "on base\n")
+	head := mkTestCommit(t, repo, "compare-head", master, "head.txt", "on head\n")
+	orphan := mkTestCommit(t, repo, "compare-orphan", git.ObjectID{}, "orphan.txt", "unrelated\n")
+	defer func() {
+		for name, id := range map[string]git.ObjectID{"compare-base": base, "compare-head": head, "compare-orphan": orphan} {
+			repo.DeleteRef("refs/heads/"+name, id)
+		}
+	}()
+
+	url := fmt.Sprintf(urlTemplate, validUser, validRepo, "compare-base", "compare-head")
+	resp, err := RunRequest("GET", url, nil, headerMap, http.StatusOK)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	var result []wire.FileDiff
+	err = json.Unmarshal(resp.Body.Bytes(), &result)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if len(result) != 1 || result[0].Path != "head.txt" || result[0].Type != "add" {
+		t.Fatalf("Expected only head.txt to be added, got %+v", result)
+	}
+
+	url = fmt.Sprintf(urlTemplate, validUser, validRepo, "compare-base", "compare-orphan")
+	_, err = RunRequest("GET", url, nil, headerMap, http.StatusUnprocessableEntity)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+}
+
+func Test_getArchive(t *testing.T) {
+	const urlTemplate = "/users/%s/repos/%s/archive/%s.%s"
+	const validUser = "bob"
+	const validRepo = "repod"
+
+	headerMap := make(map[string]string)
+	token, err := server.users.TokenForUser(validUser)
+	if err != nil {
+		t.Fatalf("Could not make token for %q: %v, %v", validUser, token, err)
+	}
+	headerMap["Authorization"] = "Bearer " + token
+
+	url := fmt.Sprintf(urlTemplate, validUser, validRepo, "master", "zip")
+	_, err = RunRequest("GET", url, nil, nil, http.StatusNotFound)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	_, err = RunRequest("GET", url+"?annex=maybe", nil, headerMap, http.StatusBadRequest)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	_, err = RunRequest("GET", fmt.Sprintf(urlTemplate, validUser, validRepo, "iDoNotExist", "zip"), nil, headerMap, http.StatusNotFound)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	resp, err := RunRequest("GET", url+"?annex=1", nil, headerMap, http.StatusOK)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if ct := resp.Header().Get("Content-Type"); ct != "application/zip" {
+		t.Fatalf("Expected a zip archive, got %q", ct)
+	}
+
+	if !strings.HasPrefix(resp.Body.String(), "PK") {
+		t.Fatalf("Expected zip data, got %q", resp.Body.String())
+	}
+
+	url = fmt.Sprintf(urlTemplate, validUser, validRepo, "master", "tar.gz")
+	resp, err = RunRequest("GET", url, nil, headerMap, http.StatusOK)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if cd := resp.Header().Get("Content-Disposition"); !strings.Contains(cd, "repod-master.tar.gz") {
+		t.Fatalf("Expected the archive name in %q", cd)
+	}
+}
+
+func Test_listContributors(t *testing.T) {
+	const urlTemplate = "/users/%s/repos/%s/contributors/%s"
+	const validUser = "bob"
+	const validRepo = "repod"
+
+	headerMap := make(map[string]string)
+	token, err := server.users.TokenForUser(validUser)
+	if err != nil {
+		t.Fatalf("Could not make token for %q: %v, %v", validUser, token, err)
+	}
+	headerMap["Authorization"] = "Bearer " + token
+
+	url := fmt.Sprintf(urlTemplate, validUser, validRepo, "master")
+	_, err = RunRequest("GET", url, nil, nil, http.StatusNotFound)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	for _, query := range []string{"mailmap=maybe", "since=yesterday"} {
+		_, err = RunRequest("GET", url+"?"+query, nil, headerMap, http.StatusBadRequest)
+		if err != nil {
+			t.Fatalf("%s: %v\n", query, err)
+		}
+	}
+
+	resp, err := RunRequest("GET", url, nil, headerMap, http.StatusOK)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	var result []wire.Contributor
+	err = json.Unmarshal(resp.Body.Bytes(), &result)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if len(result) == 0 {
+		t.Fatalf("Expected at least one contributor")
+	}
+
+	for i, c := range result {
+		if c.Name == "" || c.Commits == 0 {
+			t.Fatalf("Expected named contributors with commits, got %+v", c)
+		} else if i > 0 && c.Commits > result[i-1].Commits {
+			t.Fatalf("Expected contributors ordered by commits, got %+v", result)
+		}
+	}
+}
+
+func Test_getRepoStats(t *testing.T) {
+	const urlTemplate = "/users/%s/repos/%s/stats"
+	const validUser = "bob"
+	const validRepo = "repod"
+
+	headerMap := make(map[string]string)
+	token, err := server.users.TokenForUser(validUser)
+	if err != nil {
+		t.Fatalf("Could not make token for %q: %v, %v", validUser, token, err)
+	}
+	headerMap["Authorization"] = "Bearer " + token
+
+	url := fmt.Sprintf(urlTemplate, validUser, validRepo)
+	_, err = RunRequest("GET", url, nil, nil, http.StatusNotFound)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	for _, query := range []string{"top=-1", "top=many", "large-file=big"} {
+		_, err = RunRequest("GET", url+"?"+query, nil, headerMap, http.StatusBadRequest)
+		if err != nil {
+			t.Fatalf("%s: %v\n", query, err)
+		}
+	}
+
+	resp, err := RunRequest("GET", url+"?top=2&large-file=0", nil, headerMap, http.StatusOK)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	var result wire.RepoStats
+	err = json.Unmarshal(resp.Body.Bytes(), &result)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if result.Commits.Count == 0 || result.Total.Count < result.Blobs.Count {
+		t.Fatalf("Expected commits and blobs, got %+v", result)
+	}
+
+	if len(result.LargestBlobs) > 2 || result.LargeFiles.Count == 0 {
+		t.Fatalf("Unexpected largest blobs %+v, large files %+v", result.LargestBlobs, result.LargeFiles)
+	}
 }
@@ -11,12 +11,18 @@ func (s *Server) SetupRoutes() {
 	r.HandleFunc("/repos/public", s.listPublicRepos).Methods("GET")
 	r.HandleFunc("/repos/shared", s.listSharedRepos).Methods("GET")
 
+	r.HandleFunc("/users/{user}/gpgkeys", s.listGPGKeys).Methods("GET")
+	r.HandleFunc("/users/{user}/gpgkeys", s.addGPGKey).Methods("POST")
+	r.HandleFunc("/users/{user}/gpgkeys/challenge", s.gpgKeyChallenge).Methods("GET")
+	r.HandleFunc("/users/{user}/gpgkeys/{fingerprint}", s.deleteGPGKey).Methods("DELETE")
+
 	r.HandleFunc("/users/{user}/repos", s.createRepo).Methods("POST")
 	r.HandleFunc("/users/{user}/repos", s.listRepos).Methods("GET")
 
 	r.HandleFunc("/users/{user}/repos/{repo}", s.repoDescription).Methods("GET")
 
 	r.HandleFunc("/users/{user}/repos/{repo}/settings", s.patchRepoSettings).Methods("PATCH")
+	r.HandleFunc("/users/{user}/repos/{repo}/stats", s.getRepoStats).Methods("GET")
 
 	r.HandleFunc("/users/{user}/repos/{repo}/visibility", s.getRepoVisibility).Methods("GET")
 	r.HandleFunc("/users/{user}/repos/{repo}/visibility", s.setRepoVisibility).Methods("PUT")
@@ -25,9 +31,16 @@ func (s *Server) SetupRoutes() {
 	r.HandleFunc("/users/{user}/repos/{repo}/collaborators/{username}", s.putRepoCollaborator).Methods("PUT")
 	r.HandleFunc("/users/{user}/repos/{repo}/collaborators/{username}", s.deleteRepoCollaborator).Methods("DELETE")
 
+	r.HandleFunc("/users/{user}/repos/{repo}/branches", s.listBranches).Methods("GET")
 	r.HandleFunc("/users/{user}/repos/{repo}/branches/{branch}", s.getBranch).Methods("GET")
+	r.HandleFunc("/users/{user}/repos/{repo}/tags", s.listTags).Methods("GET")
 	r.HandleFunc("/users/{user}/repos/{repo}/objects/{object}", s.getObject).Methods("GET")
 	r.HandleFunc("/users/{user}/repos/{repo}/browse/{branch}", s.browseRepo).Methods("GET")
 	r.HandleFunc("/users/{user}/repos/{repo}/browse/{branch}/{path:.*}", s.browseRepo).Methods("GET")
+	r.HandleFunc("/users/{user}/repos/{repo}/archive/{branch}.{format:zip|tar|tar\\.gz|tgz}", s.getArchive).Methods("GET")
 	r.HandleFunc("/users/{user}/repos/{repo}/commits/{branch}", s.listRepoCommits).Methods("GET")
+	r.HandleFunc("/users/{user}/repos/{repo}/contributors/{branch}", s.listContributors).Methods("GET")
+	r.HandleFunc("/users/{user}/repos/{repo}/commit/{commit}", s.getCommit).Methods("GET")
+	r.HandleFunc("/users/{user}/repos/{repo}/commit/{commit}/diff", s.getCommitDiff).Methods("GET")
+	r.HandleFunc("/users/{user}/repos/{repo}/compare/{base}...{head}", s.compareRevisions).Methods("GET")
 }
@@ -51,7 +51,43 @@ func gitCommand(client *client.Client, args []string, push bool, uid string) int
 		return -11
 	}
 
-	return execGitCommand(args[0], path)
+	if !push {
+		return execGitCommand(args[0], path)
+	}
+
+	//repository maintenance must not remove objects while
+	//they are being pushed
+	repo, err := git.OpenRepository(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[E] could not open repository: %v\n", err)
+		return -15
+	}
+	defer repo.Close()
+
+	lock, err := repo.Lock(false)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[E] could not lock repository: %v\n", err)
+		return -12
+	}
+	defer lock.Unlock()
+
+	res := execGitCommand(args[0], path)
+
+	if res == 0 {
+		refreshCommitGraph(repo)
+	}
+
+	return res
+}
+
+//refreshCommitGraph updates the commit-graph file of the repository
+//after a push, so that listing the history stays fast. Failing to
+//do so is not fatal for the push.
+func refreshCommitGraph(repo *git.Repository) {
+	err := repo.WriteCommitGraph()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[W] could not update the commit-graph: %v\n", err)
+	}
 }
 
 func gitAnnex(client *client.Client, args []string, uid string) int {
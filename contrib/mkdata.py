@@ -27,6 +27,10 @@ def create_user(user):
     if not os.path.exists(key):
         subprocess.check_call(["ssh-keygen", "-t", "rsa", "-b", "4096",
                                "-C", user, "-f", key, "-P", ""])
+    email = os.path.join(base, "email")
+    if not os.path.exists(email):
+        with open(email, "w") as fd:
+            fd.write(user + "@example.com\n")
 
 
 def make_repo(repo):
@@ -155,3 +155,27 @@ func (repo *Repository) Astat(target string) (*AnnexStat, error) {
 
 	return &sbuf, nil
 }
+
+//openAnnexObject opens the content of the annexed file with the key,
+//or returns a nil file if it is not present in the repository.
+func (repo *Repository) openAnnexObject(key *AnnexKey) (*os.File, os.FileInfo, error) {
+	//bare repositories use hashdirlower, others hashdirmixed
+	for _, dir := range []string{key.HashDirLower(), key.HashDirMixed()} {
+		f, err := os.Open(filepath.Join(repo.Path, "annex", "objects", dir, key.Key, key.Key))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+
+		return f, fi, nil
+	}
+
+	return nil, nil, nil
+}
@@ -0,0 +1,401 @@
+package git
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+//ArchiveFormat is the file format of an archive.
+type ArchiveFormat uint8
+
+//ArchiveFormat values.
+const (
+	ArchiveTar ArchiveFormat = iota
+	ArchiveTarGz
+	ArchiveZip
+)
+
+//ParseArchiveFormat converts the name of a format, which is its usual
+//file extension ("tar", "tar.gz" or "tgz", "zip"), to the ArchiveFormat.
+func ParseArchiveFormat(s string) (ArchiveFormat, error) {
+	switch s {
+	case "tar":
+		return ArchiveTar, nil
+	case "tar.gz", "tgz":
+		return ArchiveTarGz, nil
+	case "zip":
+		return ArchiveZip, nil
+	}
+	return ArchiveTar, fmt.Errorf("git: unknown archive format %q", s)
+}
+
+func (f ArchiveFormat) String() string {
+	switch f {
+	case ArchiveTar:
+		return "tar"
+	case ArchiveTarGz:
+		return "tar.gz"
+	case ArchiveZip:
+		return "zip"
+	}
+	return fmt.Sprintf("ArchiveFormat(%d)", uint8(f))
+}
+
+//ArchiveOptions control the output of WriteArchive.
+type ArchiveOptions struct {
+	Format ArchiveFormat
+
+	//Prefix is put in front of every path in the archive, as
+	//is, so it needs a trailing slash to name a directory.
+	Prefix string
+
+	//AnnexContent replaces annexed files with their content,
+	//if it is present in the repository. Otherwise the symlink
+	//or pointer file is archived.
+	AnnexContent bool
+
+	//MTime is the modification time of all entries. If it is
+	//zero, the commit time is used, or the current time when
+	//archiving a tree.
+	MTime time.Time
+}
+
+//archiveWriter writes the entries of one archive format. Names of
+//directories have a trailing slash.
+type archiveWriter interface {
+	dir(name string) error
+	file(name string, perm os.FileMode, size int64, r io.Reader) error
+	symlink(name, target string) error
+	close() error
+}
+
+type archiver struct {
+	repo *Repository
+	opts ArchiveOptions
+	w    archiveWriter
+
+	attrs *AttrReader
+}
+
+//WriteArchive writes the tree of id, which can be a commit, a tag or
+//a tree, as archive to w. Files with the export-ignore attribute are
+//left out, as are the contents of submodules, which are empty
+//directories in the archive. For commits, their id is stored as
+//comment in the archive, like git archive does.
+func (repo *Repository) WriteArchive(w io.Writer, id ObjectID, opts ArchiveOptions) error {
+	for i, comp := range strings.Split(opts.Prefix, "/") {
+		if comp == ".." || i == 0 && comp == "" && opts.Prefix != "" {
+			return fmt.Errorf("git: invalid archive prefix %q", opts.Prefix)
+		}
+	}
+
+	tree, commit, mtime, err := repo.archiveRoot(id)
+	if err != nil {
+		return err
+	}
+
+	if !opts.MTime.IsZero() {
+		mtime = opts.MTime
+	}
+
+	a := &archiver{repo: repo, opts: opts}
+	if a.attrs, err = repo.NewAttrReader(tree); err != nil {
+		return err
+	}
+
+	switch opts.Format {
+	case ArchiveTar, ArchiveTarGz:
+		a.w, err = newTarArchive(w, opts.Format == ArchiveTarGz, mtime, commit)
+	case ArchiveZip:
+		a.w, err = newZipArchive(w, mtime, commit)
+	default:
+		err = fmt.Errorf("git: unknown archive format %s", opts.Format)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if strings.HasSuffix(opts.Prefix, "/") {
+		if err = a.w.dir(opts.Prefix); err != nil {
+			return err
+		}
+	}
+
+	if err = a.writeTree(tree, ""); err != nil {
+		return err
+	}
+
+	return a.w.close()
+}
+
+//archiveRoot peels id to a tree and returns it, together with the
+//commit and its time if id is (or points to) a commit.
+func (repo *Repository) archiveRoot(id ObjectID) (ObjectID, string, time.Time, error) {
+	for {
+		obj, err := repo.OpenObject(id)
+		if err != nil {
+			return id, "", time.Time{}, err
+		}
+		obj.Close()
+
+		switch obj := obj.(type) {
+		case *Tag:
+			id = obj.Object
+		case *Commit:
+			return obj.Tree, id.String(), obj.Date(), nil
+		case *Tree:
+			return id, "", time.Now(), nil
+		default:
+			return id, "", time.Time{}, fmt.Errorf("git: cannot archive a %s", obj.Type())
+		}
+	}
+}
+
+func (a *archiver) writeTree(id ObjectID, dir string) error {
+	tree, err := a.repo.openTree(id)
+	if err != nil {
+		return err
+	}
+
+	entries, err := readTreeEntries(tree)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		p := path.Join(dir, e.Name)
+		attrs, err := a.attrs.Lookup(p)
+		if err != nil {
+			return err
+		} else if attrs.IsSet("export-ignore") {
+			continue
+		}
+
+		name := a.opts.Prefix + p
+		switch fileKind(e.Mode) {
+		case 0040000:
+			if err = a.w.dir(name + "/"); err == nil {
+				err = a.writeTree(e.ID, p)
+			}
+		case 0160000:
+			err = a.w.dir(name + "/")
+		case 0120000:
+			err = a.writeSymlink(name, e.ID)
+		default:
+			err = a.writeFile(name, e.Mode, e.ID)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//readBlob reads the content of a blob, unless it is bigger than
+//max, which is reported by the bool.
+func (a *archiver) readBlob(id ObjectID, max int64) ([]byte, bool, error) {
+	obj, err := a.repo.OpenObject(id)
+	if err != nil {
+		return nil, false, err
+	}
+	defer obj.Close()
+
+	blob, ok := obj.(*Blob)
+	if !ok {
+		return nil, false, fmt.Errorf("git: %s is a %s, not a blob", id, obj.Type())
+	}
+
+	return readBlobData(blob, max)
+}
+
+func (a *archiver) writeFile(name string, mode os.FileMode, id ObjectID) error {
+	perm := os.FileMode(0644)
+	if mode&0111 != 0 {
+		perm = 0755
+	}
+
+	obj, err := a.repo.OpenObject(id)
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+
+	blob, ok := obj.(*Blob)
+	if !ok {
+		return fmt.Errorf("git: %s is a %s, not a blob", id, obj.Type())
+	}
+
+	//pointer files of unlocked annexed files are small
+	if !a.opts.AnnexContent || blob.Size() > 1024 {
+		return a.w.file(name, perm, blob.Size(), blob)
+	}
+
+	data, _, err := readBlobData(blob, 0)
+	if err != nil {
+		return err
+	}
+
+	if key, ok := annexPointerKey(data); ok {
+		if done, err := a.writeAnnex(name, perm, key); done || err != nil {
+			return err
+		}
+	}
+
+	return a.w.file(name, perm, int64(len(data)), bytes.NewReader(data))
+}
+
+func (a *archiver) writeSymlink(name string, id ObjectID) error {
+	data, _, err := a.readBlob(id, 0)
+	if err != nil {
+		return err
+	}
+
+	target := string(data)
+	if key, ok := annexKeyOfTarget(target); ok && a.opts.AnnexContent {
+		if done, err := a.writeAnnex(name, 0644, key); done || err != nil {
+			return err
+		}
+	}
+
+	return a.w.symlink(name, target)
+}
+
+//writeAnnex writes the content of the annexed file with the key,
+//if it is present. The bool reports whether it was written.
+func (a *archiver) writeAnnex(name string, perm os.FileMode, keystr string) (bool, error) {
+	key, err := AnnexExamineKey(keystr)
+	if err != nil {
+		return false, nil
+	}
+
+	f, fi, err := a.repo.openAnnexObject(key)
+	if err != nil || f == nil {
+		return false, err
+	}
+	defer f.Close()
+
+	return true, a.w.file(name, perm, fi.Size(), f)
+}
+
+type tarArchive struct {
+	tw    *tar.Writer
+	gz    *gzip.Writer
+	mtime time.Time
+}
+
+func newTarArchive(w io.Writer, compress bool, mtime time.Time, commit string) (*tarArchive, error) {
+	t := &tarArchive{mtime: mtime}
+	if compress {
+		t.gz = gzip.NewWriter(w)
+		t.gz.ModTime = mtime
+		w = t.gz
+	}
+	t.tw = tar.NewWriter(w)
+
+	if commit == "" {
+		return t, nil
+	}
+
+	hdr := &tar.Header{
+		Typeflag:   tar.TypeXGlobalHeader,
+		PAXRecords: map[string]string{"comment": commit},
+	}
+	return t, t.tw.WriteHeader(hdr)
+}
+
+func (t *tarArchive) header(name string, flag byte, mode int64) *tar.Header {
+	return &tar.Header{
+		Typeflag: flag,
+		Name:     name,
+		Mode:     mode,
+		ModTime:  t.mtime,
+		Uname:    "root",
+		Gname:    "root",
+	}
+}
+
+func (t *tarArchive) dir(name string) error {
+	return t.tw.WriteHeader(t.header(name, tar.TypeDir, 0755))
+}
+
+func (t *tarArchive) file(name string, perm os.FileMode, size int64, r io.Reader) error {
+	hdr := t.header(name, tar.TypeReg, int64(perm))
+	hdr.Size = size
+
+	if err := t.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+
+	_, err := io.CopyN(t.tw, r, size)
+	return err
+}
+
+func (t *tarArchive) symlink(name, target string) error {
+	hdr := t.header(name, tar.TypeSymlink, 0777)
+	hdr.Linkname = target
+	return t.tw.WriteHeader(hdr)
+}
+
+func (t *tarArchive) close() error {
+	err := t.tw.Close()
+	if t.gz != nil && err == nil {
+		err = t.gz.Close()
+	}
+	return err
+}
+
+type zipArchive struct {
+	zw    *zip.Writer
+	mtime time.Time
+}
+
+func newZipArchive(w io.Writer, mtime time.Time, commit string) (*zipArchive, error) {
+	z := &zipArchive{zw: zip.NewWriter(w), mtime: mtime}
+	return z, z.zw.SetComment(commit)
+}
+
+func (z *zipArchive) create(name string, mode os.FileMode, method uint16) (io.Writer, error) {
+	hdr := &zip.FileHeader{Name: name, Method: method, Modified: z.mtime}
+	hdr.SetMode(mode)
+	return z.zw.CreateHeader(hdr)
+}
+
+func (z *zipArchive) dir(name string) error {
+	_, err := z.create(name, os.ModeDir|0755, zip.Store)
+	return err
+}
+
+func (z *zipArchive) file(name string, perm os.FileMode, size int64, r io.Reader) error {
+	w, err := z.create(name, perm, zip.Deflate)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.CopyN(w, r, size)
+	return err
+}
+
+func (z *zipArchive) symlink(name, target string) error {
+	w, err := z.create(name, os.ModeSymlink|0777, zip.Store)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, target)
+	return err
+}
+
+func (z *zipArchive) close() error {
+	return z.zw.Close()
+}
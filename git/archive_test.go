@@ -0,0 +1,249 @@
+package git
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+type archiveEntry struct {
+	mode    os.FileMode
+	content string
+}
+
+func readTarArchive(t *testing.T, r io.Reader) (map[string]archiveEntry, string) {
+	entries := make(map[string]archiveEntry)
+	comment := ""
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("could not read tar: %v", err)
+		}
+
+		if hdr.Typeflag == tar.TypeXGlobalHeader {
+			comment = hdr.PAXRecords["comment"]
+			continue
+		}
+
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("could not read %q from tar: %v", hdr.Name, err)
+		}
+
+		content := string(data)
+		if hdr.Typeflag == tar.TypeSymlink {
+			content = hdr.Linkname
+		}
+		entries[hdr.Name] = archiveEntry{hdr.FileInfo().Mode(), content}
+	}
+
+	return entries, comment
+}
+
+func readZipArchive(t *testing.T, data []byte) (map[string]archiveEntry, string) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("could not read zip: %v", err)
+	}
+
+	entries := make(map[string]archiveEntry)
+	for _, f := range zr.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatalf("could not open %q in zip: %v", f.Name, err)
+		}
+
+		data, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatalf("could not read %q from zip: %v", f.Name, err)
+		}
+
+		entries[f.Name] = archiveEntry{f.Mode(), string(data)}
+	}
+
+	return entries, zr.Comment
+}
+
+func archiveNames(entries map[string]archiveEntry) []string {
+	var names []string
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func mkArchiveTestRepo(t *testing.T) (*testRepo, ObjectID) {
+	tr := mkTestRepo(t)
+
+	tr.write("README", "hello\n")
+	tr.write("bin/run.sh", "#!/bin/sh\n")
+	if err := os.Chmod(filepath.Join(tr.work, "bin", "run.sh"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	tr.symlink("link", "README")
+	tr.write("data/.gitattributes", "*.tmp export-ignore\n")
+	tr.write("data/keep.txt", "keep\n")
+	tr.write("data/scratch.tmp", "scratch\n")
+	tr.write("data/sub/other.tmp", "other\n")
+	tr.write(".gitattributes", "/secret/** export-ignore\n")
+	tr.write("secret/key", "key\n")
+	tr.symlink("data/a.dat", annexLink(1, annexKeyA))
+	tr.symlink("data/b.dat", annexLink(1, annexKeyB))
+
+	return tr, tr.commit("archive")
+}
+
+func TestWriteArchive(t *testing.T) {
+	tr, commit := mkArchiveTestRepo(t)
+	defer tr.cleanup()
+
+	//only the content of a is present
+	key, _ := AnnexExamineKey(annexKeyA)
+	adir := filepath.Join(tr.Path, "annex", "objects", key.HashDirMixed(), annexKeyA)
+	if err := os.MkdirAll(adir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(adir, annexKeyA), []byte("a"), 0444); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	err := tr.WriteArchive(&buf, commit, ArchiveOptions{Format: ArchiveTar, Prefix: "snap/"})
+	if err != nil {
+		t.Fatalf("WriteArchive(tar): %v", err)
+	}
+
+	//git archive must agree on the entries
+	git, _ := readTarArchive(t, bytes.NewReader(tr.gitRaw(nil, "archive", "--format=tar", "--prefix=snap/", "HEAD")))
+	entries, comment := readTarArchive(t, &buf)
+
+	if names := archiveNames(entries); !reflect.DeepEqual(names, archiveNames(git)) {
+		t.Fatalf("archive entries: got %v, expected %v", names, archiveNames(git))
+	}
+
+	if comment != commit.String() {
+		t.Errorf("tar comment: got %q, expected %q", comment, commit)
+	}
+
+	expected := map[string]archiveEntry{
+		"snap/README":        {0644, "hello\n"},
+		"snap/bin/run.sh":    {0755, "#!/bin/sh\n"},
+		"snap/link":          {os.ModeSymlink | 0777, "README"},
+		"snap/data/a.dat":    {os.ModeSymlink | 0777, annexLink(1, annexKeyA)},
+		"snap/data/keep.txt": {0644, "keep\n"},
+		"snap/data/sub/":     {os.ModeDir | 0755, ""},
+	}
+
+	for name, e := range expected {
+		if entries[name] != e {
+			t.Errorf("entry %q: got %v, expected %v", name, entries[name], e)
+		}
+	}
+
+	//annex content, as tar.gz and zip
+	opts := ArchiveOptions{Format: ArchiveTarGz, AnnexContent: true}
+	buf.Reset()
+	if err = tr.WriteArchive(&buf, commit, opts); err != nil {
+		t.Fatalf("WriteArchive(tar.gz): %v", err)
+	}
+
+	gz, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("could not read gzip: %v", err)
+	}
+	entries, _ = readTarArchive(t, gz)
+
+	opts.Format = ArchiveZip
+	buf.Reset()
+	if err = tr.WriteArchive(&buf, commit, opts); err != nil {
+		t.Fatalf("WriteArchive(zip): %v", err)
+	}
+	zentries, zcomment := readZipArchive(t, buf.Bytes())
+
+	if zcomment != commit.String() {
+		t.Errorf("zip comment: got %q, expected %q", zcomment, commit)
+	}
+
+	expected = map[string]archiveEntry{
+		"data/a.dat":     {0644, "a"},
+		"data/b.dat":     {os.ModeSymlink | 0777, annexLink(1, annexKeyB)},
+		"bin/run.sh":     {0755, "#!/bin/sh\n"},
+		"data/sub/":      {os.ModeDir | 0755, ""},
+		".gitattributes": {0644, "/secret/** export-ignore\n"},
+	}
+
+	for name, e := range expected {
+		if entries[name] != e {
+			t.Errorf("tar.gz entry %q: got %v, expected %v", name, entries[name], e)
+		}
+		if zentries[name] != e {
+			t.Errorf("zip entry %q: got %v, expected %v", name, zentries[name], e)
+		}
+	}
+
+	if !reflect.DeepEqual(archiveNames(entries), archiveNames(zentries)) {
+		t.Errorf("tar.gz and zip entries differ: %v, %v", archiveNames(entries), archiveNames(zentries))
+	}
+}
+
+func TestWriteArchiveTree(t *testing.T) {
+	tr, commit := mkArchiveTestRepo(t)
+	defer tr.cleanup()
+
+	if err := os.MkdirAll(filepath.Join(tr.Path, "info"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	err := ioutil.WriteFile(filepath.Join(tr.Path, "info", "attributes"), []byte("README export-ignore\n*.tmp -export-ignore\n"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mtime := time.Unix(1500000000, 0)
+	tree := tr.revParse("HEAD^{tree}")
+
+	var buf bytes.Buffer
+	err = tr.WriteArchive(&buf, tree, ArchiveOptions{MTime: mtime})
+	if err != nil {
+		t.Fatalf("WriteArchive: %v", err)
+	}
+
+	git, _ := readTarArchive(t, bytes.NewReader(tr.gitRaw(nil, "archive", "--format=tar", commit.String())))
+	entries, comment := readTarArchive(t, bytes.NewReader(buf.Bytes()))
+
+	if names := archiveNames(entries); !reflect.DeepEqual(names, archiveNames(git)) {
+		t.Fatalf("archive entries: got %v, expected %v", names, archiveNames(git))
+	}
+
+	if comment != "" {
+		t.Errorf("tree archive has comment %q", comment)
+	}
+
+	hdr, err := tar.NewReader(&buf).Next()
+	if err != nil {
+		t.Fatal(err)
+	} else if !hdr.ModTime.Equal(mtime) {
+		t.Errorf("mtime: got %v, expected %v", hdr.ModTime, mtime)
+	}
+
+	for _, prefix := range []string{"/abs/", "../up/", "a/../../b"} {
+		err = tr.WriteArchive(ioutil.Discard, tree, ArchiveOptions{Prefix: prefix})
+		if err == nil {
+			t.Errorf("WriteArchive with prefix %q: expected error", prefix)
+		}
+	}
+}
@@ -0,0 +1,416 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+//AttrState is the state of an attribute for a path.
+type AttrState uint8
+
+//AttrState values. An attribute is AttrSet by "attr", AttrUnset by
+//"-attr" and AttrString by "attr=value"; it is AttrUnspecified if
+//no pattern matches the path, or if "!attr" does.
+const (
+	AttrUnspecified AttrState = iota
+	AttrSet
+	AttrUnset
+	AttrString
+)
+
+//Attr is the state of an attribute and, for AttrString, its value.
+type Attr struct {
+	State AttrState
+	Value string
+}
+
+//String returns the attribute like git check-attr shows it.
+func (a Attr) String() string {
+	switch a.State {
+	case AttrSet:
+		return "set"
+	case AttrUnset:
+		return "unset"
+	case AttrString:
+		return a.Value
+	}
+	return "unspecified"
+}
+
+//Attributes are the attributes of a path. Only specified attributes
+//are in the map.
+type Attributes map[string]Attr
+
+//Get returns the attribute with the name, the zero Attr, which is
+//unspecified, if it is not in attrs.
+func (attrs Attributes) Get(name string) Attr {
+	return attrs[name]
+}
+
+//IsSet returns true if the attribute with the name is set.
+func (attrs Attributes) IsSet(name string) bool {
+	return attrs[name].State == AttrSet
+}
+
+//IsUnset returns true if the attribute with the name is unset.
+func (attrs Attributes) IsUnset(name string) bool {
+	return attrs[name].State == AttrUnset
+}
+
+//builtinMacros are the macros git always defines.
+const builtinMacros = "[attr]binary -diff -merge -text\n"
+
+//attrAssign is a single attribute of a line, e.g. "-diff".
+type attrAssign struct {
+	name string
+	attr Attr
+}
+
+//attrRule is a line of an attributes file, that assigns attrs to
+//the paths matching pattern.
+type attrRule struct {
+	pattern  []string //split into path components
+	anchored bool     //pattern is matched against the full path
+	attrs    []attrAssign
+}
+
+//attrFile is an attributes file in the directory base ("" for the
+//root). Macros are the ones it defines, which git only honours in
+//the root and in $GIT_DIR/info/attributes.
+type attrFile struct {
+	base   string
+	rules  []attrRule
+	macros map[string][]attrAssign
+}
+
+//parseAttrAssign parses an attribute of a line.
+func parseAttrAssign(s string) (attrAssign, bool) {
+	var a attrAssign
+	switch {
+	case strings.HasPrefix(s, "-"):
+		a = attrAssign{s[1:], Attr{State: AttrUnset}}
+	case strings.HasPrefix(s, "!"):
+		a = attrAssign{s[1:], Attr{State: AttrUnspecified}}
+	default:
+		name, value := split2(s, "=")
+		a = attrAssign{name, Attr{State: AttrSet}}
+		if strings.Contains(s, "=") {
+			a.attr = Attr{State: AttrString, Value: value}
+		}
+	}
+
+	return a, a.name != "" && !strings.ContainsAny(a.name, "=!")
+}
+
+//splitAttrLine returns the pattern of a line and the rest of it.
+//Patterns can be quoted like C strings.
+func splitAttrLine(line string) (string, string) {
+	line = strings.TrimLeft(line, " \t\r")
+	if strings.HasPrefix(line, "\"") {
+		for i := 1; i < len(line); i++ {
+			if line[i] == '\\' {
+				i++
+			} else if line[i] == '"' {
+				pattern, err := strconv.Unquote(line[:i+1])
+				if err != nil {
+					return "", ""
+				}
+				return pattern, line[i+1:]
+			}
+		}
+		return "", ""
+	}
+
+	if n := strings.IndexAny(line, " \t\r"); n != -1 {
+		return line[:n], line[n:]
+	}
+	return line, ""
+}
+
+//parseAttrFile parses the data of an attributes file in the
+//directory base. Invalid lines are ignored, like git does.
+func parseAttrFile(base string, data []byte) *attrFile {
+	af := &attrFile{base: base}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		pattern, rest := splitAttrLine(line)
+		if pattern == "" || strings.HasPrefix(pattern, "#") {
+			continue
+		}
+
+		var attrs []attrAssign
+		for _, field := range strings.Fields(rest) {
+			if a, ok := parseAttrAssign(field); ok {
+				attrs = append(attrs, a)
+			}
+		}
+
+		if strings.HasPrefix(pattern, "[attr]") {
+			if af.macros == nil {
+				af.macros = make(map[string][]attrAssign)
+			}
+			af.macros[pattern[6:]] = attrs
+			continue
+		}
+
+		//negative patterns are forbidden, and patterns for
+		//directories never match
+		if strings.HasPrefix(pattern, "!") || strings.HasSuffix(pattern, "/") {
+			continue
+		}
+
+		af.rules = append(af.rules, attrRule{
+			pattern:  strings.Split(strings.TrimPrefix(pattern, "/"), "/"),
+			anchored: strings.Contains(pattern, "/"),
+			attrs:    attrs,
+		})
+	}
+
+	return af
+}
+
+//match returns true if rule matches the path comps, which are
+//relative to the directory of the attributes file.
+func (rule *attrRule) match(comps []string) bool {
+	if !rule.anchored {
+		m, _ := path.Match(rule.pattern[0], comps[len(comps)-1])
+		return m
+	}
+	return matchComponents(rule.pattern, comps)
+}
+
+//matchComponents matches the path components against the pattern
+//components, where "**" matches any number of components, but at
+//least one at the end of the pattern.
+func matchComponents(pattern, comps []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			if len(pattern) == 1 {
+				return len(comps) > 0
+			}
+
+			for i := 0; i <= len(comps); i++ {
+				if matchComponents(pattern[1:], comps[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(comps) == 0 {
+			return false
+		} else if m, _ := path.Match(pattern[0], comps[0]); !m {
+			return false
+		}
+
+		pattern, comps = pattern[1:], comps[1:]
+	}
+
+	return len(comps) == 0
+}
+
+//AttrReader looks up the attributes of paths in a tree, from the
+//.gitattributes files in it and $GIT_DIR/info/attributes. Files
+//are read on first use and kept, so an AttrReader should be used
+//for many lookups in the same tree. It is not safe to use from
+//multiple goroutines.
+type AttrReader struct {
+	repo *Repository
+	tree ObjectID
+
+	info    *attrFile
+	macros  map[string][]attrAssign
+	files   map[string]*attrFile //by directory, nil if there is none
+	subdirs map[string]ObjectID  //tree ids of directories, zero if missing
+}
+
+//NewAttrReader returns an AttrReader for the tree of id, which can
+//be a commit, a tag or a tree.
+func (repo *Repository) NewAttrReader(id ObjectID) (*AttrReader, error) {
+	tree, err := repo.peelRevision(id, "tree")
+	if err != nil {
+		return nil, err
+	}
+
+	r := &AttrReader{
+		repo:    repo,
+		tree:    tree,
+		macros:  parseAttrFile("", []byte(builtinMacros)).macros,
+		files:   make(map[string]*attrFile),
+		subdirs: map[string]ObjectID{"": tree},
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(repo.Path, "info", "attributes"))
+	if err == nil {
+		r.info = parseAttrFile("", data)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	root, err := r.file("")
+	if err != nil {
+		return nil, err
+	}
+
+	//macros of info/attributes take precedence
+	for _, af := range []*attrFile{root, r.info} {
+		if af == nil {
+			continue
+		}
+		for name, attrs := range af.macros {
+			r.macros[name] = attrs
+		}
+	}
+
+	return r, nil
+}
+
+//PathAttributes returns the attributes of the path in the tree of
+//id. Use NewAttrReader for lookups of many paths.
+func (repo *Repository) PathAttributes(id ObjectID, pathstr string) (Attributes, error) {
+	r, err := repo.NewAttrReader(id)
+	if err != nil {
+		return nil, err
+	}
+	return r.Lookup(pathstr)
+}
+
+//dirTree returns the id of the tree of dir, zero if it does
+//not exist.
+func (r *AttrReader) dirTree(dir string) (ObjectID, error) {
+	if id, ok := r.subdirs[dir]; ok {
+		return id, nil
+	}
+
+	parent, name := path.Split(dir)
+	pid, err := r.dirTree(strings.TrimSuffix(parent, "/"))
+	if err != nil || pid.IsZero() {
+		return pid, err
+	}
+
+	tree, err := r.repo.openTree(pid)
+	if err != nil {
+		return ObjectID{}, err
+	}
+
+	entries, err := readTreeEntries(tree)
+	if err != nil {
+		return ObjectID{}, err
+	}
+
+	var id ObjectID
+	for _, e := range entries {
+		if e.Name == name && e.Type == ObjTree {
+			id = e.ID
+			break
+		}
+	}
+
+	r.subdirs[dir] = id
+	return id, nil
+}
+
+//file returns the .gitattributes file of dir, or nil.
+func (r *AttrReader) file(dir string) (*attrFile, error) {
+	if af, ok := r.files[dir]; ok {
+		return af, nil
+	}
+
+	id, err := r.dirTree(dir)
+	if err != nil || id.IsZero() {
+		return nil, err
+	}
+
+	tree, err := r.repo.openTree(id)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := readTreeEntries(tree)
+	if err != nil {
+		return nil, err
+	}
+
+	var af *attrFile
+	for _, e := range entries {
+		if e.Name != ".gitattributes" || fileKind(e.Mode) != 0100000 {
+			continue
+		}
+
+		data, big, err := r.repo.changeData(uint32(e.Mode), e.ID, 100*1024*1024)
+		if err != nil {
+			return nil, err
+		} else if !big {
+			af = parseAttrFile(dir, data)
+		}
+		break
+	}
+
+	r.files[dir] = af
+	return af, nil
+}
+
+//Lookup returns the attributes of the path, which is relative to
+//the root of the tree and need not exist. $GIT_DIR/info/attributes
+//takes precedence over the .gitattributes files, the ones in deeper
+//directories over those in their parents and later lines over
+//earlier ones.
+func (r *AttrReader) Lookup(pathstr string) (Attributes, error) {
+	pathstr = strings.Trim(path.Clean("/"+pathstr), "/")
+	comps := strings.Split(pathstr, "/")
+
+	files := []*attrFile{r.info}
+	for i := len(comps) - 1; i >= 0; i-- {
+		af, err := r.file(strings.Join(comps[:i], "/"))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, af)
+	}
+
+	found := make(Attributes)
+	for _, af := range files {
+		if af == nil {
+			continue
+		}
+
+		rel := comps
+		if af.base != "" {
+			rel = comps[strings.Count(af.base, "/")+1:]
+		}
+
+		for i := len(af.rules) - 1; i >= 0; i-- {
+			if af.rules[i].match(rel) {
+				r.fill(found, af.rules[i].attrs)
+			}
+		}
+	}
+
+	for name, attr := range found {
+		if attr.State == AttrUnspecified {
+			delete(found, name)
+		}
+	}
+
+	return found, nil
+}
+
+//fill assigns attrs that are not yet found, in reverse order, and
+//expands the macros among them that are set.
+func (r *AttrReader) fill(found Attributes, attrs []attrAssign) {
+	for i := len(attrs) - 1; i >= 0; i-- {
+		a := attrs[i]
+		if _, ok := found[a.name]; ok {
+			continue
+		}
+
+		found[a.name] = a.attr
+		if macro, ok := r.macros[a.name]; ok && a.attr.State == AttrSet {
+			r.fill(found, macro)
+		}
+	}
+}
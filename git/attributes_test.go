@@ -0,0 +1,159 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//gitCheckAttr returns the attributes of the path in the working
+//tree, as reported by git check-attr.
+func gitCheckAttr(tr *testRepo, p string) map[string]string {
+	attrs := make(map[string]string)
+	out := tr.git("check-attr", "-a", "--", p)
+	for _, line := range strings.Split(out, "\n") {
+		if line == "" {
+			continue
+		}
+		fields := strings.SplitN(strings.TrimPrefix(line, p+": "), ": ", 2)
+		attrs[fields[0]] = fields[1]
+	}
+	return attrs
+}
+
+var attrFiles = map[string]string{
+	".gitattributes": `# comment
+[attr]raw binary -text
+*.dat annex.largefiles=anything raw
+*.txt text diff eol=lf
+/top.md export-ignore
+docs/**/*.png binary
+"with space.txt" -text
+data/** filter=annex
+*.c diff=cpp
+`,
+	"data/.gitattributes": `*.dat !annex.largefiles -diff
+*.txt -diff
+sub/ export-ignore
+[attr]ignored foo
+*.csv ignored
+`,
+	"data/sub/.gitattributes": `*.txt diff
+`,
+}
+
+var attrPaths = []string{
+	"a.dat", "b.txt", "top.md", "sub/top.md", "docs/a/b/c.png", "docs/c.png",
+	"with space.txt", "data/x.dat", "data/x.txt", "data/sub/y.txt", "data/sub",
+	"data/z.csv", "src/main.c", "nonexistent/dir/file.dat", "",
+}
+
+func TestAttributes(t *testing.T) {
+	tr := mkTestRepo(t)
+	defer tr.cleanup()
+
+	for name, content := range attrFiles {
+		tr.write(name, content)
+	}
+	tr.write("data/sub/y.txt", "y\n")
+	commit := tr.commit("attributes")
+
+	if err := os.MkdirAll(filepath.Join(tr.Path, "info"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	err := ioutil.WriteFile(filepath.Join(tr.Path, "info", "attributes"), []byte("*.c -diff\n"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := tr.NewAttrReader(commit)
+	if err != nil {
+		t.Fatalf("NewAttrReader: %v", err)
+	}
+
+	for _, p := range attrPaths {
+		attrs, err := r.Lookup(p)
+		if err != nil {
+			t.Fatalf("Lookup(%q): %v", p, err)
+		}
+
+		got := make(map[string]string)
+		for name, attr := range attrs {
+			got[name] = attr.String()
+		}
+
+		if p == "" {
+			if len(got) != 0 {
+				t.Errorf("root has attributes %v", got)
+			}
+			continue
+		}
+
+		if expected := gitCheckAttr(tr, p); !reflect.DeepEqual(got, expected) {
+			t.Errorf("Lookup(%q): got %v, expected %v", p, got, expected)
+		}
+	}
+
+	attrs, err := tr.PathAttributes(commit, "a.dat")
+	if err != nil {
+		t.Fatalf("PathAttributes: %v", err)
+	}
+
+	if lf := attrs.Get("annex.largefiles"); lf.State != AttrString || lf.Value != "anything" {
+		t.Errorf("annex.largefiles: got %+v", lf)
+	}
+
+	if !attrs.IsUnset("diff") || attrs.IsSet("diff") || attrs.Get("merge").State != AttrUnset {
+		t.Errorf("binary macro not expanded: %v", attrs)
+	}
+}
+
+func TestDiffAttributes(t *testing.T) {
+	tr := mkTestRepo(t)
+	defer tr.cleanup()
+
+	tr.write(".gitattributes", "*.bin binary\n*.txt diff\n")
+	tr.write("a.bin", "a\n")
+	tr.write("b.txt", "b\x00\n")
+	base := tr.commit("base")
+
+	tr.write("a.bin", "aa\n")
+	tr.write("b.txt", "bb\x00\n")
+	head := tr.commit("head")
+
+	opts := DefaultDiffOptions
+	var err error
+	if opts.Attributes, err = tr.NewAttrReader(head); err != nil {
+		t.Fatalf("NewAttrReader: %v", err)
+	}
+
+	ta, err := tr.openTree(tr.revParse(base.String() + "^{tree}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tb, err := tr.openTree(tr.revParse(head.String() + "^{tree}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diffs, err := tr.DiffFiles(ta, tb, opts)
+	if err != nil {
+		t.Fatalf("DiffFiles: %v", err)
+	}
+
+	for _, fd := range diffs {
+		switch fd.Change.Path {
+		case "a.bin":
+			if !fd.Binary {
+				t.Errorf("a.bin: expected a binary diff")
+			}
+		case "b.txt":
+			if fd.Binary || len(fd.Hunks) != 1 {
+				t.Errorf("b.txt: expected a text diff, got %+v", fd.BlobDiff)
+			}
+		}
+	}
+}
@@ -0,0 +1,580 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+//DiffAlgorithm selects the algorithm used to compare lines.
+type DiffAlgorithm uint8
+
+//DiffMyers is the default algorithm of git, DiffHistogram anchors the
+//diff at lines that occur rarely, which often reads better for source
+//code. Both give the same results as git diff.
+const (
+	DiffMyers DiffAlgorithm = iota
+	DiffHistogram
+)
+
+//DiffOptions control the line diff of DiffBlobs.
+type DiffOptions struct {
+	//Context is the number of unchanged lines shown around changes.
+	Context int
+
+	Algorithm DiffAlgorithm
+
+	//MaxSize is the size above which files are treated as binary,
+	//like core.bigFileThreshold of git. Zero means no limit.
+	MaxSize int64
+
+	//Attributes, if not nil, are used by DiffChange and DiffFiles
+	//to look up the diff attribute of paths: files with it unset,
+	//e.g. by the binary macro, are binary, files with it set are
+	//always text.
+	Attributes *AttrReader
+}
+
+//DefaultDiffOptions match the defaults of git diff.
+var DefaultDiffOptions = DiffOptions{
+	Context:   3,
+	Algorithm: DiffMyers,
+	MaxSize:   16 * 1024 * 1024,
+}
+
+//DiffOp is the kind of a line in a hunk.
+type DiffOp byte
+
+//DiffOp values, which are the prefixes of the lines in a unified diff.
+const (
+	DiffContext DiffOp = ' '
+	DiffAdd     DiffOp = '+'
+	DiffDelete  DiffOp = '-'
+)
+
+//DiffLine is a single line of a hunk. Text does not include the
+//newline, NoNewline is true for the last line of a file that does
+//not end with a newline.
+type DiffLine struct {
+	Op        DiffOp
+	Text      string
+	NoNewline bool
+}
+
+//Hunk is a group of changed lines and their context. Start lines are
+//1-based; for an empty range they are the line before it, like in
+//unified diffs. Section is the last line before the hunk that looks
+//like the start of a function or section.
+type Hunk struct {
+	OldStart int
+	OldLines int
+	NewStart int
+	NewLines int
+	Section  string
+
+	Lines []DiffLine
+}
+
+//AnnexChange is the change of an annexed file, i.e. of the annex
+//key its symlink (or pointer file) refers to. Old or New is nil if
+//the file was added or deleted.
+type AnnexChange struct {
+	Old *AnnexKey
+	New *AnnexKey
+}
+
+//BlobDiff is the difference of the contents of two blobs. Binary
+//files and annexed files have no hunks.
+type BlobDiff struct {
+	Binary bool
+	Annex  *AnnexChange
+	Hunks  []Hunk
+
+	Added   int
+	Deleted int
+}
+
+//FileDiff is the difference of a single path, as given by change.
+type FileDiff struct {
+	Change TreeChange
+	*BlobDiff
+}
+
+//isBinary reports whether data looks binary, which is the case if
+//there is a NUL byte in the first 8000 bytes, like git decides.
+func isBinary(data []byte) bool {
+	if len(data) > 8000 {
+		data = data[:8000]
+	}
+	return bytes.IndexByte(data, 0) != -1
+}
+
+//annexPointerKey returns the annex key if data is the target of a
+//symlink to an annexed file or the content of an unlocked annexed
+//file (a pointer file).
+func annexPointerKey(data []byte) (string, bool) {
+	if len(data) > 1024 || isBinary(data) {
+		return "", false
+	}
+
+	text := strings.TrimSuffix(string(data), "\n")
+	if strings.ContainsAny(text, "\n") {
+		return "", false
+	}
+
+	if strings.HasPrefix(text, "/annex/objects/") {
+		return text[strings.LastIndex(text, "/")+1:], true
+	}
+
+	return annexKeyOfTarget(text)
+}
+
+//splitLines splits data into lines, including their newline.
+func splitLines(data []byte) []string {
+	var lines []string
+	for len(data) > 0 {
+		n := bytes.IndexByte(data, '\n') + 1
+		if n == 0 {
+			n = len(data)
+		}
+		lines = append(lines, string(data[:n]))
+		data = data[n:]
+	}
+	return lines
+}
+
+func readBlobData(blob *Blob, max int64) ([]byte, bool, error) {
+	if blob == nil {
+		return nil, false, nil
+	}
+
+	if max > 0 && blob.Size() > max {
+		return nil, true, nil
+	}
+
+	data, err := ioutil.ReadAll(blob)
+	return data, false, err
+}
+
+//DiffBlobs compares the content of the blobs a and b, either of which
+//may be nil for an added or deleted file. The blobs are read but not
+//closed.
+func DiffBlobs(a, b *Blob, opts DiffOptions) (*BlobDiff, error) {
+	da, bigA, err := readBlobData(a, opts.MaxSize)
+	if err != nil {
+		return nil, err
+	}
+
+	db, bigB, err := readBlobData(b, opts.MaxSize)
+	if err != nil {
+		return nil, err
+	}
+
+	if bigA || bigB {
+		return &BlobDiff{Binary: true}, nil
+	}
+
+	return diffData(da, db, a != nil, b != nil, AttrUnspecified, opts), nil
+}
+
+//annexChange returns the change of annex keys, if both sides that
+//exist are annex pointers.
+func annexChange(a, b []byte, hasA, hasB bool) *AnnexChange {
+	var change AnnexChange
+	for _, side := range []struct {
+		data []byte
+		has  bool
+		key  **AnnexKey
+	}{{a, hasA, &change.Old}, {b, hasB, &change.New}} {
+		if !side.has {
+			continue
+		}
+
+		keystr, ok := annexPointerKey(side.data)
+		if !ok {
+			return nil
+		}
+
+		key, err := AnnexExamineKey(keystr)
+		if err != nil {
+			return nil
+		}
+		*side.key = key
+	}
+
+	if change.Old == nil && change.New == nil {
+		return nil
+	}
+
+	return &change
+}
+
+//diffData compares the data of two blobs, diff is the state of the
+//diff attribute of their path.
+func diffData(a, b []byte, hasA, hasB bool, diff AttrState, opts DiffOptions) *BlobDiff {
+	if annex := annexChange(a, b, hasA, hasB); annex != nil {
+		return &BlobDiff{Annex: annex}
+	}
+
+	if diff == AttrUnset || diff != AttrSet && (isBinary(a) || isBinary(b)) {
+		return &BlobDiff{Binary: true}
+	}
+
+	//without context git leaves out the common end of the files,
+	//which makes a difference for where changes are placed
+	if opts.Context == 0 {
+		a, b = trimCommonTail(a, b)
+	}
+
+	la, lb := splitLines(a), splitLines(b)
+	ia, ib := internLines(la, lb)
+
+	d := newLineDiff(ia, ib)
+	if opts.Algorithm == DiffHistogram {
+		d.histogram(0, len(ia), 0, len(ib))
+	} else {
+		d.myers(0, len(ia), 0, len(ib))
+	}
+	fa := &diffFile{ids: ia, lines: la, changed: d.ca}
+	fb := &diffFile{ids: ib, lines: lb, changed: d.cb}
+	compactChanges(fa, fb)
+	compactChanges(fb, fa)
+
+	return buildHunks(la, lb, d.ca, d.cb, opts.Context)
+}
+
+//trimCommonTail removes the common end of a and b, in blocks of
+//1024 bytes but ending on a complete line, like git does.
+func trimCommonTail(a, b []byte) ([]byte, []byte) {
+	const blk = 1024
+	smaller := len(a)
+	if len(b) < smaller {
+		smaller = len(b)
+	}
+
+	trimmed := 0
+	for blk+trimmed <= smaller && bytes.Equal(a[len(a)-trimmed-blk:len(a)-trimmed], b[len(b)-trimmed-blk:len(b)-trimmed]) {
+		trimmed += blk
+	}
+
+	//keep the rest of the last line
+	recovered := 0
+	for recovered < trimmed {
+		recovered++
+		if a[len(a)-trimmed+recovered-1] == '\n' {
+			break
+		}
+	}
+
+	n := trimmed - recovered
+	return a[:len(a)-n], b[:len(b)-n]
+}
+
+//diffEdit is a line of the edit script, the index into a, b or both.
+type diffEdit struct {
+	op   DiffOp
+	i, j int
+}
+
+func buildHunks(la, lb []string, ca, cb []bool, context int) *BlobDiff {
+	if context < 0 {
+		context = 0
+	}
+
+	var edits []diffEdit
+	var changes []int //indices into edits
+	res := &BlobDiff{}
+
+	for i, j := 0, 0; i < len(la) || j < len(lb); {
+		switch {
+		case i < len(la) && ca[i]:
+			changes = append(changes, len(edits))
+			edits = append(edits, diffEdit{DiffDelete, i, j})
+			res.Deleted++
+			i++
+		case j < len(lb) && cb[j]:
+			changes = append(changes, len(edits))
+			edits = append(edits, diffEdit{DiffAdd, i, j})
+			res.Added++
+			j++
+		default:
+			edits = append(edits, diffEdit{DiffContext, i, j})
+			i++
+			j++
+		}
+	}
+
+	for k := 0; k < len(changes); {
+		//extend the hunk while the next change is close enough
+		first, last := changes[k], changes[k]
+		for k++; k < len(changes) && changes[k]-last <= 2*context+1; k++ {
+			last = changes[k]
+		}
+
+		start, end := first-context, last+context+1
+		if start < 0 {
+			start = 0
+		}
+		if end > len(edits) {
+			end = len(edits)
+		}
+
+		res.Hunks = append(res.Hunks, makeHunk(la, lb, edits[start:end]))
+	}
+
+	return res
+}
+
+func makeHunk(la, lb []string, edits []diffEdit) Hunk {
+	h := Hunk{OldStart: edits[0].i, NewStart: edits[0].j}
+
+	for _, e := range edits {
+		var line string
+		switch e.op {
+		case DiffDelete:
+			line = la[e.i]
+			h.OldLines++
+		case DiffAdd:
+			line = lb[e.j]
+			h.NewLines++
+		default:
+			line = la[e.i]
+			h.OldLines++
+			h.NewLines++
+		}
+
+		text := strings.TrimSuffix(line, "\n")
+		h.Lines = append(h.Lines, DiffLine{Op: e.op, Text: text, NoNewline: len(text) == len(line)})
+	}
+
+	//empty ranges start at the line before them
+	if h.OldLines > 0 {
+		h.OldStart++
+	}
+	if h.NewLines > 0 {
+		h.NewStart++
+	}
+
+	h.Section = findSection(la, edits[0].i)
+	return h
+}
+
+//findSection searches backwards from line end (exclusive) of the old
+//file for a line starting with a letter, '_' or '$', which is how git
+//finds the function name shown in hunk headers by default.
+func findSection(lines []string, end int) string {
+	for i := end - 1; i >= 0; i-- {
+		l := lines[i]
+		if l == "" {
+			continue
+		}
+
+		if c := l[0]; ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || c == '_' || c == '$' {
+			if len(l) > 80 {
+				l = l[:80]
+			}
+			return strings.TrimRight(l, " \t\r\n\v\f")
+		}
+	}
+
+	return ""
+}
+
+//subprojectData is the content git shows for a submodule.
+func subprojectData(id ObjectID) []byte {
+	return []byte(fmt.Sprintf("Subproject commit %s\n", id))
+}
+
+//changeData returns the content of one side of a change.
+func (repo *Repository) changeData(mode uint32, id ObjectID, max int64) ([]byte, bool, error) {
+	if mode == 0160000 {
+		return subprojectData(id), false, nil
+	}
+
+	obj, err := repo.OpenObject(id)
+	if err != nil {
+		return nil, false, err
+	}
+	defer obj.Close()
+
+	blob, ok := obj.(*Blob)
+	if !ok {
+		return nil, false, fmt.Errorf("git: %s is a %s, not a blob", id, obj.Type())
+	}
+
+	return readBlobData(blob, max)
+}
+
+//DiffChange computes the line diff of a change from DiffTrees (or
+//DetectRenames). Submodules are shown as the commit they refer to,
+//like git does.
+func (repo *Repository) DiffChange(change TreeChange, opts DiffOptions) (*FileDiff, error) {
+	var a, b []byte
+	var bigA, bigB bool
+	var err error
+
+	hasA, hasB := !change.OldID.IsZero(), !change.NewID.IsZero()
+
+	if hasA {
+		if a, bigA, err = repo.changeData(uint32(change.OldMode), change.OldID, opts.MaxSize); err != nil {
+			return nil, err
+		}
+	}
+
+	if hasB {
+		if b, bigB, err = repo.changeData(uint32(change.NewMode), change.NewID, opts.MaxSize); err != nil {
+			return nil, err
+		}
+	}
+
+	var diff AttrState
+	if opts.Attributes != nil {
+		attrs, err := opts.Attributes.Lookup(change.Path)
+		if err != nil {
+			return nil, err
+		}
+		diff = attrs.Get("diff").State
+	}
+
+	fd := &FileDiff{Change: change}
+	switch {
+	case change.OldID == change.NewID:
+		fd.BlobDiff = &BlobDiff{}
+	case bigA || bigB:
+		fd.BlobDiff = &BlobDiff{Binary: true}
+	default:
+		fd.BlobDiff = diffData(a, b, hasA, hasB, diff, opts)
+	}
+
+	return fd, nil
+}
+
+//abbrev returns the abbreviated id, as used in the index line.
+func abbrev(id ObjectID) string {
+	return id.String()[:7]
+}
+
+func annexKeyString(key *AnnexKey) string {
+	if key == nil {
+		return "/dev/null"
+	}
+
+	if strings.Contains(key.Key, "-s") {
+		return fmt.Sprintf("%s (%d bytes)", key.Key, key.Bytesize)
+	}
+
+	return fmt.Sprintf("%s (unknown size)", key.Key)
+}
+
+//WriteUnified writes the diff in the unified format of git diff.
+//Annexed files are shown as the change of their key instead of the
+//change of the symlink.
+func (fd *FileDiff) WriteUnified(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	c := &fd.Change
+
+	oldPath, newPath := c.Path, c.Path
+	if c.OldPath != "" {
+		oldPath = c.OldPath
+	}
+
+	fmt.Fprintf(bw, "diff --git a/%s b/%s\n", oldPath, newPath)
+
+	switch {
+	case c.OldID.IsZero():
+		fmt.Fprintf(bw, "new file mode %06o\n", uint32(c.NewMode))
+	case c.NewID.IsZero():
+		fmt.Fprintf(bw, "deleted file mode %06o\n", uint32(c.OldMode))
+	case c.OldMode != c.NewMode:
+		fmt.Fprintf(bw, "old mode %06o\nnew mode %06o\n", uint32(c.OldMode), uint32(c.NewMode))
+	}
+
+	if c.Type == ChangeRename || c.Type == ChangeCopy {
+		verb := "rename"
+		if c.Type == ChangeCopy {
+			verb = "copy"
+		}
+		fmt.Fprintf(bw, "similarity index %d%%\n%s from %s\n%s to %s\n", c.Similarity, verb, oldPath, verb, newPath)
+	}
+
+	if c.OldID != c.NewID {
+		fmt.Fprintf(bw, "index %s..%s", abbrev(c.OldID), abbrev(c.NewID))
+		if c.OldMode == c.NewMode {
+			fmt.Fprintf(bw, " %06o", uint32(c.OldMode))
+		}
+		fmt.Fprintf(bw, "\n")
+	}
+
+	from, to := "a/"+oldPath, "b/"+newPath
+	if c.OldID.IsZero() {
+		from = "/dev/null"
+	}
+	if c.NewID.IsZero() {
+		to = "/dev/null"
+	}
+
+	switch {
+	case fd.BlobDiff == nil || c.OldID == c.NewID:
+	case fd.Annex != nil:
+		fmt.Fprintf(bw, "Annexed file %s => %s\n", annexKeyString(fd.Annex.Old), annexKeyString(fd.Annex.New))
+	case fd.Binary:
+		fmt.Fprintf(bw, "Binary files %s and %s differ\n", from, to)
+	case len(fd.Hunks) > 0:
+		fmt.Fprintf(bw, "--- %s\n+++ %s\n", from, to)
+		for i := range fd.Hunks {
+			writeHunk(bw, &fd.Hunks[i])
+		}
+	}
+
+	return bw.Flush()
+}
+
+func hunkRange(start, lines int) string {
+	if lines == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, lines)
+}
+
+func writeHunk(w *bufio.Writer, h *Hunk) {
+	fmt.Fprintf(w, "@@ -%s +%s @@", hunkRange(h.OldStart, h.OldLines), hunkRange(h.NewStart, h.NewLines))
+	if h.Section != "" {
+		fmt.Fprintf(w, " %s", h.Section)
+	}
+	w.WriteByte('\n')
+
+	for _, l := range h.Lines {
+		w.WriteByte(byte(l.Op))
+		w.WriteString(l.Text)
+		w.WriteByte('\n')
+		if l.NoNewline {
+			w.WriteString("\\ No newline at end of file\n")
+		}
+	}
+}
+
+//DiffFiles compares the trees a and b, either of which may be nil for
+//the empty tree, with rename detection and returns the line diffs of
+//all changed paths. The trees are consumed and closed.
+func (repo *Repository) DiffFiles(a, b *Tree, opts DiffOptions) ([]*FileDiff, error) {
+	changes, err := repo.DiffTrees(a, b)
+	if err != nil {
+		return nil, err
+	}
+
+	if changes, err = repo.DetectRenames(changes, DefaultRenameOptions); err != nil {
+		return nil, err
+	}
+
+	diffs := make([]*FileDiff, len(changes))
+	for i, c := range changes {
+		if diffs[i], err = repo.DiffChange(c, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	return diffs, nil
+}
@@ -0,0 +1,294 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+//applyHunks applies the hunks of d to a, for checking that
+//the hunks describe the change of a to b.
+func applyHunks(t *testing.T, a []string, d *BlobDiff) []string {
+	var res []string
+	pos := 0
+
+	for _, h := range d.Hunks {
+		start := h.OldStart - 1
+		if h.OldLines == 0 {
+			start = h.OldStart
+		}
+
+		if start < pos {
+			t.Fatalf("hunks overlap: %+v", d.Hunks)
+		}
+		res = append(res, a[pos:start]...)
+		pos = start
+
+		for _, l := range h.Lines {
+			switch l.Op {
+			case DiffContext, DiffDelete:
+				if a[pos] != l.Text+"\n" {
+					t.Fatalf("hunk line %q does not match %q", l.Text, a[pos])
+				}
+				pos++
+			}
+
+			if l.Op != DiffDelete {
+				res = append(res, l.Text+"\n")
+			}
+		}
+	}
+
+	return append(res, a[pos:]...)
+}
+
+func TestDiffLines(t *testing.T) {
+	rnd := rand.New(rand.NewSource(42))
+	alphabet := []string{"a\n", "b\n", "c\n", "}\n", "\n", "func x() {\n"}
+
+	mkFile := func(n int) []string {
+		lines := make([]string, n)
+		for i := range lines {
+			lines[i] = alphabet[rnd.Intn(len(alphabet))]
+		}
+		return lines
+	}
+
+	for i := 0; i < 500; i++ {
+		a := mkFile(rnd.Intn(40))
+		b := append([]string{}, a...)
+
+		//some edits, to get realistic diffs
+		for k := rnd.Intn(6); k > 0 && len(b) > 0; k-- {
+			p := rnd.Intn(len(b))
+			switch rnd.Intn(3) {
+			case 0:
+				b = append(b[:p], b[p+1:]...)
+			case 1:
+				b = append(b[:p], append(mkFile(rnd.Intn(4)), b[p:]...)...)
+			default:
+				b[p] = alphabet[rnd.Intn(len(alphabet))]
+			}
+		}
+
+		if i%5 == 0 {
+			b = mkFile(rnd.Intn(40))
+		}
+
+		for _, algo := range []DiffAlgorithm{DiffMyers, DiffHistogram} {
+			for _, context := range []int{0, 1, 3} {
+				opts := DiffOptions{Context: context, Algorithm: algo}
+				d := diffData([]byte(strings.Join(a, "")), []byte(strings.Join(b, "")), true, true, AttrUnspecified, opts)
+
+				applied := applyHunks(t, a, d)
+				if strings.Join(applied, "") != strings.Join(b, "") {
+					t.Fatalf("%d (algorithm %d, context %d): applying the hunks to %q gives %q, expected %q",
+						i, algo, context, a, applied, b)
+				}
+
+				if d.Added-d.Deleted != len(b)-len(a) {
+					t.Fatalf("%d: %d added and %d deleted lines for %d and %d lines", i, d.Added, d.Deleted, len(a), len(b))
+				}
+			}
+		}
+	}
+}
+
+//hunkHeaders strips the sections from the hunk headers of a diff.
+var hunkHeaders = regexp.MustCompile(`(?m)^(@@ [^@]* @@).*$`)
+
+func TestDiffLinesGit(t *testing.T) {
+	rnd := rand.New(rand.NewSource(23))
+	alphabet := []string{"a\n", "b\n", "c\n", "d\n", "}\n", "\n", "func x() {\n", "\treturn\n"}
+
+	mkFile := func(n int) []string {
+		lines := make([]string, n)
+		for i := range lines {
+			lines[i] = alphabet[rnd.Intn(len(alphabet))]
+		}
+		return lines
+	}
+
+	dir, err := ioutil.TempDir("", "gin-repo-diff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for i := 0; i < 200; i++ {
+		a := mkFile(rnd.Intn(80))
+		b := append([]string{}, a...)
+
+		for k := rnd.Intn(12); k > 0 && len(b) > 0; k-- {
+			p := rnd.Intn(len(b))
+			switch rnd.Intn(3) {
+			case 0:
+				b = append(b[:p], b[p+1:]...)
+			case 1:
+				b = append(b[:p], append(mkFile(rnd.Intn(6)), b[p:]...)...)
+			default:
+				b[p] = alphabet[rnd.Intn(len(alphabet))]
+			}
+		}
+
+		da, db := []byte(strings.Join(a, "")), []byte(strings.Join(b, ""))
+		ioutil.WriteFile(filepath.Join(dir, "a"), da, 0644)
+		ioutil.WriteFile(filepath.Join(dir, "b"), db, 0644)
+
+		for _, algo := range []DiffAlgorithm{DiffMyers, DiffHistogram} {
+			name := map[DiffAlgorithm]string{DiffMyers: "myers", DiffHistogram: "histogram"}[algo]
+
+			//exits with 1 if the files differ
+			cmd := exec.Command("git", "diff", "--no-index", "--no-color", "--diff-algorithm="+name, "a", "b")
+			cmd.Dir = dir
+			out, _ := cmd.Output()
+
+			expected := ""
+			if n := bytes.Index(out, []byte("\n@@ ")); n != -1 {
+				expected = string(out[n+1:])
+			}
+
+			var buf bytes.Buffer
+			w := bufio.NewWriter(&buf)
+			d := diffData(da, db, true, true, AttrUnspecified, DiffOptions{Context: 3, Algorithm: algo})
+			for i := range d.Hunks {
+				writeHunk(w, &d.Hunks[i])
+			}
+			w.Flush()
+
+			actual := hunkHeaders.ReplaceAllString(buf.String(), "$1")
+			expected = hunkHeaders.ReplaceAllString(expected, "$1")
+			if actual != expected {
+				t.Fatalf("%d, %s: diff differs from git diff:\n%s\n--- expected ---\n%s", i, name, actual, expected)
+			}
+		}
+	}
+}
+
+//unifiedDiff writes the diff of two commits like git diff.
+func (tr *testRepo) unifiedDiff(c1, c2 ObjectID, opts DiffOptions) string {
+	changes := tr.diffRenames(c1, c2, DefaultRenameOptions)
+
+	var buf bytes.Buffer
+	for _, c := range changes {
+		fd, err := tr.DiffChange(c, opts)
+		if err != nil {
+			tr.t.Fatalf("DiffChange(%s) => %v", c.Path, err)
+		}
+
+		if err = fd.WriteUnified(&buf); err != nil {
+			tr.t.Fatal(err)
+		}
+	}
+
+	return buf.String()
+}
+
+func TestDiffChange(t *testing.T) {
+	tr := mkTestRepo(t)
+	defer tr.cleanup()
+
+	var code []string
+	for i := 0; i < 30; i++ {
+		code = append(code, fmt.Sprintf("func f%d() {", i), fmt.Sprintf("\treturn %d", i), "}", "")
+	}
+
+	tr.write("code.go", strings.Join(code, "\n"))
+	tr.write("deleted.txt", "deleted\nfile\n")
+	tr.write("noeol.txt", "first\nlast")
+	tr.write("binary.bin", "bin\x00ary")
+	tr.write("empty", "")
+	tr.write("renamed-old.txt", strings.Repeat("same content\n", 5)+"old\n")
+	c1 := tr.commit("first")
+
+	code[5] = "\treturn -1"
+	code = append(code[:40], code[44:]...)
+	code = append(code[:80], append([]string{"func added() {", "}", ""}, code[80:]...)...)
+	tr.write("code.go", strings.Join(code, "\n")+"\n")
+	os.Remove(filepath.Join(tr.work, "deleted.txt"))
+	os.Remove(filepath.Join(tr.work, "renamed-old.txt"))
+	tr.write("renamed-new.txt", strings.Repeat("same content\n", 5)+"new\n")
+	tr.write("noeol.txt", "first\nchanged")
+	tr.write("binary.bin", "bin\x00ary changed")
+	tr.write("added.txt", "added\n")
+	tr.write("empty", "not empty\n")
+	c2 := tr.commit("second")
+
+	for _, algo := range []string{"myers", "histogram"} {
+		opts := DefaultDiffOptions
+		if algo == "histogram" {
+			opts.Algorithm = DiffHistogram
+		}
+
+		for _, context := range []int{3, 0, 10} {
+			opts.Context = context
+
+			actual := tr.unifiedDiff(c1, c2, opts)
+			expected := tr.git("diff", "--no-color", "--no-ext-diff", "-M", "--diff-algorithm="+algo,
+				fmt.Sprintf("-U%d", context), c1.String(), c2.String()) + "\n"
+
+			if actual != expected {
+				t.Fatalf("%s -U%d: diff differs from git diff:\n%s\n--- expected ---\n%s", algo, context, actual, expected)
+			}
+		}
+	}
+}
+
+func TestDiffAnnex(t *testing.T) {
+	tr := mkTestRepo(t)
+	defer tr.cleanup()
+
+	old := "SHA256E-s1024--0b8c9a9e2a8b5a1f0b9c0e2d3f4a5b6c7d8e9f00112233445566778899aabbcc.dat"
+	cur := "SHA256E-s2048--ffeeddccbbaa99887766554433221100ffeeddccbbaa99887766554433221100.dat"
+	target := func(key string) string {
+		return "../.git/annex/objects/Xk/3q/" + key + "/" + key
+	}
+
+	os.MkdirAll(filepath.Join(tr.work, "raw"), 0755)
+	link := filepath.Join(tr.work, "raw", "data.dat")
+	if err := os.Symlink(target(old), link); err != nil {
+		t.Fatal(err)
+	}
+	tr.write("unlocked.dat", "/annex/objects/"+old+"\n")
+	c1 := tr.commit("annexed")
+
+	os.Remove(link)
+	if err := os.Symlink(target(cur), link); err != nil {
+		t.Fatal(err)
+	}
+	tr.write("unlocked.dat", "/annex/objects/"+cur+"\n")
+	c2 := tr.commit("changed")
+
+	changes := tr.diffRenames(c1, c2, DefaultRenameOptions)
+	if len(changes) != 2 {
+		t.Fatalf("expected two changes, got %+v", changes)
+	}
+
+	for _, c := range changes {
+		fd, err := tr.DiffChange(c, DefaultDiffOptions)
+		if err != nil {
+			t.Fatalf("DiffChange() => %v", err)
+		}
+
+		if fd.Annex == nil || fd.Annex.Old.Key != old || fd.Annex.New.Key != cur || len(fd.Hunks) != 0 {
+			t.Fatalf("%s: expected an annex key change, got %+v", c.Path, fd.BlobDiff)
+		}
+
+		if fd.Annex.Old.Bytesize != 1024 || fd.Annex.New.Bytesize != 2048 {
+			t.Fatalf("%s: unexpected sizes: %d, %d", c.Path, fd.Annex.Old.Bytesize, fd.Annex.New.Bytesize)
+		}
+	}
+
+	out := tr.unifiedDiff(c1, c2, DefaultDiffOptions)
+	if !strings.Contains(out, fmt.Sprintf("Annexed file %s (1024 bytes) => %s (2048 bytes)\n", old, cur)) {
+		t.Fatalf("unexpected unified diff:\n%s", out)
+	}
+}
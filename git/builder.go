@@ -0,0 +1,368 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+//TreeBuilder creates new trees by editing an existing tree (or an
+//empty one) at arbitrary paths. Changes are kept in memory until
+//Write is called, which then only writes the trees that changed.
+type TreeBuilder struct {
+	repo *Repository
+	root *treeNode
+}
+
+type treeNode struct {
+	id      ObjectID //id of the tree as it is on disk, if any
+	exists  bool
+	entries map[string]*treeItem //nil until loaded
+	dirty   bool
+}
+
+type treeItem struct {
+	mode os.FileMode
+	id   ObjectID
+	node *treeNode //set for trees once they are edited
+}
+
+//NewTreeBuilder returns a TreeBuilder that starts with an empty tree.
+func (repo *Repository) NewTreeBuilder() *TreeBuilder {
+	return &TreeBuilder{repo, &treeNode{entries: make(map[string]*treeItem), dirty: true}}
+}
+
+//EditTree returns a TreeBuilder that starts with the tree with the
+//given id. Commits and tags are peeled to their trees.
+func (repo *Repository) EditTree(id ObjectID) (*TreeBuilder, error) {
+	id, err := repo.peelRevision(id, "tree")
+	if err != nil {
+		return nil, err
+	}
+
+	return &TreeBuilder{repo, &treeNode{id: id, exists: true}}, nil
+}
+
+func (b *TreeBuilder) load(node *treeNode) error {
+	if node.entries != nil {
+		return nil
+	}
+
+	node.entries = make(map[string]*treeItem)
+	if !node.exists {
+		return nil
+	}
+
+	obj, err := b.repo.OpenObject(node.id)
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+
+	tree, ok := obj.(*Tree)
+	if !ok {
+		return fmt.Errorf("git: %s is a %s, not a tree", node.id, obj.Type())
+	}
+
+	for tree.Next() {
+		entry := tree.Entry()
+		node.entries[entry.Name] = &treeItem{mode: entry.Mode, id: entry.ID}
+	}
+
+	return tree.Err()
+}
+
+func splitTreePath(pathstr string) ([]string, error) {
+	comps := strings.Split(strings.Trim(pathstr, "/"), "/")
+	for _, c := range comps {
+		if c == "" || c == "." || c == ".." || strings.ContainsRune(c, 0) {
+			return nil, fmt.Errorf("git: invalid path %q", pathstr)
+		}
+	}
+	return comps, nil
+}
+
+//walk returns the tree node for the directory dirs, marking all
+//nodes on the way as modified. Missing directories are created if
+//create is true, otherwise an error is returned.
+func (b *TreeBuilder) walk(dirs []string, create bool) (*treeNode, error) {
+	node := b.root
+	for i, name := range dirs {
+		if err := b.load(node); err != nil {
+			return nil, err
+		}
+		node.dirty = true
+
+		item, ok := node.entries[name]
+		if !ok && !create {
+			return nil, &os.PathError{Op: "edit tree", Path: path.Join(dirs[:i+1]...), Err: os.ErrNotExist}
+		} else if !ok {
+			item = &treeItem{mode: 040000, node: &treeNode{entries: make(map[string]*treeItem)}}
+			node.entries[name] = item
+		} else if item.mode != 040000 {
+			return nil, &os.PathError{Op: "edit tree", Path: path.Join(dirs[:i+1]...),
+				Err: fmt.Errorf("not a directory")}
+		} else if item.node == nil {
+			item.node = &treeNode{id: item.id, exists: true}
+		}
+
+		node = item.node
+	}
+
+	if err := b.load(node); err != nil {
+		return nil, err
+	}
+	node.dirty = true
+
+	return node, nil
+}
+
+//lookup returns the mode of the entry at path, or false if there is
+//none. Directories whose entries were all removed do not count.
+//Unlike walk it does not mark any trees as modified.
+func (b *TreeBuilder) lookup(pathstr string) (os.FileMode, bool, error) {
+	comps, err := splitTreePath(pathstr)
+	if err != nil {
+		return 0, false, err
+	}
+
+	node := b.root
+	for i, name := range comps {
+		if err = b.load(node); err != nil {
+			return 0, false, err
+		}
+
+		item, ok := node.entries[name]
+		if !ok || (item.node != nil && item.node.empty()) {
+			return 0, false, nil
+		} else if i == len(comps)-1 {
+			return item.mode, true, nil
+		} else if item.mode != 040000 {
+			return 0, false, nil
+		} else if item.node == nil {
+			item.node = &treeNode{id: item.id, exists: true}
+		}
+
+		node = item.node
+	}
+
+	return 0, false, nil
+}
+
+//empty checks if the tree has no entries besides empty trees,
+//without loading it.
+func (node *treeNode) empty() bool {
+	if node.entries == nil {
+		return false
+	}
+
+	for _, item := range node.entries {
+		if item.node == nil || !item.node.empty() {
+			return false
+		}
+	}
+	return true
+}
+
+//Set adds or replaces the entry at path with an object with the
+//given id and mode (e.g. 0100644, 0100755, 0120000 or 040000).
+//Missing parent directories are created.
+func (b *TreeBuilder) Set(pathstr string, mode os.FileMode, id ObjectID) error {
+	switch mode {
+	case 0100644, 0100755, 0120000, 0160000, 040000:
+	default:
+		return fmt.Errorf("git: invalid mode for tree entry: %o", mode)
+	}
+
+	comps, err := splitTreePath(pathstr)
+	if err != nil {
+		return err
+	}
+
+	n := len(comps)
+	node, err := b.walk(comps[:n-1], true)
+	if err != nil {
+		return err
+	}
+
+	node.entries[comps[n-1]] = &treeItem{mode: mode, id: id}
+	return nil
+}
+
+//Remove deletes the entry at path. Directories that become
+//empty are removed as well when the tree is written.
+func (b *TreeBuilder) Remove(pathstr string) error {
+	comps, err := splitTreePath(pathstr)
+	if err != nil {
+		return err
+	}
+
+	n := len(comps)
+	node, err := b.walk(comps[:n-1], false)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := node.entries[comps[n-1]]; !ok {
+		return &os.PathError{Op: "edit tree", Path: pathstr, Err: os.ErrNotExist}
+	}
+
+	delete(node.entries, comps[n-1])
+	return nil
+}
+
+//Write stores all modified trees in the repository and
+//returns the id of the (new) root tree.
+func (b *TreeBuilder) Write() (ObjectID, error) {
+	return b.write(b.root)
+}
+
+//treeSortKey is the name of an entry as it is used for sorting
+//in trees: git compares tree names as if they had a trailing "/".
+func treeSortKey(name string, mode os.FileMode) string {
+	if mode == 040000 {
+		return name + "/"
+	}
+	return name
+}
+
+func (b *TreeBuilder) write(node *treeNode) (ObjectID, error) {
+	if !node.dirty {
+		return node.id, nil
+	}
+
+	keys := make([]string, 0, len(node.entries))
+	names := make(map[string]string, len(node.entries))
+	for name, item := range node.entries {
+		if item.node != nil {
+			id, err := b.write(item.node)
+			if err != nil {
+				return id, err
+			}
+
+			//git does not store empty sub-trees
+			if len(item.node.entries) == 0 {
+				delete(node.entries, name)
+				continue
+			}
+			item.id = id
+		}
+
+		key := treeSortKey(name, item.mode)
+		keys = append(keys, key)
+		names[key] = name
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	for _, key := range keys {
+		item := node.entries[names[key]]
+		fmt.Fprintf(&buf, "%o %s\x00", item.mode, names[key])
+		buf.Write(item.id.Bytes())
+	}
+
+	id, err := b.repo.WriteObject(ObjTree, int64(buf.Len()), &buf)
+	if err != nil {
+		return id, err
+	}
+
+	node.id, node.exists, node.dirty = id, true, false
+	return id, nil
+}
+
+//CommitBuilder creates new commits. The zero Committer means
+//that the Author is also the committer; a zero Date of either
+//signature is replaced by the current time.
+type CommitBuilder struct {
+	repo *Repository
+
+	Tree      ObjectID
+	Parent    []ObjectID
+	Author    Signature
+	Committer Signature
+	Message   string
+}
+
+//NewCommitBuilder returns a CommitBuilder for a commit of the
+//given tree with the given parents.
+func (repo *Repository) NewCommitBuilder(tree ObjectID, parents ...ObjectID) *CommitBuilder {
+	return &CommitBuilder{repo: repo, Tree: tree, Parent: parents}
+}
+
+func checkSignature(s Signature, now time.Time) (Signature, error) {
+	if s.Name == "" || s.Email == "" ||
+		strings.ContainsAny(s.Name, "<>\n") || strings.ContainsAny(s.Email, "<>\n") {
+		return s, fmt.Errorf("git: invalid signature: %q <%s>", s.Name, s.Email)
+	}
+
+	date := s.Date
+	if date.IsZero() {
+		date = now
+	}
+
+	if s.Offset != nil {
+		date = date.In(s.Offset)
+	}
+
+	//normalize the offset, so it is formatted like git does
+	return NewSignature(s.Name, s.Email, date), nil
+}
+
+//Write checks that the tree and parents exist and writes the
+//commit to the repository, returning its id.
+func (b *CommitBuilder) Write() (ObjectID, error) {
+	var id ObjectID
+
+	if obj, err := b.repo.OpenObject(b.Tree); err != nil {
+		return id, err
+	} else if obj.Close(); obj.Type() != ObjTree {
+		return id, fmt.Errorf("git: %s is a %s, not a tree", b.Tree, obj.Type())
+	}
+
+	for _, p := range b.Parent {
+		if obj, err := b.repo.OpenObject(p); err != nil {
+			return id, err
+		} else if obj.Close(); obj.Type() != ObjCommit {
+			return id, fmt.Errorf("git: parent %s is a %s, not a commit", p, obj.Type())
+		}
+	}
+
+	now := time.Now()
+	author, err := checkSignature(b.Author, now)
+	if err != nil {
+		return id, err
+	}
+
+	committer := author
+	if b.Committer != (Signature{}) {
+		committer, err = checkSignature(b.Committer, now)
+		if err != nil {
+			return id, err
+		}
+	}
+
+	c := &Commit{
+		Tree:      b.Tree,
+		Parent:    b.Parent,
+		Author:    author,
+		Committer: committer,
+		Message:   b.Message,
+	}
+
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	if _, err = c.writeBody(w); err != nil {
+		return id, err
+	}
+
+	if err = w.Flush(); err != nil {
+		return id, err
+	}
+
+	return b.repo.WriteObject(ObjCommit, int64(buf.Len()), &buf)
+}
@@ -0,0 +1,218 @@
+package git
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteObject(t *testing.T) {
+	tr := mkTestRepo(t)
+	defer tr.cleanup()
+
+	data := "hello world\n"
+	id, err := tr.WriteObject(ObjBlob, int64(len(data)), strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("WriteObject() => %v", err)
+	}
+
+	tr.write("hello.txt", data)
+	if expected := tr.revParse(tr.git("hash-object", "hello.txt")); id != expected {
+		t.Fatalf("WriteObject() => %s, expected %s", id, expected)
+	}
+
+	if out := tr.git("cat-file", "-p", id.String()); out+"\n" != data {
+		t.Fatalf("git cat-file => %q, expected %q", out, data)
+	}
+
+	content, err := readBlob(t, tr.Repository, id)
+	if err != nil || string(content) != data {
+		t.Fatalf("reading written object => %q, %v", content, err)
+	}
+
+	// writing it again is fine
+	again, err := tr.WriteObject(ObjBlob, int64(len(data)), strings.NewReader(data))
+	if err != nil || again != id {
+		t.Fatalf("WriteObject() again => %s, %v", again, err)
+	}
+
+	// size must match
+	for _, size := range []int64{int64(len(data)) - 1, int64(len(data)) + 1} {
+		_, err = tr.WriteObject(ObjBlob, size, strings.NewReader(data))
+		if err == nil {
+			t.Fatalf("WriteObject() with wrong size %d => success", size)
+		}
+		t.Logf("size %d: %v", size, err)
+	}
+
+	if _, err = tr.WriteObject(ObjOFSDelta, 0, strings.NewReader("")); err == nil {
+		t.Fatalf("WriteObject() with delta type => success")
+	}
+
+	// no temporary files are left behind
+	if out := tr.git("count-objects", "-v"); !strings.Contains(out, "garbage: 0") {
+		t.Fatalf("garbage in objects dir: %s", out)
+	}
+}
+
+func TestTreeBuilder(t *testing.T) {
+	tr := mkTestRepo(t)
+	defer tr.cleanup()
+
+	tr.write("README", "readme\n")
+	tr.write("src/main.go", "package main\n")
+	tr.write("src/util/util.go", "package util\n")
+	tr.write("doc/only.txt", "only\n")
+	base := tr.commit("base")
+
+	blob := func(content string) ObjectID {
+		id, err := tr.WriteObject(ObjBlob, int64(len(content)), strings.NewReader(content))
+		if err != nil {
+			t.Fatalf("WriteObject() => %v", err)
+		}
+		return id
+	}
+
+	tb, err := tr.EditTree(base)
+	if err != nil {
+		t.Fatalf("EditTree() => %v", err)
+	}
+
+	steps := []error{
+		tb.Set("README", 0100644, blob("new readme\n")),
+		tb.Set("src/util/more.go", 0100644, blob("package util // more\n")),
+		tb.Set("src-file", 0100755, blob("#!/bin/sh\n")),
+		tb.Set("new/deep/dir/file.txt", 0100644, blob("deep\n")),
+		tb.Set("link", 0120000, blob("README")),
+		tb.Remove("doc/only.txt"),
+	}
+
+	for i, err := range steps {
+		if err != nil {
+			t.Fatalf("step %d => %v", i, err)
+		}
+	}
+
+	if err := tb.Set("README/x", 0100644, blob("x")); err == nil {
+		t.Fatalf("Set() below a file => success")
+	}
+
+	if err := tb.Remove("nope/file"); err == nil {
+		t.Fatalf("Remove() of missing path => success")
+	}
+
+	if err := tb.Set("../x", 0100644, blob("x")); err == nil {
+		t.Fatalf("Set() with invalid path => success")
+	}
+
+	tree, err := tb.Write()
+	if err != nil {
+		t.Fatalf("TreeBuilder.Write() => %v", err)
+	}
+
+	// do the same with git
+	tr.write("README", "new readme\n")
+	tr.write("src/util/more.go", "package util // more\n")
+	tr.write("src-file", "#!/bin/sh\n")
+	tr.write("new/deep/dir/file.txt", "deep\n")
+	tr.git("rm", "-q", "doc/only.txt")
+	tr.git("add", "-A", ".")
+	tr.git("update-index", "--chmod=+x", "src-file")
+	link := blob("README")
+	tr.git("update-index", "--add", "--cacheinfo", "120000,"+link.String()+",link")
+
+	expected := tr.revParse(tr.git("write-tree"))
+	if tree != expected {
+		t.Fatalf("TreeBuilder.Write() => %s, expected %s\n%s", tree, expected, tr.git("ls-tree", "-r", tree.String()))
+	}
+
+	// writing again without changes returns the same tree
+	if again, err := tb.Write(); err != nil || again != tree {
+		t.Fatalf("TreeBuilder.Write() again => %s, %v", again, err)
+	}
+
+	empty, err := tr.NewTreeBuilder().Write()
+	if err != nil || empty.String() != "4b825dc642cb6eb9a060e54bf8d69288fbee4904" {
+		t.Fatalf("empty tree => %s, %v", empty, err)
+	}
+
+	tr.git("fsck", "--strict", "--no-dangling")
+}
+
+func TestCommitBuilder(t *testing.T) {
+	tr := mkTestRepo(t)
+	defer tr.cleanup()
+
+	tr.write("file.txt", "content\n")
+	parent := tr.commit("parent")
+	tree := tr.revParse("HEAD^{tree}")
+
+	when := time.Date(2017, 3, 14, 15, 9, 26, 0, time.FixedZone("CET", 3600))
+	cb := tr.NewCommitBuilder(tree, parent)
+	cb.Author = NewSignature("A U Thor", "author@example.com", when)
+	cb.Committer = Signature{Name: "C O Mitter", Email: "committer@example.com",
+		Date: when.Add(time.Hour), Offset: time.FixedZone("EST", -5*3600)}
+	cb.Message = "A commit from the builder\n"
+
+	id, err := cb.Write()
+	if err != nil {
+		t.Fatalf("CommitBuilder.Write() => %v", err)
+	}
+
+	raw := tr.git("cat-file", "-p", id.String())
+	expected := strings.Join([]string{
+		"tree " + tree.String(),
+		"parent " + parent.String(),
+		"author A U Thor <author@example.com> 1489500566 +0100",
+		"committer C O Mitter <committer@example.com> 1489504166 -0500",
+		"",
+		"A commit from the builder",
+	}, "\n")
+
+	if raw != expected {
+		t.Fatalf("unexpected commit:\n%s\nexpected:\n%s", raw, expected)
+	}
+
+	obj, err := tr.OpenObject(id)
+	if err != nil {
+		t.Fatalf("OpenObject(%s) => %v", id, err)
+	}
+	obj.Close()
+
+	commit := obj.(*Commit)
+	if commit.Tree != tree || len(commit.Parent) != 1 || commit.Author.Name != "A U Thor" {
+		t.Fatalf("unexpected commit: %+v", commit)
+	}
+
+	var buf bytes.Buffer
+	if _, err = commit.WriteTo(&buf); err != nil || buf.Len() == 0 {
+		t.Fatalf("Commit.WriteTo() => %v", err)
+	}
+
+	// committer defaults to the author, the date to now
+	cb = tr.NewCommitBuilder(tree)
+	cb.Author = Signature{Name: "A U Thor", Email: "author@example.com"}
+	cb.Message = "root commit\n"
+	if id, err = cb.Write(); err != nil {
+		t.Fatalf("CommitBuilder.Write() => %v", err)
+	}
+
+	if c := tr.git("log", "-1", "--format=%cn <%ce>", id.String()); c != "A U Thor <author@example.com>" {
+		t.Fatalf("unexpected committer: %q", c)
+	}
+
+	bad := []*CommitBuilder{
+		{repo: tr.Repository, Tree: parent, Author: cb.Author},
+		{repo: tr.Repository, Tree: tree, Parent: []ObjectID{tree}, Author: cb.Author},
+		{repo: tr.Repository, Tree: tree, Author: Signature{Name: "No <Mail>"}},
+	}
+
+	for i, b := range bad {
+		if _, err := b.Write(); err == nil {
+			t.Fatalf("bad commit %d => success", i)
+		}
+	}
+
+	tr.git("fsck", "--strict", "--no-dangling")
+}
@@ -0,0 +1,642 @@
+package git
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+//commit-graph file format constants, see git's
+//Documentation/technical/commit-graph-format.txt
+const (
+	graphSignature   = "CGPH"
+	graphHeaderSize  = 8
+	graphChunkSize   = 12
+	graphFanoutSize  = 256 * 4
+	graphParentNone  = 0x70000000
+	graphExtraEdges  = 0x80000000
+	graphLastEdge    = 0x80000000
+	graphMaxGen      = 0x3FFFFFFF
+	graphChunkFanout = 0x4f494446 //"OIDF"
+	graphChunkOIDs   = 0x4f49444c //"OIDL"
+	graphChunkData   = 0x43444154 //"CDAT"
+	graphChunkEdges  = 0x45444745 //"EDGE"
+	graphChunkBase   = 0x42415345 //"BASE"
+)
+
+//graphEntry is the information about a commit that is stored
+//in the commit-graph file.
+type graphEntry struct {
+	tree       ObjectID
+	parents    []ObjectID
+	date       int64  //committer date, seconds since the epoch
+	generation uint32 //topological level, 1 for root commits
+}
+
+//graphLayer is a single commit-graph file, i.e. one
+//layer of a chain of commit-graph files.
+type graphLayer struct {
+	path   string
+	format ObjectFormat
+	base   uint32 //number of commits in the layers below
+	fanout []byte
+	oids   []byte
+	data   []byte
+	edges  []byte
+}
+
+func (l *graphLayer) count() uint32 {
+	return binary.BigEndian.Uint32(l.fanout[255*4:])
+}
+
+//dataSize is the size of a commit in the commit data chunk.
+func (l *graphLayer) dataSize() uint32 {
+	return uint32(l.format.Size()) + 16
+}
+
+//graphHashVersion returns the hash version of the commit-graph
+//header for the object format.
+func graphHashVersion(f ObjectFormat) byte {
+	if f == FormatSHA256 {
+		return 2
+	}
+	return 1
+}
+
+//find returns the position of id in the layer.
+func (l *graphLayer) find(id ObjectID) (uint32, bool) {
+	if id.Format() != l.format {
+		return 0, false
+	}
+
+	key := id.Bytes()
+	size := uint32(len(key))
+
+	var lo uint32
+	if key[0] > 0 {
+		lo = binary.BigEndian.Uint32(l.fanout[(int(key[0])-1)*4:])
+	}
+	hi := binary.BigEndian.Uint32(l.fanout[int(key[0])*4:])
+
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		switch bytes.Compare(l.oids[mid*size:(mid+1)*size], key) {
+		case 0:
+			return mid, true
+		case -1:
+			lo = mid + 1
+		default:
+			hi = mid
+		}
+	}
+
+	return 0, false
+}
+
+//graphFile is the (possibly chained) commit-graph file of a
+//repository. It is read into memory and never modified, so
+//it can be shared freely.
+type graphFile struct {
+	layers []*graphLayer //base first
+
+	//to detect changes on disk
+	file string
+	mod  time.Time
+	size int64
+}
+
+//lookup returns the global position of the commit id.
+func (g *graphFile) lookup(id ObjectID) (uint32, bool) {
+	for _, l := range g.layers {
+		if pos, ok := l.find(id); ok {
+			return l.base + pos, true
+		}
+	}
+	return 0, false
+}
+
+//layerOf returns the layer of the global position pos and
+//the position within that layer.
+func (g *graphFile) layerOf(pos uint32) (*graphLayer, uint32, error) {
+	for i := len(g.layers) - 1; i >= 0; i-- {
+		l := g.layers[i]
+		if pos >= l.base {
+			if pos-l.base >= l.count() {
+				break
+			}
+			return l, pos - l.base, nil
+		}
+	}
+	return nil, 0, fmt.Errorf("git: commit-graph position %d out of range", pos)
+}
+
+//id returns the commit id at the global position pos.
+func (g *graphFile) id(pos uint32) (ObjectID, error) {
+	l, lpos, err := g.layerOf(pos)
+	if err != nil {
+		return ObjectID{}, err
+	}
+
+	size := uint32(l.format.Size())
+	return l.format.FromBytes(l.oids[lpos*size : (lpos+1)*size])
+}
+
+//entry returns the information about the commit at the global
+//position pos.
+func (g *graphFile) entry(pos uint32) (*graphEntry, error) {
+	l, lpos, err := g.layerOf(pos)
+	if err != nil {
+		return nil, err
+	}
+
+	size := l.dataSize()
+	data := l.data[lpos*size : (lpos+1)*size]
+	e := &graphEntry{}
+	e.tree, err = l.format.FromBytes(data[:l.format.Size()])
+	if err != nil {
+		return nil, err
+	}
+
+	data = data[l.format.Size():]
+	p1 := binary.BigEndian.Uint32(data[0:])
+	p2 := binary.BigEndian.Uint32(data[4:])
+	hi := binary.BigEndian.Uint32(data[8:])
+	lo := binary.BigEndian.Uint32(data[12:])
+
+	e.generation = hi >> 2
+	e.date = int64(hi&0x3)<<32 | int64(lo)
+
+	var parents []uint32
+	if p1 != graphParentNone {
+		parents = append(parents, p1)
+	}
+
+	if p2&graphExtraEdges != 0 {
+		//octopus merge, the remaining parents are in the
+		//extra edges list of the layer
+		for i := p2 &^ graphExtraEdges; ; i++ {
+			if int(i+1)*4 > len(l.edges) {
+				return nil, fmt.Errorf("git: commit-graph: extra edges out of range")
+			}
+
+			edge := binary.BigEndian.Uint32(l.edges[i*4:])
+			parents = append(parents, edge&^graphLastEdge)
+			if edge&graphLastEdge != 0 {
+				break
+			}
+		}
+	} else if p2 != graphParentNone {
+		parents = append(parents, p2)
+	}
+
+	e.parents = make([]ObjectID, len(parents))
+	for i, p := range parents {
+		e.parents[i], err = g.id(p)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return e, nil
+}
+
+//readCommitGraphLayer reads and checks the commit-graph file at path,
+//whose object ids must be of the given format.
+func readCommitGraphLayer(path string, format ObjectFormat) (*graphLayer, []ObjectID, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	bad := func(msg string) error {
+		return fmt.Errorf("git: invalid commit-graph %q: %s", path, msg)
+	}
+
+	hsize := format.Size()
+	if len(data) < graphHeaderSize+graphChunkSize+hsize {
+		return nil, nil, bad("file too short")
+	} else if string(data[:4]) != graphSignature {
+		return nil, nil, bad("wrong signature")
+	} else if data[4] != 1 {
+		return nil, nil, bad(fmt.Sprintf("unsupported version %d", data[4]))
+	} else if data[5] != graphHashVersion(format) {
+		return nil, nil, bad(fmt.Sprintf("unsupported hash version %d", data[5]))
+	}
+
+	end := len(data) - hsize
+	h := format.New()
+	h.Write(data[:end])
+	if !bytes.Equal(h.Sum(nil), data[end:]) {
+		return nil, nil, bad("checksum mismatch")
+	}
+
+	nchunks := int(data[6])
+	nbase := int(data[7])
+	if graphHeaderSize+(nchunks+1)*graphChunkSize > end {
+		return nil, nil, bad("chunk table out of range")
+	}
+
+	chunks := make(map[uint32][]byte, nchunks)
+	table := data[graphHeaderSize:]
+	for i := 0; i < nchunks; i++ {
+		id := binary.BigEndian.Uint32(table[i*graphChunkSize:])
+		start := binary.BigEndian.Uint64(table[i*graphChunkSize+4:])
+		stop := binary.BigEndian.Uint64(table[(i+1)*graphChunkSize+4:])
+
+		if start > stop || stop > uint64(end) {
+			return nil, nil, bad("chunk out of range")
+		}
+		chunks[id] = data[start:stop]
+	}
+
+	l := &graphLayer{
+		path:   path,
+		format: format,
+		fanout: chunks[graphChunkFanout],
+		oids:   chunks[graphChunkOIDs],
+		data:   chunks[graphChunkData],
+		edges:  chunks[graphChunkEdges],
+	}
+
+	if len(l.fanout) != graphFanoutSize {
+		return nil, nil, bad("missing or invalid fan-out")
+	}
+
+	n := int(l.count())
+	if len(l.oids) != n*hsize || len(l.data) != n*int(l.dataSize()) {
+		return nil, nil, bad("invalid object id or commit data chunk")
+	}
+
+	base := chunks[graphChunkBase]
+	if len(base) != nbase*hsize {
+		return nil, nil, bad("invalid base graphs chunk")
+	}
+
+	bases := make([]ObjectID, nbase)
+	for i := range bases {
+		bases[i], _ = format.FromBytes(base[i*hsize : (i+1)*hsize])
+	}
+
+	return l, bases, nil
+}
+
+//readCommitGraph reads the commit-graph of the repository, either
+//the single file objects/info/commit-graph or, if that does not
+//exist, the chain in objects/info/commit-graphs. A nil graph and no
+//error is returned if there is neither.
+func (repo *Repository) readCommitGraph() (*graphFile, error) {
+	info := filepath.Join(repo.Path, "objects", "info")
+
+	single := filepath.Join(info, "commit-graph")
+	chain := filepath.Join(info, "commit-graphs", "commit-graph-chain")
+
+	g := &graphFile{}
+	for _, path := range []string{single, chain} {
+		fi, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		g.file, g.mod, g.size = path, fi.ModTime(), fi.Size()
+		break
+	}
+
+	if g.file == "" {
+		return nil, nil
+	} else if g.file == single {
+		l, bases, err := readCommitGraphLayer(single, repo.ObjectFormat())
+		if err != nil {
+			return nil, err
+		} else if len(bases) != 0 {
+			return nil, fmt.Errorf("git: commit-graph %q has base graphs", single)
+		}
+
+		g.layers = []*graphLayer{l}
+		return g, nil
+	}
+
+	data, err := ioutil.ReadFile(chain)
+	if err != nil {
+		return nil, err
+	}
+
+	var hashes []ObjectID
+	for _, line := range strings.Fields(string(data)) {
+		id, err := ParseObjectID(line)
+		if err != nil {
+			return nil, fmt.Errorf("git: invalid commit-graph chain: %v", err)
+		}
+		hashes = append(hashes, id)
+	}
+
+	if len(hashes) == 0 {
+		return nil, nil
+	}
+
+	var count uint32
+	for i, hash := range hashes {
+		path := filepath.Join(info, "commit-graphs", fmt.Sprintf("graph-%s.graph", hash))
+		l, bases, err := readCommitGraphLayer(path, repo.ObjectFormat())
+		if err != nil {
+			return nil, err
+		}
+
+		if len(bases) != i {
+			return nil, fmt.Errorf("git: commit-graph %q does not match the chain", path)
+		}
+		for k := range bases {
+			if bases[k] != hashes[k] {
+				return nil, fmt.Errorf("git: commit-graph %q does not match the chain", path)
+			}
+		}
+
+		l.base = count
+		count += l.count()
+		g.layers = append(g.layers, l)
+	}
+
+	return g, nil
+}
+
+//changed checks if the files the graph was read from changed.
+func (g *graphFile) changed(repo *Repository) bool {
+	info := filepath.Join(repo.Path, "objects", "info")
+	for _, path := range []string{
+		filepath.Join(info, "commit-graph"),
+		filepath.Join(info, "commit-graphs", "commit-graph-chain")} {
+
+		fi, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+
+		return g == nil || path != g.file || !fi.ModTime().Equal(g.mod) || fi.Size() != g.size
+	}
+
+	return g != nil
+}
+
+//graphFile returns the commit-graph of the repository, which is
+//(re-)read if the files on disk changed, or nil if there is none or
+//it cannot be used. Like git, shallow repositories do not use it.
+func (repo *Repository) graphFile() *graphFile {
+	if _, err := os.Stat(filepath.Join(repo.Path, "shallow")); err == nil {
+		return nil
+	}
+
+	repo.mu.Lock()
+	g, loaded := repo.gfile, repo.gfileLoaded
+	repo.mu.Unlock()
+
+	if loaded && !g.changed(repo) {
+		return g
+	}
+
+	g, err := repo.readCommitGraph()
+	if err != nil {
+		//the objects themselves are the authority,
+		//so we just do without it
+		g = nil
+	}
+
+	repo.mu.Lock()
+	repo.gfile, repo.gfileLoaded = g, true
+	repo.mu.Unlock()
+
+	return g
+}
+
+//graphIDs sorts commit ids for the commit-graph file.
+type graphIDs []ObjectID
+
+func (ids graphIDs) Len() int           { return len(ids) }
+func (ids graphIDs) Less(i, j int) bool { return ids[i].Compare(ids[j]) < 0 }
+func (ids graphIDs) Swap(i, j int)      { ids[i], ids[j] = ids[j], ids[i] }
+
+//WriteCommitGraph writes the commit-graph file objects/info/commit-graph
+//for all commits reachable from the refs and HEAD, which speeds up
+//walking the history considerably. Commits that are in the current
+//commit-graph are taken from there, so refreshing the file after a
+//push only opens the new commits.
+func (repo *Repository) WriteCommitGraph() error {
+	refs, err := repo.ListRefs("refs/")
+	if err != nil {
+		return err
+	}
+
+	if head, err := repo.OpenRef("HEAD"); err == nil {
+		refs = append(refs, head)
+	}
+
+	graph := NewCommitGraph(repo)
+
+	var stack []*CommitNode
+	for _, ref := range refs {
+		id, err := repo.PeelRef(ref)
+		if err != nil {
+			//dangling or broken refs are not our business here
+			continue
+		}
+
+		node, err := graph.openObject(id)
+		if err != nil {
+			//refs to trees or blobs
+			continue
+		}
+		stack = append(stack, node)
+	}
+
+	//compute the topological levels depth first, the
+	//seen flag marks the nodes whose level is known
+	graph.clearFlags(NodeFlagSeen)
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		if node.Flags&NodeFlagSeen != 0 {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+
+		err = graph.loadParents(node)
+		if err != nil {
+			return err
+		}
+
+		var gen uint32
+		done := true
+		for _, parent := range node.parents {
+			if parent.Flags&NodeFlagSeen == 0 {
+				stack = append(stack, parent)
+				done = false
+			} else if parent.generation > gen {
+				gen = parent.generation
+			}
+		}
+
+		if done {
+			if gen < graphMaxGen {
+				gen++
+			}
+			node.generation = gen
+			node.Flags |= NodeFlagSeen
+			stack = stack[:len(stack)-1]
+		}
+	}
+
+	if len(graph.commits) == 0 {
+		return nil
+	}
+
+	ids := make(graphIDs, 0, len(graph.commits))
+	for id := range graph.commits {
+		ids = append(ids, id)
+	}
+	sort.Sort(ids)
+
+	dir := filepath.Join(repo.Path, "objects", "info")
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(dir, "tmp_graph_")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	err = writeCommitGraph(tmp, repo.ObjectFormat(), ids, graph.commits)
+	if err != nil {
+		return err
+	}
+
+	if err = tmp.Sync(); err != nil {
+		return err
+	} else if err = tmp.Close(); err != nil {
+		return err
+	} else if err = os.Chmod(tmp.Name(), 0444); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filepath.Join(dir, "commit-graph"))
+}
+
+//writeCommitGraph writes the commit-graph file for the commits
+//with the sorted ids of the format, whose nodes must have their
+//parents loaded and their generation computed.
+func writeCommitGraph(w io.Writer, format ObjectFormat, ids []ObjectID, nodes map[ObjectID]*CommitNode) error {
+	pos := make(map[ObjectID]uint32, len(ids))
+	for i, id := range ids {
+		pos[id] = uint32(i)
+	}
+
+	var fanout [256]uint32
+	for _, id := range ids {
+		fanout[id.Bytes()[0]]++
+	}
+	for i := 1; i < 256; i++ {
+		fanout[i] += fanout[i-1]
+	}
+
+	var oids, data, edges bytes.Buffer
+	var buf [4]byte
+	put := func(b *bytes.Buffer, v uint32) {
+		binary.BigEndian.PutUint32(buf[:], v)
+		b.Write(buf[:])
+	}
+
+	for _, id := range ids {
+		node := nodes[id]
+		oids.Write(id.Bytes())
+		data.Write(node.tree.Bytes())
+
+		parents := make([]uint32, len(node.parents))
+		for i, parent := range node.parents {
+			parents[i] = pos[parent.ID]
+		}
+
+		switch len(parents) {
+		case 0:
+			put(&data, graphParentNone)
+			put(&data, graphParentNone)
+		case 1:
+			put(&data, parents[0])
+			put(&data, graphParentNone)
+		case 2:
+			put(&data, parents[0])
+			put(&data, parents[1])
+		default:
+			put(&data, parents[0])
+			put(&data, graphExtraEdges|uint32(edges.Len()/4))
+			for i, p := range parents[1:] {
+				if i == len(parents)-2 {
+					p |= graphLastEdge
+				}
+				put(&edges, p)
+			}
+		}
+
+		date := uint64(node.date)
+		put(&data, node.generation<<2|uint32(date>>32)&0x3)
+		put(&data, uint32(date))
+	}
+
+	chunks := []struct {
+		id   uint32
+		data []byte
+	}{
+		{graphChunkFanout, nil},
+		{graphChunkOIDs, oids.Bytes()},
+		{graphChunkData, data.Bytes()},
+	}
+	if edges.Len() > 0 {
+		chunks = append(chunks, struct {
+			id   uint32
+			data []byte
+		}{graphChunkEdges, edges.Bytes()})
+	}
+
+	var fo bytes.Buffer
+	for _, n := range fanout {
+		put(&fo, n)
+	}
+	chunks[0].data = fo.Bytes()
+
+	hash := format.New()
+	out := io.MultiWriter(w, hash)
+
+	header := []byte{'C', 'G', 'P', 'H', 1, graphHashVersion(format), byte(len(chunks)), 0}
+	if _, err := out.Write(header); err != nil {
+		return err
+	}
+
+	offset := uint64(graphHeaderSize + (len(chunks)+1)*graphChunkSize)
+	table := make([]byte, (len(chunks)+1)*graphChunkSize)
+	for i, c := range chunks {
+		binary.BigEndian.PutUint32(table[i*graphChunkSize:], c.id)
+		binary.BigEndian.PutUint64(table[i*graphChunkSize+4:], offset)
+		offset += uint64(len(c.data))
+	}
+	binary.BigEndian.PutUint64(table[len(chunks)*graphChunkSize+4:], offset)
+
+	if _, err := out.Write(table); err != nil {
+		return err
+	}
+
+	for _, c := range chunks {
+		if _, err := out.Write(c.data); err != nil {
+			return err
+		}
+	}
+
+	_, err := w.Write(hash.Sum(nil))
+	return err
+}
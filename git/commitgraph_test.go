@@ -0,0 +1,189 @@
+package git
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//mkGraphRepo creates the repository of mkMergeBaseRepo with an
+//additional octopus merge on the branch octo.
+func mkGraphRepo(t *testing.T) *testRepo {
+	tr := mkMergeBaseRepo(t)
+
+	for _, b := range []string{"o1", "o2", "o3"} {
+		tr.git("checkout", "-q", "-b", b, "master")
+		tr.write(b+".txt", b+"\n")
+		tr.commit(b)
+	}
+
+	tr.git("checkout", "-q", "-b", "octo", "master")
+	tr.git("merge", "-q", "--no-ff", "-m", "octopus", "o1", "o2", "o3")
+	tr.git("checkout", "-q", "master")
+
+	return tr
+}
+
+//checkGraphFile compares the entries of the commit-graph file of
+//the repository with the commit objects of all commits in the file.
+func checkGraphFile(t *testing.T, tr *testRepo, layers int) {
+	g := tr.graphFile()
+	if g == nil {
+		t.Fatalf("commit-graph file not found")
+	} else if len(g.layers) != layers {
+		t.Fatalf("expected %d commit-graph layers, got %d", layers, len(g.layers))
+	}
+
+	gens := make(map[ObjectID]uint32)
+	ids := strings.Fields(tr.git("rev-list", "--all", "--reverse", "--topo-order"))
+	for _, idstr := range ids {
+		id, _ := ParseObjectID(idstr)
+
+		pos, ok := g.lookup(id)
+		if !ok {
+			t.Fatalf("commit %s not in the commit-graph", id)
+		} else if have, err := g.id(pos); err != nil || have != id {
+			t.Fatalf("wrong id at position %d: %s (%v), expected %s", pos, have, err, id)
+		}
+
+		entry, err := g.entry(pos)
+		if err != nil {
+			t.Fatalf("could not read commit-graph entry of %s: %v", id, err)
+		}
+
+		obj, err := tr.OpenObject(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		obj.Close()
+		commit := obj.(*Commit)
+
+		if entry.tree != commit.Tree || entry.date != commit.Committer.Date.Unix() {
+			t.Fatalf("wrong commit-graph entry for %s: %+v", id, entry)
+		} else if len(entry.parents) != len(commit.Parent) {
+			t.Fatalf("wrong parents of %s: %v, expected %v", id, entry.parents, commit.Parent)
+		}
+
+		var gen uint32
+		for i, p := range commit.Parent {
+			if entry.parents[i] != p {
+				t.Fatalf("wrong parents of %s: %v, expected %v", id, entry.parents, commit.Parent)
+			} else if gens[p] > gen {
+				gen = gens[p]
+			}
+		}
+
+		if gens[id] = gen + 1; entry.generation != gens[id] {
+			t.Fatalf("wrong generation for %s: %d, expected %d", id, entry.generation, gens[id])
+		}
+	}
+
+	if _, ok := g.lookup(ObjectID{}); ok {
+		t.Fatalf("found the zero id in the commit-graph")
+	}
+}
+
+func TestReadCommitGraph(t *testing.T) {
+	tr := mkGraphRepo(t)
+	defer tr.cleanup()
+
+	if tr.graphFile() != nil {
+		t.Fatalf("expected no commit-graph")
+	}
+
+	tr.git("commit-graph", "write", "--reachable")
+	checkGraphFile(t, tr, 1)
+	checkGraphQueries(t, tr)
+
+	//new commits are not in the file
+	tr.git("checkout", "-q", "one")
+	tr.commit("after the commit-graph")
+	tr.git("checkout", "-q", "-b", "later", "master")
+	tr.commit("after the commit-graph")
+
+	cg := NewCommitGraph(tr.Repository)
+	node, err := cg.AddTip(tr.revParse("later"))
+	if err != nil || node.Generation() != 0 || node.Parents() != nil {
+		t.Fatalf("unexpected node for a commit not in the commit-graph: %+v (%v)", node, err)
+	} else if err = cg.loadParents(node); err != nil || node.Parents()[0].Generation() == 0 {
+		t.Fatalf("parent of the new commit should be in the commit-graph (%v)", err)
+	}
+	checkGraphQueries(t, tr)
+
+	//a chain of two layers, which replaces the single file
+	tr.git("commit-graph", "write", "--reachable", "--split=no-merge")
+	os.Remove(filepath.Join(tr.Path, "objects", "info", "commit-graph"))
+	checkGraphFile(t, tr, 2)
+	checkGraphQueries(t, tr)
+
+	//corrupt files are ignored; layers are never rewritten
+	//in place, so the cached graph has to be dropped
+	tr.Close()
+	chain := filepath.Join(tr.Path, "objects", "info", "commit-graphs")
+	files, _ := filepath.Glob(filepath.Join(chain, "*.graph"))
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data[len(data)-30]++
+		os.Chmod(f, 0666)
+		if err = ioutil.WriteFile(f, data, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := tr.readCommitGraph(); err == nil {
+		t.Fatalf("expected an error for a corrupt commit-graph")
+	} else if tr.graphFile() != nil {
+		t.Fatalf("corrupt commit-graph was used")
+	}
+	checkGraphQueries(t, tr)
+}
+
+func TestWriteCommitGraph(t *testing.T) {
+	tr := mkGraphRepo(t)
+	defer tr.cleanup()
+
+	err := tr.WriteCommitGraph()
+	if err != nil {
+		t.Fatalf("WriteCommitGraph() => %v", err)
+	}
+
+	tr.git("commit-graph", "verify")
+	checkGraphFile(t, tr, 1)
+
+	path := filepath.Join(tr.Path, "objects", "info", "commit-graph")
+	ours, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr.git("-c", "commitGraph.generationVersion=1", "commit-graph", "write", "--reachable", "--no-changed-paths")
+	theirs, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(ours, theirs) {
+		t.Fatalf("commit-graph differs from the one of git")
+	}
+
+	//refresh with new commits, the existing ones are
+	//taken from the file
+	tr.git("checkout", "-q", "-b", "later", "master")
+	tr.write("new.txt", "new\n")
+	tr.commit("new")
+
+	err = tr.WriteCommitGraph()
+	if err != nil {
+		t.Fatalf("WriteCommitGraph() => %v", err)
+	}
+
+	tr.git("commit-graph", "verify")
+	checkGraphFile(t, tr, 1)
+	checkGraphQueries(t, tr)
+}
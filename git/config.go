@@ -0,0 +1,192 @@
+package git
+
+import (
+	"fmt"
+	"strings"
+)
+
+//config is a file in the format of git-config(1), like .gitmodules
+//or $GIT_DIR/config. Section and key names are case-insensitive
+//and stored in lowercase, subsection names are case-sensitive.
+type config struct {
+	entries []configEntry
+}
+
+type configEntry struct {
+	section    string
+	subsection string
+	key        string
+	value      string
+}
+
+//parseConfigValue parses the value after the "=" of a line, with
+//quotes, escapes and comments. It returns true if the value is
+//continued on the next line.
+func parseConfigValue(s string) (string, bool, error) {
+	var buf []byte
+	quoted := false
+	space := 0 //unquoted whitespace, dropped at the end
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"':
+			quoted = !quoted
+			space = 0
+			continue
+		case c == '\\':
+			if i+1 == len(s) {
+				return string(buf), true, nil
+			}
+			i++
+			switch s[i] {
+			case 'n':
+				c = '\n'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case '"', '\\':
+				c = s[i]
+			default:
+				return "", false, fmt.Errorf("git: bad escape \\%c in config", s[i])
+			}
+			buf = append(buf, c)
+			space = 0
+			continue
+		case !quoted && (c == '#' || c == ';'):
+			return string(buf[:len(buf)-space]), false, nil
+		case !quoted && (c == ' ' || c == '\t'):
+			if len(buf) == 0 {
+				continue
+			}
+			space++
+			c = ' '
+		case c != ' ' && c != '\t':
+			space = 0
+		}
+		buf = append(buf, c)
+	}
+
+	if quoted {
+		return "", false, fmt.Errorf("git: unterminated quote in config")
+	}
+	return string(buf[:len(buf)-space]), false, nil
+}
+
+//parseConfigSection parses a section header without the brackets,
+//i.e. `section "subsection"` or the deprecated `section.subsection`.
+func parseConfigSection(s string) (string, string, error) {
+	name, sub := split2(s, " ")
+	if sub == "" {
+		name, sub = split2(name, ".")
+		return strings.ToLower(name), strings.ToLower(sub), nil
+	}
+
+	sub = strings.TrimSpace(sub)
+	if len(sub) < 2 || sub[0] != '"' || sub[len(sub)-1] != '"' {
+		return "", "", fmt.Errorf("git: bad config section %q", s)
+	}
+
+	var buf []byte
+	for i := 1; i < len(sub)-1; i++ {
+		if sub[i] == '\\' && i+2 < len(sub) {
+			i++
+		}
+		buf = append(buf, sub[i])
+	}
+
+	return strings.ToLower(name), string(buf), nil
+}
+
+//parseConfig parses the data of a config file. Keys without a
+//value, which mean true for booleans, have the value "true".
+func parseConfig(data []byte) (*config, error) {
+	c := &config{}
+	var section, subsection string
+
+	lines := strings.Split(string(data), "\n")
+	for n := 0; n < len(lines); n++ {
+		line := strings.TrimSpace(lines[n])
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+
+		if line[0] == '[' {
+			end := strings.IndexByte(line, ']')
+			if end == -1 {
+				return nil, fmt.Errorf("git: bad config line %d", n+1)
+			}
+
+			var err error
+			section, subsection, err = parseConfigSection(line[1:end])
+			if err != nil {
+				return nil, err
+			}
+
+			//a key may follow on the same line
+			if line = strings.TrimSpace(line[end+1:]); line == "" || line[0] == '#' || line[0] == ';' {
+				continue
+			}
+		}
+
+		if section == "" {
+			return nil, fmt.Errorf("git: config line %d outside of a section", n+1)
+		}
+
+		key, rest := split2(line, "=")
+		key = strings.ToLower(strings.TrimSpace(key))
+		if key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("git: bad config line %d", n+1)
+		}
+
+		value := "true"
+		if strings.Contains(line, "=") {
+			var cont bool
+			var err error
+			value, cont, err = parseConfigValue(rest)
+			for err == nil && cont && n+1 < len(lines) {
+				//parse again with the next line, so that
+				//quotes and whitespace carry over
+				n++
+				rest = rest[:len(rest)-1] + lines[n]
+				value, cont, err = parseConfigValue(rest)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%v (line %d)", err, n+1)
+			}
+		}
+
+		c.entries = append(c.entries, configEntry{section, subsection, key, value})
+	}
+
+	return c, nil
+}
+
+//Get returns the last value of the key in the section and
+//subsection, and whether there is one.
+func (c *config) Get(section, subsection, key string) (string, bool) {
+	section, key = strings.ToLower(section), strings.ToLower(key)
+	for i := len(c.entries) - 1; i >= 0; i-- {
+		e := c.entries[i]
+		if e.section == section && e.subsection == subsection && e.key == key {
+			return e.value, true
+		}
+	}
+	return "", false
+}
+
+//subsections returns the names of the subsections of section, in
+//the order they first appear.
+func (c *config) subsections(section string) []string {
+	section = strings.ToLower(section)
+	seen := make(map[string]bool)
+	var names []string
+	for _, e := range c.entries {
+		if e.section == section && e.subsection != "" && !seen[e.subsection] {
+			seen[e.subsection] = true
+			names = append(names, e.subsection)
+		}
+	}
+	return names
+}
@@ -13,12 +13,13 @@ import (
 type Delta struct {
 	gitObject
 
-	BaseRef    SHA1
+	BaseRef    ObjectID
 	BaseOff    int64
 	SizeSource int64
 	SizeTarget int64
 
 	pf  *PackFile
+	off int64 //offset of the delta object in pf
 	op  DeltaOp
 	err error
 }
@@ -39,19 +40,29 @@ func readByte(r io.Reader) (byte, error) {
 	return b[0], nil
 }
 
+//parseDelta reads the header of the delta object obj, which is
+//closed if that fails.
 func parseDelta(obj gitObject) (*Delta, error) {
+	delta, err := readDeltaHeader(obj)
+	if err != nil {
+		obj.Close()
+		return nil, err
+	}
+	return delta, nil
+}
+
+func readDeltaHeader(obj gitObject) (*Delta, error) {
 	delta := Delta{gitObject: obj}
 
 	//all delta objects come from a PackFile and
 	//therefore git.Source is must be a *packReader
 	source := delta.source.(*packReader)
 	delta.pf = source.fd
+	delta.off = source.start
 
 	var err error
 	if obj.otype == ObjRefDelta {
-		_, err = source.Read(delta.BaseRef[:])
-		//TODO: check n?
-
+		delta.BaseRef, err = readObjectID(source, delta.pf.Format)
 		if err != nil {
 			return nil, err
 		}
@@ -274,8 +285,13 @@ func (d *Delta) WriteTo(w io.Writer) (int64, error) {
 type deltaChain struct {
 	baseObj gitObject
 	baseOff int64
+	base    *deltaBase //resolved base from the cache, if any
+	format  ObjectFormat
 
 	links []Delta
+
+	cfg   DeltaConfig
+	cache *deltaCache
 }
 
 func (c *deltaChain) Len() int {
@@ -283,15 +299,50 @@ func (c *deltaChain) Len() int {
 }
 
 type objectSource interface {
-	openRawObject(id SHA1) (gitObject, error)
+	openRawObject(id ObjectID) (gitObject, error)
 }
 
+//deltaSource is an objectSource that also provides limits
+//and a cache for resolving delta chains.
+type deltaSource interface {
+	objectSource
+
+	deltaConfig() DeltaConfig
+	deltaBaseCache() *deltaCache
+}
+
+//buildDeltaChain follows the delta d down to its base object.
+//Objects found in the delta base cache of s (if any) end the
+//chain early. Limits of s are checked before any data is
+//inflated.
 func buildDeltaChain(d *Delta, s objectSource) (*deltaChain, error) {
 	var chain deltaChain
 	var err error
+	chain.format = d.pf.Format
+
+	if ds, ok := s.(deltaSource); ok {
+		chain.cfg = ds.deltaConfig()
+		chain.cache = ds.deltaBaseCache()
+	}
 
 	for err == nil {
 
+		if base, ok := chain.cache.get(deltaCacheKey{d.pf, d.off}); ok {
+			d.Close()
+			chain.base = base
+			break
+		}
+
+		err = chain.cfg.checkDepth(len(chain.links) + 1)
+		if err != nil {
+			break
+		}
+
+		err = chain.cfg.checkSize(d.SizeTarget)
+		if err != nil {
+			break
+		}
+
 		chain.links = append(chain.links, *d)
 
 		var obj gitObject
@@ -306,6 +357,20 @@ func buildDeltaChain(d *Delta, s objectSource) (*deltaChain, error) {
 		}
 
 		if IsStandardObject(obj.otype) {
+			if key, ok := objectLocation(obj); ok {
+				if base, ok := chain.cache.get(key); ok {
+					obj.Close()
+					chain.base = base
+					break
+				}
+			}
+
+			err = chain.cfg.checkSize(obj.size)
+			if err != nil {
+				obj.Close()
+				break
+			}
+
 			chain.baseObj = obj
 			chain.baseOff = d.BaseOff
 			break
@@ -318,51 +383,89 @@ func buildDeltaChain(d *Delta, s objectSource) (*deltaChain, error) {
 	}
 
 	if err != nil {
-		//cleanup
+		chain.close()
 		return nil, err
 	}
 
 	return &chain, nil
 }
 
-func (c *deltaChain) resolve() (Object, error) {
+func (c *deltaChain) close() {
+	for i := range c.links {
+		c.links[i].Close()
+	}
+	c.baseObj.Close()
+}
 
-	ibuf := bytes.NewBuffer(make([]byte, 0, c.baseObj.Size()))
-	n, err := io.Copy(ibuf, c.baseObj.source)
+func (c *deltaChain) resolve() (Object, error) {
+	obj, err := c.resolveRaw()
 	if err != nil {
 		return nil, err
 	}
 
-	if n != c.baseObj.Size() {
-		return nil, io.ErrUnexpectedEOF
-	}
+	return parseObject(obj, c.format)
+}
+
+//resolveRaw patches the chain and returns the unparsed object.
+func (c *deltaChain) resolveRaw() (gitObject, error) {
+	defer c.close()
+
+	var otype ObjectType
+	var data []byte
+
+	if c.base != nil {
+		otype, data = c.base.otype, c.base.data
+	} else {
+		size := c.baseObj.Size()
+		if size > int64(^uint(0)>>1) {
+			return gitObject{}, fmt.Errorf("git: base to large for delta unpatching")
+		}
+
+		buf := bytes.NewBuffer(make([]byte, 0, size))
+		n, err := io.Copy(buf, c.baseObj.source)
+		if err != nil {
+			return gitObject{}, err
+		}
+
+		if n != size {
+			return gitObject{}, io.ErrUnexpectedEOF
+		}
+
+		otype, data = c.baseObj.otype, buf.Bytes()
 
-	obuf := bytes.NewBuffer(make([]byte, 0, c.baseObj.Size()))
+		if key, ok := objectLocation(c.baseObj); ok {
+			c.cache.add(key, otype, data)
+		}
+	}
 
 	for i := len(c.links); i > 0; i-- {
 		lk := c.links[i-1]
 
 		if lk.SizeTarget > int64(^uint(0)>>1) {
-			return nil, fmt.Errorf("git: target to large for delta unpatching")
+			return gitObject{}, fmt.Errorf("git: target to large for delta unpatching")
 		}
 
-		obuf.Grow(int(lk.SizeTarget))
-		obuf.Truncate(0)
+		if lk.SizeSource != int64(len(data)) {
+			return gitObject{}, fmt.Errorf("git: base size mismatch while patching delta object")
+		}
 
-		err = lk.Patch(bytes.NewReader(ibuf.Bytes()), obuf)
+		// cached data is shared, therefore every link
+		// gets a fresh buffer, which is never modified
+		// after it was added to the cache
+		obuf := bytes.NewBuffer(make([]byte, 0, lk.SizeTarget))
 
+		err := lk.Patch(bytes.NewReader(data), obuf)
 		if err != nil {
-			return nil, err
+			return gitObject{}, err
 		}
 
 		if lk.SizeTarget != int64(obuf.Len()) {
-			return nil, fmt.Errorf("git: size mismatch while patching delta object")
+			return gitObject{}, fmt.Errorf("git: size mismatch while patching delta object")
 		}
 
-		obuf, ibuf = ibuf, obuf
+		data = obuf.Bytes()
+		c.cache.add(deltaCacheKey{lk.pf, lk.off}, otype, data)
 	}
 
-	//ibuf is holding the data
-	obj := gitObject{c.baseObj.otype, int64(ibuf.Len()), ioutil.NopCloser(ibuf)}
-	return parseObject(obj)
+	return gitObject{otype, int64(len(data)), ioutil.NopCloser(bytes.NewReader(data))}, nil
 }
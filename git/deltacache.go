@@ -0,0 +1,192 @@
+package git
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+)
+
+//DeltaConfig controls how delta objects are resolved.
+type DeltaConfig struct {
+	//MaxDepth is the maximum length of a delta chain
+	//that will be resolved. Zero means no limit.
+	MaxDepth int
+
+	//MaxSize is the maximum size in bytes of any object
+	//(base, intermediate or target) in a delta chain.
+	//Zero means no limit.
+	MaxSize int64
+
+	//CacheSize is the memory budget in bytes of the cache
+	//for resolved delta bases. Zero disables the cache.
+	CacheSize int64
+}
+
+//DefaultDeltaConfig is used by repositories unless changed
+//via Repository.SetDeltaConfig. The maximum depth matches
+//the maximum git itself will create.
+var DefaultDeltaConfig = DeltaConfig{
+	MaxDepth:  4095,
+	MaxSize:   512 * 1024 * 1024,
+	CacheSize: 32 * 1024 * 1024,
+}
+
+//DeltaLimitError is returned when resolving a delta object
+//would exceed the limits set in the DeltaConfig.
+type DeltaLimitError struct {
+	Depth    int
+	MaxDepth int
+
+	Size    int64
+	MaxSize int64
+}
+
+func (e *DeltaLimitError) Error() string {
+	if e.MaxDepth > 0 && e.Depth > e.MaxDepth {
+		return fmt.Sprintf("git: delta chain depth %d exceeds limit of %d", e.Depth, e.MaxDepth)
+	}
+	return fmt.Sprintf("git: delta object size %d exceeds limit of %d", e.Size, e.MaxSize)
+}
+
+func (cfg DeltaConfig) checkSize(size int64) error {
+	if cfg.MaxSize > 0 && size > cfg.MaxSize {
+		return &DeltaLimitError{Size: size, MaxSize: cfg.MaxSize}
+	}
+	return nil
+}
+
+func (cfg DeltaConfig) checkDepth(depth int) error {
+	if cfg.MaxDepth > 0 && depth > cfg.MaxDepth {
+		return &DeltaLimitError{Depth: depth, MaxDepth: cfg.MaxDepth}
+	}
+	return nil
+}
+
+//DeltaCacheStats are the statistics of the delta base cache
+//of a repository.
+type DeltaCacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+
+	Entries int
+	Bytes   int64
+	Budget  int64
+}
+
+func (s DeltaCacheStats) String() string {
+	return fmt.Sprintf("delta cache: %d hits, %d misses, %d evictions, %d entries, %d/%d bytes",
+		s.Hits, s.Misses, s.Evictions, s.Entries, s.Bytes, s.Budget)
+}
+
+//deltaCacheKey identifies an object by its location in a pack.
+type deltaCacheKey struct {
+	pf  *PackFile
+	off int64
+}
+
+//objectLocation returns the location of objects that were read
+//from a pack file.
+func objectLocation(obj gitObject) (deltaCacheKey, bool) {
+	src := obj.source
+	if zr, ok := src.(*zlibReadCloser); ok {
+		src = zr.source
+	}
+
+	if pr, ok := src.(*packReader); ok {
+		return deltaCacheKey{pr.fd, pr.start}, true
+	}
+
+	return deltaCacheKey{}, false
+}
+
+//deltaBase is a resolved object. Its data is shared
+//and must never be modified.
+type deltaBase struct {
+	key   deltaCacheKey
+	otype ObjectType
+	data  []byte
+}
+
+//deltaCache is a LRU cache of resolved delta bases. It
+//is safe for concurrent use.
+type deltaCache struct {
+	mu sync.Mutex
+
+	budget  int64
+	size    int64
+	lru     *list.List
+	entries map[deltaCacheKey]*list.Element
+
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+func newDeltaCache(budget int64) *deltaCache {
+	return &deltaCache{
+		budget:  budget,
+		lru:     list.New(),
+		entries: make(map[deltaCacheKey]*list.Element),
+	}
+}
+
+func (c *deltaCache) get(key deltaCacheKey) (*deltaBase, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elm, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+
+	c.hits++
+	c.lru.MoveToFront(elm)
+	return elm.Value.(*deltaBase), true
+}
+
+func (c *deltaCache) add(key deltaCacheKey, otype ObjectType, data []byte) {
+	size := int64(len(data))
+	if c == nil || size > c.budget {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elm, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(elm)
+		return
+	}
+
+	for c.size+size > c.budget {
+		last := c.lru.Back()
+		base := c.lru.Remove(last).(*deltaBase)
+		delete(c.entries, base.key)
+		c.size -= int64(len(base.data))
+		c.evictions++
+	}
+
+	base := &deltaBase{key: key, otype: otype, data: data}
+	c.entries[key] = c.lru.PushFront(base)
+	c.size += size
+}
+
+func (c *deltaCache) stats() DeltaCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return DeltaCacheStats{
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+		Entries:   c.lru.Len(),
+		Bytes:     c.size,
+		Budget:    c.budget,
+	}
+}
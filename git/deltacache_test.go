@@ -0,0 +1,171 @@
+package git
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+//mkDeltaRepo creates a repository with a single file that was
+//changed in every commit. Every revision rewrites a different
+//part of the file, so the versions end up in a long delta chain
+//(each one based on its neighbour) when packed.
+func mkDeltaRepo(t *testing.T, n int) (*testRepo, []ObjectID) {
+	tr := mkTestRepo(t)
+
+	lines := make([]string, 200)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("line %d", i)
+	}
+
+	var commits []ObjectID
+	for i := 0; i < n; i++ {
+		for k := 0; k < 10; k++ {
+			pos := (i*10 + k) % len(lines)
+			lines[pos] = fmt.Sprintf("changed in revision %d: %x", i, sha1.Sum([]byte(lines[pos])))
+		}
+		tr.write("data.txt", strings.Join(lines, "\n")+"\n")
+		commits = append(commits, tr.commit(fmt.Sprintf("revision %d", i)))
+	}
+
+	tr.git("repack", "-a", "-d", "-f", "-q", "--depth=50", "--window=10")
+	tr.git("prune-packed")
+
+	var blobs []ObjectID
+	for _, c := range commits {
+		blobs = append(blobs, tr.revParse(c.String()+":data.txt"))
+	}
+
+	return tr, blobs
+}
+
+func readBlob(t *testing.T, repo *Repository, id ObjectID) ([]byte, error) {
+	obj, err := repo.OpenObject(id)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+
+	blob, ok := obj.(*Blob)
+	if !ok {
+		t.Fatalf("expected blob for %s, got %s", id, obj.Type())
+	}
+
+	return ioutil.ReadAll(blob)
+}
+
+func TestDeltaCache(t *testing.T) {
+	tr, blobs := mkDeltaRepo(t, 20)
+	defer tr.cleanup()
+
+	var contents [][]byte
+	for _, id := range blobs {
+		data, err := readBlob(t, tr.Repository, id)
+		if err != nil {
+			t.Fatalf("could not read blob %s: %v", id, err)
+		}
+		contents = append(contents, data)
+	}
+
+	first := tr.DeltaCacheStats()
+	t.Logf("after first pass: %s", first)
+
+	if first.Entries == 0 || first.Bytes == 0 {
+		t.Fatalf("expected entries in delta cache, got: %s", first)
+	}
+
+	// second round must be served from the cache and
+	// the shared cached data must not have been modified
+	for i, id := range blobs {
+		data, err := readBlob(t, tr.Repository, id)
+		if err != nil {
+			t.Fatalf("could not read blob %s: %v", id, err)
+		}
+
+		if !bytes.Equal(data, contents[i]) {
+			t.Fatalf("blob %s changed between reads", id)
+		}
+	}
+
+	second := tr.DeltaCacheStats()
+	t.Logf("after second pass: %s", second)
+
+	if second.Hits < first.Hits+uint64(len(blobs))/2 {
+		t.Fatalf("expected cache hits in second pass, got: %s", second)
+	}
+
+	// a tiny budget forces evictions
+	tr.SetDeltaConfig(DeltaConfig{CacheSize: int64(len(contents[len(contents)-1]))})
+	for _, id := range blobs {
+		if _, err := readBlob(t, tr.Repository, id); err != nil {
+			t.Fatalf("could not read blob %s: %v", id, err)
+		}
+	}
+
+	stats := tr.DeltaCacheStats()
+	if stats.Evictions == 0 || stats.Bytes > stats.Budget {
+		t.Fatalf("expected evictions and size within budget, got: %s", stats)
+	}
+
+	// caching disabled
+	tr.SetDeltaConfig(DeltaConfig{})
+	for _, id := range blobs {
+		if _, err := readBlob(t, tr.Repository, id); err != nil {
+			t.Fatalf("could not read blob %s: %v", id, err)
+		}
+	}
+
+	if stats := tr.DeltaCacheStats(); stats != (DeltaCacheStats{}) {
+		t.Fatalf("expected empty stats for disabled cache, got: %s", stats)
+	}
+}
+
+func TestDeltaLimits(t *testing.T) {
+	tr, blobs := mkDeltaRepo(t, 20)
+	defer tr.cleanup()
+
+	var tests = []struct {
+		cfg   DeltaConfig
+		depth bool //expect a depth error
+		size  bool //expect a size error
+	}{
+		{DeltaConfig{MaxDepth: 1}, true, false},
+		{DeltaConfig{MaxSize: 100}, false, true},
+		{DeltaConfig{MaxDepth: 100, MaxSize: 1 << 20}, false, false},
+	}
+
+	for _, tt := range tests {
+		tr.SetDeltaConfig(tt.cfg)
+
+		var depthErrs, sizeErrs int
+		for _, id := range blobs {
+			_, err := readBlob(t, tr.Repository, id)
+			if err == nil {
+				continue
+			}
+
+			lerr, ok := err.(*DeltaLimitError)
+			if !ok {
+				t.Fatalf("unexpected error for %s: %v", id, err)
+			}
+
+			if lerr.MaxDepth > 0 {
+				if _, _, err := tr.statObject(id); err == nil {
+					t.Fatalf("%+v: statObject(%s) ignored the depth limit", tt.cfg, id)
+				}
+				depthErrs++
+			} else {
+				sizeErrs++
+			}
+		}
+
+		if (depthErrs > 0) != tt.depth || (sizeErrs > 0) != tt.size {
+			t.Fatalf("%+v: got %d depth and %d size errors", tt.cfg, depthErrs, sizeErrs)
+		}
+
+		t.Logf("%+v: %d depth, %d size errors [OK!]", tt.cfg, depthErrs, sizeErrs)
+	}
+}
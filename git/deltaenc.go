@@ -0,0 +1,192 @@
+package git
+
+//deltaBlockSize is the size of the blocks of the base that
+//are indexed and matched against the target.
+const deltaBlockSize = 16
+
+//deltaMaxBucket limits the number of base offsets that are
+//stored per hash, so highly repetitive data (e.g. zeros) does
+//not make matching quadratic.
+const deltaMaxBucket = 64
+
+//deltaHashMul is the multiplier for the rolling hash.
+const deltaHashMul = 16777619
+
+//deltaHashPow is deltaHashMul^(deltaBlockSize-1), which is
+//needed to remove the first byte from the rolling hash.
+var deltaHashPow = func() uint32 {
+	p := uint32(1)
+	for i := 0; i < deltaBlockSize-1; i++ {
+		p *= deltaHashMul
+	}
+	return p
+}()
+
+func deltaHash(data []byte) uint32 {
+	var h uint32
+	for _, b := range data[:deltaBlockSize] {
+		h = h*deltaHashMul + uint32(b)
+	}
+	return h
+}
+
+//deltaIndex is an index of the blocks of a delta base.
+type deltaIndex struct {
+	base   []byte
+	blocks map[uint32][]int
+}
+
+func newDeltaIndex(base []byte) *deltaIndex {
+	idx := &deltaIndex{base: base, blocks: make(map[uint32][]int)}
+
+	for off := 0; off+deltaBlockSize <= len(base); off += deltaBlockSize {
+		h := deltaHash(base[off:])
+		if bucket := idx.blocks[h]; len(bucket) < deltaMaxBucket {
+			idx.blocks[h] = append(bucket, off)
+		}
+	}
+
+	return idx
+}
+
+func appendVarSize(out []byte, size int) []byte {
+	for size >= 0x80 {
+		out = append(out, byte(size)|0x80)
+		size >>= 7
+	}
+	return append(out, byte(size))
+}
+
+//appendDeltaInsert adds insert operations for data, which
+//can add at most 127 bytes each.
+func appendDeltaInsert(out []byte, data []byte) []byte {
+	for len(data) > 0 {
+		n := len(data)
+		if n > 0x7f {
+			n = 0x7f
+		}
+
+		out = append(out, byte(n))
+		out = append(out, data[:n]...)
+		data = data[n:]
+	}
+	return out
+}
+
+//appendDeltaCopy adds copy operations for the size bytes at
+//offset in the base. A single copy can copy 0x10000 bytes at
+//most, to stay compatible with all versions of git.
+func appendDeltaCopy(out []byte, offset, size int) []byte {
+	for size > 0 {
+		n := size
+		if n > 0x10000 {
+			n = 0x10000
+		}
+
+		cmd := byte(0x80)
+		var args [7]byte
+		k := 0
+
+		for i := uint(0); i < 4; i++ {
+			if b := byte(offset >> (8 * i)); b != 0 {
+				cmd |= 1 << i
+				args[k] = b
+				k++
+			}
+		}
+
+		//a size of 0 stands for 0x10000
+		if n != 0x10000 {
+			for i := uint(0); i < 3; i++ {
+				if b := byte(n >> (8 * i)); b != 0 {
+					cmd |= 0x10 << i
+					args[k] = b
+					k++
+				}
+			}
+		}
+
+		out = append(out, cmd)
+		out = append(out, args[:k]...)
+
+		offset += n
+		size -= n
+	}
+
+	return out
+}
+
+//encode computes the delta that transforms the indexed base into
+//target. It returns nil if the delta would be larger than maxSize.
+func (idx *deltaIndex) encode(target []byte, maxSize int) []byte {
+	base := idx.base
+	if len(base) >= 1<<32 {
+		return nil
+	}
+
+	out := make([]byte, 0, maxSize)
+	out = appendVarSize(out, len(base))
+	out = appendVarSize(out, len(target))
+
+	var h uint32
+	if len(target) >= deltaBlockSize {
+		h = deltaHash(target)
+	}
+
+	insert := 0 //start of the pending insert data
+	for i := 0; i < len(target); {
+		if len(out)+(i-insert) > maxSize {
+			return nil
+		}
+
+		if i+deltaBlockSize > len(target) {
+			i = len(target)
+			break
+		}
+
+		//find the longest match for the block at i
+		moff, mlen := 0, 0
+		for _, off := range idx.blocks[h] {
+			n := 0
+			for off+n < len(base) && i+n < len(target) && base[off+n] == target[i+n] {
+				n++
+			}
+
+			if n > mlen {
+				moff, mlen = off, n
+			}
+		}
+
+		if mlen < deltaBlockSize {
+			//no match, the byte becomes part of an insert
+			if i+deltaBlockSize < len(target) {
+				h = (h-uint32(target[i])*deltaHashPow)*deltaHashMul + uint32(target[i+deltaBlockSize])
+			}
+			i++
+			continue
+		}
+
+		//extend the match backwards into the pending insert
+		for moff > 0 && i > insert && base[moff-1] == target[i-1] {
+			moff--
+			i--
+			mlen++
+		}
+
+		out = appendDeltaInsert(out, target[insert:i])
+		out = appendDeltaCopy(out, moff, mlen)
+
+		i += mlen
+		insert = i
+		if i+deltaBlockSize <= len(target) {
+			h = deltaHash(target[i:])
+		}
+	}
+
+	out = appendDeltaInsert(out, target[insert:])
+	if len(out) > maxSize {
+		return nil
+	}
+
+	return out
+}
@@ -0,0 +1,255 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"path"
+)
+
+//ChangeType is the kind of change of a path between two trees.
+type ChangeType uint8
+
+//ChangeType values. ChangeMode means that only the mode changed
+//(e.g. the executable bit), ChangeObjectType that a path changed
+//between a regular file, a symlink and a submodule. A file that is
+//replaced by a directory is reported as deleted and the files in
+//the directory as added, like git does. ChangeRename and ChangeCopy
+//are only reported by DetectRenames.
+const (
+	ChangeAdd ChangeType = iota + 1
+	ChangeDelete
+	ChangeModify
+	ChangeMode
+	ChangeObjectType
+	ChangeRename
+	ChangeCopy
+)
+
+func (ct ChangeType) String() string {
+	switch ct {
+	case ChangeAdd:
+		return "add"
+	case ChangeDelete:
+		return "delete"
+	case ChangeModify:
+		return "modify"
+	case ChangeMode:
+		return "mode"
+	case ChangeObjectType:
+		return "type"
+	case ChangeRename:
+		return "rename"
+	case ChangeCopy:
+		return "copy"
+	}
+	return fmt.Sprintf("ChangeType(%d)", uint8(ct))
+}
+
+//TreeChange is the change of a single path. The old mode and id
+//are zero for added paths, the new ones for deleted paths. For
+//renames and copies OldPath is the source path and Similarity the
+//similarity of the content in percent.
+type TreeChange struct {
+	Type       ChangeType
+	Path       string
+	OldPath    string
+	Similarity int
+
+	OldMode os.FileMode
+	NewMode os.FileMode
+	OldID   ObjectID
+	NewID   ObjectID
+}
+
+//fileKind returns the object type bits of a tree entry mode,
+//i.e. it distinguishes files, symlinks, trees and submodules.
+func fileKind(mode os.FileMode) os.FileMode {
+	return mode & 0170000
+}
+
+//readTreeEntries reads all entries of tree, which may be nil
+//for the empty tree. The tree is closed.
+func readTreeEntries(tree *Tree) ([]TreeEntry, error) {
+	if tree == nil {
+		return nil, nil
+	}
+	defer tree.Close()
+
+	var entries []TreeEntry
+	for tree.Next() {
+		entries = append(entries, *tree.Entry())
+	}
+
+	return entries, tree.Err()
+}
+
+//openTree opens the tree with the given id.
+func (repo *Repository) openTree(id ObjectID) (*Tree, error) {
+	obj, err := repo.OpenObject(id)
+	if err != nil {
+		return nil, err
+	}
+
+	tree, ok := obj.(*Tree)
+	if !ok {
+		obj.Close()
+		return nil, fmt.Errorf("git: %s is a %s, not a tree", id, obj.Type())
+	}
+
+	return tree, nil
+}
+
+//DiffTrees compares the trees a and b recursively and returns the
+//changes of all paths below them (only files, symlinks and
+//submodules, not trees themselves), in the order of the trees. Either
+//tree may be nil, which stands for the empty tree. The trees are
+//consumed and closed.
+func (repo *Repository) DiffTrees(a, b *Tree) ([]TreeChange, error) {
+	var changes []TreeChange
+	err := repo.diffTrees("", a, b, &changes)
+	return changes, err
+}
+
+func (repo *Repository) diffTrees(prefix string, a, b *Tree, changes *[]TreeChange) error {
+	ea, err := readTreeEntries(a)
+	if err != nil {
+		if b != nil {
+			b.Close()
+		}
+		return err
+	}
+
+	eb, err := readTreeEntries(b)
+	if err != nil {
+		return err
+	}
+
+	//entries are compared by their sort key, so that a file and
+	//a directory with the same name are treated as unrelated
+	key := func(e *TreeEntry) string {
+		if fileKind(e.Mode) == 040000 {
+			return e.Name + "/"
+		}
+		return e.Name
+	}
+
+	for i, j := 0, 0; i < len(ea) || j < len(eb); {
+		var old, cur *TreeEntry
+		switch {
+		case j == len(eb) || (i < len(ea) && key(&ea[i]) < key(&eb[j])):
+			old = &ea[i]
+			i++
+		case i == len(ea) || key(&ea[i]) > key(&eb[j]):
+			cur = &eb[j]
+			j++
+		default:
+			old, cur = &ea[i], &eb[j]
+			i++
+			j++
+		}
+
+		err = repo.diffEntries(prefix, old, cur, changes)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//diffEntries compares the entries old and cur with the same sort
+//key, one of which may be nil.
+func (repo *Repository) diffEntries(prefix string, old, cur *TreeEntry, changes *[]TreeChange) error {
+	var isTree bool
+	var name string
+	if old != nil {
+		isTree, name = fileKind(old.Mode) == 040000, old.Name
+	} else {
+		isTree, name = fileKind(cur.Mode) == 040000, cur.Name
+	}
+	fullpath := path.Join(prefix, name)
+
+	if isTree {
+		if old != nil && cur != nil && old.ID == cur.ID {
+			return nil
+		}
+
+		var a, b *Tree
+		var err error
+		if old != nil {
+			if a, err = repo.openTree(old.ID); err != nil {
+				return err
+			}
+		}
+
+		if cur != nil {
+			if b, err = repo.openTree(cur.ID); err != nil {
+				if a != nil {
+					a.Close()
+				}
+				return err
+			}
+		}
+
+		return repo.diffTrees(fullpath, a, b, changes)
+	}
+
+	change := TreeChange{Path: fullpath}
+	if old != nil {
+		change.OldMode, change.OldID = old.Mode, old.ID
+	}
+	if cur != nil {
+		change.NewMode, change.NewID = cur.Mode, cur.ID
+	}
+
+	switch {
+	case old == nil:
+		change.Type = ChangeAdd
+	case cur == nil:
+		change.Type = ChangeDelete
+	case old.ID == cur.ID && old.Mode == cur.Mode:
+		return nil
+	case fileKind(old.Mode) != fileKind(cur.Mode):
+		change.Type = ChangeObjectType
+	case old.ID == cur.ID:
+		change.Type = ChangeMode
+	default:
+		change.Type = ChangeModify
+	}
+
+	*changes = append(*changes, change)
+	return nil
+}
+
+//CommitChanges returns the changes introduced by the commit, i.e.
+//the difference between the tree of its first parent (or the empty
+//tree for root commits) and its own tree.
+func (repo *Repository) CommitChanges(commit *Commit) ([]TreeChange, error) {
+	var parent *Tree
+	if len(commit.Parent) > 0 {
+		obj, err := repo.OpenObject(commit.Parent[0])
+		if err != nil {
+			return nil, err
+		}
+		obj.Close()
+
+		pc, ok := obj.(*Commit)
+		if !ok {
+			return nil, fmt.Errorf("git: parent %s is a %s, not a commit", commit.Parent[0], obj.Type())
+		}
+
+		if parent, err = repo.openTree(pc.Tree); err != nil {
+			return nil, err
+		}
+	}
+
+	tree, err := repo.openTree(commit.Tree)
+	if err != nil {
+		if parent != nil {
+			parent.Close()
+		}
+		return nil, err
+	}
+
+	return repo.DiffTrees(parent, tree)
+}
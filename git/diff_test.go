@@ -0,0 +1,145 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//rawChange formats a change like git diff-tree --raw.
+func rawChange(c TreeChange) string {
+	letter := map[ChangeType]string{
+		ChangeAdd:        "A",
+		ChangeDelete:     "D",
+		ChangeModify:     "M",
+		ChangeMode:       "M",
+		ChangeObjectType: "T",
+	}[c.Type]
+
+	return fmt.Sprintf(":%06o %06o %s %s %s\t%s", uint32(c.OldMode), uint32(c.NewMode), c.OldID, c.NewID, letter, c.Path)
+}
+
+func TestDiffTrees(t *testing.T) {
+	tr := mkTestRepo(t)
+	defer tr.cleanup()
+
+	tr.write("README", "readme\n")
+	tr.write("data/a.txt", "a\n")
+	tr.write("data/b.txt", "b\n")
+	tr.write("data/sub/c.txt", "c\n")
+	tr.write("file-to-dir", "file\n")
+	tr.write("script.sh", "#!/bin/sh\n")
+	tr.write("link", "target\n")
+	tr.write("data.txt", "sorts after data/\n")
+	c1 := tr.commit("first")
+
+	tr.write("data/a.txt", "a changed\n")
+	os.Remove(filepath.Join(tr.work, "data", "b.txt"))
+	tr.write("data/new/d.txt", "d\n")
+	os.Remove(filepath.Join(tr.work, "file-to-dir"))
+	tr.write("file-to-dir/e.txt", "e\n")
+	os.Remove(filepath.Join(tr.work, "link"))
+	if err := os.Symlink("README", filepath.Join(tr.work, "link")); err != nil {
+		t.Fatal(err)
+	}
+	tr.git("add", "-A", ".")
+	tr.git("update-index", "--chmod=+x", "script.sh")
+	tr.git("update-index", "--add", "--cacheinfo", fmt.Sprintf("160000,%s,submodule", c1))
+	tr.git("commit", "-q", "-m", "second")
+	c2 := tr.revParse("HEAD")
+
+	tests := []struct {
+		name string
+		a, b string
+	}{
+		{"changes", c1.String() + "^{tree}", c2.String() + "^{tree}"},
+		{"reverse", c2.String() + "^{tree}", c1.String() + "^{tree}"},
+		{"same", c1.String() + "^{tree}", c1.String() + "^{tree}"},
+		{"root", "", c1.String() + "^{tree}"},
+		{"subtree", c1.String() + ":data", c2.String() + ":data"},
+	}
+
+	for _, tt := range tests {
+		var a, b *Tree
+		var err error
+		if tt.a != "" {
+			id, _ := tr.ResolveRevision(tt.a)
+			if a, err = tr.openTree(id); err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+		}
+
+		id, _ := tr.ResolveRevision(tt.b)
+		if b, err = tr.openTree(id); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		changes, err := tr.DiffTrees(a, b)
+		if err != nil {
+			t.Fatalf("%s: DiffTrees() => %v", tt.name, err)
+		}
+
+		var lines []string
+		for _, c := range changes {
+			lines = append(lines, rawChange(c))
+		}
+
+		from := tt.a
+		if from == "" {
+			from = tr.git("hash-object", "-t", "tree", "--stdin")
+		}
+		expected := tr.git("diff-tree", "-r", "--no-renames", "--raw", "--abbrev=40", from, tt.b)
+
+		if actual := strings.Join(lines, "\n"); actual != expected {
+			t.Fatalf("%s: changes differ from git diff-tree:\n%s\n--- expected ---\n%s", tt.name, actual, expected)
+		}
+	}
+
+	commit, err := tr.OpenObject(c2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit.Close()
+
+	changes, err := tr.CommitChanges(commit.(*Commit))
+	if err != nil {
+		t.Fatalf("CommitChanges() => %v", err)
+	}
+
+	types := make(map[string]ChangeType)
+	for _, c := range changes {
+		types[c.Path] = c.Type
+	}
+
+	expected := map[string]ChangeType{
+		"data/a.txt":        ChangeModify,
+		"data/b.txt":        ChangeDelete,
+		"data/new/d.txt":    ChangeAdd,
+		"file-to-dir":       ChangeDelete,
+		"file-to-dir/e.txt": ChangeAdd,
+		"link":              ChangeObjectType,
+		"script.sh":         ChangeMode,
+		"submodule":         ChangeAdd,
+	}
+
+	if len(types) != len(expected) {
+		t.Fatalf("expected %d changes, got %v", len(expected), changes)
+	}
+
+	for path, ct := range expected {
+		if types[path] != ct {
+			t.Fatalf("expected %s for %q, got %s", ct, path, types[path])
+		}
+	}
+
+	summaries, err := tr.CommitsForRef("master", nil)
+	if err != nil {
+		t.Fatalf("CommitsForRef() => %v", err)
+	}
+
+	if len(summaries) != 2 || len(summaries[0].Changes) != len(changes) || len(summaries[1].Changes) != 8 {
+		t.Fatalf("unexpected commit summaries: %+v", summaries)
+	}
+}
@@ -0,0 +1,597 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//FsckProblem is a problem found by Fsck. Path is the file, relative
+//to the repository, the problem was found in and ID the affected
+//object, if known.
+type FsckProblem struct {
+	Path string
+	ID   ObjectID
+	Msg  string
+}
+
+func (p FsckProblem) String() string {
+	var parts []string
+	if p.Path != "" {
+		parts = append(parts, p.Path)
+	}
+
+	if !p.ID.IsZero() {
+		parts = append(parts, p.ID.String())
+	}
+
+	return strings.Join(append(parts, p.Msg), ": ")
+}
+
+//FsckReport is the result of Fsck. Objects is the number of objects
+//that were checked, loose and packed. Unreachable objects are not a
+//problem, they are only counted.
+type FsckReport struct {
+	Packs       int
+	Objects     int
+	Reachable   int
+	Unreachable int
+
+	Problems []FsckProblem
+}
+
+//OK returns true if no problems were found.
+func (r *FsckReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+//fsck holds the state of a Fsck run.
+type fsck struct {
+	repo   *Repository
+	report *FsckReport
+
+	//all intact objects and their types
+	objects   map[ObjectID]ObjectType
+	reachable map[ObjectID]bool
+}
+
+func (f *fsck) problem(path string, id ObjectID, format string, args ...interface{}) {
+	p := FsckProblem{Path: path, ID: id, Msg: fmt.Sprintf(format, args...)}
+	f.report.Problems = append(f.report.Problems, p)
+}
+
+//Fsck verifies the integrity of the repository. It checks the
+//checksums of all packs and their indices, including the crc32
+//values of version 2 indices, recomputes the ids of all loose and
+//packed objects, checks the format of trees, commits and tags and
+//walks the history from all refs to make sure all objects that are
+//reachable exist. Problems with the data are collected in the
+//report; an error is returned only if the check itself failed.
+func (repo *Repository) Fsck() (*FsckReport, error) {
+	f := &fsck{
+		repo:      repo,
+		report:    &FsckReport{},
+		objects:   make(map[ObjectID]ObjectType),
+		reachable: make(map[ObjectID]bool),
+	}
+
+	if err := f.checkPacks(); err != nil {
+		return nil, err
+	}
+
+	if err := f.checkLooseObjects(); err != nil {
+		return nil, err
+	}
+
+	if err := f.checkConnectivity(); err != nil {
+		return nil, err
+	}
+
+	f.report.Reachable = len(f.reachable)
+	f.report.Unreachable = len(f.objects) - len(f.reachable)
+
+	return f.report, nil
+}
+
+//fileChecksum computes the checksum of the file at path with the
+//hash of format, excluding the trailing checksum, and returns it
+//together with the trailer.
+func fileChecksum(path string, format ObjectFormat) (sum, trailer ObjectID, err error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer fd.Close()
+
+	fi, err := fd.Stat()
+	if err != nil {
+		return
+	} else if fi.Size() < int64(format.Size()) {
+		err = fmt.Errorf("git: file too short")
+		return
+	}
+
+	h := format.New()
+	if _, err = io.CopyN(h, fd, fi.Size()-int64(format.Size())); err != nil {
+		return
+	}
+	sum = format.sum(h)
+
+	trailer, err = readObjectID(fd, format)
+	return
+}
+
+func (f *fsck) checkPacks() error {
+	indices, err := filepath.Glob(filepath.Join(f.repo.Path, "objects", "pack", "*.idx"))
+	if err != nil {
+		return err
+	}
+
+	for _, path := range indices {
+		f.report.Packs++
+		if err = f.checkPack(path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//fsckPackEntry is an object in a pack, as listed in its index.
+type fsckPackEntry struct {
+	id  ObjectID
+	pos int
+	off int64
+}
+
+type fsckPackEntries []fsckPackEntry
+
+func (e fsckPackEntries) Len() int           { return len(e) }
+func (e fsckPackEntries) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+func (e fsckPackEntries) Less(i, j int) bool { return e[i].off < e[j].off }
+
+//checkPack checks the index at path and its pack.
+func (f *fsck) checkPack(path string) error {
+	idxname := f.relPath(path)
+	packpath := strings.TrimSuffix(path, ".idx") + ".pack"
+	packname := f.relPath(packpath)
+
+	var zero ObjectID
+	format := f.repo.ObjectFormat()
+
+	sum, trailer, err := fileChecksum(path, format)
+	if err != nil {
+		f.problem(idxname, zero, "could not read index: %v", err)
+		return nil
+	} else if sum != trailer {
+		f.problem(idxname, zero, "index checksum mismatch")
+	}
+
+	sum, trailer, err = fileChecksum(packpath, format)
+	if err != nil {
+		f.problem(packname, zero, "could not read pack: %v", err)
+		return nil
+	} else if sum != trailer {
+		f.problem(packname, zero, "pack checksum mismatch")
+	}
+
+	idx, err := PackIndexOpen(path)
+	if err != nil {
+		f.problem(idxname, zero, "%v", err)
+		return nil
+	}
+	defer idx.Close()
+
+	if idx.Format != format {
+		f.problem(idxname, zero, "index has object format %s, repository %s", idx.Format, format)
+		return nil
+	}
+
+	fi, err := idx.Stat()
+	if err != nil {
+		return err
+	}
+
+	packsum, err := readObjectID(io.NewSectionReader(idx, fi.Size()-2*int64(format.Size()), int64(format.Size())), format)
+	if err != nil {
+		f.problem(idxname, zero, "could not read pack checksum: %v", err)
+	} else if packsum != trailer {
+		f.problem(idxname, zero, "index belongs to a different pack (%s)", packsum)
+	}
+
+	pf, err := idx.OpenPackFile()
+	if err != nil {
+		f.problem(packname, zero, "%v", err)
+		return nil
+	}
+	defer pf.Close()
+
+	n := int(idx.FO[255])
+	if pf.ObjCount != uint32(n) {
+		f.problem(packname, zero, "pack has %d objects, index %d", pf.ObjCount, n)
+	}
+
+	entries := make([]fsckPackEntry, 0, n)
+	for pos := 0; pos < n; pos++ {
+		e := fsckPackEntry{pos: pos}
+
+		if err = idx.ReadObjectID(&e.id, pos); err != nil {
+			f.problem(idxname, zero, "could not read object id %d: %v", pos, err)
+			return nil
+		}
+
+		if e.off, err = idx.ReadOffset(pos); err != nil {
+			f.problem(idxname, e.id, "could not read offset: %v", err)
+			return nil
+		}
+
+		if pos > 0 && entries[pos-1].id.Compare(e.id) >= 0 {
+			f.problem(idxname, e.id, "index is not sorted")
+		}
+
+		entries = append(entries, e)
+	}
+
+	//the crc32 covers the data up to the next object
+	sort.Sort(fsckPackEntries(entries))
+	pfi, err := pf.Stat()
+	if err != nil {
+		return err
+	}
+	end := pfi.Size() - int64(format.Size())
+
+	for i, e := range entries {
+		next := end
+		if i+1 < len(entries) {
+			next = entries[i+1].off
+		}
+
+		if e.off < 12 || e.off >= next {
+			f.problem(idxname, e.id, "invalid offset %d", e.off)
+			continue
+		}
+
+		if idx.Version >= 2 {
+			expected, err := idx.ReadCRC32(e.pos)
+			if err != nil {
+				f.problem(idxname, e.id, "%v", err)
+				continue
+			}
+
+			crc := crc32.NewIEEE()
+			_, err = io.Copy(crc, io.NewSectionReader(pf, e.off, next-e.off))
+			if err != nil {
+				f.problem(packname, e.id, "could not read packed data: %v", err)
+				continue
+			} else if crc.Sum32() != expected {
+				f.problem(packname, e.id, "crc32 mismatch")
+				continue
+			}
+		}
+
+		obj, err := f.openPackedObject(pf, e.off)
+		if err != nil {
+			f.problem(packname, e.id, "could not read object: %v", err)
+			continue
+		}
+
+		f.checkObject(packname, e.id, obj)
+	}
+
+	return nil
+}
+
+//openPackedObject reads the object at off in pf, resolving deltas.
+func (f *fsck) openPackedObject(pf *PackFile, off int64) (gitObject, error) {
+	obj, err := pf.readRawObject(off)
+	if err != nil || !IsDeltaObject(obj.otype) {
+		return obj, err
+	}
+
+	delta, err := parseDelta(obj)
+	if err != nil {
+		return gitObject{}, err
+	}
+
+	chain, err := buildDeltaChain(delta, f.repo)
+	if err != nil {
+		return gitObject{}, err
+	}
+
+	return chain.resolveRaw()
+}
+
+func (f *fsck) checkLooseObjects() error {
+	dirs, err := ioutil.ReadDir(filepath.Join(f.repo.Path, "objects"))
+	if err != nil {
+		return err
+	}
+
+	for _, dir := range dirs {
+		if !dir.IsDir() || len(dir.Name()) != 2 || !isHex(dir.Name()) {
+			continue
+		}
+
+		dirpath := filepath.Join(f.repo.Path, "objects", dir.Name())
+		files, err := ioutil.ReadDir(dirpath)
+		if err != nil {
+			return err
+		}
+
+		for _, fi := range files {
+			id, err := ParseObjectID(dir.Name() + fi.Name())
+			if err != nil {
+				//e.g. temporary files
+				continue
+			}
+
+			path := filepath.Join(dirpath, fi.Name())
+			obj, err := openRawObject(path)
+			if err != nil {
+				f.problem(f.relPath(path), id, "could not read object: %v", err)
+				continue
+			}
+
+			f.checkObject(f.relPath(path), id, obj)
+		}
+	}
+
+	return nil
+}
+
+//checkObject reads obj, which is closed afterwards, checks that its
+//id is id and checks the content of trees, commits and tags.
+func (f *fsck) checkObject(path string, id ObjectID, obj gitObject) {
+	defer obj.Close()
+	f.report.Objects++
+
+	var data []byte
+	var r io.Reader = obj.source
+	if obj.otype != ObjBlob {
+		var err error
+		data, err = ioutil.ReadAll(io.LimitReader(obj.source, obj.size+1))
+		if err != nil {
+			f.problem(path, id, "could not read object: %v", err)
+			return
+		}
+		r = bytes.NewReader(data)
+	}
+
+	format := f.repo.ObjectFormat()
+	actual, err := hashObject(format, obj.otype, obj.size, r)
+	if err != nil {
+		f.problem(path, id, "could not read object: %v", err)
+		return
+	} else if actual != id {
+		f.problem(path, id, "hash mismatch (content is %s)", actual)
+		return
+	}
+
+	switch obj.otype {
+	case ObjTree:
+		for _, msg := range checkTreeData(data, format) {
+			f.problem(path, id, "%s", msg)
+		}
+	case ObjCommit, ObjTag:
+		parsed, err := parseObject(gitObject{obj.otype, obj.size, ioutil.NopCloser(bytes.NewReader(data))}, format)
+		if err != nil {
+			f.problem(path, id, "malformed %s: %v", obj.otype, err)
+			return
+		}
+		parsed.Close()
+	}
+
+	f.objects[id] = obj.otype
+}
+
+//validTreeModes are the modes git allows for tree entries.
+var validTreeModes = map[string]bool{
+	"40000":  true,
+	"100644": true,
+	"100755": true,
+	"120000": true,
+	"160000": true,
+}
+
+//checkTreeData checks the raw data of a tree with ids of format for
+//invalid modes and names and for the order of its entries.
+func checkTreeData(data []byte, format ObjectFormat) []string {
+	var msgs []string
+	var last string
+	names := make(map[string]bool)
+
+	for len(data) > 0 {
+		sp := bytes.IndexByte(data, ' ')
+		nul := bytes.IndexByte(data, 0)
+		if sp < 0 || nul < sp || len(data) < nul+1+format.Size() {
+			return append(msgs, "malformed tree entry")
+		}
+
+		mode, name := string(data[:sp]), string(data[sp+1:nul])
+		data = data[nul+1+format.Size():]
+
+		if strings.HasPrefix(mode, "0") {
+			msgs = append(msgs, fmt.Sprintf("zero-padded mode %s for %q", mode, name))
+			mode = strings.TrimLeft(mode, "0")
+		}
+
+		if !validTreeModes[mode] {
+			msgs = append(msgs, fmt.Sprintf("invalid mode %s for %q", mode, name))
+		}
+
+		switch {
+		case name == "", name == ".", name == "..", strings.Contains(name, "/"):
+			msgs = append(msgs, fmt.Sprintf("invalid entry name %q", name))
+		case strings.EqualFold(name, ".git"):
+			msgs = append(msgs, fmt.Sprintf("entry %q not allowed", name))
+		}
+
+		if names[name] {
+			msgs = append(msgs, fmt.Sprintf("duplicate entry %q", name))
+		}
+		names[name] = true
+
+		//trees sort as if their name ended with a slash
+		key := name
+		if m, err := strconv.ParseUint(mode, 8, 32); err == nil && m == 040000 {
+			key += "/"
+		}
+
+		if key < last {
+			msgs = append(msgs, fmt.Sprintf("entry %q is not sorted", name))
+		}
+		last = key
+	}
+
+	return msgs
+}
+
+//checkConnectivity walks all refs (and HEAD) and checks that all
+//objects reachable from them exist.
+func (f *fsck) checkConnectivity() error {
+	refs, err := f.repo.ListRefs("refs/")
+	if err != nil {
+		return err
+	}
+
+	var zero ObjectID
+	tips := make(map[ObjectID]string)
+
+	if head, err := f.repo.parseRef("HEAD"); err == nil {
+		if id, err := head.Resolve(); err == nil {
+			tips[id] = "HEAD"
+		} else if _, ok := head.(*SymbolicRef); !ok {
+			f.problem("HEAD", zero, "%v", err)
+		}
+	}
+
+	for _, ref := range refs {
+		id, err := ref.Resolve()
+		if err != nil {
+			f.problem(RefPath(ref), zero, "%v", err)
+			continue
+		}
+		tips[id] = RefPath(ref)
+	}
+
+	//the commit-graph file is not used: it must not hide missing
+	//or corrupt commits
+	graph := newObjectGraph(f.repo)
+	for id, name := range tips {
+		id = f.peelTags(name, id)
+
+		switch f.objects[id] {
+		case ObjCommit:
+			if _, err := graph.AddTip(id); err != nil {
+				f.problem(name, id, "%v", err)
+			}
+		case ObjTree:
+			f.walkTree(name, id)
+		case ObjBlob:
+			f.reachable[id] = true
+		}
+	}
+
+	//a missing parent must not stop the walk
+	graph.walkAll(func(node *CommitNode, parents []*CommitNode, missing []ObjectID) []*CommitNode {
+		f.reachable[node.ID] = true
+
+		for _, parent := range missing {
+			f.problem("", node.ID, "parent %s is missing or corrupt", parent)
+		}
+
+		var follow []*CommitNode
+		for _, parent := range parents {
+			if f.objects[parent.ID] != ObjCommit {
+				f.problem("", node.ID, "parent %s is missing or corrupt", parent.ID)
+				continue
+			}
+			follow = append(follow, parent)
+		}
+
+		f.walkTree("", node.tree)
+		return follow
+	})
+
+	return nil
+}
+
+//peelTags marks the tags starting at id as reachable and returns the
+//object they point to. Missing objects are reported.
+func (f *fsck) peelTags(name string, id ObjectID) ObjectID {
+	for {
+		otype, ok := f.objects[id]
+		if !ok {
+			f.problem(name, id, "missing or corrupt object")
+			return id
+		} else if otype != ObjTag || f.reachable[id] {
+			return id
+		}
+		f.reachable[id] = true
+
+		obj, err := f.repo.OpenObject(id)
+		if err != nil {
+			f.problem(name, id, "%v", err)
+			return id
+		}
+		obj.Close()
+
+		id = obj.(*Tag).Object
+	}
+}
+
+//walkTree marks the tree id and everything in it as reachable.
+func (f *fsck) walkTree(path string, id ObjectID) {
+	if f.reachable[id] {
+		return
+	}
+
+	if f.objects[id] != ObjTree {
+		f.problem(path, id, "tree is missing or corrupt")
+		return
+	}
+	f.reachable[id] = true
+
+	obj, err := f.repo.OpenObject(id)
+	if err != nil {
+		f.problem(path, id, "%v", err)
+		return
+	}
+	defer obj.Close()
+
+	tree := obj.(*Tree)
+	for tree.Next() {
+		entry := tree.Entry()
+
+		switch entry.Mode {
+		case 040000:
+			f.walkTree(path, entry.ID)
+		case 0160000:
+			//submodule commits live in other repositories
+		default:
+			if f.objects[entry.ID] != ObjBlob {
+				f.problem(path, entry.ID, "blob %q of tree %s is missing or corrupt", entry.Name, id)
+				continue
+			}
+			f.reachable[entry.ID] = true
+		}
+	}
+
+	if err = tree.Err(); err != nil {
+		f.problem(path, id, "%v", err)
+	}
+}
+
+func (f *fsck) relPath(path string) string {
+	if rel, err := filepath.Rel(f.repo.Path, path); err == nil {
+		return filepath.ToSlash(rel)
+	}
+	return path
+}
@@ -0,0 +1,223 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func checkFsckProblems(t *testing.T, name string, report *FsckReport, expected ...string) {
+	for _, e := range expected {
+		found := false
+		for _, p := range report.Problems {
+			if strings.Contains(p.String(), e) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			t.Errorf("%s: expected a problem containing %q, got:", name, e)
+			for _, p := range report.Problems {
+				t.Errorf("\t%s", p)
+			}
+		}
+	}
+}
+
+func TestFsck(t *testing.T) {
+	tr, blobs := mkDeltaRepo(t, 10)
+	defer tr.cleanup()
+
+	tr.git("tag", "-a", "-m", "a tag", "v1")
+	tr.write("loose.txt", "a loose object\n")
+	tr.commit("loose objects")
+
+	report, err := tr.Fsck()
+	if err != nil {
+		t.Fatalf("Fsck() => %v", err)
+	}
+
+	if !report.OK() {
+		t.Fatalf("expected no problems, got %v", report.Problems)
+	}
+
+	objects := strings.Split(tr.git("rev-list", "--objects", "--all"), "\n")
+	if report.Reachable != len(objects) || report.Packs != 1 || report.Unreachable != 0 {
+		t.Fatalf("unexpected report: %+v (%d objects)", report, len(objects))
+	}
+
+	//an unreachable object is fine
+	tr.git("hash-object", "-w", "--stdin")
+	if report, err = tr.Fsck(); err != nil || !report.OK() || report.Unreachable != 1 {
+		t.Fatalf("unexpected report: %+v, %v", report, err)
+	}
+
+	//a loose object with the wrong content
+	id := tr.revParse("HEAD:loose.txt")
+	path := filepath.Join(tr.Path, "objects", id.String()[:2], id.String()[2:])
+	other := tr.git("hash-object", "-w", "--stdin")
+	otherPath := filepath.Join(tr.Path, "objects", other[:2], other[2:])
+
+	os.Chmod(path, 0644)
+	data, err := ioutil.ReadFile(otherPath)
+	if err == nil {
+		err = ioutil.WriteFile(path, data, 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err = tr.Fsck()
+	if err != nil {
+		t.Fatalf("Fsck() => %v", err)
+	}
+	checkFsckProblems(t, "loose", report, id.String()+": hash mismatch", "blob \"loose.txt\"")
+
+	//a missing object
+	os.Remove(path)
+	report, err = tr.Fsck()
+	if err != nil {
+		t.Fatalf("Fsck() => %v", err)
+	}
+	checkFsckProblems(t, "missing", report, "blob \"loose.txt\"")
+
+	//damaged packed data
+	packs, _ := filepath.Glob(filepath.Join(tr.Path, "objects", "pack", "*.pack"))
+	if len(packs) != 1 {
+		t.Fatalf("expected a single pack, got %v", packs)
+	}
+
+	idx, err := PackIndexOpen(strings.TrimSuffix(packs[0], ".pack") + ".idx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	off, err := idx.FindOffset(blobs[0])
+	idx.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.Chmod(packs[0], 0644)
+	data, err = ioutil.ReadFile(packs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[off+10] ^= 0xff
+	if err = ioutil.WriteFile(packs[0], data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tr.Close()
+	report, err = tr.Fsck()
+	if err != nil {
+		t.Fatalf("Fsck() => %v", err)
+	}
+	checkFsckProblems(t, "pack", report, "pack checksum mismatch", blobs[0].String()+": crc32 mismatch")
+
+	for _, p := range report.Problems {
+		t.Logf("%s", p)
+	}
+}
+
+func TestFsckMissingParent(t *testing.T) {
+	tr := mkTestRepo(t)
+	defer tr.cleanup()
+
+	tr.write("a", "a\n")
+	base := tr.commit("base")
+	tr.write("lost", "lost\n")
+	lost := tr.commit("lost")
+	tr.git("rm", "-q", "lost")
+	tr.commit("after the lost commit")
+
+	tr.git("checkout", "-q", "-b", "side", base.String())
+	tr.write("b", "b\n")
+	tr.commit("side")
+	tr.git("checkout", "-q", "master")
+
+	//the commit-graph file still has the lost commit
+	if err := tr.WriteCommitGraph(); err != nil {
+		t.Fatalf("WriteCommitGraph() => %v", err)
+	}
+
+	path := filepath.Join(tr.Path, "objects", lost.String()[:2], lost.String()[2:])
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+
+	//the walk goes on past the missing parent, only the
+	//tree and blob of the lost commit become unreachable
+	for i := 0; i < 5; i++ {
+		report, err := tr.Fsck()
+		if err != nil {
+			t.Fatalf("Fsck() => %v", err)
+		}
+
+		checkFsckProblems(t, "missing parent", report, "parent "+lost.String()+" is missing")
+		if len(report.Problems) != 1 || report.Unreachable != 2 {
+			t.Fatalf("unexpected report: %+v", report)
+		}
+	}
+}
+
+func TestFsckTree(t *testing.T) {
+	tr := mkTestRepo(t)
+	defer tr.cleanup()
+
+	tr.write("a", "a\n")
+	tr.commit("initial")
+	blob := tr.revParse("HEAD:a")
+
+	entry := func(mode, name string) string {
+		return fmt.Sprintf("%s %s\x00%s", mode, name, blob.Bytes())
+	}
+
+	tests := []struct {
+		name    string
+		entries []string
+		problem string
+	}{
+		{"ok", []string{entry("100644", "a"), entry("40000", "a-b"), entry("100755", "a.c"), entry("40000", "a.d")}, ""},
+		{"gitlink", []string{entry("160000", "sub"), entry("120000", "link")}, "not sorted"},
+		{"order", []string{entry("100644", "b"), entry("100644", "a")}, "not sorted"},
+		{"tree-order", []string{entry("40000", "a"), entry("100644", "a.b")}, "not sorted"},
+		{"duplicate", []string{entry("100644", "a"), entry("100644", "a")}, "duplicate entry"},
+		{"zero-padded", []string{entry("040000", "a")}, "zero-padded"},
+		{"mode", []string{entry("100664", "a")}, "invalid mode 100664"},
+		{"dotdot", []string{entry("100644", "..")}, "invalid entry name"},
+		{"dotgit", []string{entry("40000", ".GIT")}, "not allowed"},
+		{"truncated", []string{entry("100644", "a")[:10]}, "malformed"},
+	}
+
+	for _, tt := range tests {
+		data := []byte(strings.Join(tt.entries, ""))
+		msgs := checkTreeData(data, blob.Format())
+
+		if tt.problem == "" && len(msgs) != 0 {
+			t.Fatalf("%s: expected no problems, got %v", tt.name, msgs)
+		} else if tt.problem != "" && (len(msgs) == 0 || !strings.Contains(strings.Join(msgs, "\n"), tt.problem)) {
+			t.Fatalf("%s: expected %q, got %v", tt.name, tt.problem, msgs)
+		}
+
+		if tt.problem == "" {
+			continue
+		}
+
+		//the problem is found in the repository, too
+		id, err := tr.WriteObject(ObjTree, int64(len(data)), bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: WriteObject() => %v", tt.name, err)
+		}
+
+		report, err := tr.Fsck()
+		if err != nil {
+			t.Fatalf("%s: Fsck() => %v", tt.name, err)
+		}
+		checkFsckProblems(t, tt.name, report, id.String()+": ")
+	}
+}
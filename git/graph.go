@@ -18,51 +18,152 @@ const (
 	NodeFlagSeen = 1 << 4
 )
 
+//CommitNode is a commit in a CommitGraph. Parents, tree and date
+//are taken from the commit-graph file of the repository, if the
+//commit is in there; the commit object itself is then only read
+//on demand.
 type CommitNode struct {
 	commit  *Commit
 	parents []*CommitNode
 	Flags   NodeFlag
-	ID      SHA1
+	ID      ObjectID
+
+	repo       *Repository
+	graph      *CommitGraph
+	parentIDs  []ObjectID
+	tree       ObjectID
+	date       int64  //committer date, seconds since the epoch
+	generation uint32 //0 if unknown, i.e. not in the commit-graph
 }
 
 func (n *CommitNode) Parents() []*CommitNode {
 	return n.parents
 }
 
+//Commit returns the commit object of the node, which is
+//read from the repository if necessary.
+func (n *CommitNode) Commit() (*Commit, error) {
+	if n.commit != nil {
+		return n.commit, nil
+	}
+
+	obj, err := n.repo.OpenObject(n.ID)
+	if err != nil {
+		return nil, err
+	}
+	obj.Close()
+
+	commit, ok := obj.(*Commit)
+	if !ok {
+		return nil, fmt.Errorf("object [%s] not of type commit", n.ID)
+	}
+
+	n.commit = commit
+	return commit, nil
+}
+
+//Author returns the author of the commit, mapped with the mailmap
+//of the graph.
+func (n *CommitNode) Author() (Signature, error) {
+	commit, err := n.Commit()
+	if err != nil {
+		return Signature{}, err
+	}
+	return n.graph.Mailmap.Map(commit.Author), nil
+}
+
+//Committer returns the committer of the commit, mapped with the
+//mailmap of the graph.
+func (n *CommitNode) Committer() (Signature, error) {
+	commit, err := n.Commit()
+	if err != nil {
+		return Signature{}, err
+	}
+	return n.graph.Mailmap.Map(commit.Committer), nil
+}
+
+//Generation returns the generation number of the commit, which is
+//greater than the ones of all its ancestors, or 0 if it is unknown
+//since the commit is not in the commit-graph file.
+func (n *CommitNode) Generation() uint32 {
+	return n.generation
+}
+
+//CommitGraph is a lazily loaded part of the history of a repository
+//for walks and reachability queries. The commit-graph file of the
+//repository is used, if there is one (see WriteCommitGraph).
 type CommitGraph struct {
+	//Mailmap, if not nil, is applied to the signatures
+	//returned by CommitNode.Author and Committer.
+	Mailmap *Mailmap
+
 	tips []*CommitNode
 
-	commits map[SHA1]*CommitNode
+	commits map[ObjectID]*CommitNode
 	repo    *Repository
+	file    *graphFile
 }
 
 func NewCommitGraph(repo *Repository) *CommitGraph {
-	return &CommitGraph{repo: repo, commits: make(map[SHA1]*CommitNode, 0)}
+	return &CommitGraph{
+		repo:    repo,
+		commits: make(map[ObjectID]*CommitNode, 0),
+		file:    repo.graphFile(),
+	}
+}
+
+//newObjectGraph returns a CommitGraph that ignores the commit-graph
+//file of the repository, i.e. all commits are read from their objects.
+func newObjectGraph(repo *Repository) *CommitGraph {
+	return &CommitGraph{
+		repo:    repo,
+		commits: make(map[ObjectID]*CommitNode, 0),
+	}
 }
 
-func (c *CommitGraph) openObject(oid SHA1) (*CommitNode, error) {
+func (c *CommitGraph) openObject(oid ObjectID) (*CommitNode, error) {
 	if node, ok := c.commits[oid]; ok {
 		return node, nil
 	}
 
-	obj, err := c.repo.OpenObject(oid)
+	node := &CommitNode{ID: oid, repo: c.repo, graph: c}
 
+	if pos, ok := c.lookupFile(oid); ok {
+		entry, err := c.file.entry(pos)
+		if err != nil {
+			return nil, err
+		}
+
+		node.parentIDs = entry.parents
+		node.tree = entry.tree
+		node.date = entry.date
+		node.generation = entry.generation
+
+		c.commits[oid] = node
+		return node, nil
+	}
+
+	commit, err := node.Commit()
 	if err != nil {
 		return nil, err
 	}
 
-	commit, ok := obj.(*Commit)
-	if !ok {
-		return nil, fmt.Errorf("object [%s] not of type commit", oid)
-	}
+	node.parentIDs = commit.Parent
+	node.tree = commit.Tree
+	node.date = commit.Committer.Date.Unix()
 
-	node := &CommitNode{commit: commit, ID: oid}
 	c.commits[oid] = node
-
 	return node, nil
 }
 
-func (c *CommitGraph) AddTip(oid SHA1) (*CommitNode, error) {
+func (c *CommitGraph) lookupFile(oid ObjectID) (uint32, bool) {
+	if c.file == nil {
+		return 0, false
+	}
+	return c.file.lookup(oid)
+}
+
+func (c *CommitGraph) AddTip(oid ObjectID) (*CommitNode, error) {
 	node, err := c.openObject(oid)
 
 	if err != nil {
@@ -74,9 +175,9 @@ func (c *CommitGraph) AddTip(oid SHA1) (*CommitNode, error) {
 }
 
 func (c *CommitGraph) loadParents(node *CommitNode) error {
-	if len(node.parents) != len(node.commit.Parent) {
-		node.parents = make([]*CommitNode, len(node.commit.Parent))
-		for i, parent := range node.commit.Parent {
+	if len(node.parents) != len(node.parentIDs) {
+		node.parents = make([]*CommitNode, len(node.parentIDs))
+		for i, parent := range node.parentIDs {
 			var err error
 			node.parents[i], err = c.openObject(parent)
 			if err != nil {
@@ -100,9 +201,7 @@ func (y youngestFirst) Less(i, j int) bool {
 	// true -> i before j
 	//      -> i.Date() after j.Date
 
-	ic, jc := y[i], y[j]
-
-	return ic.commit.Date().After(jc.commit.Date())
+	return y[i].date > y[j].date
 }
 
 func (y youngestFirst) Swap(i, j int) {
@@ -122,14 +221,44 @@ func (y *youngestFirst) Pop() interface{} {
 }
 
 func (y youngestFirst) notAllWhite() bool {
+	return y.notAllColor(NodeColorWhite)
+}
+
+func (y youngestFirst) notAllColor(color NodeFlag) bool {
 	for _, node := range y {
-		if node.Flags&NodeColorWhite != NodeColorWhite {
+		if node.Flags&color != color {
 			return true
 		}
 	}
 	return false
 }
 
+//generationFirst is a priority queue like youngestFirst, but it
+//orders by generation number first and only by date for commits of
+//the same generation. Commits with unknown generation come first. In
+//contrast to youngestFirst, no commit is taken from the queue before
+//its children, even with clock skew, if all of them are in the
+//commit-graph file.
+type generationFirst struct {
+	youngestFirst
+}
+
+func (g generationFirst) Less(i, j int) bool {
+	gi, gj := g.youngestFirst[i].generation-1, g.youngestFirst[j].generation-1
+	if gi != gj {
+		//the unknown generation, 0, wraps around to the maximum
+		return gi > gj
+	}
+	return g.youngestFirst.Less(i, j)
+}
+
+func newGenerationFirst(nodes ...*CommitNode) *generationFirst {
+	pq := &generationFirst{make(youngestFirst, len(nodes))}
+	copy(pq.youngestFirst, nodes)
+	heap.Init(pq)
+	return pq
+}
+
 //youngestFirstFromTips creates a priority queue initialized
 //with the tips of the graph.
 func (c *CommitGraph) youngestFirstFromTips() youngestFirst {
@@ -145,38 +274,239 @@ func (c *CommitGraph) youngestFirstFromTips() youngestFirst {
 }
 
 func (c *CommitGraph) PaintDownToCommon() error {
+	_, err := c.paintDownToCommon(0, c.tips...)
+	return err
+}
 
-	pq := c.youngestFirstFromTips()
-
+//paintDownToCommon propagates the colors of nodes to their ancestors
+//until only common ancestors are left in the queue. Nodes that are
+//painted red and green, i.e. common ancestors, propagate blue in
+//addition. The nodes that were common but not blue when they were
+//taken from the queue are returned, they are the candidates for the
+//best common ancestors. If minGen is not 0, painting stops at commits
+//with a lower generation number.
+func (c *CommitGraph) paintDownToCommon(minGen uint32, nodes ...*CommitNode) ([]*CommitNode, error) {
+	var common []*CommitNode
+
+	pq := newGenerationFirst(nodes...)
 	for pq.notAllWhite() {
-		node := heap.Pop(&pq).(*CommitNode)
+		node := heap.Pop(pq).(*CommitNode)
+
+		//the queue is ordered by generation, so
+		//all following ones are lower as well
+		if minGen != 0 && node.generation != 0 && node.generation < minGen {
+			break
+		}
 
-		flags := node.Flags
-		if flags&NodeColorWhite == NodeColorYellow {
+		flags := node.Flags & NodeColorWhite
+		if flags == NodeColorYellow {
 			flags |= NodeColorBlue
+
+			if !containsNode(common, node) {
+				common = append(common, node)
+			}
 		}
 
 		err := c.loadParents(node)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		for _, parent := range node.parents {
-			if parent.Flags == flags {
+			if parent.Flags&flags == flags {
 				continue
 			}
 
 			parent.Flags |= flags
-			heap.Push(&pq, parent)
+			heap.Push(pq, parent)
 		}
 	}
 
-	return nil
+	return common, nil
+}
+
+func containsNode(nodes []*CommitNode, node *CommitNode) bool {
+	for _, n := range nodes {
+		if n == node {
+			return true
+		}
+	}
+	return false
+}
+
+//clearFlags clears the flags in mask of all loaded nodes.
+func (c *CommitGraph) clearFlags(mask NodeFlag) {
+	for _, node := range c.commits {
+		node.Flags &^= mask
+	}
+}
+
+//paintPair opens the commits a and b and paints them red and green,
+//respectively, after clearing the colors of the graph.
+func (c *CommitGraph) paintPair(a, b ObjectID) (na, nb *CommitNode, err error) {
+	c.clearFlags(NodeColorWhite)
+
+	na, err = c.openObject(a)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nb, err = c.openObject(b)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	na.Flags |= NodeColorRed
+	nb.Flags |= NodeColorGreen
+
+	return na, nb, nil
+}
+
+//MergeBase returns the best common ancestors of the commits a and b,
+//i.e. all common ancestors that are not an ancestor of another common
+//ancestor, like git merge-base --all. The result is empty if a and
+//b have no common history. The colors of the graph are reset.
+func (c *CommitGraph) MergeBase(a, b ObjectID) ([]*CommitNode, error) {
+	na, nb, err := c.paintPair(a, b)
+	if err != nil {
+		return nil, err
+	} else if na == nb {
+		return []*CommitNode{na}, nil
+	}
+
+	candidates, err := c.paintDownToCommon(0, na, nb)
+	if err != nil {
+		return nil, err
+	}
+
+	//candidates that were reached by the blue paint
+	//later on are ancestors of other common ancestors
+	var common []*CommitNode
+	for _, node := range candidates {
+		if node.Flags&NodeColorBlue == 0 {
+			common = append(common, node)
+		}
+	}
+
+	if len(common) < 2 {
+		return common, nil
+	}
+
+	//with clock skew the painting stops too early to catch
+	//all of them, so check the remaining ones pairwise
+	var bases []*CommitNode
+	for i, node := range common {
+		redundant := false
+		for j, other := range common {
+			if i == j {
+				continue
+			}
+
+			redundant, err = c.IsAncestor(node.ID, other.ID)
+			if err != nil {
+				return nil, err
+			} else if redundant {
+				break
+			}
+		}
+
+		if !redundant {
+			bases = append(bases, node)
+		}
+	}
+
+	return bases, nil
+}
+
+//IsAncestor checks if the commit a is an ancestor of the commit b,
+//where every commit is an ancestor of itself. This is the case if
+//updating a ref from a to b is a fast-forward. The colors of the
+//graph are reset.
+func (c *CommitGraph) IsAncestor(a, b ObjectID) (bool, error) {
+	na, nb, err := c.paintPair(a, b)
+	if err != nil {
+		return false, err
+	} else if na == nb {
+		return true, nil
+	}
+
+	//the ancestors of b have lower generation numbers and, since
+	//the commit-graph file is closed under reachability, are all
+	//in there if b is
+	if nb.generation != 0 && (na.generation == 0 || na.generation >= nb.generation) {
+		return false, nil
+	}
+
+	_, err = c.paintDownToCommon(na.generation, na, nb)
+	if err != nil {
+		return false, err
+	}
+
+	return na.Flags&NodeColorGreen != 0, nil
+}
+
+//AheadBehind counts the commits that are reachable from a but not
+//from b (ahead) and those reachable from b but not from a (behind),
+//like git rev-list --left-right --count a...b. The colors of the
+//graph are reset.
+func (c *CommitGraph) AheadBehind(a, b ObjectID) (ahead, behind int, err error) {
+	na, nb, err := c.paintPair(a, b)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	pq := newGenerationFirst(na, nb)
+
+	//the walk goes on until only common ancestors are queued,
+	//all of whose ancestors are common as well
+	for pq.notAllColor(NodeColorYellow) {
+		node := heap.Pop(pq).(*CommitNode)
+		flags := node.Flags & NodeColorYellow
+
+		err = c.loadParents(node)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		for _, parent := range node.parents {
+			if parent.Flags&flags == flags {
+				continue
+			}
+
+			parent.Flags |= flags
+			heap.Push(pq, parent)
+		}
+	}
+
+	for _, node := range c.commits {
+		switch node.Flags & NodeColorYellow {
+		case NodeColorRed:
+			ahead++
+		case NodeColorGreen:
+			behind++
+		}
+	}
+
+	return ahead, behind, nil
 }
 
+//CommitVisitor is called for every commit by VisitCommits.
+//Returning true stops the walk.
 type CommitVisitor func(node *CommitNode) bool
 
-func (c *CommitGraph) VisitCommits(fn CommitVisitor) {
+//VisitCommits walks the history from the tips of the graph, youngest
+//commit first, and calls fn once for every commit. Parents are loaded
+//on demand; the error of loading a parent ends the walk.
+func (c *CommitGraph) VisitCommits(fn CommitVisitor) error {
+	return c.walk(func(node *CommitNode) ([]*CommitNode, bool) {
+		return node.parents, fn(node)
+	})
+}
+
+//walk is VisitCommits, but fn also selects the parents of each
+//commit that the walk continues with. The parents are loaded before
+//fn is called.
+func (c *CommitGraph) walk(fn func(node *CommitNode) ([]*CommitNode, bool)) error {
 
 	//let's clear all the seen flags so we can use them
 	for _, v := range c.commits {
@@ -193,14 +523,57 @@ func (c *CommitGraph) VisitCommits(fn CommitVisitor) {
 		}
 		node.Flags |= NodeFlagSeen
 
-		stop := fn(node)
+		err := c.loadParents(node)
+		if err != nil {
+			return err
+		}
+
+		follow, stop := fn(node)
 		if stop {
 			break
 		}
 
-		// ensure commits are loaded?
-		for _, parent := range node.Parents() {
+		for _, parent := range follow {
 			heap.Push(&pq, parent)
 		}
 	}
+
+	return nil
+}
+
+//walkAll visits every commit reachable from the tips of the graph
+//once, in no particular order. In contrast to walk, parents that can
+//not be loaded do not end the walk, they are passed to fn as missing.
+//fn returns the (loaded) parents that the walk continues with.
+func (c *CommitGraph) walkAll(fn func(node *CommitNode, parents []*CommitNode, missing []ObjectID) []*CommitNode) {
+
+	for _, v := range c.commits {
+		v.Flags &^= NodeFlagSeen
+	}
+
+	stack := make([]*CommitNode, len(c.tips))
+	copy(stack, c.tips)
+
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if node.Flags&NodeFlagSeen != 0 {
+			continue
+		}
+		node.Flags |= NodeFlagSeen
+
+		var parents []*CommitNode
+		var missing []ObjectID
+		for _, id := range node.parentIDs {
+			parent, err := c.openObject(id)
+			if err != nil {
+				missing = append(missing, id)
+				continue
+			}
+			parents = append(parents, parent)
+		}
+
+		stack = append(stack, fn(node, parents, missing)...)
+	}
 }
@@ -0,0 +1,106 @@
+package git
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+//mkMergeBaseRepo extends the repository of mkLogRepo by criss-cross
+//merges and an unrelated history.
+func mkMergeBaseRepo(t *testing.T) *testRepo {
+	tr := mkLogRepo(t)
+
+	//criss-cross merges with two best common ancestors
+	tr.git("checkout", "-q", "-b", "one", "master")
+	tr.write("one.txt", "1\n")
+	tr.commit("one")
+
+	tr.git("checkout", "-q", "-b", "two", "master")
+	tr.write("two.txt", "2\n")
+	tr.commit("two")
+	tr.git("merge", "-q", "--no-ff", "-m", "merge one into two", "one")
+
+	tr.git("checkout", "-q", "one")
+	tr.git("merge", "-q", "--no-ff", "-m", "merge two~1 into one", "two~1")
+
+	//unrelated history
+	tr.git("checkout", "-q", "--orphan", "orphan")
+	tr.commit("orphan")
+
+	return tr
+}
+
+func TestMergeBase(t *testing.T) {
+	tr := mkMergeBaseRepo(t)
+	defer tr.cleanup()
+
+	checkGraphQueries(t, tr)
+
+	cg := NewCommitGraph(tr.Repository)
+	two := tr.revParse("two")
+	bases, err := cg.MergeBase(tr.revParse("one"), two)
+	if err != nil || len(bases) != 2 {
+		t.Fatalf("expected two merge bases for the criss-cross merge, got %v (%v)", bases, err)
+	}
+}
+
+//mergeBaseRevs are the revisions of the repository of TestMergeBase
+//whose pairs checkGraphQueries checks.
+var mergeBaseRevs = []string{"master", "master~1", "master~3", "master~1^2", "side", "topic", "one", "two", "one^1", "orphan"}
+
+//checkGraphQueries compares MergeBase, IsAncestor and AheadBehind
+//for all pairs of mergeBaseRevs with git.
+func checkGraphQueries(t *testing.T, tr *testRepo) {
+	revs := mergeBaseRevs
+
+	cg := NewCommitGraph(tr.Repository)
+	for _, ra := range revs {
+		for _, rb := range revs {
+			a, b := tr.revParse(ra), tr.revParse(rb)
+
+			bases, err := cg.MergeBase(a, b)
+			if err != nil {
+				t.Fatalf("MergeBase(%s, %s) => %v", ra, rb, err)
+			}
+
+			var have []string
+			for _, node := range bases {
+				have = append(have, node.ID.String())
+			}
+			sort.Strings(have)
+
+			//git merge-base fails if there is no common ancestor
+			var expected []string
+			if (ra == "orphan") == (rb == "orphan") {
+				expected = strings.Fields(tr.git("merge-base", "--all", ra, rb))
+				sort.Strings(expected)
+			}
+
+			if strings.Join(have, " ") != strings.Join(expected, " ") {
+				t.Fatalf("MergeBase(%s, %s) => %v, expected %v", ra, rb, have, expected)
+			}
+
+			isAnc, err := cg.IsAncestor(a, b)
+			if err != nil {
+				t.Fatalf("IsAncestor(%s, %s) => %v", ra, rb, err)
+			}
+
+			reachable := strings.Contains(tr.git("rev-list", rb), a.String())
+			if isAnc != reachable {
+				t.Fatalf("IsAncestor(%s, %s) => %v, expected %v", ra, rb, isAnc, reachable)
+			}
+
+			ahead, behind, err := cg.AheadBehind(a, b)
+			if err != nil {
+				t.Fatalf("AheadBehind(%s, %s) => %v", ra, rb, err)
+			}
+
+			counts := tr.git("rev-list", "--left-right", "--count", ra+"..."+rb)
+			if have := fmt.Sprintf("%d\t%d", ahead, behind); have != counts {
+				t.Fatalf("AheadBehind(%s, %s) => %q, expected %q", ra, rb, have, counts)
+			}
+		}
+	}
+}
@@ -0,0 +1,606 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// IndexPackConfig holds the limits for IndexPack and an optional
+// callback for progress reporting. Zero limits mean no limit.
+type IndexPackConfig struct {
+	//MaxObjects is the maximum number of objects in the pack.
+	MaxObjects int64
+
+	//MaxObjectSize is the maximum size of any (resolved) object.
+	MaxObjectSize int64
+
+	//MaxBytes is the maximum size of the pack stream.
+	MaxBytes int64
+
+	//Progress, if set, is called after every object that was
+	//received and every delta that was resolved.
+	Progress func(IndexPackProgress)
+}
+
+// IndexPackProgress is the state of IndexPack. Deltas is the number
+// of delta objects received so far, Resolved the number of those
+// that have been resolved.
+type IndexPackProgress struct {
+	Objects uint32
+	Total   uint32
+	Bytes   int64
+
+	Deltas   uint32
+	Resolved uint32
+}
+
+// PackLimitError is returned if a pack that is indexed exceeds one
+// of the limits of the IndexPackConfig.
+type PackLimitError struct {
+	What  string
+	Value int64
+	Limit int64
+}
+
+func (e *PackLimitError) Error() string {
+	return fmt.Sprintf("git: pack %s %d exceeds limit of %d", e.What, e.Value, e.Limit)
+}
+
+// packStream reads a pack from a reader and copies all the data
+// that was consumed to w, while updating the checksum of the pack
+// and the crc32 of the current object. It implements io.ByteReader
+// so that zlib does not read beyond the end of an object.
+type packStream struct {
+	r      *bufio.Reader
+	w      *bufio.Writer
+	sum    hash.Hash
+	crc    hash.Hash32
+	format ObjectFormat
+
+	off int64
+	max int64
+	err error //set if the size limit was exceeded
+}
+
+func (s *packStream) consume(data []byte) error {
+	s.sum.Write(data)
+	s.crc.Write(data)
+	s.off += int64(len(data))
+
+	if s.max > 0 && s.off > s.max {
+		s.err = &PackLimitError{"size", s.off, s.max}
+		return s.err
+	}
+
+	_, err := s.w.Write(data)
+	return err
+}
+
+func (s *packStream) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if cerr := s.consume(p[:n]); cerr != nil {
+		return n, cerr
+	}
+	return n, err
+}
+
+func (s *packStream) ReadByte() (byte, error) {
+	b, err := s.r.ReadByte()
+	if err != nil {
+		return b, err
+	}
+	return b, s.consume([]byte{b})
+}
+
+// indexObject is an object of a pack that is being indexed.
+type indexObject struct {
+	PackIndexEntry
+	otype ObjectType
+
+	baseOff int64    //the base of an ofs delta
+	baseRef ObjectID //the base of a ref delta
+}
+
+// hashObject computes the id of the object with the given type,
+// size and data, in the object format f.
+func hashObject(f ObjectFormat, otype ObjectType, size int64, r io.Reader) (ObjectID, error) {
+	h := f.New()
+	fmt.Fprintf(h, "%s %d\x00", otype, size)
+
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return ObjectID{}, err
+	} else if n != size {
+		return ObjectID{}, fmt.Errorf("git: object size mismatch (%d != %d)", n, size)
+	}
+
+	return f.sum(h), nil
+}
+
+// IndexPack reads a pack from r, like it is sent by a client that
+// pushes, stores it in the pack directory of the repository and
+// creates its index. Deltas are resolved and the checksum of the
+// pack is verified; thin packs, i.e. packs with deltas whose bases
+// are in the repository, are completed by appending those bases.
+// Data beyond the end of the pack might be read from r. It returns
+// the checksum of the pack (which is part of its file name).
+func (repo *Repository) IndexPack(r io.Reader, cfg IndexPackConfig) (ObjectID, error) {
+	var packsum ObjectID
+	dir := filepath.Join(repo.Path, "objects", "pack")
+
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return packsum, err
+	}
+
+	pack, err := ioutil.TempFile(dir, "tmp_pack_")
+	if err != nil {
+		return packsum, err
+	}
+	defer os.Remove(pack.Name())
+	defer pack.Close()
+
+	objs, progress, err := receivePack(r, pack, repo.ObjectFormat(), cfg)
+	if err != nil {
+		return packsum, err
+	}
+
+	thin, err := repo.resolvePackDeltas(pack.Name(), objs, progress, cfg)
+	if err != nil {
+		return packsum, err
+	}
+
+	entries := make([]PackIndexEntry, 0, len(objs)+len(thin))
+	seen := make(map[ObjectID]bool, len(objs))
+	for _, obj := range objs {
+		if seen[obj.ID] {
+			return packsum, fmt.Errorf("git: object %s is in the pack twice", obj.ID)
+		}
+		seen[obj.ID] = true
+		entries = append(entries, obj.PackIndexEntry)
+	}
+
+	packsum, appended, err := repo.completeThinPack(pack, uint32(len(objs)), thin)
+	if err != nil {
+		return packsum, err
+	}
+	entries = append(entries, appended...)
+
+	idx, err := ioutil.TempFile(dir, "tmp_idx_")
+	if err != nil {
+		return packsum, err
+	}
+	defer os.Remove(idx.Name())
+	defer idx.Close()
+
+	if _, err = WritePackIndex(idx, entries, packsum); err != nil {
+		return packsum, err
+	}
+
+	return packsum, installPack(pack, idx, packsum)
+}
+
+// receivePack copies the pack from r to w, checking its header and
+// trailer, and records the offset and crc32 of all objects as well
+// as the ids, of the given format, of all non-delta objects.
+func receivePack(r io.Reader, w io.Writer, format ObjectFormat, cfg IndexPackConfig) ([]*indexObject, IndexPackProgress, error) {
+	var progress IndexPackProgress
+
+	s := &packStream{
+		r:      bufio.NewReader(r),
+		w:      bufio.NewWriter(w),
+		sum:    format.New(),
+		crc:    crc32.NewIEEE(),
+		format: format,
+		max:    cfg.MaxBytes,
+	}
+
+	var header PackHeader
+	if err := binary.Read(s, binary.BigEndian, &header); err != nil {
+		return nil, progress, fmt.Errorf("git: could not read pack header: %v", err)
+	} else if string(header.Sig[:]) != "PACK" {
+		return nil, progress, fmt.Errorf("git: packfile signature error")
+	} else if header.Version != 2 {
+		return nil, progress, fmt.Errorf("git: unsupported packfile version")
+	}
+
+	if cfg.MaxObjects > 0 && int64(header.Objects) > cfg.MaxObjects {
+		return nil, progress, &PackLimitError{"object count", int64(header.Objects), cfg.MaxObjects}
+	}
+
+	progress.Total = header.Objects
+	var objs []*indexObject
+	for i := uint32(0); i < header.Objects; i++ {
+		obj, err := receiveObject(s, cfg)
+		if s.err != nil {
+			return nil, progress, s.err
+		} else if err != nil {
+			return nil, progress, err
+		}
+		objs = append(objs, obj)
+
+		progress.Objects++
+		progress.Bytes = s.off
+		if IsDeltaObject(obj.otype) {
+			progress.Deltas++
+		}
+
+		if cfg.Progress != nil {
+			cfg.Progress(progress)
+		}
+	}
+
+	//the trailer is not part of the checksum
+	sum := format.sum(s.sum)
+	trailer, err := readObjectID(s.r, format)
+	if err != nil {
+		return nil, progress, fmt.Errorf("git: could not read pack trailer: %v", err)
+	} else if sum != trailer {
+		return nil, progress, fmt.Errorf("git: pack checksum mismatch (%s != %s)", trailer, sum)
+	}
+
+	if _, err := s.w.Write(trailer.Bytes()); err != nil {
+		return nil, progress, err
+	}
+
+	return objs, progress, s.w.Flush()
+}
+
+// receiveObject reads one object from the pack stream. The ids of
+// delta objects are unknown at this point.
+func receiveObject(s *packStream, cfg IndexPackConfig) (*indexObject, error) {
+	obj := &indexObject{}
+	obj.Offset = s.off
+	s.crc.Reset()
+
+	otype, size, err := readPackObjectHeader(s)
+	if err != nil {
+		return nil, err
+	}
+	obj.otype = otype
+
+	if cfg.MaxObjectSize > 0 && size > cfg.MaxObjectSize {
+		return nil, &PackLimitError{"object size", size, cfg.MaxObjectSize}
+	}
+
+	switch otype {
+	case ObjOFSDelta:
+		off, err := readVarint(s)
+		if err != nil {
+			return nil, err
+		} else if off <= 0 || obj.Offset-off < 12 {
+			return nil, fmt.Errorf("git: invalid delta base offset at %d", obj.Offset)
+		}
+		obj.baseOff = obj.Offset - off
+	case ObjRefDelta:
+		if obj.baseRef, err = readObjectID(s, s.format); err != nil {
+			return nil, err
+		}
+	default:
+		if !IsStandardObject(otype) {
+			return nil, fmt.Errorf("git: unknown object type %d at %d", otype, obj.Offset)
+		}
+	}
+
+	zr, err := zlib.NewReader(s)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	data := io.LimitReader(zr, size)
+	if IsDeltaObject(otype) {
+		//the target size is checked now, the resolved
+		//object again while resolving the delta
+		if _, err = readVarSize(data, 0); err != nil {
+			return nil, err
+		}
+
+		target, err := readVarSize(data, 0)
+		if err != nil {
+			return nil, err
+		} else if cfg.MaxObjectSize > 0 && target > cfg.MaxObjectSize {
+			return nil, &PackLimitError{"object size", target, cfg.MaxObjectSize}
+		}
+
+		_, err = io.Copy(ioutil.Discard, data)
+	} else {
+		obj.ID, err = hashObject(s.format, otype, size, data)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("git: could not read object at %d: %v", obj.Offset, err)
+	}
+
+	//reading to the end verifies the zlib checksum
+	var extra [1]byte
+	if _, err = io.ReadFull(zr, extra[:]); err == nil {
+		return nil, fmt.Errorf("git: object at %d is larger than its size", obj.Offset)
+	} else if err != io.EOF {
+		return nil, fmt.Errorf("git: could not read object at %d: %v", obj.Offset, err)
+	}
+
+	obj.CRC32 = s.crc.Sum32()
+	return obj, nil
+}
+
+// deltaResolver resolves the deltas of a pack that is being
+// indexed. The pending deltas are indexed by their base, so that
+// the dependents of an object are resolved, depth-first, as soon
+// as the object is known, like git index-pack does. Every delta is
+// therefore read and inflated exactly once.
+type deltaResolver struct {
+	pf  *PackFile
+	cfg DeltaConfig
+
+	byOff map[int64][]*indexObject
+	byRef map[ObjectID][]*indexObject
+
+	progress IndexPackProgress
+	report   func(IndexPackProgress)
+}
+
+func (r *deltaResolver) hasDependents(off int64, id ObjectID) bool {
+	return len(r.byOff[off]) > 0 || len(r.byRef[id]) > 0
+}
+
+// resolveDependents resolves the deltas with the object at offset
+// off (-1 if it is not in the pack) with the given id, type and
+// data as base, and recursively their dependents.
+func (r *deltaResolver) resolveDependents(off int64, id ObjectID, otype ObjectType, data []byte, depth int) error {
+	deps := append(r.byOff[off], r.byRef[id]...)
+	delete(r.byOff, off)
+	delete(r.byRef, id)
+
+	if len(deps) == 0 {
+		return nil
+	}
+
+	if err := r.cfg.checkDepth(depth + 1); err != nil {
+		return err
+	}
+
+	for _, obj := range deps {
+		target, err := r.patch(obj, data)
+		if err != nil {
+			return fmt.Errorf("git: could not resolve delta at %d: %v", obj.Offset, err)
+		}
+
+		obj.ID, err = hashObject(r.pf.Format, otype, int64(len(target)), bytes.NewReader(target))
+		if err != nil {
+			return err
+		}
+
+		r.progress.Resolved++
+		if r.report != nil {
+			r.report(r.progress)
+		}
+
+		if err = r.resolveDependents(obj.Offset, obj.ID, otype, target, depth+1); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// patch applies the delta obj to the data of its base.
+func (r *deltaResolver) patch(obj *indexObject, base []byte) ([]byte, error) {
+	raw, err := r.pf.readRawObject(obj.Offset)
+	if err != nil {
+		return nil, err
+	}
+
+	delta, err := parseDelta(raw)
+	if err != nil {
+		return nil, err
+	}
+	defer delta.Close()
+
+	if err = r.cfg.checkSize(delta.SizeTarget); err != nil {
+		return nil, err
+	} else if delta.SizeSource != int64(len(base)) {
+		return nil, fmt.Errorf("git: base size mismatch while patching delta object")
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, delta.SizeTarget))
+	if err = delta.Patch(bytes.NewReader(base), buf); err != nil {
+		return nil, err
+	} else if int64(buf.Len()) != delta.SizeTarget {
+		return nil, fmt.Errorf("git: size mismatch while patching delta object")
+	}
+
+	return buf.Bytes(), nil
+}
+
+// readBase reads the complete data of the (non-delta) object obj,
+// which is closed afterwards.
+func (r *deltaResolver) readBase(obj gitObject) ([]byte, error) {
+	defer obj.Close()
+
+	if err := r.cfg.checkSize(obj.size); err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, obj.size)
+	_, err := io.ReadFull(obj.source, data)
+	return data, err
+}
+
+// resolvePackDeltas determines the ids of all delta objects of the
+// pack at path. It returns the ids of the delta bases that were
+// taken from the repository, i.e. if the pack is thin.
+func (repo *Repository) resolvePackDeltas(path string, objs []*indexObject, progress IndexPackProgress, cfg IndexPackConfig) ([]ObjectID, error) {
+	pf, err := OpenPackFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer pf.Close()
+	pf.Format = repo.ObjectFormat()
+
+	dcfg := repo.deltaConfig()
+	if cfg.MaxObjectSize > 0 {
+		dcfg.MaxSize = cfg.MaxObjectSize
+	}
+
+	r := &deltaResolver{
+		pf:       pf,
+		cfg:      dcfg,
+		byOff:    make(map[int64][]*indexObject),
+		byRef:    make(map[ObjectID][]*indexObject),
+		progress: progress,
+		report:   cfg.Progress,
+	}
+
+	for _, obj := range objs {
+		if obj.otype == ObjOFSDelta {
+			r.byOff[obj.baseOff] = append(r.byOff[obj.baseOff], obj)
+		} else if obj.otype == ObjRefDelta {
+			r.byRef[obj.baseRef] = append(r.byRef[obj.baseRef], obj)
+		}
+	}
+
+	for _, obj := range objs {
+		if IsDeltaObject(obj.otype) || !r.hasDependents(obj.Offset, obj.ID) {
+			continue
+		}
+
+		raw, err := pf.readRawObject(obj.Offset)
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := r.readBase(raw)
+		if err != nil {
+			return nil, fmt.Errorf("git: could not read delta base at %d: %v", obj.Offset, err)
+		}
+
+		if err = r.resolveDependents(obj.Offset, obj.ID, obj.otype, data, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	//what is left are ref deltas with bases that are not in
+	//the pack; those have to be in the repository (thin pack)
+	var bases []ObjectID
+	for id := range r.byRef {
+		bases = append(bases, id)
+	}
+	sort.Sort(shaList(bases))
+
+	var thin []ObjectID
+	for _, id := range bases {
+		if !r.hasDependents(-1, id) || !repo.hasObject(id) {
+			continue
+		}
+
+		obj, err := repo.openObject(id)
+		if err != nil {
+			return nil, err
+		}
+		otype := obj.otype
+
+		data, err := r.readBase(obj)
+		if err != nil {
+			return nil, fmt.Errorf("git: could not read delta base %s: %v", id, err)
+		}
+		thin = append(thin, id)
+
+		if err = r.resolveDependents(-1, id, otype, data, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	missing := 0
+	for _, deps := range r.byOff {
+		missing += len(deps)
+	}
+	for _, deps := range r.byRef {
+		missing += len(deps)
+	}
+
+	if missing > 0 {
+		return nil, fmt.Errorf("git: pack has %d deltas with missing bases", missing)
+	}
+
+	return thin, nil
+}
+
+type shaList []ObjectID
+
+func (l shaList) Len() int           { return len(l) }
+func (l shaList) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l shaList) Less(i, j int) bool { return l[i].Compare(l[j]) < 0 }
+
+// completeThinPack appends the objects thin from the repository to
+// the pack with count objects, updates the object count in its
+// header and rewrites the trailer. It returns the (new) checksum of
+// the pack and the index entries of the appended objects.
+func (repo *Repository) completeThinPack(pack *os.File, count uint32, thin []ObjectID) (ObjectID, []PackIndexEntry, error) {
+	var packsum ObjectID
+	format := repo.ObjectFormat()
+
+	end, err := pack.Seek(-int64(format.Size()), os.SEEK_END)
+	if err != nil {
+		return packsum, nil, err
+	}
+
+	if len(thin) == 0 {
+		packsum, err = readObjectID(pack, format)
+		return packsum, nil, err
+	}
+
+	if err = pack.Truncate(end); err != nil {
+		return packsum, nil, err
+	}
+
+	bw := bufio.NewWriter(pack)
+	out := &packOutput{w: bw, off: end, sum: format.New(), crc: crc32.NewIEEE()}
+
+	var entries []PackIndexEntry
+	for _, id := range thin {
+		otype, size, err := repo.statObject(id)
+		if err != nil {
+			return packsum, nil, err
+		}
+
+		obj := &packObject{id: id, otype: otype, size: size}
+		if _, err = repo.writePackObject(out, obj); err != nil {
+			return packsum, nil, err
+		}
+
+		entries = append(entries, PackIndexEntry{obj.id, obj.offset, obj.crc})
+	}
+
+	if err = bw.Flush(); err != nil {
+		return packsum, nil, err
+	}
+
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], count+uint32(len(thin)))
+	if _, err = pack.WriteAt(n[:], 8); err != nil {
+		return packsum, nil, err
+	}
+
+	if _, err = pack.Seek(0, os.SEEK_SET); err != nil {
+		return packsum, nil, err
+	}
+
+	h := format.New()
+	if _, err = io.Copy(h, pack); err != nil {
+		return packsum, nil, err
+	}
+
+	packsum = format.sum(h)
+	_, err = pack.Write(packsum.Bytes())
+	return packsum, entries, err
+}
@@ -0,0 +1,195 @@
+package git
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//mkTargetRepo creates an empty bare repository in the
+//working tree of tr.
+func mkTargetRepo(tr *testRepo, name string) *Repository {
+	tr.git("init", "-q", "--bare", "--object-format="+tr.ObjectFormat().String(), name)
+	return &Repository{Path: filepath.Join(tr.work, name)}
+}
+
+func TestIndexPack(t *testing.T) {
+	tr, _ := mkDeltaRepo(t, 20)
+	defer tr.cleanup()
+
+	revs := tr.git("rev-list", "--objects", "--all") + "\n"
+
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"ofs-delta", []string{"pack-objects", "--stdout", "-q"}},
+		{"ref-delta", []string{"pack-objects", "--stdout", "-q", "--no-delta-base-offset"}},
+		{"no-delta", []string{"pack-objects", "--stdout", "-q", "--window=0", "--no-reuse-delta"}},
+	}
+
+	for _, tt := range tests {
+		data := tr.gitRaw(strings.NewReader(revs), tt.args...)
+
+		target := mkTargetRepo(tr, tt.name+".git")
+
+		var last IndexPackProgress
+		calls := 0
+		cfg := IndexPackConfig{Progress: func(p IndexPackProgress) {
+			last = p
+			calls++
+		}}
+
+		packsum, err := target.IndexPack(bytes.NewReader(data), cfg)
+		if err != nil {
+			t.Fatalf("%s: IndexPack() => %v", tt.name, err)
+		}
+		target.Close()
+
+		if !bytes.Equal(packsum.Bytes(), data[len(data)-packsum.Format().Size():]) {
+			t.Fatalf("%s: pack checksum %s does not match the trailer", tt.name, packsum)
+		}
+
+		if last.Objects != last.Total || last.Resolved != last.Deltas || last.Bytes != int64(len(data)-tr.ObjectFormat().Size()) {
+			t.Fatalf("%s: unexpected final progress: %+v", tt.name, last)
+		} else if calls != int(last.Objects+last.Resolved) {
+			t.Fatalf("%s: progress was reported %d times, expected %d", tt.name, calls, last.Objects+last.Resolved)
+		}
+
+		t.Logf("%s: %+v", tt.name, last)
+
+		//git creates the same index for the same pack
+		base := filepath.Join(target.Path, "objects", "pack", fmt.Sprintf("pack-%s", packsum))
+		ours, err := ioutil.ReadFile(base + ".idx")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		theirs := filepath.Join(tr.work, tt.name+".idx")
+		tr.git("index-pack", "-o", theirs, base+".pack")
+
+		expected, err := ioutil.ReadFile(theirs)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(ours, expected) {
+			t.Fatalf("%s: index differs from the one created by git index-pack", tt.name)
+		}
+
+		tr.git("--git-dir", target.Path, "update-ref", "refs/heads/master", tr.revParse("master").String())
+		tr.git("--git-dir", target.Path, "fsck", "--strict", "--no-dangling")
+	}
+}
+
+func TestIndexThinPack(t *testing.T) {
+	tr, _ := mkDeltaRepo(t, 10)
+	defer tr.cleanup()
+
+	old, head := tr.revParse("master~5"), tr.revParse("master")
+
+	target := mkTargetRepo(tr, "target.git")
+	defer target.Close()
+	tr.git("push", "-q", target.Path, fmt.Sprintf("%s:refs/heads/master", old))
+
+	input := fmt.Sprintf("%s\n^%s\n", head, old)
+	data := tr.gitRaw(strings.NewReader(input), "pack-objects", "--stdout", "-q", "--revs", "--thin")
+	count := binary.BigEndian.Uint32(data[8:12])
+
+	packsum, err := target.IndexPack(bytes.NewReader(data), IndexPackConfig{})
+	if err != nil {
+		t.Fatalf("IndexPack() => %v", err)
+	}
+
+	path := filepath.Join(target.Path, "objects", "pack", fmt.Sprintf("pack-%s.idx", packsum))
+	idx, err := PackIndexOpen(path)
+	if err != nil {
+		t.Fatalf("PackIndexOpen() => %v", err)
+	}
+	defer idx.Close()
+
+	if total := idx.FO[255]; total <= count {
+		t.Fatalf("expected bases to be appended to the thin pack (%d objects), got %d objects", count, total)
+	}
+
+	out := tr.git("verify-pack", "-v", path)
+	if strings.Contains(out, "missing") {
+		t.Fatalf("pack is still thin:\n%s", out)
+	}
+
+	tr.git("--git-dir", target.Path, "update-ref", "refs/heads/master", head.String())
+	tr.git("--git-dir", target.Path, "fsck", "--strict", "--no-dangling")
+
+	//without the bases the pack can not be indexed
+	empty := mkTargetRepo(tr, "empty.git")
+	defer empty.Close()
+
+	if _, err = empty.IndexPack(bytes.NewReader(data), IndexPackConfig{}); err == nil {
+		t.Fatalf("expected an error for a thin pack without bases")
+	}
+}
+
+func TestIndexPackErrors(t *testing.T) {
+	tr, _ := mkDeltaRepo(t, 5)
+	defer tr.cleanup()
+
+	tr.write("large.bin", strings.Repeat("large file\n", 10000))
+	tr.commit("large file")
+
+	revs := tr.git("rev-list", "--objects", "--all") + "\n"
+	data := tr.gitRaw(strings.NewReader(revs), "pack-objects", "--stdout", "-q")
+
+	corrupt := append([]byte{}, data...)
+	corrupt[len(corrupt)-1] ^= 0xff
+
+	tests := []struct {
+		name  string
+		data  []byte
+		cfg   IndexPackConfig
+		limit string
+	}{
+		{"objects", data, IndexPackConfig{MaxObjects: 10}, "object count"},
+		{"bytes", data, IndexPackConfig{MaxBytes: int64(len(data) / 2)}, "size"},
+		{"object-size", data, IndexPackConfig{MaxObjectSize: 100000}, "object size"},
+		{"checksum", corrupt, IndexPackConfig{}, ""},
+		{"truncated", data[:len(data)/2], IndexPackConfig{}, ""},
+		{"signature", append([]byte("KCAP"), data[4:]...), IndexPackConfig{}, ""},
+	}
+
+	for _, tt := range tests {
+		target := mkTargetRepo(tr, tt.name+".git")
+
+		_, err := target.IndexPack(bytes.NewReader(tt.data), tt.cfg)
+		target.Close()
+
+		if err == nil {
+			t.Fatalf("%s: expected an error", tt.name)
+		}
+
+		if lerr, ok := err.(*PackLimitError); tt.limit != "" && (!ok || lerr.What != tt.limit) {
+			t.Fatalf("%s: expected a %q limit error, got %v", tt.name, tt.limit, err)
+		}
+
+		t.Logf("%s: %v", tt.name, err)
+
+		files, _ := ioutil.ReadDir(filepath.Join(target.Path, "objects", "pack"))
+		if len(files) != 0 {
+			t.Fatalf("%s: files were left in the pack directory: %d", tt.name, len(files))
+		}
+	}
+
+	//the delta depth is limited by the config of the repository
+	target := mkTargetRepo(tr, "depth.git")
+	target.SetDeltaConfig(DeltaConfig{MaxDepth: 1})
+	defer target.Close()
+
+	if _, err := target.IndexPack(bytes.NewReader(data), IndexPackConfig{}); err == nil {
+		t.Fatalf("depth: expected an error")
+	} else if lerr, ok := err.(*DeltaLimitError); !ok || lerr.MaxDepth != 1 {
+		t.Fatalf("depth: expected a delta limit error, got %v", err)
+	}
+}
@@ -0,0 +1,844 @@
+package git
+
+//lineDiff computes the changed lines of two files, given as
+//sequences of line numbers (see internLines). After compare, ca[i]
+//is true if line i of a was deleted, cb[j] if line j of b was added.
+type lineDiff struct {
+	a, b   []int
+	ca, cb []bool
+}
+
+func newLineDiff(a, b []int) *lineDiff {
+	return &lineDiff{a: a, b: b, ca: make([]bool, len(a)), cb: make([]bool, len(b))}
+}
+
+//internLines maps the lines of both files to numbers, equal
+//lines to the same number.
+func internLines(a, b []string) ([]int, []int) {
+	ids := make(map[string]int)
+	intern := func(lines []string) []int {
+		res := make([]int, len(lines))
+		for i, l := range lines {
+			id, ok := ids[l]
+			if !ok {
+				id = len(ids)
+				ids[l] = id
+			}
+			res[i] = id
+		}
+		return res
+	}
+
+	return intern(a), intern(b)
+}
+
+//markChanged marks all lines of the ranges as changed.
+func (d *lineDiff) markChanged(a0, a1, b0, b1 int) {
+	for i := a0; i < a1; i++ {
+		d.ca[i] = true
+	}
+	for j := b0; j < b1; j++ {
+		d.cb[j] = true
+	}
+}
+
+//trim strips the common prefix and suffix of the ranges and marks
+//the remaining lines as changed if either range is empty then, in
+//which case done is true.
+func (d *lineDiff) trim(a0, a1, b0, b1 int) (int, int, int, int, bool) {
+	for a0 < a1 && b0 < b1 && d.a[a0] == d.b[b0] {
+		a0++
+		b0++
+	}
+
+	for a0 < a1 && b0 < b1 && d.a[a1-1] == d.b[b1-1] {
+		a1--
+		b1--
+	}
+
+	if a0 == a1 || b0 == b1 {
+		d.markChanged(a0, a1, b0, b1)
+		return a0, a1, b0, b1, true
+	}
+
+	return a0, a1, b0, b1, false
+}
+
+//Parameters of the myers diff, as in the xdiff library of git.
+const (
+	xdiffMaxEqLimit    = 1024
+	xdiffSimscanWindow = 100
+	xdiffKeepDisRun    = 4
+	xdiffMaxCostMin    = 256
+	xdiffHeurMinCost   = 256
+	xdiffSnakeCount    = 20
+	xdiffKHeur         = 4
+)
+
+//bogosqrt approximates the square root of n, like xdiff.
+func bogosqrt(n int) int {
+	i := 1
+	for ; n > 0; n >>= 2 {
+		i <<= 1
+	}
+	return i
+}
+
+//myers compares the ranges with the algorithm of Eugene W. Myers,
+//"An O(ND) Difference Algorithm and Its Variations", in the linear
+//space variant and with the heuristics of the xdiff library of git,
+//which gives the same diffs as git diff: lines without a match in the
+//other file are marked as changed before comparing (as are lines with
+//very many matches that are surrounded by such lines) and the search
+//is cut short for very different files.
+func (d *lineDiff) myers(a0, a1, b0, b1 int) {
+	//occurrences of each line in the other file
+	inA, inB := make(map[int]int), make(map[int]int)
+	for i := a0; i < a1; i++ {
+		inA[d.a[i]]++
+	}
+	for j := b0; j < b1; j++ {
+		inB[d.b[j]]++
+	}
+
+	s0, s1, t0, t1, done := d.trim(a0, a1, b0, b1)
+	if done {
+		return
+	}
+
+	ra := d.cleanupRecords(d.a, d.ca, s0, s1, inB, a1-a0)
+	rb := d.cleanupRecords(d.b, d.cb, t0, t1, inA, b1-b0)
+
+	x := &xdiff{ra: ra, rb: rb, ca: d.ca, cb: d.cb}
+	x.ha1 = make([]int, len(ra))
+	for i, r := range ra {
+		x.ha1[i] = d.a[r]
+	}
+	x.ha2 = make([]int, len(rb))
+	for i, r := range rb {
+		x.ha2[i] = d.b[r]
+	}
+
+	ndiags := len(ra) + len(rb) + 3
+	x.kvdf = make([]int, ndiags)
+	x.kvdb = make([]int, ndiags)
+	x.koff = len(rb) + 1
+
+	x.mxcost = bogosqrt(ndiags)
+	if x.mxcost < xdiffMaxCostMin {
+		x.mxcost = xdiffMaxCostMin
+	}
+
+	x.compare(0, len(ra), 0, len(rb), false)
+}
+
+//cleanupRecords returns the lines [start, end) of lines that are
+//compared by myers, and marks the others as changed: lines without
+//a match in the other file and lines with many matches that are in
+//a run of such lines.
+func (d *lineDiff) cleanupRecords(lines []int, changed []bool, start, end int, other map[int]int, n int) []int {
+	mlim := bogosqrt(n)
+	if mlim > xdiffMaxEqLimit {
+		mlim = xdiffMaxEqLimit
+	}
+
+	//0: no match, 1: keep, 2: many matches
+	dis := make([]byte, end-start)
+	for i := range dis {
+		switch nm := other[lines[start+i]]; {
+		case nm == 0:
+			dis[i] = 0
+		case nm >= mlim:
+			dis[i] = 2
+		default:
+			dis[i] = 1
+		}
+	}
+
+	var keep []int
+	for i := range dis {
+		if dis[i] == 1 || (dis[i] == 2 && !cleanMultiMatch(dis, i)) {
+			keep = append(keep, start+i)
+		} else {
+			changed[start+i] = true
+		}
+	}
+
+	return keep
+}
+
+//cleanMultiMatch decides if the line i, which has many matches, is
+//discarded, which is the case if it is surrounded by lines that are
+//discarded or have many matches, too.
+func cleanMultiMatch(dis []byte, i int) bool {
+	s, e := 0, len(dis)-1
+	if i-s > xdiffSimscanWindow {
+		s = i - xdiffSimscanWindow
+	}
+	if e-i > xdiffSimscanWindow {
+		e = i + xdiffSimscanWindow
+	}
+
+	rdis0, rpdis0 := 0, 1
+	for r := 1; i-r >= s; r++ {
+		if dis[i-r] == 0 {
+			rdis0++
+		} else if dis[i-r] == 2 {
+			rpdis0++
+		} else {
+			break
+		}
+	}
+	if rdis0 == 0 {
+		return false
+	}
+
+	rdis1, rpdis1 := 0, 1
+	for r := 1; i+r <= e; r++ {
+		if dis[i+r] == 0 {
+			rdis1++
+		} else if dis[i+r] == 2 {
+			rpdis1++
+		} else {
+			break
+		}
+	}
+	if rdis1 == 0 {
+		return false
+	}
+
+	rdis1 += rdis0
+	rpdis1 += rpdis0
+	return rpdis1*xdiffKeepDisRun < rpdis1+rdis1
+}
+
+//xdiff compares the lines that remain after cleanupRecords, ha1
+//and ha2, which are the lines ra and rb of the files.
+type xdiff struct {
+	ha1, ha2 []int
+	ra, rb   []int
+	ca, cb   []bool
+
+	//furthest reaching paths by diagonal, which is the index
+	//minus koff
+	kvdf, kvdb []int
+	koff       int
+
+	mxcost int
+}
+
+func (x *xdiff) compare(off1, lim1, off2, lim2 int, needMin bool) {
+	for off1 < lim1 && off2 < lim2 && x.ha1[off1] == x.ha2[off2] {
+		off1++
+		off2++
+	}
+	for off1 < lim1 && off2 < lim2 && x.ha1[lim1-1] == x.ha2[lim2-1] {
+		lim1--
+		lim2--
+	}
+
+	switch {
+	case off1 == lim1:
+		for ; off2 < lim2; off2++ {
+			x.cb[x.rb[off2]] = true
+		}
+	case off2 == lim2:
+		for ; off1 < lim1; off1++ {
+			x.ca[x.ra[off1]] = true
+		}
+	default:
+		i1, i2, minLo, minHi := x.split(off1, lim1, off2, lim2, needMin)
+		x.compare(off1, i1, off2, i2, minLo)
+		x.compare(i1, lim1, i2, lim2, minHi)
+	}
+}
+
+//split finds the middle snake of the ranges, or a good enough split
+//if that gets too expensive and needMin is false.
+func (x *xdiff) split(off1, lim1, off2, lim2 int, needMin bool) (int, int, bool, bool) {
+	ha1, ha2 := x.ha1, x.ha2
+	kvdf := func(d int) *int { return &x.kvdf[d+x.koff] }
+	kvdb := func(d int) *int { return &x.kvdb[d+x.koff] }
+
+	dmin, dmax := off1-lim2, lim1-off2
+	fmid, bmid := off1-off2, lim1-lim2
+	odd := (fmid-bmid)&1 != 0
+	fmin, fmax := fmid, fmid
+	bmin, bmax := bmid, bmid
+
+	*kvdf(fmid) = off1
+	*kvdb(bmid) = lim1
+
+	const lineMax = int(^uint(0) >> 1)
+
+	for ec := 1; ; ec++ {
+		gotSnake := false
+
+		if fmin > dmin {
+			fmin--
+			*kvdf(fmin - 1) = -1
+		} else {
+			fmin++
+		}
+		if fmax < dmax {
+			fmax++
+			*kvdf(fmax + 1) = -1
+		} else {
+			fmax--
+		}
+
+		for d := fmax; d >= fmin; d -= 2 {
+			var i1 int
+			if *kvdf(d - 1) >= *kvdf(d + 1) {
+				i1 = *kvdf(d - 1) + 1
+			} else {
+				i1 = *kvdf(d + 1)
+			}
+			prev1 := i1
+			i2 := i1 - d
+			for i1 < lim1 && i2 < lim2 && ha1[i1] == ha2[i2] {
+				i1++
+				i2++
+			}
+			if i1-prev1 > xdiffSnakeCount {
+				gotSnake = true
+			}
+			*kvdf(d) = i1
+			if odd && bmin <= d && d <= bmax && *kvdb(d) <= i1 {
+				return i1, i2, true, true
+			}
+		}
+
+		if bmin > dmin {
+			bmin--
+			*kvdb(bmin - 1) = lineMax
+		} else {
+			bmin++
+		}
+		if bmax < dmax {
+			bmax++
+			*kvdb(bmax + 1) = lineMax
+		} else {
+			bmax--
+		}
+
+		for d := bmax; d >= bmin; d -= 2 {
+			var i1 int
+			if *kvdb(d - 1) < *kvdb(d + 1) {
+				i1 = *kvdb(d - 1)
+			} else {
+				i1 = *kvdb(d + 1) - 1
+			}
+			prev1 := i1
+			i2 := i1 - d
+			for i1 > off1 && i2 > off2 && ha1[i1-1] == ha2[i2-1] {
+				i1--
+				i2--
+			}
+			if prev1-i1 > xdiffSnakeCount {
+				gotSnake = true
+			}
+			*kvdb(d) = i1
+			if !odd && fmin <= d && d <= fmax && i1 <= *kvdf(d) {
+				return i1, i2, true, true
+			}
+		}
+
+		if needMin {
+			continue
+		}
+
+		//take a long snake that is far enough along, if any
+		if gotSnake && ec > xdiffHeurMinCost {
+			best, s1, s2 := 0, 0, 0
+			for d := fmax; d >= fmin; d -= 2 {
+				dd := d - fmid
+				if dd < 0 {
+					dd = -dd
+				}
+				i1 := *kvdf(d)
+				i2 := i1 - d
+				v := (i1 - off1) + (i2 - off2) - dd
+
+				if v > xdiffKHeur*ec && v > best &&
+					off1+xdiffSnakeCount <= i1 && i1 < lim1 &&
+					off2+xdiffSnakeCount <= i2 && i2 < lim2 {
+					for k := 1; ha1[i1-k] == ha2[i2-k]; k++ {
+						if k == xdiffSnakeCount {
+							best, s1, s2 = v, i1, i2
+							break
+						}
+					}
+				}
+			}
+			if best > 0 {
+				return s1, s2, true, false
+			}
+
+			for d := bmax; d >= bmin; d -= 2 {
+				dd := d - bmid
+				if dd < 0 {
+					dd = -dd
+				}
+				i1 := *kvdb(d)
+				i2 := i1 - d
+				v := (lim1 - i1) + (lim2 - i2) - dd
+
+				if v > xdiffKHeur*ec && v > best &&
+					off1 < i1 && i1 <= lim1-xdiffSnakeCount &&
+					off2 < i2 && i2 <= lim2-xdiffSnakeCount {
+					for k := 0; ha1[i1+k] == ha2[i2+k]; k++ {
+						if k == xdiffSnakeCount-1 {
+							best, s1, s2 = v, i1, i2
+							break
+						}
+					}
+				}
+			}
+			if best > 0 {
+				return s1, s2, false, true
+			}
+		}
+
+		//too expensive, take the furthest reaching path
+		if ec >= x.mxcost {
+			fbest, fbest1 := -1, -1
+			for d := fmax; d >= fmin; d -= 2 {
+				i1 := *kvdf(d)
+				if i1 > lim1 {
+					i1 = lim1
+				}
+				i2 := i1 - d
+				if lim2 < i2 {
+					i1, i2 = lim2+d, lim2
+				}
+				if fbest < i1+i2 {
+					fbest, fbest1 = i1+i2, i1
+				}
+			}
+
+			bbest, bbest1 := lineMax, lineMax
+			for d := bmax; d >= bmin; d -= 2 {
+				i1 := *kvdb(d)
+				if i1 < off1 {
+					i1 = off1
+				}
+				i2 := i1 - d
+				if i2 < off2 {
+					i1, i2 = off2+d, off2
+				}
+				if i1+i2 < bbest {
+					bbest, bbest1 = i1+i2, i1
+				}
+			}
+
+			if (lim1+lim2)-bbest < fbest-(off1+off2) {
+				return fbest1, fbest - fbest1, true, false
+			}
+			return bbest1, bbest - bbest1, false, true
+		}
+	}
+}
+
+//histogramMaxChain is the number of occurrences of a line above
+//which it is not used as an anchor by the histogram diff.
+const histogramMaxChain = 64
+
+//histogram compares the ranges with the histogram diff of git (a port
+//of xhistogram.c, which follows jgit): the longest common region
+//that contains the line occurring least often in a is used as an
+//anchor and the parts before and after it are compared recursively.
+//Ranges with common lines that all occur too often are compared with
+//myers, ranges without common lines are changed completely.
+func (d *lineDiff) histogram(a0, a1, b0, b1 int) {
+	if a0 == a1 || b0 == b1 {
+		d.markChanged(a0, a1, b0, b1)
+		return
+	}
+
+	//the occurrences of the lines of a, next[i-a0] is the next
+	//occurrence of line i, -1 for the last one
+	count := make(map[int]int)
+	first := make(map[int]int)
+	next := make([]int, a1-a0)
+	for i := a1 - 1; i >= a0; i-- {
+		next[i-a0] = -1
+		if f, ok := first[d.a[i]]; ok {
+			next[i-a0] = f
+		}
+		first[d.a[i]] = i
+		count[d.a[i]]++
+	}
+
+	bestCount := histogramMaxChain + 1
+	hasCommon, found := false, false
+	var as, ae, bs, be int
+
+	//the length of the region minus one, as in git
+	length := 0
+
+	for j := b0; j < b1; {
+		bnext := j + 1
+
+		c, ok := count[d.b[j]]
+		hasCommon = hasCommon || ok
+
+		if !ok || c > bestCount {
+			j = bnext
+			continue
+		}
+
+		for i := first[d.b[j]]; ; {
+			sa, sb, ea, eb := i, j, i+1, j+1
+			rc := c
+
+			for sa > a0 && sb > b0 && d.a[sa-1] == d.b[sb-1] {
+				sa--
+				sb--
+				if rc > 1 && count[d.a[sa]] < rc {
+					rc = count[d.a[sa]]
+				}
+			}
+
+			for ea < a1 && eb < b1 && d.a[ea] == d.b[eb] {
+				if rc > 1 && count[d.a[ea]] < rc {
+					rc = count[d.a[ea]]
+				}
+				ea++
+				eb++
+			}
+
+			if bnext < eb {
+				bnext = eb
+			}
+
+			//a longer region wins even if its lines occur
+			//more often
+			if length < ea-sa-1 || rc < bestCount {
+				as, ae, bs, be = sa, ea, sb, eb
+				length = ea - sa - 1
+				bestCount = rc
+				found = true
+			}
+
+			//the next occurrence after the region
+			for i = next[i-a0]; i != -1 && i < ea; i = next[i-a0] {
+			}
+			if i == -1 {
+				break
+			}
+		}
+
+		j = bnext
+	}
+
+	if hasCommon && bestCount > histogramMaxChain {
+		d.myers(a0, a1, b0, b1)
+		return
+	} else if !found {
+		d.markChanged(a0, a1, b0, b1)
+		return
+	}
+
+	d.histogram(a0, as, b0, bs)
+	d.histogram(ae, a1, be, b1)
+}
+
+//diffFile is one side of a line diff, for compactChanges.
+type diffFile struct {
+	ids     []int
+	lines   []string
+	changed []bool
+}
+
+func (f *diffFile) isChanged(i int) bool {
+	return i >= 0 && i < len(f.changed) && f.changed[i]
+}
+
+//diffGroup is a group of changed lines [start, end) of a file, which
+//is empty if no lines changed between two unchanged lines. The groups
+//of both files correspond to each other.
+type diffGroup struct {
+	start, end int
+}
+
+func (f *diffFile) firstGroup() diffGroup {
+	g := diffGroup{}
+	for f.isChanged(g.end) {
+		g.end++
+	}
+	return g
+}
+
+func (f *diffFile) nextGroup(g *diffGroup) bool {
+	if g.end == len(f.ids) {
+		return false
+	}
+
+	g.start = g.end + 1
+	for g.end = g.start; f.isChanged(g.end); g.end++ {
+	}
+	return true
+}
+
+func (f *diffFile) previousGroup(g *diffGroup) bool {
+	if g.start == 0 {
+		return false
+	}
+
+	g.end = g.start - 1
+	for g.start = g.end; f.isChanged(g.start - 1); g.start-- {
+	}
+	return true
+}
+
+func (f *diffFile) slideDown(g *diffGroup) bool {
+	if g.end < len(f.ids) && f.ids[g.start] == f.ids[g.end] {
+		f.changed[g.start] = false
+		f.changed[g.end] = true
+		g.start++
+		g.end++
+		for f.isChanged(g.end) {
+			g.end++
+		}
+		return true
+	}
+	return false
+}
+
+func (f *diffFile) slideUp(g *diffGroup) bool {
+	if g.start > 0 && f.ids[g.start-1] == f.ids[g.end-1] {
+		g.start--
+		g.end--
+		f.changed[g.start] = true
+		f.changed[g.end] = false
+		for f.isChanged(g.start - 1) {
+			g.start--
+		}
+		return true
+	}
+	return false
+}
+
+//compactChanges moves the groups of changed lines of f, whose other
+//side is other, to where they read best, like git does: groups are
+//merged where sliding allows it, aligned with changes in the other
+//file if possible and placed by the indentation of the lines around
+//them otherwise (the indent heuristic of git).
+func compactChanges(f, other *diffFile) {
+	g, og := f.firstGroup(), other.firstGroup()
+
+	for {
+		if g.end != g.start {
+			var size, earliestEnd int
+			endMatchingOther := -1
+
+			for {
+				size = g.end - g.start
+				endMatchingOther = -1
+
+				//as far up as possible, merging groups on the way
+				for f.slideUp(&g) {
+					other.previousGroup(&og)
+				}
+
+				earliestEnd = g.end
+				if og.end > og.start {
+					endMatchingOther = g.end
+				}
+
+				//then down, remembering the alignment with a change
+				//in the other file
+				for f.slideDown(&g) {
+					other.nextGroup(&og)
+					if og.end > og.start {
+						endMatchingOther = g.end
+					}
+				}
+
+				if size == g.end-g.start {
+					break
+				}
+			}
+
+			switch {
+			case g.end == earliestEnd:
+			case endMatchingOther != -1:
+				for og.end == og.start {
+					f.slideUp(&g)
+					other.previousGroup(&og)
+				}
+			default:
+				best := f.bestShift(g, size, earliestEnd)
+				for g.end > best {
+					f.slideUp(&g)
+					other.previousGroup(&og)
+				}
+			}
+		}
+
+		if !f.nextGroup(&g) {
+			break
+		}
+		other.nextGroup(&og)
+	}
+}
+
+//Parameters of the indent heuristic, as tuned for git.
+const (
+	indentMax          = 200
+	indentMaxBlanks    = 20
+	indentMaxSliding   = 100
+	indentWeight       = 60
+	startOfFilePenalty = 1
+	endOfFilePenalty   = 21
+	totalBlankWeight   = -30
+	postBlankWeight    = 6
+
+	relativeIndentPenalty           = -4
+	relativeIndentWithBlankPenalty  = 10
+	relativeOutdentPenalty          = 24
+	relativeOutdentWithBlankPenalty = 17
+	relativeDedentPenalty           = 23
+	relativeDedentWithBlankPenalty  = 17
+)
+
+//lineIndent returns the indentation of line, with tabs to multiples
+//of 8, or -1 for blank lines.
+func lineIndent(line string) int {
+	n := 0
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case ' ':
+			n++
+		case '\t':
+			n += 8 - n%8
+		case '\n', '\r', '\f', '\v':
+		default:
+			return n
+		}
+
+		if n >= indentMax {
+			return indentMax
+		}
+	}
+	return -1
+}
+
+//splitScore rates the position of the start or end of a group,
+//lower is better.
+type splitScore struct {
+	effectiveIndent int
+	penalty         int
+}
+
+func (s *splitScore) cmp(other *splitScore) int {
+	indents := 0
+	if s.effectiveIndent > other.effectiveIndent {
+		indents = 1
+	} else if s.effectiveIndent < other.effectiveIndent {
+		indents = -1
+	}
+	return indentWeight*indents + (s.penalty - other.penalty)
+}
+
+//addSplit rates a split of the file before line split.
+func (f *diffFile) addSplit(split int, s *splitScore) {
+	endOfFile, indent := split >= len(f.lines), -1
+	if !endOfFile {
+		indent = lineIndent(f.lines[split])
+	}
+
+	preBlank, preIndent := 0, -1
+	for i := split - 1; i >= 0; i-- {
+		if preIndent = lineIndent(f.lines[i]); preIndent != -1 {
+			break
+		}
+		if preBlank++; preBlank == indentMaxBlanks {
+			preIndent = 0
+			break
+		}
+	}
+
+	postBlank, postIndent := 0, -1
+	for i := split + 1; i < len(f.lines); i++ {
+		if postIndent = lineIndent(f.lines[i]); postIndent != -1 {
+			break
+		}
+		if postBlank++; postBlank == indentMaxBlanks {
+			postIndent = 0
+			break
+		}
+	}
+
+	if preIndent == -1 && preBlank == 0 {
+		s.penalty += startOfFilePenalty
+	}
+	if endOfFile {
+		s.penalty += endOfFilePenalty
+	}
+
+	blank := 0
+	if indent == -1 {
+		blank = 1 + postBlank
+	}
+	totalBlank := preBlank + blank
+
+	s.penalty += totalBlankWeight*totalBlank + postBlankWeight*blank
+
+	if indent == -1 {
+		indent = postIndent
+	}
+	anyBlanks := totalBlank != 0
+
+	s.effectiveIndent += indent
+
+	switch {
+	case indent == -1 || preIndent == -1 || indent == preIndent:
+	case indent > preIndent:
+		if anyBlanks {
+			s.penalty += relativeIndentWithBlankPenalty
+		} else {
+			s.penalty += relativeIndentPenalty
+		}
+	case postIndent != -1 && postIndent > indent:
+		if anyBlanks {
+			s.penalty += relativeOutdentWithBlankPenalty
+		} else {
+			s.penalty += relativeOutdentPenalty
+		}
+	default:
+		if anyBlanks {
+			s.penalty += relativeDedentWithBlankPenalty
+		} else {
+			s.penalty += relativeDedentPenalty
+		}
+	}
+}
+
+//bestShift returns the end of the group g at the best position
+//according to the indent heuristic.
+func (f *diffFile) bestShift(g diffGroup, size, earliestEnd int) int {
+	shift := earliestEnd
+	if g.end-size-1 > shift {
+		shift = g.end - size - 1
+	}
+	if g.end-indentMaxSliding > shift {
+		shift = g.end - indentMaxSliding
+	}
+
+	best := -1
+	var bestScore splitScore
+	for ; shift <= g.end; shift++ {
+		var score splitScore
+		f.addSplit(shift, &score)
+		f.addSplit(shift-size, &score)
+
+		if best == -1 || score.cmp(&bestScore) <= 0 {
+			bestScore = score
+			best = shift
+		}
+	}
+
+	return best
+}
@@ -0,0 +1,55 @@
+package git
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+//ErrRepositoryLocked is returned by TryLock if the repository
+//is locked by someone else.
+var ErrRepositoryLocked = errors.New("git: repository is locked")
+
+//RepositoryLock is an advisory lock of the whole repository.
+//Operations that only add objects and update refs, like pushes,
+//hold it shared; maintenance that removes objects holds it
+//exclusively. It is released when the process exits.
+type RepositoryLock struct {
+	fd *os.File
+}
+
+func (repo *Repository) lock(exclusive, wait bool) (*RepositoryLock, error) {
+	fd, err := os.OpenFile(filepath.Join(repo.Path, "gin.lock"), os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+
+	err = flockFile(fd, exclusive, wait)
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+
+	return &RepositoryLock{fd}, nil
+}
+
+//Lock locks the repository, shared or exclusive, and waits
+//until the lock is acquired.
+func (repo *Repository) Lock(exclusive bool) (*RepositoryLock, error) {
+	return repo.lock(exclusive, true)
+}
+
+//TryLock locks the repository like Lock, but returns
+//ErrRepositoryLocked instead of waiting.
+func (repo *Repository) TryLock(exclusive bool) (*RepositoryLock, error) {
+	return repo.lock(exclusive, false)
+}
+
+//Unlock releases the lock.
+func (l *RepositoryLock) Unlock() error {
+	err := funlockFile(l.fd)
+	if cerr := l.fd.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,18 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package git
+
+import (
+	"os"
+)
+
+//flockFile is not supported on this platform, repository
+//locks do not exclude each other.
+func flockFile(fd *os.File, exclusive, wait bool) error {
+	return nil
+}
+
+func funlockFile(fd *os.File) error {
+	return nil
+}
@@ -0,0 +1,36 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package git
+
+import (
+	"os"
+	"syscall"
+)
+
+//flockFile locks the file with flock(2), which is released
+//when the file is closed, also if the process dies.
+func flockFile(fd *os.File, exclusive, wait bool) error {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+
+	if !wait {
+		how |= syscall.LOCK_NB
+	}
+
+	for {
+		err := syscall.Flock(int(fd.Fd()), how)
+		if err == syscall.EINTR {
+			continue
+		} else if err == syscall.EWOULDBLOCK {
+			return ErrRepositoryLocked
+		}
+		return err
+	}
+}
+
+func funlockFile(fd *os.File) error {
+	return syscall.Flock(int(fd.Fd()), syscall.LOCK_UN)
+}
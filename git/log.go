@@ -0,0 +1,475 @@
+package git
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+//LogOrder is the order in which Log returns commits.
+type LogOrder int
+
+const (
+	//LogDateOrder returns commits youngest first (by committer date),
+	//like git log does by default.
+	LogDateOrder LogOrder = iota
+
+	//LogTopoOrder returns no parent before all of its children and
+	//avoids intermixing lines of history, like git log --topo-order.
+	LogTopoOrder
+)
+
+//LogOptions select and order the commits returned by Log.
+//The zero value selects all commits in date order.
+type LogOptions struct {
+	//Paths limits the log to commits that change any of the
+	//paths (files or directories). Merges that are identical to
+	//one of their parents at the paths are skipped and only that
+	//parent is followed, like git log's default history
+	//simplification does.
+	Paths []string
+
+	//Author and Committer, if not nil, must match the
+	//"Name <email>" of the author and the committer, respectively.
+	Author    *regexp.Regexp
+	Committer *regexp.Regexp
+
+	//Mailmap, if not nil, maps authors and committers before they
+	//are matched and is set as mailmap of the commit graph, so it
+	//applies to the returned commits as well.
+	Mailmap *Mailmap
+
+	//Since and Until, if not zero, limit the committer dates.
+	//History older than Since is not walked.
+	Since time.Time
+	Until time.Time
+
+	//FirstParent follows only the first parent of merges.
+	FirstParent bool
+
+	//Skip omits the first Skip selected commits, Limit, if
+	//greater than zero, returns at most Limit commits.
+	Skip  int
+	Limit int
+
+	Order LogOrder
+}
+
+//flags private to the log walker
+const (
+	nodeFlagExcluded NodeFlag = 1 << (iota + 8)
+	nodeFlagQueued
+	nodeFlagShown
+)
+
+//logWalker holds the state of a single call of Log.
+type logWalker struct {
+	repo  *Repository
+	graph *CommitGraph
+	opts  LogOptions
+
+	paths [][]string
+
+	//pending is the number of queued commits that are not
+	//excluded, the walk is over once it drops to zero
+	pending int
+	shown   int
+
+	walked  []*CommitNode
+	follows map[*CommitNode][]*CommitNode
+	err     error
+}
+
+//Log walks the history of rev and returns the commits that are
+//selected by opts. For ranges ("A..B") the commits reachable from
+//A are excluded.
+func (repo *Repository) Log(rev Revision, opts LogOptions) ([]*CommitNode, error) {
+	w := &logWalker{
+		repo:    repo,
+		graph:   NewCommitGraph(repo),
+		opts:    opts,
+		follows: make(map[*CommitNode][]*CommitNode),
+	}
+	w.graph.Mailmap = opts.Mailmap
+
+	for _, p := range opts.Paths {
+		p = strings.Trim(p, "/")
+		if p == "" || p == "." {
+			//the whole tree, i.e. no filtering at all
+			w.paths = nil
+			break
+		}
+		w.paths = append(w.paths, strings.Split(p, "/"))
+	}
+
+	tip, err := w.graph.AddTip(rev.ID)
+	if err != nil {
+		return nil, err
+	}
+	w.queue(tip)
+
+	if rev.IsRange {
+		exclude, err := w.graph.AddTip(rev.Exclude)
+		if err != nil {
+			return nil, err
+		}
+		w.exclude(exclude)
+	}
+
+	if w.pending == 0 {
+		return nil, nil
+	}
+
+	err = w.graph.walk(w.visit)
+	if err != nil {
+		return nil, err
+	} else if w.err != nil {
+		return nil, w.err
+	}
+
+	shown := w.walked
+	if opts.Order == LogTopoOrder {
+		shown = w.topoSort()
+	}
+
+	var commits []*CommitNode
+	skip := opts.Skip
+	for _, node := range shown {
+		if node.Flags&nodeFlagShown == 0 || node.Flags&nodeFlagExcluded != 0 {
+			continue
+		} else if skip > 0 {
+			skip--
+			continue
+		}
+
+		commits = append(commits, node)
+		if opts.Limit > 0 && len(commits) == opts.Limit {
+			break
+		}
+	}
+
+	return commits, nil
+}
+
+//queue accounts for node being pushed onto the walk queue.
+func (w *logWalker) queue(node *CommitNode) {
+	if node.Flags&nodeFlagQueued != 0 {
+		return
+	}
+	node.Flags |= nodeFlagQueued
+	if node.Flags&nodeFlagExcluded == 0 {
+		w.pending++
+	}
+}
+
+//exclude marks node and all its loaded ancestors as reachable
+//from an excluded tip.
+func (w *logWalker) exclude(node *CommitNode) {
+	stack := []*CommitNode{node}
+
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if node.Flags&nodeFlagExcluded != 0 {
+			continue
+		}
+
+		if node.Flags&nodeFlagQueued != 0 && node.Flags&NodeFlagSeen == 0 {
+			w.pending--
+		}
+		node.Flags |= nodeFlagExcluded
+
+		stack = append(stack, node.parents...)
+	}
+}
+
+//visit is called for every commit of the walk, it decides if the
+//commit is shown and which of its parents are followed.
+func (w *logWalker) visit(node *CommitNode) ([]*CommitNode, bool) {
+	parents := node.parents
+	if w.opts.FirstParent && len(parents) > 1 {
+		parents = parents[:1]
+	}
+
+	if node.Flags&nodeFlagExcluded != 0 {
+		//like git, load the parents so that the exclusion
+		//reaches the grandparents before they are walked
+		for _, parent := range node.parents {
+			if err := w.graph.loadParents(parent); err != nil {
+				w.err = err
+				return nil, true
+			}
+			w.exclude(parent)
+		}
+		return node.parents, w.pending == 0
+	}
+	w.pending--
+
+	date := time.Unix(node.date, 0)
+
+	if !w.opts.Since.IsZero() && date.Before(w.opts.Since) {
+		return nil, w.pending == 0
+	}
+
+	follow, show, err := w.simplify(node, parents)
+	if err != nil {
+		w.err = err
+		return nil, true
+	}
+
+	if !w.opts.Until.IsZero() && date.After(w.opts.Until) {
+		show = false
+	}
+
+	if show && (w.opts.Author != nil || w.opts.Committer != nil) {
+		//only now the commit object is needed
+		author, err := node.Author()
+		if err != nil {
+			w.err = err
+			return nil, true
+		}
+		committer, _ := node.Committer()
+
+		switch {
+		case w.opts.Author != nil && !w.opts.Author.MatchString(personOf(author)):
+			show = false
+		case w.opts.Committer != nil && !w.opts.Committer.MatchString(personOf(committer)):
+			show = false
+		}
+	}
+
+	if show {
+		node.Flags |= nodeFlagShown
+		w.shown++
+	}
+
+	w.walked = append(w.walked, node)
+	w.follows[node] = follow
+	for _, parent := range follow {
+		w.queue(parent)
+	}
+
+	stop := w.pending == 0
+	if w.opts.Order == LogDateOrder && w.opts.Limit > 0 {
+		stop = stop || w.shown >= w.opts.Skip+w.opts.Limit
+	}
+
+	return follow, stop
+}
+
+//personOf returns "Name <email>" of a signature, which is what
+//git log's --author and --committer match against.
+func personOf(sig Signature) string {
+	return fmt.Sprintf("%s <%s>", sig.Name, sig.Email)
+}
+
+//simplify returns the parents of node that are followed and if node
+//changes any of the paths of the walker.
+func (w *logWalker) simplify(node *CommitNode, parents []*CommitNode) ([]*CommitNode, bool, error) {
+	if w.paths == nil {
+		return parents, true, nil
+	}
+
+	tree := node.tree
+
+	if len(parents) == 0 {
+		same, err := w.samePaths(ObjectID{}, tree)
+		return parents, !same, err
+	}
+
+	for _, parent := range parents {
+		if parent.Flags&nodeFlagExcluded != 0 && len(parents) > 1 {
+			continue
+		}
+
+		same, err := w.samePaths(parent.tree, tree)
+		if err != nil {
+			return nil, false, err
+		} else if same {
+			return []*CommitNode{parent}, false, nil
+		}
+	}
+
+	return parents, true, nil
+}
+
+//samePaths checks if the trees a and b, either of which may be zero
+//for the empty tree, are identical at the paths of the walker.
+func (w *logWalker) samePaths(a, b ObjectID) (bool, error) {
+	if a == b {
+		return true, nil
+	}
+
+	for _, comps := range w.paths {
+		ea, err := w.repo.lookupPath(a, comps)
+		if err != nil {
+			return false, err
+		}
+
+		eb, err := w.repo.lookupPath(b, comps)
+		if err != nil {
+			return false, err
+		}
+
+		if ea != eb {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+//lookupPath returns the entry at the path comps below the tree
+//with the given id, or the zero entry if there is none.
+func (repo *Repository) lookupPath(id ObjectID, comps []string) (TreeEntry, error) {
+	var entry TreeEntry
+
+	for i, name := range comps {
+		if id.IsZero() {
+			return TreeEntry{}, nil
+		}
+
+		tree, err := repo.openTree(id)
+		if err != nil {
+			return entry, err
+		}
+
+		entries, err := readTreeEntries(tree)
+		if err != nil {
+			return entry, err
+		}
+
+		entry = TreeEntry{}
+		for _, e := range entries {
+			if e.Name == name {
+				entry = e
+				break
+			}
+		}
+
+		if entry.Type != ObjTree && i+1 < len(comps) {
+			return TreeEntry{}, nil
+		}
+		id = entry.ID
+	}
+
+	return entry, nil
+}
+
+//topoSort orders the walked commits like git's
+//sort_in_topological_order: no parent comes before all of its
+//children and each line of history is shown as a whole.
+func (w *logWalker) topoSort() []*CommitNode {
+	indegree := make(map[*CommitNode]int, len(w.walked))
+	for _, node := range w.walked {
+		indegree[node] = 1
+	}
+
+	for _, node := range w.walked {
+		for _, parent := range w.follows[node] {
+			if indegree[parent] > 0 {
+				indegree[parent]++
+			}
+		}
+	}
+
+	//tips are processed in walk order, i.e. the stack is
+	//filled in reverse
+	var stack []*CommitNode
+	for i := len(w.walked) - 1; i >= 0; i-- {
+		if node := w.walked[i]; indegree[node] == 1 {
+			stack = append(stack, node)
+		}
+	}
+
+	sorted := make([]*CommitNode, 0, len(w.walked))
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		for _, parent := range w.follows[node] {
+			if indegree[parent] == 0 {
+				continue
+			}
+
+			indegree[parent]--
+			if indegree[parent] == 1 {
+				stack = append(stack, parent)
+			}
+		}
+
+		indegree[node] = 0
+		sorted = append(sorted, node)
+	}
+
+	return sorted
+}
+
+//relativeDate formats the time passed between t and now like git's
+//relative dates ("2 hours ago", "1 year, 3 months ago").
+func relativeDate(t, now time.Time) string {
+	if now.Before(t) {
+		return "in the future"
+	}
+
+	plural := func(n int64, unit string) string {
+		if n == 1 {
+			return fmt.Sprintf("%d %s", n, unit)
+		}
+		return fmt.Sprintf("%d %ss", n, unit)
+	}
+
+	diff := now.Unix() - t.Unix()
+	if diff < 90 {
+		return plural(diff, "second") + " ago"
+	}
+
+	diff = (diff + 30) / 60
+	if diff < 90 {
+		return plural(diff, "minute") + " ago"
+	}
+
+	diff = (diff + 30) / 60
+	if diff < 36 {
+		return plural(diff, "hour") + " ago"
+	}
+
+	diff = (diff + 12) / 24
+	switch {
+	case diff < 14:
+		return plural(diff, "day") + " ago"
+	case diff < 70:
+		return plural((diff+3)/7, "week") + " ago"
+	case diff < 365:
+		return plural((diff+15)/30, "month") + " ago"
+	case diff < 1825:
+		months := (diff*12*2 + 365) / (365 * 2)
+		years := months / 12
+		months %= 12
+		if months == 0 {
+			return plural(years, "year") + " ago"
+		}
+		return plural(years, "year") + ", " + plural(months, "month") + " ago"
+	}
+
+	return plural((diff+183)/365, "year") + " ago"
+}
+
+//commitSubject returns the first paragraph of a commit message
+//joined into a single line, like git's %s format.
+func commitSubject(msg string) string {
+	var lines []string
+	for _, line := range strings.Split(msg, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" && lines == nil {
+			continue
+		} else if line == "" {
+			break
+		}
+		lines = append(lines, line)
+	}
+
+	return strings.Join(lines, " ")
+}
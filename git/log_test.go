@@ -0,0 +1,186 @@
+package git
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+//mkLogRepo creates a history with two merged side branches whose
+//commits are interleaved in time with the ones of master.
+func mkLogRepo(t *testing.T) *testRepo {
+	tr := mkTestRepo(t)
+
+	tr.write("a.txt", "a\n")
+	tr.write("dir/b.txt", "b\n")
+	tr.commit("initial")
+	tr.write("a.txt", "a\na\n")
+	tr.commit("second\n\nwith a body")
+
+	tr.git("checkout", "-q", "-b", "side")
+	tr.write("dir/b.txt", "b\nb\n")
+	tr.commit("side one")
+
+	tr.git("checkout", "-q", "master")
+	tr.write("a.txt", "a\na\na\n")
+	tr.commit("third")
+
+	tr.git("checkout", "-q", "side")
+	tr.write("c.txt", "c\n")
+	tr.git("add", "c.txt")
+	tr.git("commit", "-q", "-m", "side two", "--author=Other Person <other@example.com>")
+
+	tr.git("checkout", "-q", "-b", "topic", "master")
+	tr.write("dir/d.txt", "d\n")
+	tr.commit("topic one")
+
+	tr.git("checkout", "-q", "master")
+	tr.git("merge", "-q", "--no-ff", "-m", "merge side", "side")
+	tr.commit("empty")
+
+	tr.git("checkout", "-q", "topic")
+	tr.write("dir/d.txt", "d\nd\n")
+	tr.commit("topic two")
+
+	tr.git("checkout", "-q", "master")
+	tr.git("merge", "-q", "--no-ff", "-m", "merge topic", "topic")
+	tr.write("a.txt", "a\n")
+	tr.commit("last")
+
+	return tr
+}
+
+func TestLog(t *testing.T) {
+	tr := mkLogRepo(t)
+	defer tr.cleanup()
+
+	since := tr.git("log", "-1", "--format=%cI", "master~2")
+	until := tr.git("log", "-1", "--format=%cI", "master~1^2")
+	tSince, _ := time.Parse(time.RFC3339, since)
+	tUntil, _ := time.Parse(time.RFC3339, until)
+
+	tests := []struct {
+		rev  string
+		opts LogOptions
+		args []string
+	}{
+		{"master", LogOptions{}, nil},
+		{"master", LogOptions{FirstParent: true}, []string{"--first-parent"}},
+		{"master", LogOptions{Order: LogTopoOrder}, []string{"--topo-order"}},
+		{"master", LogOptions{Order: LogTopoOrder, FirstParent: true}, []string{"--topo-order", "--first-parent"}},
+		{"master", LogOptions{Skip: 2, Limit: 3}, []string{"--skip=2", "--max-count=3"}},
+		{"master", LogOptions{Order: LogTopoOrder, Skip: 1, Limit: 4}, []string{"--topo-order", "--skip=1", "--max-count=4"}},
+		{"master", LogOptions{Limit: 100}, []string{"--max-count=100"}},
+		{"master", LogOptions{Paths: []string{"dir"}}, []string{"--", "dir"}},
+		{"master", LogOptions{Paths: []string{"a.txt"}}, []string{"--", "a.txt"}},
+		{"master", LogOptions{Paths: []string{"dir/b.txt", "c.txt"}}, []string{"--", "dir/b.txt", "c.txt"}},
+		{"master", LogOptions{Paths: []string{"dir/"}, Order: LogTopoOrder}, []string{"--topo-order", "--", "dir/"}},
+		{"master", LogOptions{Paths: []string{"nope"}}, []string{"--", "nope"}},
+		{"master", LogOptions{Paths: []string{"dir"}, FirstParent: true}, []string{"--first-parent", "--", "dir"}},
+		{"master", LogOptions{Author: regexp.MustCompile("Other")}, []string{"--author=Other"}},
+		{"master", LogOptions{Author: regexp.MustCompile("author@")}, []string{"--author=author@"}},
+		{"master", LogOptions{Committer: regexp.MustCompile("^C O")}, []string{"--committer=^C O"}},
+		{"master", LogOptions{Since: tSince}, []string{"--since=" + since}},
+		{"master", LogOptions{Until: tUntil}, []string{"--until=" + until}},
+		{"master", LogOptions{Since: tSince, Until: tUntil}, []string{"--since=" + since, "--until=" + until}},
+		{"side..master", LogOptions{}, nil},
+		{"master..side", LogOptions{}, nil},
+		{"master~2..master", LogOptions{Order: LogTopoOrder}, []string{"--topo-order"}},
+		{"topic..master", LogOptions{Paths: []string{"a.txt"}}, []string{"--", "a.txt"}},
+	}
+
+	//with and without commit-graph file
+	for _, graph := range []bool{false, true} {
+		if graph {
+			if err := tr.WriteCommitGraph(); err != nil {
+				t.Fatalf("WriteCommitGraph() => %v", err)
+			}
+		}
+
+		for _, tt := range tests {
+			rev, err := tr.ParseRevision(tt.rev)
+			if err != nil {
+				t.Fatalf("ParseRevision(%q) => %v", tt.rev, err)
+			}
+
+			nodes, err := tr.Log(rev, tt.opts)
+			if err != nil {
+				t.Fatalf("Log(%s, %+v) => %v", tt.rev, tt.opts, err)
+			}
+
+			var ids []string
+			for _, node := range nodes {
+				ids = append(ids, node.ID.String())
+			}
+
+			args := append([]string{"log", "--format=%H", tt.rev}, tt.args...)
+			expected := tr.git(args...)
+
+			if have := strings.Join(ids, "\n"); have != expected {
+				t.Fatalf("git %s:\n%s\n, got\n%s", strings.Join(args, " "), expected, have)
+			}
+		}
+	}
+}
+
+func TestLogSummaries(t *testing.T) {
+	tr := mkLogRepo(t)
+	defer tr.cleanup()
+
+	summaries, err := tr.CommitsForRef("master", nil)
+	if err != nil {
+		t.Fatalf("CommitsForRef() => %v", err)
+	}
+
+	format := "%H%n%cn%n%an%n%ai%n%s"
+	lines := strings.Split(tr.git("log", "--format="+format, "master"), "\n")
+
+	if len(lines) != 5*len(summaries) {
+		t.Fatalf("expected %d commits, got %d", len(lines)/5, len(summaries))
+	}
+
+	for i, s := range summaries {
+		have := []string{s.Commit, s.Committer, s.Author, s.DateIso, s.Subject}
+		for k := range have {
+			if have[k] != lines[5*i+k] {
+				t.Fatalf("expected %q, got %q for commit %d", lines[5*i+k], have[k], i)
+			}
+		}
+
+		merge := strings.HasPrefix(s.Subject, "merge")
+		if merge && s.Changes != nil || !merge && s.Subject != "empty" && len(s.Changes) == 0 {
+			t.Fatalf("unexpected changes for %q: %v", s.Subject, s.Changes)
+		}
+	}
+}
+
+func TestRelativeDate(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+
+	tests := []struct {
+		ago      int64
+		expected string
+	}{
+		{-10, "in the future"},
+		{1, "1 second ago"},
+		{89, "89 seconds ago"},
+		{90, "2 minutes ago"},
+		{60 * 89, "89 minutes ago"},
+		{3600 * 2, "2 hours ago"},
+		{3600 * 35, "35 hours ago"},
+		{86400 * 13, "13 days ago"},
+		{86400 * 20, "3 weeks ago"},
+		{86400 * 100, "3 months ago"},
+		{86400 * 365, "1 year ago"},
+		{86400 * 500, "1 year, 4 months ago"},
+		{86400 * 4000, "11 years ago"},
+	}
+
+	for _, tt := range tests {
+		have := relativeDate(now.Add(-time.Duration(tt.ago)*time.Second), now)
+		if have != tt.expected {
+			t.Fatalf("relativeDate(-%ds) => %q, expected %q", tt.ago, have, tt.expected)
+		}
+	}
+}
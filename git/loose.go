@@ -0,0 +1,132 @@
+package git
+
+import (
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+//WriteObject stores an object of type otype, whose content are the
+//size bytes read from r, as loose object and returns its id. The
+//data is written to a temporary file that is renamed once the id
+//is known, so readers never see partially written objects. Objects
+//that already exist in the repository are not written again.
+func (repo *Repository) WriteObject(otype ObjectType, size int64, r io.Reader) (ObjectID, error) {
+	var id ObjectID
+
+	if !IsStandardObject(otype) {
+		return id, fmt.Errorf("git: can not write objects of type %s", otype)
+	} else if size < 0 {
+		return id, fmt.Errorf("git: invalid object size: %d", size)
+	}
+
+	if repo.mem != nil {
+		return repo.mem.write(repo.ObjectFormat(), otype, size, r)
+	}
+
+	return repo.writeLoose(otype, size, r, false)
+}
+
+//writeLoose writes the object as loose object. Unless force is true,
+//objects that already exist, loose or packed, are not written again.
+func (repo *Repository) writeLoose(otype ObjectType, size int64, r io.Reader, force bool) (ObjectID, error) {
+	var id ObjectID
+
+	objdir := filepath.Join(repo.Path, "objects")
+	tmp, err := ioutil.TempFile(objdir, "tmp_obj_")
+	if err != nil {
+		return id, err
+	}
+
+	tmpname := tmp.Name()
+	defer os.Remove(tmpname) //no-op after the rename
+
+	err = writeLooseObject(tmp, repo.ObjectFormat(), otype, size, r, &id)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		return id, err
+	}
+
+	if !force && repo.hasObject(id) {
+		return id, nil
+	}
+
+	idstr := id.String()
+	dir := filepath.Join(objdir, idstr[:2])
+	if err = os.MkdirAll(dir, 0777); err != nil {
+		return id, err
+	}
+
+	//loose objects are immutable
+	if err = os.Chmod(tmpname, 0444); err != nil {
+		return id, err
+	}
+
+	err = os.Rename(tmpname, filepath.Join(dir, idstr[2:]))
+	return id, err
+}
+
+//writeLooseObject writes the compressed header and content to fd
+//and stores the hash of the uncompressed data, computed with the
+//hash function of format, in id.
+func writeLooseObject(fd *os.File, format ObjectFormat, otype ObjectType, size int64, r io.Reader, id *ObjectID) error {
+	h := format.New()
+	zw := zlib.NewWriter(fd)
+	w := io.MultiWriter(h, zw)
+
+	_, err := fmt.Fprintf(w, "%s %d\x00", otype, size)
+	if err != nil {
+		return err
+	}
+
+	n, err := io.CopyN(w, r, size)
+	if err == io.EOF {
+		return fmt.Errorf("git: object data too short (%d of %d bytes)", n, size)
+	} else if err != nil {
+		return err
+	}
+
+	var extra [1]byte
+	if k, _ := r.Read(extra[:]); k > 0 {
+		return fmt.Errorf("git: object data exceeds size of %d bytes", size)
+	}
+
+	if err = zw.Close(); err != nil {
+		return err
+	}
+
+	if err = fd.Sync(); err != nil {
+		return err
+	}
+
+	*id = format.sum(h)
+	return nil
+}
+
+//hasObject checks if the object exists, either as loose
+//object or in one of the packs.
+func (repo *Repository) hasObject(id ObjectID) bool {
+	if repo.mem != nil {
+		if _, ok := repo.mem.get(id); ok {
+			return true
+		}
+	}
+
+	idstr := id.String()
+	_, err := os.Stat(filepath.Join(repo.Path, "objects", idstr[:2], idstr[2:]))
+	if err == nil {
+		return true
+	}
+
+	p, _, ok := repo.packRegistry().find(id)
+	if ok {
+		p.release()
+	}
+	return ok
+}
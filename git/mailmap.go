@@ -0,0 +1,260 @@
+package git
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+//Mailmap maps the names and emails that authors and committers
+//used to their canonical ones, see gitmailmap(5). Emails and names
+//are matched case-insensitively. The nil Mailmap maps nothing.
+type Mailmap struct {
+	entries map[string]*mailmapEntry //by lowercase email
+}
+
+//mailmapEntry holds the mappings of one email: name and email are
+//used for any name, unless there is a mapping for the name in names.
+type mailmapEntry struct {
+	name  string
+	email string
+	names map[string]mailmapIdent //by lowercase name
+}
+
+type mailmapIdent struct {
+	name  string
+	email string
+}
+
+//parseMailmapIdent splits "Name <email>" off the start of line,
+//name and email are "" if there is no email.
+func parseMailmapIdent(line string) (name, email, rest string) {
+	start := strings.IndexByte(line, '<')
+	if start == -1 {
+		return "", "", line
+	}
+
+	end := strings.IndexByte(line[start:], '>')
+	if end == -1 {
+		return "", "", line
+	}
+	end += start
+
+	name = strings.TrimSpace(line[:start])
+	return name, line[start+1 : end], line[end+1:]
+}
+
+//ParseMailmap parses the content of a .mailmap file, which has
+//lines of the forms
+//	Proper Name <commit@email>
+//	<proper@email> <commit@email>
+//	Proper Name <proper@email> <commit@email>
+//	Proper Name <proper@email> Commit Name <commit@email>
+//Other lines are ignored, like git does.
+func ParseMailmap(data []byte) *Mailmap {
+	m := &Mailmap{entries: make(map[string]*mailmapEntry)}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+
+		name1, email1, rest := parseMailmapIdent(line)
+		if email1 == "" {
+			continue
+		}
+		name2, email2, _ := parseMailmapIdent(rest)
+
+		//only the form with a single email maps it by itself
+		if email2 == "" {
+			m.add("", email1, name1, "")
+		} else {
+			m.add(name2, email2, name1, email1)
+		}
+	}
+
+	return m
+}
+
+//add maps the identity with oldEmail (and oldName, if not empty)
+//to name and email, either of which can be empty to keep the
+//original.
+func (m *Mailmap) add(oldName, oldEmail, name, email string) {
+	key := strings.ToLower(oldEmail)
+	entry, ok := m.entries[key]
+	if !ok {
+		entry = &mailmapEntry{}
+		m.entries[key] = entry
+	}
+
+	if oldName == "" {
+		if name != "" {
+			entry.name = name
+		}
+		if email != "" {
+			entry.email = email
+		}
+		return
+	}
+
+	if entry.names == nil {
+		entry.names = make(map[string]mailmapIdent)
+	}
+	entry.names[strings.ToLower(oldName)] = mailmapIdent{name, email}
+}
+
+//Len returns the number of emails the mailmap has mappings for.
+func (m *Mailmap) Len() int {
+	if m == nil {
+		return 0
+	}
+	return len(m.entries)
+}
+
+//Lookup returns the canonical name and email for name and email.
+func (m *Mailmap) Lookup(name, email string) (string, string) {
+	if m == nil {
+		return name, email
+	}
+
+	entry, ok := m.entries[strings.ToLower(email)]
+	if !ok {
+		return name, email
+	}
+
+	mapped := mailmapIdent{entry.name, entry.email}
+	if ident, ok := entry.names[strings.ToLower(name)]; ok {
+		mapped = ident
+	}
+
+	if mapped.name != "" {
+		name = mapped.name
+	}
+	if mapped.email != "" {
+		email = mapped.email
+	}
+
+	return name, email
+}
+
+//Map returns the signature with the canonical name and email.
+func (m *Mailmap) Map(sig Signature) Signature {
+	sig.Name, sig.Email = m.Lookup(sig.Name, sig.Email)
+	return sig
+}
+
+//ReadMailmap reads the .mailmap file in the root of the tree of id,
+//which can be a commit, a tag or a tree. If there is no such file,
+//the mailmap is empty.
+func (repo *Repository) ReadMailmap(id ObjectID) (*Mailmap, error) {
+	tree, err := repo.peelRevision(id, "tree")
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := repo.openTree(tree)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := readTreeEntries(t)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range entries {
+		if e.Name != ".mailmap" || fileKind(e.Mode) != 0100000 {
+			continue
+		}
+
+		data, big, err := repo.changeData(uint32(e.Mode), e.ID, 16*1024*1024)
+		if err != nil {
+			return nil, err
+		} else if big {
+			break
+		}
+		return ParseMailmap(data), nil
+	}
+
+	return ParseMailmap(nil), nil
+}
+
+//Mailmap reads the .mailmap file of HEAD, see ReadMailmap. It is
+//empty for repositories without commits.
+func (repo *Repository) Mailmap() (*Mailmap, error) {
+	ref, err := repo.OpenRef("HEAD")
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := ref.Resolve()
+	if err != nil {
+		//unborn branch
+		return ParseMailmap(nil), nil
+	}
+
+	return repo.ReadMailmap(id)
+}
+
+//Contributor is an author of commits and the number of commits
+//they made.
+type Contributor struct {
+	Name    string
+	Email   string
+	Commits int
+
+	First time.Time //author date of the first commit
+	Last  time.Time //author date of the last commit
+}
+
+//Contributors returns the authors of the commits of rev selected by
+//opts, with most commits first, like git shortlog -sne. Authors are
+//mapped with opts.Mailmap, so that identities that map to the same
+//name and email are counted together.
+func (repo *Repository) Contributors(rev Revision, opts LogOptions) ([]Contributor, error) {
+	nodes, err := repo.Log(rev, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	byIdent := make(map[string]int)
+	var res contributors
+	for _, node := range nodes {
+		author, err := node.Author()
+		if err != nil {
+			return nil, err
+		}
+
+		key := author.Name + "\x00" + strings.ToLower(author.Email)
+		i, ok := byIdent[key]
+		if !ok {
+			i = len(res)
+			byIdent[key] = i
+			res = append(res, Contributor{Name: author.Name, Email: author.Email, First: author.Date, Last: author.Date})
+		}
+
+		c := &res[i]
+		c.Commits++
+		if author.Date.Before(c.First) {
+			c.First = author.Date
+		}
+		if author.Date.After(c.Last) {
+			c.Last = author.Date
+		}
+	}
+
+	sort.Stable(res)
+	return res, nil
+}
+
+//contributors sorts by number of commits, descending, then by name.
+type contributors []Contributor
+
+func (c contributors) Len() int      { return len(c) }
+func (c contributors) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c contributors) Less(i, j int) bool {
+	if c[i].Commits != c[j].Commits {
+		return c[i].Commits > c[j].Commits
+	}
+	return c[i].Name < c[j].Name
+}
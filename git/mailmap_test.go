@@ -0,0 +1,136 @@
+package git
+
+import (
+	"regexp"
+	"testing"
+)
+
+const testMailmap = `# the four forms
+Jane Doe <jane@example.com>
+<jane@example.com> <jane@laptop.local>
+Jane Doe <jane@example.com> <JANE@lab.example.com>
+Joe Developer <joe@example.com> joe <root@localhost>
+Other Admin <admin@example.com> admin <root@localhost>
+not a mapping
+`
+
+var mailmapTests = []struct {
+	name  string
+	email string
+}{
+	{"jane", "jane@example.com"},
+	{"Jane", "jane@laptop.local"},
+	{"J. Doe", "jane@lab.example.com"},
+	{"JOE", "root@localhost"},
+	{"admin", "root@localhost"},
+	{"root", "root@localhost"},
+	{"Someone", "someone@example.com"},
+}
+
+func TestMailmap(t *testing.T) {
+	tr := mkTestRepo(t)
+	defer tr.cleanup()
+
+	tr.write(".mailmap", testMailmap)
+	tr.commit("mailmap")
+
+	m, err := tr.Mailmap()
+	if err != nil {
+		t.Fatalf("Mailmap: %v", err)
+	}
+
+	if m.Len() != 4 {
+		t.Errorf("expected mappings for 4 emails, got %d", m.Len())
+	}
+
+	for _, tt := range mailmapTests {
+		name, email := m.Lookup(tt.name, tt.email)
+		got := name + " <" + email + ">"
+
+		expected := tr.git("check-mailmap", tt.name+" <"+tt.email+">")
+		if got != expected {
+			t.Errorf("Lookup(%q, %q): got %q, expected %q", tt.name, tt.email, got, expected)
+		}
+	}
+
+	var nilmap *Mailmap
+	if sig := nilmap.Map(Signature{Name: "a", Email: "b"}); sig.Name != "a" || sig.Email != "b" {
+		t.Errorf("nil mailmap changed signature: %v", sig)
+	}
+}
+
+func TestMailmapLog(t *testing.T) {
+	tr := mkTestRepo(t)
+	defer tr.cleanup()
+
+	tr.write(".mailmap", testMailmap)
+	tr.commit("by A U Thor")
+
+	//mappings are not chained, the first name must be mapped already
+	for _, author := range []string{"Jane Doe <jane@laptop.local>", "jane <jane@example.com>", "jane <root@localhost>"} {
+		tr.write("file", author)
+		tr.git("add", "file")
+		tr.git("commit", "-q", "--author="+author, "-m", author)
+	}
+
+	m, err := tr.Mailmap()
+	if err != nil {
+		t.Fatalf("Mailmap: %v", err)
+	}
+
+	summaries, err := tr.CommitsForRef("master", m)
+	if err != nil {
+		t.Fatalf("CommitsForRef: %v", err)
+	}
+
+	authors := []string{"jane", "Jane Doe", "Jane Doe", "A U Thor"}
+	for i, s := range summaries {
+		if s.Author != authors[i] {
+			t.Errorf("commit %d: got author %q, expected %q", i, s.Author, authors[i])
+		}
+	}
+
+	rev, err := tr.ParseRevision("master")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := LogOptions{Author: regexp.MustCompile("^Jane Doe <jane@example.com>$"), Mailmap: m}
+	nodes, err := tr.Log(rev, opts)
+	if err != nil {
+		t.Fatalf("Log: %v", err)
+	} else if len(nodes) != 2 {
+		t.Errorf("Log with mailmap: expected 2 commits of Jane Doe, got %d", len(nodes))
+	}
+
+	opts.Mailmap = nil
+	if nodes, err = tr.Log(rev, opts); err != nil {
+		t.Fatalf("Log: %v", err)
+	} else if len(nodes) != 0 {
+		t.Errorf("Log without mailmap: expected no commits of Jane Doe, got %d", len(nodes))
+	}
+
+	contributors, err := tr.Contributors(rev, LogOptions{Mailmap: m})
+	if err != nil {
+		t.Fatalf("Contributors: %v", err)
+	}
+
+	expected := []Contributor{
+		{Name: "Jane Doe", Email: "jane@example.com", Commits: 2},
+		{Name: "A U Thor", Email: "author@example.com", Commits: 1},
+		{Name: "jane", Email: "root@localhost", Commits: 1},
+	}
+
+	if len(contributors) != len(expected) {
+		t.Fatalf("Contributors: got %+v, expected %+v", contributors, expected)
+	}
+
+	for i, c := range contributors {
+		e := expected[i]
+		if c.Name != e.Name || c.Email != e.Email || c.Commits != e.Commits {
+			t.Errorf("contributor %d: got %+v, expected %+v", i, c, e)
+		} else if c.First.After(c.Last) {
+			t.Errorf("contributor %d: first commit after last: %+v", i, c)
+		}
+	}
+}
@@ -0,0 +1,571 @@
+package git
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+//MaintenanceOptions controls Maintain.
+type MaintenanceOptions struct {
+	//GracePeriod is the minimum age of unreachable objects that
+	//are removed. Younger ones, and everything reachable from
+	//them, are kept since they might still be needed, e.g. by
+	//a ref update that was not finished yet. They are kept as
+	//loose objects, so that they still age, like git gc
+	//--unpack-unreachable does.
+	GracePeriod time.Duration
+
+	//DryRun reports what would be done, without changing
+	//anything.
+	DryRun bool
+
+	//Wait waits for the repository lock, e.g. until pushes are
+	//done, instead of failing with ErrRepositoryLocked.
+	Wait bool
+
+	//Pack controls the delta compression of the new pack.
+	Pack PackConfig
+}
+
+//DefaultMaintenanceOptions uses the grace period of git gc.
+var DefaultMaintenanceOptions = MaintenanceOptions{
+	GracePeriod: 14 * 24 * time.Hour,
+	Pack:        DefaultPackConfig,
+}
+
+//MaintenanceReport is the result of Maintain. For dry runs it
+//reports what would have been done.
+type MaintenanceReport struct {
+	Objects   int //distinct objects in the repository
+	Reachable int //objects reachable from refs, HEAD and reflogs
+	Kept      int //unreachable objects that were kept loose
+
+	//Pruned are the unreachable objects that were removed.
+	Pruned []ObjectInfo
+
+	//Pack is the checksum of the new pack, the zero id if no
+	//pack was written.
+	Pack ObjectID
+
+	RemovedPacks []string //names of packs that were replaced
+	RemovedLoose int      //number of loose objects removed
+	PackedRefs   int      //number of loose refs moved to packed-refs
+}
+
+//maintenance holds the state of a Maintain run.
+type maintenance struct {
+	repo   *Repository
+	opts   MaintenanceOptions
+	report *MaintenanceReport
+
+	objects map[ObjectID]ObjectInfo //the most recent copy of each object
+	loose   map[ObjectID]bool
+	kept    map[ObjectID]bool //objects in .keep packs
+	packs   map[string]bool   //packs to be replaced
+
+	reachable map[ObjectID]bool
+	recent    map[ObjectID]bool //unreachable, but within the grace period
+}
+
+//Maintain consolidates the objects of the repository into a single
+//new pack and removes unreachable objects older than the grace period.
+//Objects are reachable from refs, HEAD and the reflogs. All reachable
+//loose and packed objects are written to the new pack, which then
+//replaces all loose objects and all other packs, except the ones with
+//a .keep file. Unreachable objects within the grace period stay, or
+//become, loose objects with the modification time they had. Loose
+//refs are moved to packed-refs. The repository is locked exclusively
+//while doing so, so Maintain cannot run concurrently with pushes (dry
+//runs only take a shared lock).
+func (repo *Repository) Maintain(opts MaintenanceOptions) (*MaintenanceReport, error) {
+	var lock *RepositoryLock
+	var err error
+	if opts.Wait {
+		lock, err = repo.Lock(!opts.DryRun)
+	} else {
+		lock, err = repo.TryLock(!opts.DryRun)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+
+	m := &maintenance{
+		repo:    repo,
+		opts:    opts,
+		report:  &MaintenanceReport{},
+		objects: make(map[ObjectID]ObjectInfo),
+		loose:   make(map[ObjectID]bool),
+		kept:    make(map[ObjectID]bool),
+		packs:   make(map[string]bool),
+
+		reachable: make(map[ObjectID]bool),
+		recent:    make(map[ObjectID]bool),
+	}
+
+	if err = m.readObjects(); err != nil {
+		return nil, err
+	}
+
+	if err = m.markReachable(); err != nil {
+		return nil, err
+	}
+
+	var ids []ObjectID
+	var unpack []ObjectInfo
+	for id, info := range m.objects {
+		if m.kept[id] {
+			//stays in its pack
+			continue
+		} else if m.reachable[id] {
+			ids = append(ids, id)
+		} else if m.recent[id] {
+			//stays loose, a copy from a pack might be younger
+			if info.Pack != "" {
+				unpack = append(unpack, info)
+			}
+			delete(m.loose, id)
+		} else {
+			m.report.Pruned = append(m.report.Pruned, info)
+		}
+	}
+	sort.Sort(sha1s(ids))
+	sort.Sort(objectInfosByID(m.report.Pruned))
+
+	if err = m.packRefs(); err != nil {
+		return nil, err
+	}
+
+	//nothing to consolidate or to remove
+	if len(m.loose) == 0 && len(m.report.Pruned) == 0 && len(m.packs) <= 1 {
+		return m.report, nil
+	}
+
+	for name := range m.packs {
+		m.report.RemovedPacks = append(m.report.RemovedPacks, name)
+	}
+	sort.Strings(m.report.RemovedPacks)
+	m.report.RemovedLoose = len(m.loose)
+
+	if opts.DryRun {
+		return m.report, nil
+	}
+
+	if len(ids) > 0 {
+		m.report.Pack, err = repo.CreatePack(ids, opts.Pack)
+		if err != nil {
+			return nil, err
+		}
+
+		//the same objects give the same pack
+		name := fmt.Sprintf("pack-%s.pack", m.report.Pack)
+		if m.packs[name] {
+			delete(m.packs, name)
+			m.report.RemovedPacks = removeString(m.report.RemovedPacks, name)
+		}
+	}
+
+	if err = m.unpackObjects(unpack); err != nil {
+		return nil, err
+	}
+
+	if err = m.removeObjects(); err != nil {
+		return nil, err
+	}
+
+	err = repo.WriteCommitGraph()
+	if err != nil {
+		return nil, err
+	}
+
+	return m.report, nil
+}
+
+type objectInfosByID []ObjectInfo
+
+func (o objectInfosByID) Len() int           { return len(o) }
+func (o objectInfosByID) Swap(i, j int)      { o[i], o[j] = o[j], o[i] }
+func (o objectInfosByID) Less(i, j int) bool { return o[i].ID.Compare(o[j].ID) < 0 }
+
+func removeString(list []string, s string) []string {
+	for i := range list {
+		if list[i] == s {
+			return append(list[:i], list[i+1:]...)
+		}
+	}
+	return list
+}
+
+//readObjects enumerates all objects and the packs they are in.
+func (m *maintenance) readObjects() error {
+	keep := make(map[string]bool)
+	files, err := filepath.Glob(filepath.Join(m.repo.Path, "objects", "pack", "*.keep"))
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		keep[strings.TrimSuffix(filepath.Base(f), ".keep")+".pack"] = true
+	}
+
+	err = m.repo.ForEachObject(func(info ObjectInfo) bool {
+		if info.Pack == "" {
+			m.loose[info.ID] = true
+		} else if keep[info.Pack] {
+			m.kept[info.ID] = true
+		} else {
+			m.packs[info.Pack] = true
+		}
+
+		if known, ok := m.objects[info.ID]; !ok || info.ModTime.After(known.ModTime) {
+			m.objects[info.ID] = info
+		}
+		return false
+	})
+
+	m.report.Objects = len(m.objects)
+	return err
+}
+
+//markReachable marks the objects reachable from the refs, HEAD and
+//the reflogs, and then the unreachable objects within the grace
+//period and the objects reachable from them.
+func (m *maintenance) markReachable() error {
+	refs, err := m.repo.ListRefs("refs/")
+	if err != nil {
+		return err
+	}
+
+	if head, err := m.repo.parseRef("HEAD"); err == nil {
+		refs = append(refs, head)
+	}
+
+	var tips []ObjectID
+	for _, ref := range refs {
+		id, err := ref.Resolve()
+		if _, ok := ref.(*SymbolicRef); ok && err != nil {
+			//e.g. HEAD of an empty repository
+			continue
+		} else if err != nil {
+			return err
+		}
+		tips = append(tips, id)
+	}
+
+	if err = m.mark(m.reachable, tips, true); err != nil {
+		return err
+	}
+
+	//old reflog entries can point to objects that are gone
+	logged, err := m.reflogIDs()
+	if err != nil {
+		return err
+	}
+
+	if err = m.mark(m.reachable, logged, false); err != nil {
+		return err
+	}
+	m.report.Reachable = len(m.reachable)
+
+	var recent []ObjectID
+	cutoff := time.Now().Add(-m.opts.GracePeriod)
+	for id, info := range m.objects {
+		if !m.reachable[id] && info.ModTime.After(cutoff) {
+			recent = append(recent, id)
+		}
+	}
+
+	//parts of recent objects might be missing
+	if err = m.mark(m.recent, recent, false); err != nil {
+		return err
+	}
+	m.report.Kept = len(m.recent)
+
+	return nil
+}
+
+//reflogIDs returns the old and new ids of all reflog entries.
+func (m *maintenance) reflogIDs() ([]ObjectID, error) {
+	var ids []ObjectID
+	root := filepath.Join(m.repo.Path, "logs")
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if path == root && os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		} else if info.IsDir() {
+			return nil
+		}
+
+		fd, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer fd.Close()
+
+		scanner := bufio.NewScanner(fd)
+		for scanner.Scan() {
+			//<old> <new> <signature>\t<message>
+			fields := strings.SplitN(scanner.Text(), " ", 3)
+			if len(fields) < 3 {
+				continue
+			}
+
+			for _, f := range fields[:2] {
+				id, err := ParseObjectID(f)
+				if err == nil && !id.IsZero() {
+					ids = append(ids, id)
+				}
+			}
+		}
+
+		return scanner.Err()
+	})
+
+	return ids, err
+}
+
+//mark adds the objects and everything reachable from them, that is
+//not reachable from the refs already, to marks. If strict is true,
+//a missing object is an error, since removing anything could then
+//lose data.
+func (m *maintenance) mark(marks map[ObjectID]bool, ids []ObjectID, strict bool) error {
+	stack := ids
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if marks[id] || m.reachable[id] {
+			continue
+		}
+
+		info, ok := m.objects[id]
+		if !ok {
+			if strict {
+				return fmt.Errorf("git: reachable object %s is missing", id)
+			}
+			continue
+		}
+		marks[id] = true
+
+		if info.Type == ObjBlob {
+			continue
+		}
+
+		obj, err := m.repo.OpenObject(id)
+		if err != nil {
+			return err
+		}
+
+		switch obj := obj.(type) {
+		case *Commit:
+			stack = append(stack, obj.Tree)
+			stack = append(stack, obj.Parent...)
+		case *Tag:
+			stack = append(stack, obj.Object)
+		case *Tree:
+			for obj.Next() {
+				entry := obj.Entry()
+				//submodule commits live in other repositories
+				if entry.Mode != 0160000 {
+					stack = append(stack, entry.ID)
+				}
+			}
+			err = obj.Err()
+		}
+
+		obj.Close()
+		if err != nil {
+			return fmt.Errorf("git: %s: %v", id, err)
+		}
+	}
+
+	return nil
+}
+
+//packRefs writes all refs except symbolic ones to packed-refs,
+//with the peeled values of tags, and removes the loose refs.
+func (m *maintenance) packRefs() error {
+	refs, err := m.repo.ListRefs("refs/")
+	if err != nil {
+		return err
+	}
+
+	var ids []*IDRef
+	var loose []string
+	for _, ref := range refs {
+		idref, ok := ref.(*IDRef)
+		if !ok {
+			continue
+		}
+		ids = append(ids, idref)
+
+		name := RefPath(ref)
+		path := filepath.Join(m.repo.Path, filepath.FromSlash(name))
+		if _, err := os.Stat(path); err == nil {
+			loose = append(loose, name)
+		}
+	}
+
+	m.report.PackedRefs = len(loose)
+	if len(loose) == 0 || m.opts.DryRun {
+		return nil
+	}
+
+	path := filepath.Join(m.repo.Path, "packed-refs")
+	lock, err := lockFile(path+".lock", packedRefsLockTimeout)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(lock.Name())
+	defer lock.Close()
+
+	w := bufio.NewWriter(lock)
+	fmt.Fprintf(w, "# pack-refs with: peeled fully-peeled sorted \n")
+
+	for _, ref := range ids {
+		id, _ := ref.Resolve()
+		fmt.Fprintf(w, "%s %s\n", id, RefPath(ref))
+
+		peeled, err := m.repo.PeelRef(ref)
+		if err != nil {
+			return err
+		} else if peeled != id {
+			fmt.Fprintf(w, "^%s\n", peeled)
+		}
+	}
+
+	if err = w.Flush(); err != nil {
+		return err
+	}
+
+	if err = lock.Sync(); err != nil {
+		return err
+	}
+
+	if err = lock.Close(); err != nil {
+		return err
+	}
+
+	if err = os.Rename(lock.Name(), path); err != nil {
+		return err
+	}
+
+	for _, name := range loose {
+		if err = m.removeLooseRef(name, refs); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//removeLooseRef removes the loose ref name, if it still has the
+//value that was packed.
+func (m *maintenance) removeLooseRef(name string, refs []Ref) error {
+	path := filepath.Join(m.repo.Path, filepath.FromSlash(name))
+	lock, err := lockRef(path)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(lock.Name())
+	defer lock.Close()
+
+	ref, err := m.repo.readLooseRef(name)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, packed := range refs {
+		if RefPath(packed) != name {
+			continue
+		}
+
+		a, aerr := ref.Resolve()
+		b, berr := packed.Resolve()
+		if aerr == nil && berr == nil && a == b {
+			err = os.Remove(path)
+		}
+		break
+	}
+
+	if err != nil {
+		return err
+	}
+
+	//keep the namespace directories, e.g. "refs/heads"
+	os.Remove(lock.Name())
+	ns := strings.Join(strings.SplitN(name, "/", 3)[:2], "/")
+	pruneEmptyDirs(filepath.Dir(path), filepath.Join(m.repo.Path, filepath.FromSlash(ns)))
+
+	return nil
+}
+
+//unpackObjects writes packed objects as loose objects with the
+//modification time in their info, i.e. the one of their pack.
+func (m *maintenance) unpackObjects(objects []ObjectInfo) error {
+	root := filepath.Join(m.repo.Path, "objects")
+	for _, info := range objects {
+		idstr := info.ID.String()
+		path := filepath.Join(root, idstr[:2], idstr[2:])
+
+		obj, err := m.repo.openObject(info.ID)
+		if err != nil {
+			return err
+		}
+
+		_, err = m.repo.writeLoose(obj.otype, obj.size, obj.source, true)
+		obj.Close()
+		if err != nil {
+			return err
+		}
+
+		if err = os.Chtimes(path, info.ModTime, info.ModTime); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//removeObjects removes the replaced packs and the loose objects
+//that are either in the new pack or unreachable.
+func (m *maintenance) removeObjects() error {
+	dir := filepath.Join(m.repo.Path, "objects", "pack")
+
+	for name := range m.packs {
+		base := filepath.Join(dir, strings.TrimSuffix(name, ".pack"))
+
+		//without the index, the pack is not used anymore
+		for _, ext := range []string{".idx", ".pack", ".rev", ".bitmap", ".mtimes", ".promisor"} {
+			err := os.Remove(base + ext)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
+	root := filepath.Join(m.repo.Path, "objects")
+	for id := range m.loose {
+		idstr := id.String()
+		path := filepath.Join(root, idstr[:2], idstr[2:])
+
+		err := os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		pruneEmptyDirs(filepath.Dir(path), root)
+	}
+
+	return nil
+}
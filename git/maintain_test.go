@@ -0,0 +1,211 @@
+package git
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+//mkLooseObject writes a loose blob with the content and sets its
+//modification time to age ago.
+func mkLooseObject(tr *testRepo, content string, age time.Duration) ObjectID {
+	id, err := tr.WriteObject(ObjBlob, int64(len(content)), strings.NewReader(content))
+	if err != nil {
+		tr.t.Fatalf("could not write object: %v", err)
+	}
+
+	mtime := time.Now().Add(-age)
+	path := filepath.Join(tr.Path, "objects", id.String()[:2], id.String()[2:])
+	if err = os.Chtimes(path, mtime, mtime); err != nil {
+		tr.t.Fatal(err)
+	}
+
+	return id
+}
+
+//countObjects returns the number of loose objects and packs.
+func countObjects(tr *testRepo) (int, int) {
+	loose, packs := 0, 0
+	err := tr.ForEachObject(func(info ObjectInfo) bool {
+		if info.Pack == "" {
+			loose++
+		}
+		return false
+	})
+	if err != nil {
+		tr.t.Fatalf("ForEachObject() => %v", err)
+	}
+
+	indices, _ := filepath.Glob(filepath.Join(tr.Path, "objects", "pack", "*.idx"))
+	packs = len(indices)
+	return loose, packs
+}
+
+func TestMaintain(t *testing.T) {
+	tr := mkTestRepo(t)
+	defer tr.cleanup()
+
+	tr.write("a.txt", "a\n")
+	tr.commit("first")
+	tr.git("tag", "-a", "-m", "a tag", "v1")
+	tr.git("repack", "-q", "-d")
+
+	tr.write("b.txt", "b\n")
+	tr.commit("second")
+	tr.git("branch", "topic")
+
+	month := 30 * 24 * time.Hour
+	old := mkLooseObject(tr, "old and unreachable\n", month)
+	recent := mkLooseObject(tr, "recent and unreachable\n", 0)
+
+	//a commit of another branch that is only in the reflog
+	tr.git("checkout", "-q", "-b", "gone")
+	tr.write("c.txt", "c\n")
+	logged := tr.commit("only in the reflog")
+	tr.git("checkout", "-q", "master")
+	tr.git("update-ref", "-d", "refs/heads/gone")
+
+	opts := DefaultMaintenanceOptions
+	opts.DryRun = true
+
+	loose, packs := countObjects(tr)
+	report, err := tr.Maintain(opts)
+	if err != nil {
+		t.Fatalf("Maintain(dry run) => %v", err)
+	}
+
+	if len(report.Pruned) != 1 || report.Pruned[0].ID != old {
+		t.Fatalf("dry run: expected %s to be pruned, got %+v", old, report.Pruned)
+	} else if report.Kept != 1 || !report.Pack.IsZero() {
+		t.Fatalf("dry run: unexpected report %+v", report)
+	} else if report.RemovedLoose != loose-1 || len(report.RemovedPacks) != packs || report.PackedRefs == 0 {
+		t.Fatalf("dry run: expected %d loose objects and %d packs, got %+v", loose-1, packs, report)
+	}
+
+	if l, p := countObjects(tr); l != loose || p != packs {
+		t.Fatalf("dry run: objects changed, %d loose and %d packs", l, p)
+	}
+
+	//pushes hold the lock shared
+	lock, err := tr.Lock(false)
+	if err != nil {
+		t.Fatalf("Lock() => %v", err)
+	}
+
+	if _, err = tr.Maintain(DefaultMaintenanceOptions); err != ErrRepositoryLocked {
+		t.Fatalf("Maintain() while locked => %v, expected ErrRepositoryLocked", err)
+	}
+	lock.Unlock()
+
+	refs := tr.git("show-ref", "-d")
+	report, err = tr.Maintain(DefaultMaintenanceOptions)
+	if err != nil {
+		t.Fatalf("Maintain() => %v", err)
+	} else if report.Pack.IsZero() || len(report.Pruned) != 1 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	//the recent object stays loose
+	if l, p := countObjects(tr); l != 1 || p != 1 {
+		t.Fatalf("expected a loose object and a single pack, got %d loose objects and %d packs", l, p)
+	}
+
+	for _, id := range []ObjectID{recent, logged, tr.revParse("v1^{commit}")} {
+		if !tr.hasObject(id) {
+			t.Errorf("object %s is gone", id)
+		}
+	}
+
+	if tr.hasObject(old) {
+		t.Errorf("object %s was not pruned", old)
+	}
+
+	if after := tr.git("show-ref", "-d"); after != refs {
+		t.Errorf("refs changed:\n%s\nexpected\n%s", after, refs)
+	}
+
+	if _, err = os.Stat(filepath.Join(tr.Path, "refs", "heads", "topic")); !os.IsNotExist(err) {
+		t.Errorf("expected loose refs to be packed")
+	}
+	tr.git("fsck", "--no-dangling")
+
+	fsck, err := tr.Fsck()
+	if err != nil || !fsck.OK() {
+		t.Fatalf("Fsck() => %+v, %v", fsck, err)
+	}
+
+	//nothing left to do
+	again, err := tr.Maintain(DefaultMaintenanceOptions)
+	if err != nil {
+		t.Fatalf("Maintain() => %v", err)
+	}
+
+	expected := &MaintenanceReport{Objects: report.Objects - 1, Reachable: report.Reachable, Kept: 1}
+	if !reflect.DeepEqual(again, expected) {
+		t.Errorf("second run: got %+v, expected %+v", again, expected)
+	}
+}
+
+//ageObjects moves the modification times of all files below objects
+//back by d, as if the clock moved forward.
+func ageObjects(tr *testRepo, d time.Duration) {
+	err := filepath.Walk(filepath.Join(tr.Path, "objects"), func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		mtime := info.ModTime().Add(-d)
+		return os.Chtimes(path, mtime, mtime)
+	})
+
+	if err != nil {
+		tr.t.Fatal(err)
+	}
+}
+
+func TestMaintainGracePeriod(t *testing.T) {
+	tr := mkTestRepo(t)
+	defer tr.cleanup()
+
+	tr.write("a.txt", "a\n")
+	tr.commit("first")
+
+	loose := mkLooseObject(tr, "loose and unreachable\n", 0)
+
+	//an unreachable object that is only in a pack
+	packed := mkLooseObject(tr, "packed and unreachable\n", 0)
+	if _, err := tr.CreatePack([]ObjectID{packed}, DefaultPackConfig); err != nil {
+		t.Fatalf("CreatePack() => %v", err)
+	}
+
+	idstr := packed.String()
+	if err := os.Remove(filepath.Join(tr.Path, "objects", idstr[:2], idstr[2:])); err != nil {
+		t.Fatal(err)
+	}
+	day := 24 * time.Hour
+	ageObjects(tr, 10*day)
+
+	for age := 10 * day; age <= 19*day; age += 3 * day {
+		report, err := tr.Maintain(DefaultMaintenanceOptions)
+		if err != nil {
+			t.Fatalf("Maintain() after %v => %v", age, err)
+		}
+
+		keep := age < DefaultMaintenanceOptions.GracePeriod
+		for _, id := range []ObjectID{loose, packed} {
+			if tr.hasObject(id) != keep {
+				t.Fatalf("after %v: expected object %s to be kept: %v, report %+v", age, id, keep, report)
+			}
+		}
+
+		if l, p := countObjects(tr); keep && (l != 2 || p != 1) {
+			t.Fatalf("after %v: expected 2 loose objects and a pack, got %d and %d", age, l, p)
+		}
+
+		ageObjects(tr, 3*day)
+	}
+
+	tr.git("fsck", "--no-dangling")
+}
@@ -0,0 +1,84 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+//memObjects are the objects of an in-memory view of a repository,
+//see Repository.inMemory.
+type memObjects struct {
+	mu      sync.Mutex
+	objects map[ObjectID]memObject
+}
+
+type memObject struct {
+	otype ObjectType
+	data  []byte
+}
+
+//inMemory returns a view of the repository that reads all objects of
+//repo, but keeps the objects written to it in memory, e.g. for merges
+//whose intermediate results must not end up in the repository. The
+//view shares the open packs of repo and must not be closed.
+func (repo *Repository) inMemory() *Repository {
+	packs, deltas, cfg := repo.packRegistry(), repo.deltaBaseCache(), repo.deltaConfig()
+
+	return &Repository{
+		Path:         repo.Path,
+		packs:        packs,
+		deltas:       deltas,
+		dcfg:         &cfg,
+		format:       repo.ObjectFormat(),
+		formatLoaded: true,
+		mem:          &memObjects{objects: make(map[ObjectID]memObject)},
+	}
+}
+
+//write stores the object in memory and returns its id, which is
+//computed with the hash function of format.
+func (mem *memObjects) write(format ObjectFormat, otype ObjectType, size int64, r io.Reader) (ObjectID, error) {
+	var id ObjectID
+
+	data, err := ioutil.ReadAll(io.LimitReader(r, size+1))
+	if err != nil {
+		return id, err
+	} else if int64(len(data)) < size {
+		return id, fmt.Errorf("git: object data too short (%d of %d bytes)", len(data), size)
+	} else if int64(len(data)) > size {
+		return id, fmt.Errorf("git: object data exceeds size of %d bytes", size)
+	}
+
+	h := format.New()
+	fmt.Fprintf(h, "%s %d\x00", otype, size)
+	h.Write(data)
+	id = format.sum(h)
+
+	mem.mu.Lock()
+	mem.objects[id] = memObject{otype, data}
+	mem.mu.Unlock()
+
+	return id, nil
+}
+
+func (mem *memObjects) get(id ObjectID) (memObject, bool) {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	obj, ok := mem.objects[id]
+	return obj, ok
+}
+
+//open returns the object with the id, false if it is not in memory.
+func (mem *memObjects) open(id ObjectID) (gitObject, bool) {
+	obj, ok := mem.get(id)
+	if !ok {
+		return gitObject{}, false
+	}
+
+	source := ioutil.NopCloser(bytes.NewReader(obj.data))
+	return gitObject{obj.otype, int64(len(obj.data)), source}, true
+}
@@ -0,0 +1,984 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+//ConflictType is the kind of a merge conflict.
+type ConflictType uint8
+
+//ConflictType values. ConflictContent means that both sides changed
+//a file in ways that could not be merged, including changes of its
+//mode or type and binary files. ConflictAnnex is the same for
+//annexed files whose key was changed on both sides. A file renamed
+//on one side and deleted on the other is a ConflictRenameDelete,
+//one renamed to different paths on both sides a ConflictRenameRename.
+//Different files put at the same path by both sides, by adding or
+//renaming them, are a ConflictAddAdd.
+const (
+	ConflictContent ConflictType = iota + 1
+	ConflictAnnex
+	ConflictAddAdd
+	ConflictModifyDelete
+	ConflictRenameDelete
+	ConflictRenameRename
+	ConflictDirectoryFile
+)
+
+func (ct ConflictType) String() string {
+	switch ct {
+	case ConflictContent:
+		return "content"
+	case ConflictAnnex:
+		return "annex"
+	case ConflictAddAdd:
+		return "add/add"
+	case ConflictModifyDelete:
+		return "modify/delete"
+	case ConflictRenameDelete:
+		return "rename/delete"
+	case ConflictRenameRename:
+		return "rename/rename"
+	case ConflictDirectoryFile:
+		return "directory/file"
+	}
+	return fmt.Sprintf("ConflictType(%d)", uint8(ct))
+}
+
+//MergeEntry is the version of a conflicting file on one side of a
+//merge. Annex is the annex key if the file is annexed.
+type MergeEntry struct {
+	Path  string
+	Mode  os.FileMode
+	ID    ObjectID
+	Annex *AnnexKey
+}
+
+//MergeConflict is a path that could not be merged. Base, Ours and
+//Theirs are the versions of the file in the merge base and on both
+//sides, nil where the file does not exist. Their paths differ from
+//Path for renamed files. Path is where the conflicting file is in
+//the merged tree, which contains the version with conflict markers
+//for content conflicts and otherwise the version of one side, like
+//git leaves it in the working tree: files in the way of a directory
+//are moved to their path with "~" and the label of their side
+//appended. For ConflictRenameRename, Path is the path of ours and the
+//file of theirs is at its new path as well.
+type MergeConflict struct {
+	Type   ConflictType
+	Path   string
+	Base   *MergeEntry
+	Ours   *MergeEntry
+	Theirs *MergeEntry
+}
+
+//MergeOptions control how MergeTrees and MergeCommits merge files.
+type MergeOptions struct {
+	//DetectRenames enables the detection of renames on both
+	//sides, with the options in Renames.
+	DetectRenames bool
+	Renames       RenameOptions
+
+	//Algorithm is the diff algorithm used to merge the content
+	//of files.
+	Algorithm DiffAlgorithm
+
+	//MaxSize is the size limit for files to be merged by content,
+	//bigger files are treated like binary files. Zero means no
+	//limit.
+	MaxSize int64
+
+	//OursLabel and TheirsLabel are used in conflict markers.
+	OursLabel   string
+	TheirsLabel string
+}
+
+//DefaultMergeOptions match the defaults of git merge.
+var DefaultMergeOptions = MergeOptions{
+	DetectRenames: true,
+	Renames:       DefaultRenameOptions,
+	Algorithm:     DiffHistogram,
+	MaxSize:       512 * 1024 * 1024,
+	OursLabel:     "ours",
+	TheirsLabel:   "theirs",
+}
+
+//MergeResult is the outcome of a merge. The merged tree is kept in
+//memory until Write is called, so a merge can be used as preview of
+//its conflicts without adding any objects to the repository.
+type MergeResult struct {
+	//Bases are the merge bases of the commits for MergeCommits.
+	Bases     []ObjectID
+	Conflicts []MergeConflict
+
+	repo    *Repository
+	builder *TreeBuilder
+	blobs   map[ObjectID][]byte //new file contents, not yet written
+}
+
+//Clean returns true if the merge has no conflicts.
+func (res *MergeResult) Clean() bool {
+	return len(res.Conflicts) == 0
+}
+
+//Write stores the merged tree and the new files in it in the
+//repository and returns the id of the tree. The tree can be written
+//for conflicting merges as well, with the conflicts as described
+//for MergeConflict.
+func (res *MergeResult) Write() (ObjectID, error) {
+	ids := make(sha1s, 0, len(res.blobs))
+	for id := range res.blobs {
+		ids = append(ids, id)
+	}
+	sort.Sort(ids)
+
+	for _, id := range ids {
+		data := res.blobs[id]
+		if _, err := res.repo.WriteObject(ObjBlob, int64(len(data)), bytes.NewReader(data)); err != nil {
+			return ObjectID{}, err
+		}
+		delete(res.blobs, id)
+	}
+
+	return res.builder.Write()
+}
+
+type sha1s []ObjectID
+
+func (s sha1s) Len() int           { return len(s) }
+func (s sha1s) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s sha1s) Less(i, j int) bool { return s[i].Compare(s[j]) < 0 }
+
+//MergeCommits merges the commit theirs into ours. The trees are
+//merged against the merge base of the commits; if there are several
+//bases (after criss-cross merges), they are merged first and the
+//result is used as base, like git does. Commits without a common
+//ancestor can not be merged.
+func (repo *Repository) MergeCommits(ours, theirs ObjectID, opts MergeOptions) (*MergeResult, error) {
+	cg := NewCommitGraph(repo)
+	bases, err := cg.MergeBase(ours, theirs)
+	if err != nil {
+		return nil, err
+	} else if len(bases) == 0 {
+		return nil, fmt.Errorf("git: refusing to merge unrelated histories of %s and %s", ours, theirs)
+	}
+
+	var ids []ObjectID
+	for _, node := range bases {
+		ids = append(ids, node.ID)
+	}
+
+	//the merges of several bases are only kept in memory
+	src := repo
+	if len(ids) > 1 {
+		src = repo.inMemory()
+	}
+
+	base, err := src.virtualBase(cg, ids, opts, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := repo.mergeTrees(src, base, ours, theirs, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	res.Bases = ids
+	return res, nil
+}
+
+//virtualBase returns the tree of the merge bases, merging them
+//recursively if there are several. Conflicts in these merges are
+//left in the tree. The merged trees are written to repo, which is
+//an in-memory view for MergeCommits.
+func (repo *Repository) virtualBase(cg *CommitGraph, bases []ObjectID, opts MergeOptions, depth int) (ObjectID, error) {
+	tree, err := repo.peelRevision(bases[0], "tree")
+	if err != nil || len(bases) == 1 {
+		return tree, err
+	}
+
+	opts.OursLabel = fmt.Sprintf("Temporary merge branch %d", depth)
+	opts.TheirsLabel = fmt.Sprintf("Temporary merge branch %d", depth+1)
+
+	for _, other := range bases[1:] {
+		nodes, err := cg.MergeBase(bases[0], other)
+		if err != nil {
+			return tree, err
+		}
+
+		var base ObjectID
+		if len(nodes) > 0 {
+			var ids []ObjectID
+			for _, node := range nodes {
+				ids = append(ids, node.ID)
+			}
+
+			if base, err = repo.virtualBase(cg, ids, opts, depth+1); err != nil {
+				return tree, err
+			}
+		}
+
+		res, err := repo.MergeTrees(base, tree, other, opts)
+		if err != nil {
+			return tree, err
+		}
+
+		if tree, err = res.Write(); err != nil {
+			return tree, err
+		}
+	}
+
+	return tree, nil
+}
+
+//MergeTrees merges the changes from base to theirs into ours, all
+//of which are trees or commits and tags that are peeled to trees. A
+//zero base stands for the empty tree.
+func (repo *Repository) MergeTrees(base, ours, theirs ObjectID, opts MergeOptions) (*MergeResult, error) {
+	return repo.mergeTrees(repo, base, ours, theirs, opts)
+}
+
+//mergeTrees is MergeTrees with a base that is read from src, which
+//is either repo or an in-memory view of it that holds the virtual
+//base. The merged tree only refers to objects of ours, theirs and
+//new blobs, so the result can be written to repo.
+func (repo *Repository) mergeTrees(src *Repository, base, ours, theirs ObjectID, opts MergeOptions) (*MergeResult, error) {
+	var err error
+	if ours, err = repo.peelRevision(ours, "tree"); err != nil {
+		return nil, err
+	} else if theirs, err = repo.peelRevision(theirs, "tree"); err != nil {
+		return nil, err
+	} else if !base.IsZero() {
+		if base, err = src.peelRevision(base, "tree"); err != nil {
+			return nil, err
+		}
+	}
+
+	builder, err := repo.EditTree(ours)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &treeMerger{
+		repo:  src,
+		opts:  opts,
+		base:  make(map[string]*MergeEntry),
+		res:   &MergeResult{repo: repo, builder: builder, blobs: make(map[ObjectID][]byte)},
+		owner: make(map[string]string),
+	}
+
+	for i, tree := range []ObjectID{ours, theirs} {
+		if m.sides[i], err = m.loadSide(base, tree); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = m.merge(); err != nil {
+		return nil, err
+	}
+
+	return m.res, nil
+}
+
+//mergeSide are the changes of one side of a merge relative to the
+//base: the new entries of all changed paths (nil for deleted ones)
+//and the renames from the old to the new path.
+type mergeSide struct {
+	entries map[string]*MergeEntry
+	renames map[string]string
+	sources map[string]string //new path to old path
+}
+
+//The sides of a merge.
+const (
+	sideOurs   = 0
+	sideTheirs = 1
+)
+
+//mergeWrite is the entry to put at path in the merged tree, or nil
+//if the path is removed. side is the side the entry comes from.
+type mergeWrite struct {
+	path  string
+	entry *MergeEntry
+	side  int
+}
+
+type treeMerger struct {
+	repo  *Repository
+	opts  MergeOptions
+	base  map[string]*MergeEntry
+	sides [2]*mergeSide
+
+	writes []mergeWrite
+	res    *MergeResult
+	owner  map[string]string //label of the side of files written to the tree
+}
+
+func (m *treeMerger) loadSide(base, tree ObjectID) (*mergeSide, error) {
+	var a, b *Tree
+	var err error
+	if !base.IsZero() {
+		if a, err = m.repo.openTree(base); err != nil {
+			return nil, err
+		}
+	}
+
+	if b, err = m.repo.openTree(tree); err != nil {
+		if a != nil {
+			a.Close()
+		}
+		return nil, err
+	}
+
+	changes, err := m.repo.DiffTrees(a, b)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.opts.DetectRenames {
+		opts := m.opts.Renames
+		opts.Copies = false
+		if changes, err = m.repo.DetectRenames(changes, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	side := &mergeSide{
+		entries: make(map[string]*MergeEntry),
+		renames: make(map[string]string),
+		sources: make(map[string]string),
+	}
+
+	for _, c := range changes {
+		oldPath := c.Path
+		if c.Type == ChangeRename {
+			oldPath = c.OldPath
+			side.renames[c.OldPath] = c.Path
+			side.sources[c.Path] = c.OldPath
+			side.entries[c.OldPath] = nil
+		}
+
+		if !c.OldID.IsZero() {
+			m.base[oldPath] = &MergeEntry{Path: oldPath, Mode: c.OldMode, ID: c.OldID}
+		}
+
+		if !c.NewID.IsZero() {
+			side.entries[c.Path] = &MergeEntry{Path: c.Path, Mode: c.NewMode, ID: c.NewID}
+		} else {
+			side.entries[c.Path] = nil
+		}
+	}
+
+	return side, nil
+}
+
+//entry returns the entry of the path on the side, which is the one
+//of the base if the side did not change the path.
+func (m *treeMerger) entry(side int, p string) *MergeEntry {
+	if e, ok := m.sides[side].entries[p]; ok {
+		return e
+	}
+	return m.base[p]
+}
+
+func (m *treeMerger) label(side int) string {
+	if side == sideOurs {
+		return m.opts.OursLabel
+	}
+	return m.opts.TheirsLabel
+}
+
+func sameEntry(a, b *MergeEntry) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Mode == b.Mode && a.ID == b.ID
+}
+
+//put records that p should be e (or removed, for nil) in the merged
+//tree, which starts out as the tree of ours.
+func (m *treeMerger) put(p string, e *MergeEntry, side int) {
+	if sameEntry(e, m.entry(sideOurs, p)) {
+		return
+	}
+	m.writes = append(m.writes, mergeWrite{p, e, side})
+}
+
+func (m *treeMerger) conflict(ct ConflictType, p string, base, o, t *MergeEntry) {
+	m.res.Conflicts = append(m.res.Conflicts, MergeConflict{Type: ct, Path: p, Base: base, Ours: o, Theirs: t})
+}
+
+func (m *treeMerger) merge() error {
+	var paths []string
+	seen := make(map[string]bool)
+	for _, side := range m.sides {
+		for p := range side.entries {
+			if !seen[p] {
+				seen[p] = true
+				paths = append(paths, p)
+			}
+		}
+	}
+	sort.Strings(paths)
+
+	for _, p := range paths {
+		var err error
+		o, t := m.sides[sideOurs], m.sides[sideTheirs]
+		switch {
+		case o.renames[p] != "" || t.renames[p] != "":
+			//the file is merged at its new path
+			m.put(p, nil, sideOurs)
+		case o.sources[p] != "":
+			err = m.mergeRenamed(p, sideOurs, o.sources[p])
+		case t.sources[p] != "":
+			err = m.mergeRenamed(p, sideTheirs, t.sources[p])
+		default:
+			err = m.mergePath(p, m.base[p], m.entry(sideOurs, p), m.entry(sideTheirs, p))
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := m.apply(); err != nil {
+		return err
+	}
+
+	for i := range m.res.Conflicts {
+		if err := m.examineAnnex(&m.res.Conflicts[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//mergeRenamed merges the file at p, which the side x renamed from
+//src, with the version of src on the other side.
+func (m *treeMerger) mergeRenamed(p string, x int, src string) error {
+	y := 1 - x
+	base, xe := m.base[src], m.sides[x].entries[p]
+
+	ye := m.entry(y, src)
+	if dst := m.sides[y].renames[src]; dst == p {
+		ye = m.sides[y].entries[p]
+	} else if dst != "" {
+		//ours reports the conflict, theirs only adds its file
+		if x == sideOurs {
+			m.conflict(ConflictRenameRename, p, base, xe, m.sides[y].entries[dst])
+		}
+		m.put(p, xe, x)
+		return nil
+	} else if other := m.entry(y, p); other != nil {
+		//the other side put another file at p
+		o, t := xe, other
+		if x == sideTheirs {
+			o, t = other, xe
+		}
+
+		if sameEntry(o, t) {
+			m.put(p, o, sideOurs)
+			return nil
+		}
+
+		m.conflict(ConflictAddAdd, p, nil, o, t)
+		return m.mergeEntries(p, nil, o, t, false)
+	}
+
+	if ye == nil {
+		o, t := xe, ye
+		if x == sideTheirs {
+			o, t = ye, xe
+		}
+		m.conflict(ConflictRenameDelete, p, base, o, t)
+		m.put(p, xe, x)
+		return nil
+	}
+
+	if x == sideOurs {
+		return m.mergePath(p, base, xe, ye)
+	}
+	return m.mergePath(p, base, ye, xe)
+}
+
+//mergePath merges the versions of the file at p.
+func (m *treeMerger) mergePath(p string, base, o, t *MergeEntry) error {
+	switch {
+	case sameEntry(o, t):
+		m.put(p, o, sideOurs)
+	case sameEntry(base, o):
+		m.put(p, t, sideTheirs)
+	case sameEntry(base, t):
+		m.put(p, o, sideOurs)
+	case o == nil:
+		m.conflict(ConflictModifyDelete, p, base, nil, t)
+		m.put(p, t, sideTheirs)
+	case t == nil:
+		m.conflict(ConflictModifyDelete, p, base, o, nil)
+		m.put(p, o, sideOurs)
+	case base == nil:
+		m.conflict(ConflictAddAdd, p, nil, o, t)
+		return m.mergeEntries(p, nil, o, t, false)
+	default:
+		return m.mergeEntries(p, base, o, t, true)
+	}
+
+	return nil
+}
+
+//mergeEntries merges two different versions of a file. If report
+//is false, the conflict is already recorded and only the content of
+//the file in the merged tree is determined.
+func (m *treeMerger) mergeEntries(p string, base, o, t *MergeEntry, report bool) error {
+	fail := func(ct ConflictType) {
+		if report {
+			m.conflict(ct, p, base, o, t)
+		}
+	}
+
+	if fileKind(o.Mode) != fileKind(t.Mode) {
+		fail(ConflictContent)
+		m.put(p, o, sideOurs)
+		return nil
+	}
+
+	mode := o.Mode
+	switch {
+	case o.Mode == t.Mode:
+	case base != nil && base.Mode == o.Mode:
+		mode = t.Mode
+	case base != nil && base.Mode == t.Mode:
+	default:
+		//e.g. both added the file with different modes
+		fail(ConflictContent)
+		report = false
+	}
+
+	switch {
+	case o.ID == t.ID:
+		m.put(p, &MergeEntry{Path: o.Path, Mode: mode, ID: o.ID}, sideOurs)
+		return nil
+	case base != nil && base.ID == o.ID:
+		m.put(p, &MergeEntry{Path: t.Path, Mode: mode, ID: t.ID}, sideTheirs)
+		return nil
+	case base != nil && base.ID == t.ID:
+		m.put(p, &MergeEntry{Path: o.Path, Mode: mode, ID: o.ID}, sideOurs)
+		return nil
+	}
+
+	kind := fileKind(mode)
+	if kind != 0100000 && kind != 0120000 {
+		//submodules
+		fail(ConflictContent)
+		m.put(p, o, sideOurs)
+		return nil
+	}
+
+	var data [3][]byte
+	var tooBig bool
+	for i, e := range []*MergeEntry{base, o, t} {
+		if e == nil || fileKind(e.Mode) != kind {
+			continue
+		}
+
+		var err error
+		var big bool
+		if data[i], big, err = m.readData(e.ID, m.opts.MaxSize); err != nil {
+			return err
+		}
+		tooBig = tooBig || big
+	}
+
+	//annexed files are merged by their keys, which do not depend on
+	//the location of the symlinks
+	keyB, annexB := annexPointerKey(data[0])
+	keyO, annexO := annexPointerKey(data[1])
+	keyT, annexT := annexPointerKey(data[2])
+	if annexO || annexT {
+		switch {
+		case keyO == keyT:
+			m.put(p, &MergeEntry{Path: o.Path, Mode: mode, ID: o.ID}, sideOurs)
+		case annexB && keyB == keyO:
+			m.put(p, &MergeEntry{Path: t.Path, Mode: mode, ID: t.ID}, sideTheirs)
+		case annexB && keyB == keyT:
+			m.put(p, &MergeEntry{Path: o.Path, Mode: mode, ID: o.ID}, sideOurs)
+		case annexO && annexT:
+			fail(ConflictAnnex)
+			m.put(p, o, sideOurs)
+		default:
+			fail(ConflictContent)
+			m.put(p, o, sideOurs)
+		}
+		return nil
+	}
+
+	if kind == 0120000 || tooBig || isBinary(data[0]) || isBinary(data[1]) || isBinary(data[2]) {
+		fail(ConflictContent)
+		m.put(p, o, sideOurs)
+		return nil
+	}
+
+	merged, conflicts := mergeLines(data[0], data[1], data[2], m.opts)
+	if conflicts > 0 {
+		fail(ConflictContent)
+	}
+
+	m.put(p, &MergeEntry{Path: p, Mode: mode, ID: m.addBlob(merged)}, sideOurs)
+	return nil
+}
+
+//readData reads the content of a blob, unless it is bigger than
+//max, which is reported by the bool.
+func (m *treeMerger) readData(id ObjectID, max int64) ([]byte, bool, error) {
+	if data, ok := m.res.blobs[id]; ok {
+		return data, false, nil
+	}
+
+	obj, err := m.repo.OpenObject(id)
+	if err != nil {
+		return nil, false, err
+	}
+	defer obj.Close()
+
+	blob, ok := obj.(*Blob)
+	if !ok {
+		return nil, false, fmt.Errorf("git: %s is a %s, not a blob", id, obj.Type())
+	}
+
+	return readBlobData(blob, max)
+}
+
+//addBlob keeps data as new blob for the merged tree.
+func (m *treeMerger) addBlob(data []byte) ObjectID {
+	format := m.repo.ObjectFormat()
+	h := format.New()
+	fmt.Fprintf(h, "%s %d\x00", ObjBlob, len(data))
+	h.Write(data)
+	id := format.sum(h)
+
+	if !m.res.repo.hasObject(id) {
+		m.res.blobs[id] = data
+	}
+	return id
+}
+
+//examineAnnex sets the annex keys of the versions of an annexed
+//file in a conflict.
+func (m *treeMerger) examineAnnex(c *MergeConflict) error {
+	for _, e := range []*MergeEntry{c.Base, c.Ours, c.Theirs} {
+		if e == nil || fileKind(e.Mode) == 0160000 {
+			continue
+		}
+
+		//pointer files are small, big files are not annexed
+		data, _, err := m.readData(e.ID, 1024)
+		if err != nil {
+			return err
+		}
+
+		if keystr, ok := annexPointerKey(data); ok {
+			e.Annex, _ = AnnexExamineKey(keystr)
+		}
+	}
+	return nil
+}
+
+//relinkAnnex returns the entry to use for e at p: symlinks to annexed
+//files point to the annex relative to their directory, so their
+//targets change when they move to another directory.
+func (m *treeMerger) relinkAnnex(p string, e *MergeEntry) (*MergeEntry, error) {
+	depth := strings.Count(p, "/")
+	if e.Mode != 0120000 || e.Path == p || strings.Count(e.Path, "/") == depth {
+		return e, nil
+	}
+
+	data, _, err := m.readData(e.ID, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	target := path.Clean(string(data))
+	if _, ok := annexKeyOfTarget(target); !ok {
+		return e, nil
+	}
+
+	for strings.HasPrefix(target, "../") {
+		target = target[3:]
+	}
+	target = strings.Repeat("../", depth) + target
+
+	return &MergeEntry{Path: p, Mode: e.Mode, ID: m.addBlob([]byte(target))}, nil
+}
+
+//apply makes the recorded changes to the merged tree. A file that
+//is in the way of a directory (or the other way around) is moved
+//to a path with the label of its side appended, like git does.
+func (m *treeMerger) apply() error {
+	b := m.res.builder
+
+	for _, w := range m.writes {
+		if w.entry != nil {
+			continue
+		}
+
+		mode, ok, err := b.lookup(w.path)
+		if err != nil {
+			return err
+		} else if ok && mode != 040000 {
+			if err = b.Remove(w.path); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, w := range m.writes {
+		if w.entry == nil {
+			continue
+		}
+
+		e, err := m.relinkAnnex(w.path, w.entry)
+		if err != nil {
+			return err
+		}
+
+		p := w.path
+		if mode, ok, err := b.lookup(p); err != nil {
+			return err
+		} else if ok && mode == 040000 {
+			p = m.freePath(p, m.label(w.side))
+			m.conflict(ConflictDirectoryFile, p, m.base[w.path], m.entry(sideOurs, w.path), m.entry(sideTheirs, w.path))
+		}
+
+		//a file in place of a parent directory
+		dirs := strings.Split(p, "/")
+		for i := 1; i < len(dirs); i++ {
+			dir := strings.Join(dirs[:i], "/")
+			mode, ok, err := b.lookup(dir)
+			if err != nil {
+				return err
+			} else if !ok || mode == 040000 {
+				continue
+			}
+
+			moved, err := m.move(dir)
+			if err != nil {
+				return err
+			}
+			m.conflict(ConflictDirectoryFile, moved, m.base[dir], m.entry(sideOurs, dir), m.entry(sideTheirs, dir))
+			break
+		}
+
+		if err = b.Set(p, e.Mode, e.ID); err != nil {
+			return err
+		}
+
+		m.owner[p] = m.label(w.side)
+	}
+
+	return nil
+}
+
+//move moves the file at p out of the way of a directory and
+//returns its new path.
+func (m *treeMerger) move(p string) (string, error) {
+	label, ok := m.owner[p]
+	if !ok {
+		label = m.opts.OursLabel
+	}
+
+	b := m.res.builder
+	node, err := b.walk(strings.Split(p, "/")[:strings.Count(p, "/")], false)
+	if err != nil {
+		return "", err
+	}
+
+	item := node.entries[path.Base(p)]
+	if err = b.Remove(p); err != nil {
+		return "", err
+	}
+
+	moved := m.freePath(p, label)
+	return moved, b.Set(moved, item.mode, item.id)
+}
+
+//freePath returns a path for the file p of the side with the given
+//label that is not used in the merged tree.
+func (m *treeMerger) freePath(p, label string) string {
+	label = strings.Map(func(r rune) rune {
+		if r == '/' {
+			return '_'
+		}
+		return r
+	}, label)
+
+	candidate := p + "~" + label
+	for i := 0; ; i++ {
+		if _, ok, err := m.res.builder.lookup(candidate); err != nil || !ok {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s~%s_%d", p, label, i)
+	}
+}
+
+//mergeHunk is a change of lines [b0, b1) of the base to
+//lines [x0, x1) of one side.
+type mergeHunk struct {
+	b0, b1 int
+	x0, x1 int
+}
+
+//diffHunks compares base with side and returns the changes.
+func diffHunks(base, side []string, algorithm DiffAlgorithm) []mergeHunk {
+	ia, ib := internLines(base, side)
+
+	d := newLineDiff(ia, ib)
+	if algorithm == DiffHistogram {
+		d.histogram(0, len(ia), 0, len(ib))
+	} else {
+		d.myers(0, len(ia), 0, len(ib))
+	}
+	fa := &diffFile{ids: ia, lines: base, changed: d.ca}
+	fb := &diffFile{ids: ib, lines: side, changed: d.cb}
+	compactChanges(fa, fb)
+	compactChanges(fb, fa)
+
+	var hunks []mergeHunk
+	for i, j := 0, 0; i < len(ia) || j < len(ib); {
+		if (i < len(ia) && d.ca[i]) || (j < len(ib) && d.cb[j]) {
+			h := mergeHunk{b0: i, x0: j}
+			for i < len(ia) && d.ca[i] {
+				i++
+			}
+			for j < len(ib) && d.cb[j] {
+				j++
+			}
+			h.b1, h.x1 = i, j
+			hunks = append(hunks, h)
+			continue
+		}
+		i++
+		j++
+	}
+
+	return hunks
+}
+
+//mergeLines merges the changes of ours and theirs to base line by
+//line and returns the result and the number of conflicts, which
+//are marked like git does. Changes of both sides conflict if they
+//overlap or touch, unless they are identical; lines at the start
+//and end of a conflict that both sides agree on are moved out of
+//it.
+func mergeLines(base, o, t []byte, opts MergeOptions) ([]byte, int) {
+	lb, lo, lt := splitLines(base), splitLines(o), splitLines(t)
+	ho := diffHunks(lb, lo, opts.Algorithm)
+	ht := diffHunks(lb, lt, opts.Algorithm)
+
+	var out bytes.Buffer
+	emit := func(lines []string, terminate bool) {
+		for i, l := range lines {
+			out.WriteString(l)
+			if terminate && i == len(lines)-1 && !strings.HasSuffix(l, "\n") {
+				out.WriteByte('\n')
+			}
+		}
+	}
+
+	conflicts, pos := 0, 0
+	for i, j := 0, 0; i < len(ho) || j < len(ht); {
+		//the group of overlapping hunks starting with the first one
+		start, end := 0, 0
+		si, sj := i, j
+		if j == len(ht) || (i < len(ho) && ho[i].b0 <= ht[j].b0) {
+			start, end = ho[i].b0, ho[i].b1
+			i++
+		} else {
+			start, end = ht[j].b0, ht[j].b1
+			j++
+		}
+
+		for {
+			if i < len(ho) && ho[i].b0 <= end {
+				if ho[i].b1 > end {
+					end = ho[i].b1
+				}
+				i++
+			} else if j < len(ht) && ht[j].b0 <= end {
+				if ht[j].b1 > end {
+					end = ht[j].b1
+				}
+				j++
+			} else {
+				break
+			}
+		}
+
+		emit(lb[pos:start], false)
+		pos = end
+
+		//the lines of each side that replace [start, end) of the base
+		region := func(hunks []mergeHunk, lines []string) []string {
+			if len(hunks) == 0 {
+				return lb[start:end]
+			}
+			first, last := hunks[0], hunks[len(hunks)-1]
+			return lines[first.x0-(first.b0-start) : last.x1+(end-last.b1)]
+		}
+		ro, rt := region(ho[si:i], lo), region(ht[sj:j], lt)
+
+		if equalLines(ro, rt) {
+			emit(ro, false)
+			continue
+		} else if si == i {
+			emit(rt, false)
+			continue
+		} else if sj == j {
+			emit(ro, false)
+			continue
+		}
+
+		n := 0
+		for n < len(ro) && n < len(rt) && ro[n] == rt[n] {
+			n++
+		}
+		emit(ro[:n], false)
+		ro, rt = ro[n:], rt[n:]
+
+		n = 0
+		for n < len(ro) && n < len(rt) && ro[len(ro)-1-n] == rt[len(rt)-1-n] {
+			n++
+		}
+		tail := ro[len(ro)-n:]
+		ro, rt = ro[:len(ro)-n], rt[:len(rt)-n]
+
+		conflicts++
+		fmt.Fprintf(&out, "<<<<<<< %s\n", opts.OursLabel)
+		emit(ro, true)
+		out.WriteString("=======\n")
+		emit(rt, true)
+		fmt.Fprintf(&out, ">>>>>>> %s\n", opts.TheirsLabel)
+		emit(tail, false)
+	}
+
+	emit(lb[pos:], false)
+	return out.Bytes(), conflicts
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,365 @@
+package git
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+//gitMergeTree runs git merge-tree for the branches ours and theirs
+//and returns the merged tree and the conflicting paths.
+func gitMergeTree(tr *testRepo, ours, theirs string) (string, []string) {
+	cmd := exec.Command("git", "merge-tree", "--write-tree", "--name-only", "--no-messages", ours, theirs)
+	cmd.Dir = tr.work
+	cmd.Env = append(os.Environ(), "GIT_CONFIG_NOSYSTEM=1", "HOME="+tr.work)
+
+	out, err := cmd.Output()
+	if ee, ok := err.(*exec.ExitError); ok && ee.Sys() != nil && len(out) == 0 {
+		tr.t.Fatalf("git merge-tree failed: %v\n%s", err, ee.Stderr)
+	}
+
+	lines := strings.Split(strings.TrimRight(string(out), "\n"), "\n")
+	return lines[0], lines[1:]
+}
+
+func (tr *testRepo) symlink(name, target string) {
+	path := filepath.Join(tr.work, name)
+	os.Remove(path)
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		tr.t.Fatal(err)
+	} else if err = os.Symlink(target, path); err != nil {
+		tr.t.Fatal(err)
+	}
+}
+
+const (
+	annexKeyA = "SHA256E-s1--ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb.dat"
+	annexKeyB = "SHA256E-s1--3e23e8160039594a33894f6564e1b1348bbd7a0088d42c4acb73eeaed59c009d.dat"
+	annexKeyC = "SHA256E-s1--2e7d2c03a9507ae265ecf5b5356885a53393a2029d241394997265a1a25aefc6.dat"
+)
+
+func annexLink(depth int, key string) string {
+	return strings.Repeat("../", depth) + ".git/annex/objects/Xx/Yy/" + key + "/" + key
+}
+
+var mergeTests = []struct {
+	name      string
+	ours      func(tr *testRepo)
+	theirs    func(tr *testRepo)
+	conflicts map[string]ConflictType
+}{
+	{"different lines",
+		func(tr *testRepo) { tr.write("a.txt", "1\nours\n3\n4\n5\n6\n7\n8\n9\n") },
+		func(tr *testRepo) { tr.write("a.txt", "1\n2\n3\n4\n5\n6\n7\ntheirs\n9\n") },
+		nil},
+	{"same change",
+		func(tr *testRepo) { tr.write("a.txt", "1\n2\n3\n4\nnew\n6\n7\n8\n9\n") },
+		func(tr *testRepo) { tr.write("a.txt", "1\n2\n3\n4\nnew\n6\n7\n8\n9\n") },
+		nil},
+	{"same line",
+		func(tr *testRepo) { tr.write("a.txt", "1\n2\n3\n4\nours\n6\n7\n8\n9\n") },
+		func(tr *testRepo) { tr.write("a.txt", "1\n2\n3\n4\ntheirs\n6\n7\n8\n9\n") },
+		map[string]ConflictType{"a.txt": ConflictContent}},
+	{"modify/delete",
+		func(tr *testRepo) { tr.write("b.txt", "b\nb\n") },
+		func(tr *testRepo) { tr.git("rm", "-q", "b.txt") },
+		map[string]ConflictType{"b.txt": ConflictModifyDelete}},
+	{"delete/modify",
+		func(tr *testRepo) { tr.git("rm", "-q", "b.txt") },
+		func(tr *testRepo) { tr.write("b.txt", "b\nb\n") },
+		map[string]ConflictType{"b.txt": ConflictModifyDelete}},
+	{"add/add",
+		func(tr *testRepo) { tr.write("new.txt", "ours\n") },
+		func(tr *testRepo) { tr.write("new.txt", "theirs\n") },
+		map[string]ConflictType{"new.txt": ConflictAddAdd}},
+	{"same add",
+		func(tr *testRepo) { tr.write("dir/new.txt", "new\n") },
+		func(tr *testRepo) { tr.write("dir/new.txt", "new\n") },
+		nil},
+	{"rename and modify",
+		func(tr *testRepo) { tr.git("mv", "c.txt", "dir/renamed.txt") },
+		func(tr *testRepo) { tr.write("c.txt", "c1\nc2\nc3\nc4\nc5\nc6\nc7\ntheirs\n") },
+		nil},
+	{"modify and rename",
+		func(tr *testRepo) { tr.write("c.txt", "ours\nc2\nc3\nc4\nc5\nc6\nc7\nc8\n") },
+		func(tr *testRepo) { tr.git("mv", "c.txt", "renamed.txt") },
+		nil},
+	{"rename/delete",
+		func(tr *testRepo) { tr.git("mv", "c.txt", "renamed.txt") },
+		func(tr *testRepo) { tr.git("rm", "-q", "c.txt") },
+		map[string]ConflictType{"renamed.txt": ConflictRenameDelete}},
+	{"rename/rename",
+		func(tr *testRepo) { tr.git("mv", "c.txt", "ours.txt") },
+		func(tr *testRepo) { tr.git("mv", "c.txt", "theirs.txt") },
+		map[string]ConflictType{"ours.txt": ConflictRenameRename}},
+	{"mode and content",
+		func(tr *testRepo) { os.Chmod(filepath.Join(tr.work, "a.txt"), 0755) },
+		func(tr *testRepo) { tr.write("a.txt", "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n") },
+		nil},
+	{"binary",
+		func(tr *testRepo) { tr.write("bin.dat", "\x00ours") },
+		func(tr *testRepo) { tr.write("bin.dat", "\x00theirs") },
+		map[string]ConflictType{"bin.dat": ConflictContent}},
+	{"annex keys",
+		func(tr *testRepo) { tr.symlink("data/x.dat", annexLink(1, annexKeyB)) },
+		func(tr *testRepo) { tr.symlink("data/x.dat", annexLink(1, annexKeyC)) },
+		map[string]ConflictType{"data/x.dat": ConflictAnnex}},
+	{"directory/file",
+		func(tr *testRepo) { tr.write("df", "file\n") },
+		func(tr *testRepo) { tr.write("df/file.txt", "file\n") },
+		map[string]ConflictType{"df~directory-file-ours": ConflictDirectoryFile}},
+	{"file/directory",
+		func(tr *testRepo) { tr.write("fd/file.txt", "file\n") },
+		func(tr *testRepo) { tr.write("fd", "file\n") },
+		map[string]ConflictType{"fd~file-directory-theirs": ConflictDirectoryFile}},
+}
+
+func mkMergeRepo(t *testing.T) *testRepo {
+	tr := mkTestRepo(t)
+
+	tr.write("a.txt", "1\n2\n3\n4\n5\n6\n7\n8\n9\n")
+	tr.write("b.txt", "b\n")
+	tr.write("c.txt", "c1\nc2\nc3\nc4\nc5\nc6\nc7\nc8\n")
+	tr.write("dir/d.txt", "d\n")
+	tr.write("bin.dat", "\x00base")
+	tr.symlink("data/x.dat", annexLink(1, annexKeyA))
+	tr.commit("base")
+	tr.git("branch", "base")
+
+	return tr
+}
+
+func TestMergeTrees(t *testing.T) {
+	tr := mkMergeRepo(t)
+	defer tr.cleanup()
+
+	opts := DefaultMergeOptions
+	for _, tt := range mergeTests {
+		branch := strings.NewReplacer(" ", "-", "/", "-").Replace(tt.name)
+		tr.git("checkout", "-q", "-b", branch+"-ours", "base")
+		tt.ours(tr)
+		ours := tr.commit(tt.name + " ours")
+
+		tr.git("checkout", "-q", "-b", branch+"-theirs", "base")
+		tt.theirs(tr)
+		theirs := tr.commit(tt.name + " theirs")
+
+		opts.OursLabel, opts.TheirsLabel = branch+"-ours", branch+"-theirs"
+		res, err := tr.MergeCommits(ours, theirs, opts)
+		if err != nil {
+			t.Fatalf("%s: MergeCommits() => %v", tt.name, err)
+		} else if len(res.Bases) != 1 || res.Bases[0] != tr.revParse("base") {
+			t.Fatalf("%s: wrong merge bases %v", tt.name, res.Bases)
+		}
+
+		tree, paths := gitMergeTree(tr, opts.OursLabel, opts.TheirsLabel)
+
+		var have []string
+		for _, c := range res.Conflicts {
+			if tt.conflicts[c.Path] != c.Type {
+				t.Errorf("%s: unexpected %s conflict of %s", tt.name, c.Type, c.Path)
+			}
+			have = append(have, c.Path)
+		}
+
+		if len(have) != len(tt.conflicts) {
+			t.Errorf("%s: expected conflicts %v, got %v", tt.name, tt.conflicts, res.Conflicts)
+		}
+
+		//git also lists the source and the other destination
+		//of rename/rename conflicts
+		sort.Strings(have)
+		if tt.name == "rename/rename" {
+			paths = []string{"ours.txt"}
+		}
+
+		if strings.Join(have, " ") != strings.Join(paths, " ") {
+			t.Errorf("%s: conflicts of %v, git reports %v", tt.name, have, paths)
+		}
+
+		id, err := res.Write()
+		if err != nil {
+			t.Fatalf("%s: Write() => %v", tt.name, err)
+		} else if res.Clean() && id.String() != tree {
+			t.Errorf("%s: merged tree %s, expected %s", tt.name, id, tree)
+		}
+	}
+}
+
+func TestMergeContent(t *testing.T) {
+	tr := mkMergeRepo(t)
+	defer tr.cleanup()
+
+	tr.git("checkout", "-q", "-b", "ours", "base")
+	tr.write("a.txt", "1\n2\n3\n4\nours\n6\n7\n8\n9\n")
+	ours := tr.commit("ours")
+
+	tr.git("checkout", "-q", "-b", "theirs", "base")
+	tr.write("a.txt", "1\n2\n3\n4\ntheirs\n6\n7\n8\n9\n")
+	theirs := tr.commit("theirs")
+
+	//git leaves the same conflict markers
+	opts := DefaultMergeOptions
+	opts.OursLabel, opts.TheirsLabel = "ours", "theirs"
+	res, err := tr.MergeCommits(ours, theirs, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := res.Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tree, _ := gitMergeTree(tr, "ours", "theirs")
+	if id.String() != tree {
+		t.Fatalf("tree with conflict markers %s, expected %s", id, tree)
+	}
+
+	//merges that are not written leave nothing in the repository
+	tr.write("a.txt", "1\n2\n3\n4\nagain\n6\n7\n8\n9\n")
+	theirs = tr.commit("theirs again")
+
+	objects := tr.git("count-objects")
+	if res, err = tr.MergeCommits(ours, theirs, opts); err != nil || res.Clean() {
+		t.Fatalf("expected a conflict, got %+v (%v)", res, err)
+	} else if tr.git("count-objects") != objects {
+		t.Fatalf("merge preview wrote objects")
+	}
+}
+
+func TestMergeAnnexRename(t *testing.T) {
+	tr := mkMergeRepo(t)
+	defer tr.cleanup()
+
+	//moving an annexed file changes its symlink, not its key
+	tr.git("checkout", "-q", "-b", "ours", "base")
+	tr.git("mv", "data/x.dat", "x.dat")
+	tr.symlink("x.dat", annexLink(0, annexKeyA))
+	ours := tr.commit("ours")
+
+	tr.git("checkout", "-q", "-b", "theirs", "base")
+	tr.symlink("data/x.dat", annexLink(1, annexKeyB))
+	theirs := tr.commit("theirs")
+
+	opts := DefaultMergeOptions
+	opts.Renames.Threshold = 100
+	res, err := tr.MergeCommits(ours, theirs, opts)
+	if err != nil {
+		t.Fatal(err)
+	} else if !res.Clean() {
+		t.Fatalf("unexpected conflicts: %+v", res.Conflicts)
+	}
+
+	id, err := res.Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := tr.git("cat-file", "-p", id.String()+":x.dat")
+	if target != annexLink(0, annexKeyB) {
+		t.Fatalf("wrong symlink target %q", target)
+	}
+}
+
+func TestMergeCrissCross(t *testing.T) {
+	tr := mkMergeBaseRepo(t)
+	defer tr.cleanup()
+
+	res, err := tr.MergeCommits(tr.revParse("one"), tr.revParse("two"), DefaultMergeOptions)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(res.Bases) != 2 || !res.Clean() {
+		t.Fatalf("unexpected merge result: %+v", res)
+	}
+
+	id, err := res.Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tree, _ := gitMergeTree(tr, "one", "two"); id.String() != tree {
+		t.Fatalf("merged tree %s, expected %s", id, tree)
+	}
+
+	if _, err = tr.MergeCommits(tr.revParse("one"), tr.revParse("orphan"), DefaultMergeOptions); err == nil {
+		t.Fatalf("expected an error for unrelated histories")
+	}
+}
+
+func TestMergeCrissCrossConflict(t *testing.T) {
+	tr := mkMergeRepo(t)
+	defer tr.cleanup()
+
+	//both bases change the same line, the merges resolve it
+	tr.git("checkout", "-q", "-b", "x", "base")
+	tr.write("a.txt", "1\n2\n3\n4\nx\n6\n7\n8\n9\n")
+	tr.commit("x")
+
+	tr.git("checkout", "-q", "-b", "y", "base")
+	tr.write("a.txt", "1\n2\n3\n4\ny\n6\n7\n8\n9\n")
+	tr.commit("y")
+
+	for _, b := range []string{"x", "y"} {
+		other := map[string]string{"x": "y", "y": "x"}[b]
+		tr.git("checkout", "-q", "-b", b+"2", b)
+		tr.git("merge", "-q", "--no-ff", "--no-commit", "-s", "ours", other)
+		tr.write("a.txt", "1\n2\n3\n4\n"+b+"2\n6\n7\n8\n9\n")
+		tr.commit("merged " + other + " into " + b)
+	}
+
+	opts := DefaultMergeOptions
+	opts.OursLabel, opts.TheirsLabel = "x2", "y2"
+
+	objects := tr.git("count-objects")
+	res, err := tr.MergeCommits(tr.revParse("x2"), tr.revParse("y2"), opts)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(res.Bases) != 2 || len(res.Conflicts) != 1 || res.Conflicts[0].Path != "a.txt" {
+		t.Fatalf("unexpected merge result: %+v", res)
+	} else if tr.git("count-objects") != objects {
+		t.Fatalf("merge preview wrote objects")
+	}
+
+	id, err := res.Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tree, paths := gitMergeTree(tr, "x2", "y2")
+	if id.String() != tree || len(paths) != 1 {
+		t.Fatalf("merged tree %s, expected %s (%v)\n%s\n--- expected ---\n%s", id, tree, paths,
+			tr.git("cat-file", "-p", id.String()+":a.txt"), tr.git("cat-file", "-p", tree+":a.txt"))
+	}
+	tr.git("fsck", "--no-dangling")
+}
+
+func TestMergeLines(t *testing.T) {
+	tests := []struct {
+		base, ours, theirs string
+		merged             string
+		conflicts          int
+	}{
+		{"a\nb\nc\n", "a\nb\nc\n", "a\nB\nc\n", "a\nB\nc\n", 0},
+		{"a\nb\nc\nd\ne\n", "A\nb\nc\nd\ne\n", "a\nb\nc\nd\nE\n", "A\nb\nc\nd\nE\n", 0},
+		{"a\nb\nc\n", "a\nx\ny\nc\n", "a\nx\ny\nc\n", "a\nx\ny\nc\n", 0},
+		{"a\nb\nc\n", "a\nO\nc\n", "a\nT\nc\n", "a\n<<<<<<< o\nO\n=======\nT\n>>>>>>> t\nc\n", 1},
+		{"a\nb", "a\nO", "a\nT", "a\n<<<<<<< o\nO\n=======\nT\n>>>>>>> t\n", 1},
+		//common lines at the ends of a conflict are moved out of it
+		{"a\nb\nc\n", "a\nx\nO\ny\nc\n", "a\nx\nT\ny\nc\n", "a\nx\n<<<<<<< o\nO\n=======\nT\n>>>>>>> t\ny\nc\n", 1},
+		{"", "O\n", "T\n", "<<<<<<< o\nO\n=======\nT\n>>>>>>> t\n", 1},
+	}
+
+	opts := DefaultMergeOptions
+	opts.OursLabel, opts.TheirsLabel = "o", "t"
+	for _, tt := range tests {
+		merged, n := mergeLines([]byte(tt.base), []byte(tt.ours), []byte(tt.theirs), opts)
+		if string(merged) != tt.merged || n != tt.conflicts {
+			t.Errorf("mergeLines(%q, %q, %q) => %q, %d; expected %q, %d",
+				tt.base, tt.ours, tt.theirs, merged, n, tt.merged, tt.conflicts)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package git
+
+import (
+	"os"
+)
+
+//mmapFile is not supported on this platform, callers will
+//fall back to ReadAt.
+func mmapFile(fd *os.File) ([]byte, error) {
+	return nil, nil
+}
+
+func munmapFile(data []byte) error {
+	return nil
+}
@@ -0,0 +1,34 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package git
+
+import (
+	"os"
+	"syscall"
+)
+
+//mmapFile maps the whole file read-only into memory. A nil
+//slice and no error is returned if the file cannot be mapped
+//on this platform (e.g. it is too large for the address space),
+//callers should then fall back to ReadAt.
+func mmapFile(fd *os.File) ([]byte, error) {
+	fi, err := fd.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	size := fi.Size()
+	if size == 0 || int64(int(size)) != size {
+		return nil, nil
+	}
+
+	return syscall.Mmap(int(fd.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+func munmapFile(data []byte) error {
+	if data == nil {
+		return nil
+	}
+	return syscall.Munmap(data)
+}
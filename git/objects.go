@@ -1,36 +1,167 @@
 package git
 
 import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"hash"
 	"io"
 	"os"
 	"strings"
 	"time"
 )
 
-//SHA1 is the object identifying checksum of
-// the object data
-type SHA1 [20]byte
+//ObjectFormat is the hash function that object ids are
+//computed with, see extensions.objectFormat in git-config(1).
+type ObjectFormat byte
 
-func (oid SHA1) String() string {
-	return hex.EncodeToString(oid[:])
+//The object formats. The zero value is SHA-1, the format
+//of all repositories that do not set one.
+const (
+	FormatSHA1 ObjectFormat = iota
+	FormatSHA256
+)
+
+//maxHashSize is the size of the largest object id.
+const maxHashSize = sha256.Size
+
+//ParseObjectFormat converts the name of an object format, as in
+//extensions.objectFormat, to the ObjectFormat.
+func ParseObjectFormat(name string) (ObjectFormat, error) {
+	switch strings.ToLower(name) {
+	case "sha1":
+		return FormatSHA1, nil
+	case "sha256":
+		return FormatSHA256, nil
+	}
+	return FormatSHA1, fmt.Errorf("git: unknown object format: %q", name)
+}
+
+func (f ObjectFormat) String() string {
+	if f == FormatSHA256 {
+		return "sha256"
+	}
+	return "sha1"
+}
+
+//Size returns the size of the object ids in bytes.
+func (f ObjectFormat) Size() int {
+	if f == FormatSHA256 {
+		return sha256.Size
+	}
+	return sha1.Size
+}
+
+//HexSize returns the length of the hex encoded object ids.
+func (f ObjectFormat) HexSize() int {
+	return 2 * f.Size()
+}
+
+//New returns a new hash.Hash computing the object ids.
+func (f ObjectFormat) New() hash.Hash {
+	if f == FormatSHA256 {
+		return sha256.New()
+	}
+	return sha1.New()
+}
+
+//Zero returns the id consisting of zeros only, which git uses
+//for objects that do not exist.
+func (f ObjectFormat) Zero() ObjectID {
+	return ObjectID{format: f}
+}
+
+//FromBytes returns the id with the raw bytes in b, which must
+//be Size() long.
+func (f ObjectFormat) FromBytes(b []byte) (ObjectID, error) {
+	id := ObjectID{format: f}
+	if len(b) != f.Size() {
+		return id, fmt.Errorf("git: %s object id must be %d bytes", f, f.Size())
+	}
+
+	copy(id.hash[:], b)
+	return id, nil
+}
+
+//sum returns the id computed by h, which must be a hash.Hash
+//returned by New().
+func (f ObjectFormat) sum(h hash.Hash) ObjectID {
+	id := ObjectID{format: f}
+	h.Sum(id.hash[:0])
+	return id
+}
+
+//readObjectID reads a raw object id of format f from r.
+func readObjectID(r io.Reader, f ObjectFormat) (ObjectID, error) {
+	id := ObjectID{format: f}
+	_, err := io.ReadFull(r, id.hash[:f.Size()])
+	return id, err
+}
+
+//ObjectID is the object identifying checksum of
+//the object data. It is either a SHA-1 or a SHA-256
+//checksum, depending on the format of the repository.
+//The zero value is the SHA-1 id consisting of zeros.
+type ObjectID struct {
+	hash   [maxHashSize]byte
+	format ObjectFormat
+}
+
+//Format returns the object format of the id.
+func (oid ObjectID) Format() ObjectFormat {
+	return oid.format
+}
+
+//Bytes returns the raw bytes of the id.
+func (oid ObjectID) Bytes() []byte {
+	return oid.hash[:oid.format.Size()]
+}
+
+//IsZero checks if the id consists of zeros only.
+func (oid ObjectID) IsZero() bool {
+	return oid.hash == [maxHashSize]byte{}
+}
+
+//Compare compares the raw bytes of two ids, like bytes.Compare.
+func (oid ObjectID) Compare(other ObjectID) int {
+	return bytes.Compare(oid.Bytes(), other.Bytes())
+}
+
+func (oid ObjectID) String() string {
+	return hex.EncodeToString(oid.Bytes())
 }
 
-//ParseSHA1 expects a string with a hex encoded sha1.
-//It will trim the string of newline and space before
-//parsing.
-func ParseSHA1(input string) (sha SHA1, err error) {
+//ParseObjectID expects a string with a hex encoded object id,
+//the format is determined by its length. It will trim the
+//string of newline and space before parsing.
+func ParseObjectID(input string) (ObjectID, error) {
 	data, err := hex.DecodeString(strings.Trim(input, " \n"))
 	if err != nil {
-		return
-	} else if len(data) != 20 {
-		err = fmt.Errorf("git: sha1 must be 20 bytes")
-		return
+		return ObjectID{}, err
 	}
 
-	copy(sha[:], data)
-	return
+	switch len(data) {
+	case sha1.Size:
+		return FormatSHA1.FromBytes(data)
+	case sha256.Size:
+		return FormatSHA256.FromBytes(data)
+	}
+
+	return ObjectID{}, fmt.Errorf("git: object id must be %d or %d bytes", sha1.Size, sha256.Size)
+}
+
+//SHA1 is the old name of ObjectID.
+//
+//Deprecated: use ObjectID, which also holds SHA-256 ids.
+type SHA1 = ObjectID
+
+//ParseSHA1 parses a hex encoded object id.
+//
+//Deprecated: use ParseObjectID.
+func ParseSHA1(input string) (SHA1, error) {
+	return ParseObjectID(input)
 }
 
 //Signature is a combination of who (Name, Email) and when (Date, Offset).
@@ -43,6 +174,20 @@ type Signature struct {
 	Offset *time.Location
 }
 
+//NewSignature creates a new signature for the given person,
+//with its offset taken from the location of t.
+func NewSignature(name, email string, t time.Time) Signature {
+	_, secs := t.Zone()
+
+	sign, mins := '+', secs/60
+	if mins < 0 {
+		sign, mins = '-', -mins
+	}
+
+	off := fmt.Sprintf("%c%02d%02d", sign, mins/60, mins%60)
+	return Signature{Name: name, Email: email, Date: t, Offset: time.FixedZone(off, secs)}
+}
+
 func (s Signature) String() string {
 	return fmt.Sprintf("%s <%s> %d %s", s.Name, s.Email, s.Date.Unix(), s.Offset)
 }
@@ -152,8 +297,8 @@ func (o *gitObject) Close() error {
 type Commit struct {
 	gitObject
 
-	Tree      SHA1
-	Parent    []SHA1
+	Tree      ObjectID
+	Parent    []ObjectID
 	Author    Signature
 	Committer Signature
 	Message   string
@@ -169,8 +314,9 @@ func (c *Commit) Date() time.Time {
 type Tree struct {
 	gitObject
 
-	entry *TreeEntry
-	err   error
+	format ObjectFormat
+	entry  *TreeEntry
+	err    error
 }
 
 //TreeEntry holds information about a single
@@ -178,7 +324,7 @@ type Tree struct {
 type TreeEntry struct {
 	Mode os.FileMode
 	Type ObjectType
-	ID   SHA1
+	ID   ObjectID
 	Name string
 }
 
@@ -188,7 +334,7 @@ type TreeEntry struct {
 //if there was an error while advacing. Use Err()
 //to resolve between the to conditions.
 func (tree *Tree) Next() bool {
-	tree.entry, tree.err = parseTreeEntry(tree.source)
+	tree.entry, tree.err = parseTreeEntry(tree.source, tree.format)
 	return tree.err == nil
 }
 
@@ -220,7 +366,7 @@ func (b *Blob) Read(data []byte) (n int, err error) {
 type Tag struct {
 	gitObject
 
-	Object  SHA1
+	Object  ObjectID
 	ObjType ObjectType
 	Tag     string
 	Tagger  Signature
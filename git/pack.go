@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 )
@@ -44,9 +45,11 @@ type PackIndex struct {
 	*os.File
 
 	Version uint32
+	Format  ObjectFormat
 	FO      FanOut
 
 	shaBase int64
+	data    []byte //mmap-ed file contents, nil if not mapped
 }
 
 //PackFile is git pack file with the actual
@@ -57,6 +60,26 @@ type PackFile struct {
 
 	Version  uint32
 	ObjCount uint32
+	Format   ObjectFormat //of the ids of delta bases
+
+	data  []byte     //mmap-ed file contents, nil if not mapped
+	entry *packEntry //of the packRegistry the pack belongs to, if any
+}
+
+//readAtMapped implements io.ReaderAt on top of a mapped file.
+func readAtMapped(data []byte, p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("git: negative offset")
+	} else if off >= int64(len(data)) {
+		return 0, io.EOF
+	}
+
+	n := copy(p, data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
 }
 
 //PackIndexOpen opens the git pack file with the given
@@ -105,74 +128,198 @@ func PackIndexOpen(path string) (*PackIndex, error) {
 
 	idx.shaBase = int64((idx.Version-1)*8) + int64(binary.Size(idx.FO))
 
+	fi, err := fd.Stat()
+	if err != nil {
+		idx.Close()
+		return nil, fmt.Errorf("git: io error: %v", err)
+	}
+
+	idx.Format, err = idx.detectFormat(fi.Size())
+	if err != nil {
+		idx.Close()
+		return nil, err
+	}
+
+	// if mapping fails we silently fall back to
+	// reading via the file descriptor
+	idx.data, err = mmapFile(fd)
+	if err != nil {
+		idx.data = nil
+	}
+
 	return idx, nil
 }
 
-//ReadSHA1 reads the SHA1 stared at position pos (in the FanOut table).
-func (pi *PackIndex) ReadSHA1(chksum *SHA1, pos int) error {
-	if version := pi.Version; version != 2 {
-		return fmt.Errorf("git: v%d version support incomplete", version)
+//detectFormat determines the object format of the index from
+//its size, since the index itself does not record it. The sizes
+//of the tables can only add up for one of the formats.
+func (pi *PackIndex) detectFormat(size int64) (ObjectFormat, error) {
+	n := int64(pi.FO[255])
+
+	for _, f := range []ObjectFormat{FormatSHA1, FormatSHA256} {
+		hsize := int64(f.Size())
+
+		switch pi.Version {
+		case 1:
+			//FanOut + n * (offset[4] + id) + packsum + idxsum
+			if pi.shaBase+n*(4+hsize)+2*hsize == size {
+				return f, nil
+			}
+		default:
+			//header + FanOut + n * (id + crc[4] + offset[4])
+			//+ k * offset64[8] + packsum + idxsum
+			rest := size - pi.shaBase - n*(hsize+8) - 2*hsize
+			if rest >= 0 && rest%8 == 0 && rest/8 <= n {
+				return f, nil
+			}
+		}
+	}
+
+	return FormatSHA1, fmt.Errorf("git: pack index has an invalid size")
+}
+
+//ReadAt implements io.ReaderAt. It reads from the mapped
+//index, if available, otherwise from the underlying file.
+//It is safe to be used concurrently.
+func (pi *PackIndex) ReadAt(p []byte, off int64) (int, error) {
+	if pi.data != nil {
+		return readAtMapped(pi.data, p, off)
+	}
+	return pi.File.ReadAt(p, off)
+}
+
+//Close unmaps and closes the index file.
+func (pi *PackIndex) Close() error {
+	err := munmapFile(pi.data)
+	pi.data = nil
+
+	if cerr := pi.File.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+//ReadObjectID reads the id stored at position pos (in the FanOut table).
+func (pi *PackIndex) ReadObjectID(chksum *ObjectID, pos int) error {
+	var start int64
+	hsize := int64(pi.Format.Size())
+
+	switch pi.Version {
+	case 1:
+		//FanOut[256*4] + n * (offset[4] + id)
+		start = pi.shaBase + int64(pos)*(4+hsize) + 4
+	default:
+		//header[2*4] + FanOut[256*4] + n * id
+		start = pi.shaBase + int64(pos)*hsize
 	}
 
-	start := pi.shaBase
-	_, err := pi.ReadAt(chksum[0:20], start+int64(pos)*int64(20))
+	var buf [maxHashSize]byte
+	_, err := pi.ReadAt(buf[:hsize], start)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	*chksum, err = pi.Format.FromBytes(buf[:hsize])
+	return err
+}
+
+//ReadSHA1 reads the id stored at position pos (in the FanOut table).
+//
+//Deprecated: use ReadObjectID.
+func (pi *PackIndex) ReadSHA1(chksum *SHA1, pos int) error {
+	return pi.ReadObjectID(chksum, pos)
 }
 
 //ReadOffset returns the offset in the pack file of the object
 //at position pos in the FanOut table.
 func (pi *PackIndex) ReadOffset(pos int) (int64, error) {
-	if version := pi.Version; version != 2 {
-		return -1, fmt.Errorf("git: v%d version incomplete", version)
+	var start int64
+	n := int64(pi.FO[255])
+	hsize := int64(pi.Format.Size())
+
+	switch pi.Version {
+	case 1:
+		//FanOut[256*4] + n * (offset[4] + id)
+		start = pi.shaBase + int64(pos)*(4+hsize)
+	default:
+		//header[2*4] + FanOut[256*4] + n * (id + crc[4])
+		start = pi.shaBase + n*(hsize+4) + int64(pos)*4
+	}
+
+	var buf [8]byte
+	_, err := pi.ReadAt(buf[:4], start)
+	if err != nil {
+		return -1, fmt.Errorf("git: io error: %v", err)
 	}
 
-	//header[2*4] + FanOut[256*4] + n * (sha1[20]+crc[4])
-	start := int64(2*4+256*4) + int64(pi.FO[255]*24) + int64(pos*4)
+	offset := binary.BigEndian.Uint32(buf[:4])
 
-	var offset uint32
+	//see if msb is set, if so this is an
+	// offset into the 64b_offset table
+	// (v1 indices have plain 32 bit offsets)
+	if pi.Version == 1 || offset&(1<<31) == 0 {
+		return int64(offset), nil
+	}
+
+	//... + n * offset[4] + k * offset64[8]
+	k := int64(offset &^ (1 << 31))
+	start = pi.shaBase + n*(hsize+8) + k*8
 
-	_, err := pi.Seek(start, 0)
+	_, err = pi.ReadAt(buf[:], start)
 	if err != nil {
 		return -1, fmt.Errorf("git: io error: %v", err)
 	}
 
-	err = binary.Read(pi, binary.BigEndian, &offset)
-	if err != nil {
-		return -1, err
+	large := binary.BigEndian.Uint64(buf[:])
+	if large > 1<<63-1 {
+		return -1, fmt.Errorf("git: pack offset overflow")
 	}
 
-	//see if msb is set, if so this is an
-	// offset into the 64b_offset table
-	if val := uint32(1<<31) & offset; val != 0 {
-		return -1, fmt.Errorf("git: > 31 bit offests not implemented. Meh")
+	return int64(large), nil
+}
+
+//ReadCRC32 returns the CRC32 checksum of the packed data of the
+//object at position pos in the FanOut table. Only version 2
+//indices contain checksums.
+func (pi *PackIndex) ReadCRC32(pos int) (uint32, error) {
+	if pi.Version < 2 {
+		return 0, fmt.Errorf("git: pack index version %d has no crc32 values", pi.Version)
+	}
+
+	//header[2*4] + FanOut[256*4] + n * id + pos * crc[4]
+	start := pi.shaBase + int64(pi.FO[255])*int64(pi.Format.Size()) + int64(pos)*4
+
+	var buf [4]byte
+	_, err := pi.ReadAt(buf[:], start)
+	if err != nil {
+		return 0, fmt.Errorf("git: io error: %v", err)
 	}
 
-	return int64(offset), nil
+	return binary.BigEndian.Uint32(buf[:]), nil
 }
 
-func (pi *PackIndex) findSHA1(target SHA1) (int, error) {
+func (pi *PackIndex) findObjectID(target ObjectID) (int, error) {
+	if target.Format() != pi.Format {
+		return 0, fmt.Errorf("git: object id not found in index")
+	}
 
 	//s, e and midpoint are one-based indices,
 	//where s is the index before interval and
 	//e is the index of the last element in it
 	//-> search interval is: (s | 1, 2, ... e]
-	s, e := pi.FO.Bounds(target[0])
+	s, e := pi.FO.Bounds(target.Bytes()[0])
 
 	//invariant: object is, if present, in the interval, (s, e]
 	for s < e {
 		midpoint := s + (e-s+1)/2
 
-		var sha SHA1
-		err := pi.ReadSHA1(&sha, midpoint-1)
+		var sha ObjectID
+		err := pi.ReadObjectID(&sha, midpoint-1)
 		if err != nil {
 			return 0, fmt.Errorf("git: io error: %v", err)
 		}
 
-		switch bytes.Compare(target[:], sha[:]) {
+		switch target.Compare(sha) {
 		case -1: // target < sha1, new interval (s, m-1]
 			e = midpoint - 1
 		case +1: //taget > sha1, new interval (m, e]
@@ -182,16 +329,63 @@ func (pi *PackIndex) findSHA1(target SHA1) (int, error) {
 		}
 	}
 
-	return 0, fmt.Errorf("git: sha1 not found in index")
+	return 0, fmt.Errorf("git: object id not found in index")
+}
+
+//findPrefix returns the ids of all objects in the index whose
+//hex representation starts with prefix (at least two digits).
+func (pi *PackIndex) findPrefix(prefix string) ([]ObjectID, error) {
+	if len(prefix) > pi.Format.HexSize() {
+		return nil, nil
+	}
+
+	lower, err := ParseObjectID(prefix + strings.Repeat("0", pi.Format.HexSize()-len(prefix)))
+	if err != nil {
+		return nil, err
+	}
+
+	//find the first entry >= lower in [s, e)
+	s, e := pi.FO.Bounds(lower.Bytes()[0])
+	for s < e {
+		midpoint := s + (e-s)/2
+
+		var sha ObjectID
+		err := pi.ReadObjectID(&sha, midpoint)
+		if err != nil {
+			return nil, fmt.Errorf("git: io error: %v", err)
+		}
+
+		if sha.Compare(lower) < 0 {
+			s = midpoint + 1
+		} else {
+			e = midpoint
+		}
+	}
+
+	var ids []ObjectID
+	for k := s; k < int(pi.FO[255]); k++ {
+		var sha ObjectID
+		err := pi.ReadObjectID(&sha, k)
+		if err != nil {
+			return nil, fmt.Errorf("git: io error: %v", err)
+		}
+
+		if !strings.HasPrefix(sha.String(), prefix) {
+			break
+		}
+		ids = append(ids, sha)
+	}
+
+	return ids, nil
 }
 
 //FindOffset tries to find  object with the id target and if
 //if found returns the offset of the object in the pack file.
 //Returns an error that can be detected by os.IsNotExist if
 //the object could not be found.
-func (pi *PackIndex) FindOffset(target SHA1) (int64, error) {
+func (pi *PackIndex) FindOffset(target ObjectID) (int64, error) {
 
-	pos, err := pi.findSHA1(target)
+	pos, err := pi.findObjectID(target)
 	if err != nil {
 		return 0, err
 	}
@@ -212,6 +406,7 @@ func (pi *PackIndex) OpenPackFile() (*PackFile, error) {
 		return nil, err
 	}
 
+	pf.Format = pi.Format
 	return pf, nil
 }
 
@@ -221,7 +416,9 @@ func (pi *PackIndex) OpenPackFile() (*PackFile, error) {
 //If the object cannot be found it will return an error
 //the can be detected via os.IsNotExist()
 //Delta objects will returned as such and not be resolved.
-func (pi *PackIndex) OpenObject(id SHA1) (Object, error) {
+//The pack file is opened for the object and closed together
+//with it.
+func (pi *PackIndex) OpenObject(id ObjectID) (Object, error) {
 
 	off, err := pi.FindOffset(id)
 
@@ -234,29 +431,39 @@ func (pi *PackIndex) OpenObject(id SHA1) (Object, error) {
 		return nil, err
 	}
 
-	obj, err := pf.readRawObject(off)
+	r := newPackReader(pf, off)
+	r.closePack = true
+
+	obj, err := pf.readRawObjectFrom(r)
 
 	if err != nil {
 		return nil, err
 	}
 
+	var o Object
 	if IsStandardObject(obj.otype) {
-		return parseObject(obj)
+		o, err = parseObject(obj, pf.Format)
+	} else if IsDeltaObject(obj.otype) {
+		//This is a delta object
+		o, err = parseDelta(obj)
+	} else {
+		err = fmt.Errorf("git: unsupported object")
 	}
 
-	if !IsDeltaObject(obj.otype) {
-		return nil, fmt.Errorf("git: unsupported object")
+	if err != nil {
+		obj.Close()
+		return nil, err
 	}
 
-	//This is a delta object
-	delta, err := parseDelta(obj)
-
-	return delta, err
+	return o, nil
 }
 
 //OpenPackFile opens the git pack file at the given path
 //It will check the pack file header and version.
-//Currently only version 2 is supported.
+//Currently only version 2 is supported. The pack does
+//not record its object format, it is taken to be sha1,
+//PackIndex.OpenPackFile sets the one of the index. Packs bigger
+//than the address space are read via the file descriptor.
 //NB: This is low-level API and should most likely
 //not be used directly.
 func OpenPackFile(path string) (*PackFile, error) {
@@ -269,14 +476,17 @@ func OpenPackFile(path string) (*PackFile, error) {
 	var header PackHeader
 	err = binary.Read(osfd, binary.BigEndian, &header)
 	if err != nil {
+		osfd.Close()
 		return nil, fmt.Errorf("git: could not read header: %v", err)
 	}
 
 	if string(header.Sig[:]) != "PACK" {
+		osfd.Close()
 		return nil, fmt.Errorf("git: packfile signature error")
 	}
 
 	if header.Version != 2 {
+		osfd.Close()
 		return nil, fmt.Errorf("git: unsupported packfile version")
 	}
 
@@ -284,15 +494,41 @@ func OpenPackFile(path string) (*PackFile, error) {
 		Version:  header.Version,
 		ObjCount: header.Objects}
 
+	// fall back to the file descriptor if mapping fails
+	fd.data, err = mmapFile(osfd)
+	if err != nil {
+		fd.data = nil
+	}
+
 	return fd, nil
 }
 
-func (pf *PackFile) readRawObject(offset int64) (gitObject, error) {
-	r := newPackReader(pf, offset)
+//ReadAt implements io.ReaderAt. It reads from the mapped
+//pack, if available, otherwise from the underlying file.
+//It is safe to be used concurrently.
+func (pf *PackFile) ReadAt(p []byte, off int64) (int, error) {
+	if pf.data != nil {
+		return readAtMapped(pf.data, p, off)
+	}
+	return pf.File.ReadAt(p, off)
+}
+
+//Close unmaps and closes the pack file.
+func (pf *PackFile) Close() error {
+	err := munmapFile(pf.data)
+	pf.data = nil
 
-	b, err := r.ReadByte()
+	if cerr := pf.File.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+//readPackObjectHeader reads the type and size of a pack entry.
+func readPackObjectHeader(r io.Reader) (ObjectType, int64, error) {
+	b, err := readByte(r)
 	if err != nil {
-		return gitObject{}, fmt.Errorf("git: io error: %v", err)
+		return 0, 0, fmt.Errorf("git: io error: %v", err)
 	}
 
 	//object header format:
@@ -307,16 +543,34 @@ func (pf *PackFile) readRawObject(offset int64) (gitObject, error) {
 	if b&0x80 != 0 {
 		s, err := readVarSize(r, 4)
 		if err != nil {
-			return gitObject{}, err
+			return 0, 0, err
 		}
 
 		size += s
 	}
+
+	return otype, size, nil
+}
+
+func (pf *PackFile) readRawObject(offset int64) (gitObject, error) {
+	return pf.readRawObjectFrom(newPackReader(pf, offset))
+}
+
+//readRawObjectFrom reads the object at the position of r, which
+//is closed if that fails.
+func (pf *PackFile) readRawObjectFrom(r *packReader) (gitObject, error) {
+	otype, size, err := readPackObjectHeader(r)
+	if err != nil {
+		r.Close()
+		return gitObject{}, err
+	}
+
 	obj := gitObject{otype, size, r}
 
 	if IsStandardObject(otype) {
 		err = obj.wrapSourceWithDeflate()
 		if err != nil {
+			r.Close()
 			return gitObject{}, err
 		}
 	}
@@ -338,7 +592,7 @@ func (pf *PackFile) OpenObject(offset int64) (Object, error) {
 	case ObjCommit:
 		return parseCommit(obj)
 	case ObjTree:
-		return parseTree(obj)
+		return parseTree(obj, pf.Format)
 	case ObjBlob:
 		return parseBlob(obj)
 	case ObjTag:
@@ -354,13 +608,21 @@ func (pf *PackFile) OpenObject(offset int64) (Object, error) {
 	}
 }
 
+//packReader reads from a pack file. Packs of a packRegistry
+//stay mapped as long as readers are open.
 type packReader struct {
 	fd    *PackFile
 	start int64
 	off   int64
+
+	closePack bool //close fd together with the reader
+	closed    bool
 }
 
 func newPackReader(fd *PackFile, offset int64) *packReader {
+	if fd.entry != nil {
+		fd.entry.acquire()
+	}
 	return &packReader{fd: fd, start: offset, off: offset}
 }
 
@@ -378,5 +640,15 @@ func (p *packReader) ReadByte() (c byte, err error) {
 }
 
 func (p *packReader) Close() (err error) {
-	return //noop
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+
+	if p.closePack {
+		err = p.fd.Close()
+	} else if p.fd.entry != nil {
+		p.fd.entry.release()
+	}
+	return
 }
@@ -2,8 +2,8 @@ package git
 
 import (
 	"bytes"
-	"crypto/sha1"
 	"io"
+	"path/filepath"
 	"testing"
 )
 
@@ -37,102 +37,182 @@ func TestPackBasic(t *testing.T) {
 			t.Fatalf("could not open pack file: %v", err)
 		}
 
-		count := 0
-		for i := byte(0); i < 255; i++ {
-			s, e := idx.FO.Bounds(i)
-			for k := s; k < e; k++ {
-				var oid SHA1
+		count := checkPackIndex(t, idx, data, repo)
+		t.Logf("tested %d objects in pack", count)
 
-				err := idx.ReadSHA1(&oid, k)
-				if err != nil {
-					t.Fatalf("could not read sha1 at pos %d: %v", k, err)
-				}
+		onf, err := ParseObjectID("0000000000000000000000000000000000000000")
+		if err != nil {
+			t.Fatalf("could not parse all-zero sha1: %v", err)
+		}
 
-				//t.Logf("\t obj %s", oid)
+		off, err := idx.FindOffset(onf)
 
-				//we use FindOffset, not ReadOffset, to test the
-				//search functionality
-				off, err := idx.FindOffset(oid)
+		if err == nil {
+			t.Fatalf("found all-zero sha1 @: %d", off)
+		}
 
-				if err != nil {
-					t.Fatalf("could not find sha1 (%s) that was in the index: %v", oid, err)
-				}
+		onf, err = ParseObjectID("FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF")
+		if err != nil {
+			t.Fatalf("could not parse all-0xF sha1: %v", err)
+		}
 
-				o2, err := idx.ReadOffset(k)
+		off, err = idx.FindOffset(onf)
+		if err == nil {
+			t.Fatalf("found all-0xF sha1 @: %d", off)
+		}
+	}
+}
 
-				if err != nil {
-					t.Fatalf("could not read offset at %d that was in the index: %v", k, err)
-				}
+//checkPackIndex opens every object listed in the index and
+//verifies its id by hashing its contents. Returns the number
+//of objects checked.
+func checkPackIndex(t *testing.T, idx *PackIndex, data *PackFile, src objectSource) int {
+	count := 0
+	for i := 0; i < 256; i++ {
+		s, e := idx.FO.Bounds(byte(i))
+		for k := s; k < e; k++ {
+			var oid ObjectID
+
+			err := idx.ReadObjectID(&oid, k)
+			if err != nil {
+				t.Fatalf("could not read sha1 at pos %d: %v", k, err)
+			}
 
-				if o2 != off {
-					t.Fatalf("offset returned by FindOffset differs from ReadOffset")
-				}
+			//t.Logf("\t obj %s", oid)
 
-				obj, err := data.OpenObject(off)
+			//we use FindOffset, not ReadOffset, to test the
+			//search functionality
+			off, err := idx.FindOffset(oid)
 
-				if err != nil {
-					t.Fatalf("could not open object (%s) at %d: %v", oid, k, err)
-				}
+			if err != nil {
+				t.Fatalf("could not find sha1 (%s) that was in the index: %v", oid, err)
+			}
+
+			o2, err := idx.ReadOffset(k)
 
-				if IsDeltaObject(obj.Type()) {
-					//t.Logf("checking delta obj: %q", oid)
-					delta := obj.(*Delta)
-					chain, err := buildDeltaChain(delta, repo)
+			if err != nil {
+				t.Fatalf("could not read offset at %d that was in the index: %v", k, err)
+			}
 
-					if err != nil {
-						t.Fatalf("building delta chain failed for %q: %v", oid, err)
-					}
+			if o2 != off {
+				t.Fatalf("offset returned by FindOffset differs from ReadOffset")
+			}
 
-					obj, err = chain.resolve()
+			obj, err := data.OpenObject(off)
 
-					if err != nil {
-						t.Fatalf("resolving delta chain failed for %q: %v", oid, err)
-					}
-				}
+			if err != nil {
+				t.Fatalf("could not open object (%s) at %d: %v", oid, k, err)
+			}
 
-				var b bytes.Buffer
-				h := sha1.New()
-				mw := io.MultiWriter(h, &b)
+			if IsDeltaObject(obj.Type()) {
+				//t.Logf("checking delta obj: %q", oid)
+				delta := obj.(*Delta)
+				chain, err := buildDeltaChain(delta, src)
 
-				_, err = obj.WriteTo(mw)
 				if err != nil {
-					t.Fatalf("Object.WriteTo(%q) => failed!: %v ", oid, err)
+					t.Fatalf("building delta chain failed for %q: %v", oid, err)
 				}
 
-				hid := h.Sum(nil)
-				var cid SHA1
-				copy(cid[:], hid)
+				obj, err = chain.resolve()
 
-				if cid != oid {
-					t.Logf("[E] object proof:\n%s---EOF---\n", b.String())
-					t.Fatalf("sha1(%s) => %q expected %q", obj.Type(), cid, oid)
+				if err != nil {
+					t.Fatalf("resolving delta chain failed for %q: %v", oid, err)
 				}
+			}
+
+			var b bytes.Buffer
+			h := oid.Format().New()
+			mw := io.MultiWriter(h, &b)
+
+			_, err = obj.WriteTo(mw)
+			if err != nil {
+				t.Fatalf("Object.WriteTo(%q) => failed!: %v ", oid, err)
+			}
+
+			cid, _ := oid.Format().FromBytes(h.Sum(nil))
 
-				count++
+			if cid != oid {
+				t.Logf("[E] object proof:\n%s---EOF---\n", b.String())
+				t.Fatalf("sha1(%s) => %q expected %q", obj.Type(), cid, oid)
 			}
+
+			count++
 		}
+	}
 
-		t.Logf("tested %d objects in pack", count)
+	return count
+}
+
+//The pack fixtures in testdata were created with git from a small
+//repository ("git repack -a -d") and then indexed via:
+//  git index-pack --index-version=1 -o pack-v1.idx pack-v1.pack
+//  git index-pack --index-version=2,0x100 -o pack-large.idx pack-large.pack
+//The latter forces all objects after offset 0x100 into the large
+//(64 bit) offset table, as it would happen for packs > 2 GiB.
+var packfixtures = []struct {
+	name    string
+	version uint32
+	objects int
+	large   int //number of entries in the large offset table
+}{
+	{"pack-v1", 1, 31, 0},
+	{"pack-large", 2, 31, 29},
+}
 
-		onf, err := ParseSHA1("0000000000000000000000000000000000000000")
+func TestPackIndexFixtures(t *testing.T) {
+	// HEAD of the repository the fixtures were made from
+	// and a delta object (numbers.txt, 2nd revision)
+	head, _ := ParseObjectID("7a6bb477cf1cd8e60b7e4a399312f24f9bb93a3f")
+	delta, _ := ParseObjectID("98f829e42920370fc71cefd0b74c2935a18e4c99")
+
+	for _, tt := range packfixtures {
+		idx, err := PackIndexOpen(filepath.Join("testdata", tt.name))
 		if err != nil {
-			t.Fatalf("could not parse all-zero sha1: %v", err)
+			t.Fatalf("PackIndexOpen(%q) => %v", tt.name, err)
 		}
 
-		off, err := idx.FindOffset(onf)
+		if idx.Version != tt.version {
+			t.Fatalf("PackIndexOpen(%q) => version %d, expected %d", tt.name, idx.Version, tt.version)
+		}
 
-		if err == nil {
-			t.Fatalf("found all-zero sha1 @: %d", off)
+		fi, err := idx.Stat()
+		if err != nil {
+			t.Fatalf("could not stat %q: %v", tt.name, err)
+		}
+
+		// v2: header + fanout + n * (sha1 + crc + offset) + large offsets + 2 * sha1
+		// v1: fanout + n * (offset + sha1) + 2 * sha1
+		n := int64(tt.objects)
+		expected := 8 + 256*4 + n*28 + int64(tt.large)*8 + 40
+		if tt.version == 1 {
+			expected = 256*4 + n*24 + 40
+		}
+
+		if fi.Size() != expected {
+			t.Fatalf("%q: unexpected index size %d, wanted %d", tt.name, fi.Size(), expected)
 		}
 
-		onf, err = ParseSHA1("FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF")
+		pf, err := idx.OpenPackFile()
 		if err != nil {
-			t.Fatalf("could not parse all-0xF sha1: %v", err)
+			t.Fatalf("%q: OpenPackFile() => %v", tt.name, err)
 		}
 
-		off, err = idx.FindOffset(onf)
-		if err == nil {
-			t.Fatalf("found all-0xF sha1 @: %d", off)
+		count := checkPackIndex(t, idx, pf, nil)
+		if count != tt.objects {
+			t.Fatalf("%q: checked %d objects, expected %d", tt.name, count, tt.objects)
+		}
+
+		for _, id := range []ObjectID{head, delta} {
+			obj, err := idx.OpenObject(id)
+			if err != nil {
+				t.Fatalf("%q: OpenObject(%s) => %v", tt.name, id, err)
+			}
+			obj.Close()
 		}
+
+		pf.Close()
+		idx.Close()
+
+		t.Logf("%q: v%d index, %d objects [OK!]", tt.name, tt.version, count)
 	}
 }
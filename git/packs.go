@@ -0,0 +1,249 @@
+package git
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+//packEntry is an index and its pack file, both
+//opened once and kept open by the packRegistry.
+//Readers of the pack hold a reference to it, a
+//retired pack is closed when the last one is gone.
+type packEntry struct {
+	name string //path without the .idx/.pack extension
+	idx  *PackIndex
+	pf   *PackFile
+
+	reg     *packRegistry
+	refs    int32 //accessed atomically
+	retired bool  //guarded by reg.mu
+	closed  bool  //guarded by reg.mu
+}
+
+func (p *packEntry) acquire() {
+	atomic.AddInt32(&p.refs, 1)
+}
+
+//release drops a reference taken by acquire.
+func (p *packEntry) release() {
+	if atomic.AddInt32(&p.refs, -1) == 0 {
+		p.reg.closeRetired(p)
+	}
+}
+
+func (p *packEntry) close() error {
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+
+	err := p.idx.Close()
+	if perr := p.pf.Close(); err == nil {
+		err = perr
+	}
+	return err
+}
+
+//packRegistry keeps all pack files of a repository open
+//(and mapped) so object lookups do not need to glob and
+//open the index files over and over. New packs, e.g. after
+//a push or a repack, are picked up when a lookup misses.
+//It is safe for concurrent use.
+type packRegistry struct {
+	dir string
+
+	mu      sync.RWMutex
+	packs   []*packEntry
+	retired []*packEntry //gone from disk, but maybe still in use
+	scanned bool
+}
+
+func newPackRegistry(repo *Repository) *packRegistry {
+	return &packRegistry{dir: filepath.Join(repo.Path, "objects", "pack")}
+}
+
+//find looks up the object id in all known packs. If it is
+//not found, the pack directory is rescanned once for packs
+//that were added in the meantime. The pack is returned with
+//a reference, which the caller must release.
+func (r *packRegistry) find(id ObjectID) (*packEntry, int64, bool) {
+	r.mu.RLock()
+	scanned := r.scanned
+	pf, off, ok := r.lookup(id)
+	r.mu.RUnlock()
+
+	if ok {
+		return pf, off, true
+	}
+
+	if changed := r.rescan(); !changed && scanned {
+		return nil, 0, false
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.lookup(id)
+}
+
+//lookup must be called with r.mu held.
+func (r *packRegistry) lookup(id ObjectID) (*packEntry, int64, bool) {
+	for _, p := range r.packs {
+		off, err := p.idx.FindOffset(id)
+		if err == nil {
+			p.acquire()
+			return p, off, true
+		}
+	}
+	return nil, 0, false
+}
+
+//findPrefix returns the ids of all objects in all packs that
+//start with the given (hex) prefix. Like find, it rescans the
+//pack directory if nothing was found.
+func (r *packRegistry) findPrefix(prefix string) ([]ObjectID, error) {
+	r.mu.RLock()
+	scanned := r.scanned
+	ids, err := r.lookupPrefix(prefix)
+	r.mu.RUnlock()
+
+	if err != nil || len(ids) > 0 {
+		return ids, err
+	}
+
+	if changed := r.rescan(); !changed && scanned {
+		return nil, nil
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.lookupPrefix(prefix)
+}
+
+//lookupPrefix must be called with r.mu held.
+func (r *packRegistry) lookupPrefix(prefix string) ([]ObjectID, error) {
+	var ids []ObjectID
+	for _, p := range r.packs {
+		found, err := p.idx.findPrefix(prefix)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, found...)
+	}
+
+	return ids, nil
+}
+
+//rescan synchronizes the list of open packs with the
+//contents of the pack directory. Packs that vanished
+//are retired, they stay mapped until all objects read
+//from them are closed. Returns true if anything changed.
+func (r *packRegistry) rescan() bool {
+	files, err := filepath.Glob(filepath.Join(r.dir, "*.idx"))
+	if err != nil {
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.scanned = true
+
+	ondisk := make(map[string]bool, len(files))
+	for _, f := range files {
+		ondisk[strings.TrimSuffix(f, ".idx")] = true
+	}
+
+	changed := false
+	known := make(map[string]bool, len(r.packs))
+	packs := r.packs[:0]
+	for _, p := range r.packs {
+		if ondisk[p.name] {
+			known[p.name] = true
+			packs = append(packs, p)
+			continue
+		}
+		p.retired = true
+		if atomic.LoadInt32(&p.refs) == 0 {
+			p.close()
+		} else {
+			r.retired = append(r.retired, p)
+		}
+		changed = true
+	}
+	r.packs = packs
+
+	for _, f := range files {
+		name := strings.TrimSuffix(f, ".idx")
+		if known[name] {
+			continue
+		}
+
+		p, err := openPackEntry(r, name)
+		if err != nil {
+			//most likely a pack that is currently being
+			//written, we will try again on the next miss
+			continue
+		}
+
+		r.packs = append(r.packs, p)
+		changed = true
+	}
+
+	return changed
+}
+
+func openPackEntry(r *packRegistry, name string) (*packEntry, error) {
+	idx, err := PackIndexOpen(name + ".idx")
+	if err != nil {
+		return nil, err
+	}
+
+	pf, err := idx.OpenPackFile()
+	if err != nil {
+		idx.Close()
+		return nil, err
+	}
+
+	p := &packEntry{name: name, idx: idx, pf: pf, reg: r}
+	pf.entry = p
+	return p, nil
+}
+
+//closeRetired closes the pack if it is retired and
+//not used anymore.
+func (r *packRegistry) closeRetired(p *packEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !p.retired || p.closed || atomic.LoadInt32(&p.refs) != 0 {
+		return
+	}
+
+	p.close()
+	for i := range r.retired {
+		if r.retired[i] == p {
+			r.retired = append(r.retired[:i], r.retired[i+1:]...)
+			break
+		}
+	}
+}
+
+//close releases all open and retired packs.
+func (r *packRegistry) close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var err error
+	for _, p := range append(r.packs, r.retired...) {
+		if cerr := p.close(); err == nil {
+			err = cerr
+		}
+	}
+
+	r.packs = nil
+	r.retired = nil
+	r.scanned = false
+	return err
+}
@@ -0,0 +1,120 @@
+package git
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"testing"
+)
+
+func TestPackRegistry(t *testing.T) {
+	tr := mkTestRepo(t)
+	defer tr.cleanup()
+
+	var ids []ObjectID
+	for i := 0; i < 3; i++ {
+		tr.write(fmt.Sprintf("file-%d.txt", i), fmt.Sprintf("content %d\n", i))
+		ids = append(ids, tr.commit(fmt.Sprintf("commit %d", i)))
+	}
+	tr.git("repack", "-a", "-d", "-q")
+	tr.git("prune-packed")
+
+	for _, id := range ids {
+		obj, err := tr.OpenObject(id)
+		if err != nil {
+			t.Fatalf("OpenObject(%s) => %v", id, err)
+		}
+		obj.Close()
+	}
+
+	packs := tr.packRegistry()
+	if n := len(packs.packs); n != 1 {
+		t.Fatalf("expected 1 open pack, got %d", n)
+	}
+
+	// a new pack (e.g. from a push) must be noticed
+	tr.write("file-new.txt", "new content\n")
+	id := tr.commit("new commit")
+	tr.git("repack", "-d", "-q")
+	tr.git("prune-packed")
+
+	obj, err := tr.OpenObject(id)
+	if err != nil {
+		t.Fatalf("OpenObject(%s) after repack => %v", id, err)
+	}
+	obj.Close()
+
+	if n := len(packs.packs); n != 2 {
+		t.Fatalf("expected 2 open packs, got %d", n)
+	}
+
+	// consolidating packs retires the old ones
+	tr.git("repack", "-a", "-d", "-q")
+	ids = append(ids, id)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(ids)*4)
+	for i := 0; i < 4; i++ {
+		for _, id := range ids {
+			wg.Add(1)
+			go func(id ObjectID) {
+				defer wg.Done()
+				obj, err := tr.OpenObject(id)
+				if err != nil {
+					errs <- fmt.Errorf("OpenObject(%s) => %v", id, err)
+					return
+				}
+				obj.Close()
+			}(id)
+		}
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	// a miss triggers a rescan, unused packs are closed
+	var zero ObjectID
+	if _, err := tr.OpenObject(zero); err == nil {
+		t.Fatalf("OpenObject(%s) => success, expected error", zero)
+	}
+
+	if len(packs.packs) != 1 || len(packs.retired) != 0 {
+		t.Fatalf("expected 1 open, 0 retired packs, got %d, %d", len(packs.packs), len(packs.retired))
+	}
+
+	// a pack stays mapped while objects from it are read
+	blob, err := tr.OpenObject(tr.revParse("HEAD:file-new.txt"))
+	if err != nil {
+		t.Fatalf("OpenObject(file-new.txt) => %v", err)
+	}
+	old := packs.packs[0]
+
+	tr.write("file-other.txt", "other content\n")
+	tr.commit("other commit")
+	tr.git("repack", "-a", "-d", "-q")
+
+	if _, err := tr.OpenObject(zero); err == nil {
+		t.Fatalf("OpenObject(%s) => success, expected error", zero)
+	}
+
+	if len(packs.retired) != 1 || packs.retired[0] != old || old.closed {
+		t.Fatalf("expected the pack in use to be retired, but open")
+	}
+
+	data, err := ioutil.ReadAll(blob.(*Blob))
+	if err != nil || string(data) != "new content\n" {
+		t.Fatalf("reading from a retired pack => %q, %v", data, err)
+	}
+	blob.Close()
+
+	if len(packs.retired) != 0 || !old.closed {
+		t.Fatalf("expected the retired pack to be closed once unused")
+	}
+
+	if err := tr.Close(); err != nil {
+		t.Fatalf("Repository.Close() => %v", err)
+	}
+}
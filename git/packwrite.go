@@ -0,0 +1,480 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+//PackConfig controls how packs are written.
+type PackConfig struct {
+	//Window is the number of objects that are tried as delta
+	//base for each object. Zero disables delta compression.
+	Window int
+
+	//Depth is the maximum length of delta chains.
+	Depth int
+
+	//MaxDeltaSize is the size limit for objects to be considered
+	//for delta compression (as base or target). Objects in the
+	//window are kept in memory.
+	MaxDeltaSize int64
+}
+
+//DefaultPackConfig matches the defaults of git.
+var DefaultPackConfig = PackConfig{
+	Window:       10,
+	Depth:        50,
+	MaxDeltaSize: 64 * 1024 * 1024,
+}
+
+//PackIndexEntry is what the index of a pack records for
+//each object.
+type PackIndexEntry struct {
+	ID     ObjectID
+	Offset int64
+	CRC32  uint32
+}
+
+type packIndexEntries []PackIndexEntry
+
+func (e packIndexEntries) Len() int      { return len(e) }
+func (e packIndexEntries) Swap(i, j int) { e[i], e[j] = e[j], e[i] }
+func (e packIndexEntries) Less(i, j int) bool {
+	return e[i].ID.Compare(e[j].ID) < 0
+}
+
+//packObject is an object that is going to be written to a pack.
+type packObject struct {
+	id    ObjectID
+	otype ObjectType
+	size  int64
+
+	base  *packObject //delta base, if any
+	delta []byte      //delta data against base
+	depth int
+
+	written bool
+	offset  int64
+	crc     uint32
+}
+
+//packOutput keeps track of the offset, checksum and the
+//crc32 of the current object while writing a pack.
+type packOutput struct {
+	w   io.Writer
+	off int64
+	sum hash.Hash
+	crc hash.Hash32
+}
+
+func (p *packOutput) Write(data []byte) (int, error) {
+	n, err := p.w.Write(data)
+	p.sum.Write(data[:n])
+	p.crc.Write(data[:n])
+	p.off += int64(n)
+	return n, err
+}
+
+//WritePack writes a pack containing the objects with the given ids
+//to w. Delta compression is used as configured in cfg. It returns
+//the entries for the pack index (in the order they were written)
+//and the checksum of the pack.
+func (repo *Repository) WritePack(w io.Writer, ids []ObjectID, cfg PackConfig) ([]PackIndexEntry, ObjectID, error) {
+	var packsum ObjectID
+
+	objs := make([]*packObject, 0, len(ids))
+	seen := make(map[ObjectID]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		otype, size, err := repo.statObject(id)
+		if err != nil {
+			return nil, packsum, fmt.Errorf("git: could not pack %s: %v", id, err)
+		}
+
+		objs = append(objs, &packObject{id: id, otype: otype, size: size})
+	}
+
+	if cfg.Window > 0 && cfg.Depth > 0 {
+		err := repo.findDeltas(objs, cfg)
+		if err != nil {
+			return nil, packsum, err
+		}
+	}
+
+	format := repo.ObjectFormat()
+	bw := bufio.NewWriter(w)
+	out := &packOutput{w: bw, sum: format.New(), crc: crc32.NewIEEE()}
+
+	var header [12]byte
+	copy(header[:], "PACK")
+	binary.BigEndian.PutUint32(header[4:], 2)
+	binary.BigEndian.PutUint32(header[8:], uint32(len(objs)))
+	if _, err := out.Write(header[:]); err != nil {
+		return nil, packsum, err
+	}
+
+	entries := make([]PackIndexEntry, 0, len(objs))
+	for _, obj := range objs {
+		written, err := repo.writePackObject(out, obj)
+		if err != nil {
+			return nil, packsum, err
+		}
+
+		for _, o := range written {
+			entries = append(entries, PackIndexEntry{o.id, o.offset, o.crc})
+		}
+	}
+
+	packsum = format.sum(out.sum)
+	if _, err := bw.Write(packsum.Bytes()); err != nil {
+		return nil, packsum, err
+	}
+
+	return entries, packsum, bw.Flush()
+}
+
+//findDeltas looks for delta bases for objs. Like git, objects are
+//sorted by type and size, so that each object is compared to the
+//slightly larger objects of the same type before it in the window.
+func (repo *Repository) findDeltas(objs []*packObject, cfg PackConfig) error {
+	sorted := make([]*packObject, len(objs))
+	copy(sorted, objs)
+	sort.Stable(packObjectsBySize(sorted))
+
+	type windowEntry struct {
+		obj *packObject
+		idx *deltaIndex
+	}
+
+	var window []windowEntry
+	for _, obj := range sorted {
+		if obj.size > cfg.MaxDeltaSize || obj.size < 64 {
+			continue
+		}
+
+		if len(window) > 0 && window[len(window)-1].obj.otype != obj.otype {
+			window = window[:0]
+		}
+
+		data, err := repo.readObjectData(obj.id)
+		if err != nil {
+			return err
+		}
+
+		//the delta must at least save half of the object, minus
+		//a bit for the delta header (the same heuristic git uses)
+		maxSize := len(data)/2 - 20
+		for i := len(window) - 1; i >= 0; i-- {
+			base := window[i]
+			if base.obj.depth >= cfg.Depth || maxSize <= 0 {
+				continue
+			}
+
+			//no chance if the sizes are too different
+			if diff := base.obj.size - obj.size; diff > int64(maxSize) || -diff > int64(maxSize) {
+				continue
+			}
+
+			delta := base.idx.encode(data, maxSize)
+			if delta == nil {
+				continue
+			}
+
+			obj.base, obj.delta, obj.depth = base.obj, delta, base.obj.depth+1
+			maxSize = len(delta) - 1
+		}
+
+		if len(window) == cfg.Window {
+			window = window[1:]
+		}
+		window = append(window, windowEntry{obj, newDeltaIndex(data)})
+	}
+
+	return nil
+}
+
+type packObjectsBySize []*packObject
+
+func (p packObjectsBySize) Len() int      { return len(p) }
+func (p packObjectsBySize) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p packObjectsBySize) Less(i, j int) bool {
+	if p[i].otype != p[j].otype {
+		return p[i].otype < p[j].otype
+	}
+	return p[i].size > p[j].size
+}
+
+//readObjectData reads the complete (resolved) data of an object.
+func (repo *Repository) readObjectData(id ObjectID) ([]byte, error) {
+	obj, err := repo.openObject(id)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+
+	data := make([]byte, obj.size)
+	_, err = io.ReadFull(obj.source, data)
+	return data, err
+}
+
+//appendPackHeader encodes type and size of an object entry.
+func appendPackHeader(out []byte, otype ObjectType, size int64) []byte {
+	b := byte(otype)<<4 | byte(size&0x0f)
+	size >>= 4
+
+	for size > 0 {
+		out = append(out, b|0x80)
+		b = byte(size & 0x7f)
+		size >>= 7
+	}
+
+	return append(out, b)
+}
+
+//appendOfsDelta encodes the (negative) offset to the base of a
+//delta, the inverse of readVarint.
+func appendOfsDelta(out []byte, off int64) []byte {
+	var buf [10]byte
+	n := len(buf) - 1
+
+	buf[n] = byte(off & 0x7f)
+	for off >>= 7; off > 0; off >>= 7 {
+		off--
+		n--
+		buf[n] = 0x80 | byte(off&0x7f)
+	}
+
+	return append(out, buf[n:]...)
+}
+
+//writePackObject writes obj to the pack, after writing its delta
+//base if that is not in the pack yet. It returns all objects that
+//were written.
+func (repo *Repository) writePackObject(out *packOutput, obj *packObject) ([]*packObject, error) {
+	if obj.written {
+		return nil, nil
+	}
+
+	var written []*packObject
+	if obj.base != nil && !obj.base.written {
+		objs, err := repo.writePackObject(out, obj.base)
+		if err != nil {
+			return nil, err
+		}
+		written = objs
+	}
+
+	obj.offset = out.off
+	out.crc.Reset()
+
+	var header []byte
+	var data io.Reader
+	if obj.base != nil {
+		header = appendPackHeader(header, ObjOFSDelta, int64(len(obj.delta)))
+		header = appendOfsDelta(header, obj.offset-obj.base.offset)
+		data = bytes.NewReader(obj.delta)
+	} else {
+		src, err := repo.openObject(obj.id)
+		if err != nil {
+			return nil, err
+		}
+		defer src.Close()
+
+		header = appendPackHeader(header, obj.otype, obj.size)
+		data = src.source
+	}
+
+	if _, err := out.Write(header); err != nil {
+		return nil, err
+	}
+
+	zw := zlib.NewWriter(out)
+	n, err := io.Copy(zw, data)
+	if err != nil {
+		return nil, err
+	} else if obj.base == nil && n != obj.size {
+		return nil, fmt.Errorf("git: size mismatch while packing %s", obj.id)
+	}
+
+	if err = zw.Close(); err != nil {
+		return nil, err
+	}
+
+	obj.crc = out.crc.Sum32()
+	obj.written = true
+	obj.delta = nil
+
+	return append(written, obj), nil
+}
+
+//WritePackIndex writes the version 2 index for a pack with the given
+//entries and checksum to w. It returns the checksum of the index.
+//The object format of the index is the one of packsum.
+func WritePackIndex(w io.Writer, entries []PackIndexEntry, packsum ObjectID) (ObjectID, error) {
+	var idxsum ObjectID
+	format := packsum.Format()
+
+	sorted := make([]PackIndexEntry, len(entries))
+	copy(sorted, entries)
+	sort.Sort(packIndexEntries(sorted))
+
+	h := format.New()
+	bw := bufio.NewWriter(w)
+	out := io.MultiWriter(bw, h)
+
+	var fo FanOut
+	for _, e := range sorted {
+		if e.ID.Format() != format {
+			return idxsum, fmt.Errorf("git: object %s is not a %s id", e.ID, format)
+		}
+		fo[e.ID.Bytes()[0]]++
+	}
+	for i := 1; i < len(fo); i++ {
+		fo[i] += fo[i-1]
+	}
+
+	write := func(data interface{}) error {
+		return binary.Write(out, binary.BigEndian, data)
+	}
+
+	if err := write([]byte{0377, 't', 'O', 'c'}); err != nil {
+		return idxsum, err
+	}
+
+	if err := write(uint32(2)); err != nil {
+		return idxsum, err
+	}
+
+	if err := write(fo); err != nil {
+		return idxsum, err
+	}
+
+	for _, e := range sorted {
+		if err := write(e.ID.Bytes()); err != nil {
+			return idxsum, err
+		}
+	}
+
+	for _, e := range sorted {
+		if err := write(e.CRC32); err != nil {
+			return idxsum, err
+		}
+	}
+
+	var large []int64
+	for _, e := range sorted {
+		off := uint32(e.Offset)
+		if e.Offset >= 0x80000000 {
+			off = 0x80000000 | uint32(len(large))
+			large = append(large, e.Offset)
+		}
+
+		if err := write(off); err != nil {
+			return idxsum, err
+		}
+	}
+
+	for _, off := range large {
+		if err := write(off); err != nil {
+			return idxsum, err
+		}
+	}
+
+	if err := write(packsum.Bytes()); err != nil {
+		return idxsum, err
+	}
+
+	idxsum = format.sum(h)
+	if _, err := bw.Write(idxsum.Bytes()); err != nil {
+		return idxsum, err
+	}
+
+	return idxsum, bw.Flush()
+}
+
+//CreatePack writes a new pack with the objects ids (and its index)
+//to the pack directory of the repository and returns its checksum,
+//which is also part of the file names.
+func (repo *Repository) CreatePack(ids []ObjectID, cfg PackConfig) (ObjectID, error) {
+	var packsum ObjectID
+	dir := filepath.Join(repo.Path, "objects", "pack")
+
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return packsum, err
+	}
+
+	pack, err := ioutil.TempFile(dir, "tmp_pack_")
+	if err != nil {
+		return packsum, err
+	}
+	defer os.Remove(pack.Name())
+	defer pack.Close()
+
+	entries, packsum, err := repo.WritePack(pack, ids, cfg)
+	if err != nil {
+		return packsum, err
+	}
+
+	idx, err := ioutil.TempFile(dir, "tmp_idx_")
+	if err != nil {
+		return packsum, err
+	}
+	defer os.Remove(idx.Name())
+	defer idx.Close()
+
+	if _, err = WritePackIndex(idx, entries, packsum); err != nil {
+		return packsum, err
+	}
+
+	return packsum, installPack(pack, idx, packsum)
+}
+
+//installPack syncs and closes the temporary files pack and idx
+//in the pack directory and renames them to their final names.
+//The index is moved into place last, so the pack is never
+//visible without its data.
+func installPack(pack, idx *os.File, packsum ObjectID) error {
+	base := filepath.Join(filepath.Dir(pack.Name()), fmt.Sprintf("pack-%s", packsum))
+	for _, f := range []struct {
+		fd   *os.File
+		name string
+	}{{pack, base + ".pack"}, {idx, base + ".idx"}} {
+
+		if err := f.fd.Sync(); err != nil {
+			return err
+		}
+
+		if err := f.fd.Close(); err != nil {
+			return err
+		}
+
+		if err := os.Chmod(f.fd.Name(), 0444); err != nil {
+			return err
+		}
+
+		//a pack with the same objects exists already
+		if _, err := os.Stat(f.name); err == nil {
+			continue
+		}
+
+		if err := os.Rename(f.fd.Name(), f.name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
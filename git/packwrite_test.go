@@ -0,0 +1,155 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDeltaEncode(t *testing.T) {
+	rnd := rand.New(rand.NewSource(42))
+
+	random := func(n int) []byte {
+		data := make([]byte, n)
+		rnd.Read(data)
+		return data
+	}
+
+	base := random(200000)
+	edited := append(append(append([]byte{}, base[:1000]...), random(500)...), base[1500:]...)
+	shuffled := append(append([]byte{}, base[150000:]...), base[:150000]...)
+
+	tests := []struct {
+		name   string
+		target []byte
+		delta  bool
+	}{
+		{"same", base, true},
+		{"edited", edited, true},
+		{"shuffled", shuffled, true},
+		{"prefix", base[:100000], true},
+		{"tiny", base[:10], false},
+		{"random", random(200000), false},
+	}
+
+	idx := newDeltaIndex(base)
+	for _, tt := range tests {
+		delta := idx.encode(tt.target, len(tt.target)/2)
+		if (delta != nil) != tt.delta {
+			t.Fatalf("%s: expected delta: %t, got %d bytes", tt.name, tt.delta, len(delta))
+		} else if delta == nil {
+			continue
+		}
+
+		d := &Delta{gitObject: gitObject{otype: ObjOFSDelta, source: ioutil.NopCloser(bytes.NewReader(delta))}}
+		if d.SizeSource, _ = readVarSize(d.source, 0); d.SizeSource != int64(len(base)) {
+			t.Fatalf("%s: source size %d, expected %d", tt.name, d.SizeSource, len(base))
+		}
+
+		if d.SizeTarget, _ = readVarSize(d.source, 0); d.SizeTarget != int64(len(tt.target)) {
+			t.Fatalf("%s: target size %d, expected %d", tt.name, d.SizeTarget, len(tt.target))
+		}
+
+		var out bytes.Buffer
+		if err := d.Patch(bytes.NewReader(base), &out); err != nil {
+			t.Fatalf("%s: Patch() => %v", tt.name, err)
+		}
+
+		if !bytes.Equal(out.Bytes(), tt.target) {
+			t.Fatalf("%s: patched data differs from target", tt.name)
+		}
+
+		t.Logf("%s: %d bytes -> %d bytes delta", tt.name, len(tt.target), len(delta))
+	}
+}
+
+func listObjects(tr *testRepo) []ObjectID {
+	var ids []ObjectID
+	for _, l := range strings.Split(tr.git("rev-list", "--objects", "--all"), "\n") {
+		id, err := ParseObjectID(strings.Fields(l)[0])
+		if err != nil {
+			tr.t.Fatalf("could not parse rev-list output %q: %v", l, err)
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func TestWritePack(t *testing.T) {
+	tr, _ := mkDeltaRepo(t, 20)
+	defer tr.cleanup()
+
+	tr.write("big/random.bin", string(bytes.Repeat([]byte("0123456789abcdef"), 10000)))
+	tr.write("small.txt", "small\n")
+	tr.commit("more files")
+	tr.git("tag", "-a", "-m", "a tag", "v1")
+
+	ids := listObjects(tr)
+
+	var chainRe = regexp.MustCompile(`(?m)^chain length = (\d+): (\d+) objects?$`)
+	for _, cfg := range []PackConfig{{}, DefaultPackConfig, {Window: 10, Depth: 3, MaxDeltaSize: 1 << 20}} {
+		packsum, err := tr.CreatePack(ids, cfg)
+		if err != nil {
+			t.Fatalf("%+v: CreatePack() => %v", cfg, err)
+		}
+
+		base := filepath.Join(tr.Path, "objects", "pack", fmt.Sprintf("pack-%s", packsum))
+		out := tr.git("verify-pack", "-v", base+".idx")
+
+		maxDepth, deltas := 0, 0
+		for _, m := range chainRe.FindAllStringSubmatch(out, -1) {
+			depth, _ := strconv.Atoi(m[1])
+			n, _ := strconv.Atoi(m[2])
+			deltas += n
+			if depth > maxDepth {
+				maxDepth = depth
+			}
+		}
+
+		if cfg.Window == 0 && deltas != 0 {
+			t.Fatalf("%+v: expected no deltas, got %d", cfg, deltas)
+		} else if cfg.Window > 0 && (deltas == 0 || maxDepth > cfg.Depth) {
+			t.Fatalf("%+v: %d deltas with max depth %d\n%s", cfg, deltas, maxDepth, out)
+		}
+		t.Logf("%+v: %d objects, %d deltas, max depth %d", cfg, len(ids), deltas, maxDepth)
+
+		// read back with our own code
+		idx, err := PackIndexOpen(base + ".idx")
+		if err != nil {
+			t.Fatalf("PackIndexOpen() => %v", err)
+		}
+
+		pf, err := idx.OpenPackFile()
+		if err != nil {
+			t.Fatalf("OpenPackFile() => %v", err)
+		}
+
+		if n := checkPackIndex(t, idx, pf, tr.Repository); n != len(ids) {
+			t.Fatalf("expected %d objects in pack, found %d", len(ids), n)
+		}
+		pf.Close()
+		idx.Close()
+
+		// the pack replaces everything else
+		for _, f := range []string{".pack", ".idx"} {
+			os.Rename(base+f, filepath.Join(tr.work, "keep"+f))
+		}
+		tr.git("repack", "-a", "-d", "-q")
+		tr.git("prune-packed")
+		os.RemoveAll(filepath.Join(tr.Path, "objects", "pack"))
+		os.MkdirAll(filepath.Join(tr.Path, "objects", "pack"), 0777)
+		for _, f := range []string{".pack", ".idx"} {
+			os.Rename(filepath.Join(tr.work, "keep"+f), base+f)
+		}
+
+		tr.git("fsck", "--strict", "--no-dangling")
+		tr.Close()
+	}
+}
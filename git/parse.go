@@ -139,13 +139,14 @@ func openRawObject(path string) (gitObject, error) {
 	return obj, nil
 }
 
-func parseObject(obj gitObject) (Object, error) {
+//parseObject parses obj, whose ids are of the given format.
+func parseObject(obj gitObject, format ObjectFormat) (Object, error) {
 	switch obj.otype {
 	case ObjCommit:
 		return parseCommit(obj)
 
 	case ObjTree:
-		return parseTree(obj)
+		return parseTree(obj, format)
 
 	case ObjBlob:
 		return parseBlob(obj)
@@ -172,9 +173,9 @@ func parseCommit(obj gitObject) (*Commit, error) {
 
 		switch head {
 		case "tree":
-			c.Tree, err = ParseSHA1(tail)
+			c.Tree, err = ParseObjectID(tail)
 		case "parent":
-			parent, err := ParseSHA1(tail)
+			parent, err := ParseObjectID(tail)
 			if err == nil {
 				c.Parent = append(c.Parent, parent)
 			}
@@ -182,7 +183,7 @@ func parseCommit(obj gitObject) (*Commit, error) {
 			c.Author, err = parseSignature(strings.Trim(tail, "\n"))
 		case "committer":
 			c.Committer, err = parseSignature(strings.Trim(tail, "\n"))
-		case "gpgsig":
+		case "gpgsig", "gpgsig-sha256":
 			sw := bytes.NewBufferString(strings.Trim(tail, "\n"))
 			err = parseCommitGPGSig(br, sw)
 			c.GPGSig = sw.String()
@@ -207,13 +208,13 @@ func parseCommit(obj gitObject) (*Commit, error) {
 	return c, nil
 }
 
-func parseTree(obj gitObject) (*Tree, error) {
-	tree := Tree{obj, nil, nil}
+func parseTree(obj gitObject, format ObjectFormat) (*Tree, error) {
+	tree := Tree{obj, format, nil, nil}
 	return &tree, nil
 }
 
-func parseTreeEntry(r io.Reader) (*TreeEntry, error) {
-	//format is: [mode{ASCII, octal}][space][name][\0][SHA1]
+func parseTreeEntry(r io.Reader, format ObjectFormat) (*TreeEntry, error) {
+	//format is: [mode{ASCII, octal}][space][name][\0][id]
 	entry := &TreeEntry{}
 
 	l, err := readUntilNul(r) // read until \0
@@ -233,20 +234,23 @@ func parseTreeEntry(r io.Reader) (*TreeEntry, error) {
 	// info bits by 16
 	entry.Mode = os.FileMode(mode)
 
-	if entry.Mode == 040000 {
+	switch entry.Mode {
+	case 040000:
 		entry.Type = ObjTree
-	} else {
+	case 0160000:
+		entry.Type = ObjCommit
+	default:
 		entry.Type = ObjBlob
 	}
 
 	entry.Name = name
 
-	n, err := r.Read(entry.ID[:])
+	entry.ID, err = readObjectID(r, format)
 
-	if err != nil && err != io.EOF {
-		return nil, err
-	} else if err == io.EOF && n != 20 {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
 		return nil, fmt.Errorf("git: unexpected EOF")
+	} else if err != nil {
+		return nil, err
 	}
 
 	return entry, nil
@@ -272,7 +276,7 @@ func parseTag(obj gitObject) (*Tag, error) {
 
 		switch head {
 		case "object":
-			c.Object, err = ParseSHA1(tail)
+			c.Object, err = ParseObjectID(tail)
 		case "type":
 			c.ObjType, err = ParseObjectType(tail)
 		case "tag":
@@ -55,7 +55,7 @@ tagger cgars <christian@stuebeweg50.de> 1479220880 +0100
 
 some annotation for tag3
 `
-	tobj, _       = ParseSHA1("920514e51fdb27a8fcedb036391570788f5a6234")
+	tobj, _       = ParseObjectID("920514e51fdb27a8fcedb036391570788f5a6234")
 	FakeSignedTag = Tag{
 		gitObject: gitObject{
 			otype:  ObjTag,
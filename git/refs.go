@@ -2,14 +2,13 @@ package git
 
 import (
 	"bufio"
-	"bytes"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
@@ -18,7 +17,7 @@ type Ref interface {
 	Name() string
 	Fullname() string
 	Namespace() string
-	Resolve() (SHA1, error)
+	Resolve() (ObjectID, error)
 }
 
 type ref struct {
@@ -39,6 +38,30 @@ func (r *ref) Fullname() string {
 	return fullname
 }
 
+//path returns the path of the ref relative to the
+//git directory, e.g. "refs/heads/master"
+func (r *ref) path() string {
+	switch r.ns {
+	case "#special":
+		return r.name
+	case "#branch":
+		return path.Join("refs", "heads", r.name)
+	}
+	return path.Join("refs", r.ns, r.name)
+}
+
+//RefPath returns the full path of the ref, e.g.
+//"refs/heads/master" for the branch "master".
+func RefPath(r Ref) string {
+	switch r := r.(type) {
+	case *IDRef:
+		return r.path()
+	case *SymbolicRef:
+		return r.path()
+	}
+	return r.Fullname()
+}
+
 func (r *ref) Repo() *Repository {
 	return r.repo
 }
@@ -55,15 +78,24 @@ func IsBranchRef(r Ref) bool {
 //a sha1 directly to a git object
 type IDRef struct {
 	ref
-	id SHA1
+	id ObjectID
+
+	peeled    ObjectID
+	hasPeeled bool
 }
 
 //Resolve for IDRef returns the stored object
-//id (SHA1)
-func (r *IDRef) Resolve() (SHA1, error) {
+//id (ObjectID)
+func (r *IDRef) Resolve() (ObjectID, error) {
 	return r.id, nil
 }
 
+//Peeled returns the id of the object an annotated tag
+//ultimately points to, if it was recorded in packed-refs.
+func (r *IDRef) Peeled() (ObjectID, bool) {
+	return r.peeled, r.hasPeeled
+}
+
 //SymbolicRef is a reference that points
 //to another reference
 type SymbolicRef struct {
@@ -72,19 +104,33 @@ type SymbolicRef struct {
 }
 
 //Resolve will resolve the symbolic reference into
-//an object id.
-func (r *SymbolicRef) Resolve() (SHA1, error) {
-	gdir := fmt.Sprintf("--git-dir=%s", r.repo.Path)
+//an object id, following chains of symbolic references.
+func (r *SymbolicRef) Resolve() (ObjectID, error) {
+	var id ObjectID
 
-	cmd := exec.Command("git", gdir, "rev-parse", r.Fullname())
-	body, err := cmd.Output()
+	seen := map[string]bool{r.path(): true}
+	chain := []string{r.path()}
 
-	if err != nil {
-		var id SHA1
-		return id, err
+	target := r.Symbol
+	for !seen[target] {
+		seen[target] = true
+		chain = append(chain, target)
+
+		next, err := r.repo.parseRef(target)
+		if err != nil {
+			return id, err
+		}
+
+		sym, ok := next.(*SymbolicRef)
+		if !ok {
+			return next.Resolve()
+		}
+
+		target = sym.Symbol
 	}
 
-	return ParseSHA1(string(body))
+	chain = append(chain, target)
+	return id, fmt.Errorf("git: symbolic ref cycle: %s", strings.Join(chain, " -> "))
 }
 
 func parseRefName(filename string) (name, ns string, err error) {
@@ -120,7 +166,45 @@ func parseRefName(filename string) (name, ns string, err error) {
 	return
 }
 
+//isValidRefName checks the name according to the rules of
+//git-check-ref-format(1), which also makes sure that the name
+//can not point outside of the refs in the git directory.
+func isValidRefName(name string) bool {
+	if name == "" || name == "@" || strings.Contains(name, "..") ||
+		strings.Contains(name, "@{") || strings.HasSuffix(name, ".") {
+		return false
+	}
+
+	for _, comp := range strings.Split(name, "/") {
+		if comp == "" || strings.HasPrefix(comp, ".") || strings.HasSuffix(comp, ".lock") {
+			return false
+		}
+	}
+
+	for _, c := range name {
+		if c < 040 || c == 0177 || strings.ContainsRune(" ~^:?*[\\", c) {
+			return false
+		}
+	}
+
+	return true
+}
+
 func (repo *Repository) parseRef(filename string) (Ref, error) {
+	r, err := repo.readLooseRef(filename)
+	if os.IsNotExist(err) {
+		return repo.findPackedRef(filename)
+	}
+	return r, err
+}
+
+//readLooseRef reads the ref stored in the file with the
+//given name (relative to the git directory).
+func (repo *Repository) readLooseRef(filename string) (Ref, error) {
+
+	if !isValidRefName(filename) {
+		return nil, fmt.Errorf("git: invalid ref name: %q", filename)
+	}
 
 	name, ns, err := parseRefName(filename)
 	if err != nil {
@@ -130,11 +214,8 @@ func (repo *Repository) parseRef(filename string) (Ref, error) {
 	base := ref{repo, name, ns}
 
 	//now to the actual contents of the ref
-	data, err := ioutil.ReadFile(filepath.Join(repo.Path, filename))
+	data, err := ioutil.ReadFile(filepath.Join(repo.Path, filepath.FromSlash(filename)))
 	if err != nil {
-		if os.IsNotExist(err) {
-			return repo.findPackedRef(base.Fullname())
-		}
 		return nil, err
 	}
 
@@ -144,44 +225,113 @@ func (repo *Repository) parseRef(filename string) (Ref, error) {
 		return &SymbolicRef{base, trimmed}, nil
 	}
 
-	id, err := ParseSHA1(b)
+	id, err := ParseObjectID(strings.TrimSpace(b))
 	if err == nil {
-		return &IDRef{base, id}, nil
+		return &IDRef{ref: base, id: id}, nil
 	}
 
 	return nil, fmt.Errorf("git: unknown ref type: %q", b)
 }
 
-func (repo *Repository) listRefWithName(name string) (res []Ref) {
-	gdir := fmt.Sprintf("--git-dir=%s", repo.Path)
-	cmd := exec.Command("git", gdir, "show-ref", name)
-	body, err := cmd.Output()
+//ListRefs returns all refs whose path (e.g. "refs/tags/v1.0")
+//starts with prefix, sorted by their path. Loose refs take
+//precedence over packed refs with the same name.
+func (repo *Repository) ListRefs(prefix string) ([]Ref, error) {
+	refs := make(map[string]Ref)
+
+	//like git, loose refs are read before packed-refs: a ref
+	//that is packed concurrently is written to packed-refs
+	//before its loose file is removed, so it is seen in one
+	//of the two (or both)
+	root := filepath.Join(repo.Path, "refs")
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			//no refs at all, or removed (packed) since
+			//the directory was read
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		rel, err := filepath.Rel(repo.Path, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+
+		if info.IsDir() {
+			//no need to descend if nothing in it can match
+			if !strings.HasPrefix(name+"/", prefix) && !strings.HasPrefix(prefix, name+"/") {
+				return filepath.SkipDir
+			}
+			return nil
+		} else if strings.HasSuffix(name, ".lock") || !strings.HasPrefix(name, prefix) {
+			return nil
+		}
+
+		r, err := repo.readLooseRef(name)
+		if os.IsNotExist(err) {
+			//deleted (or packed) while we were looking
+			return nil
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "git: could not parse ref with name %q: %v\n", name, err)
+			return nil
+		}
+
+		refs[name] = r
+		return nil
+	})
 
 	if err != nil {
-		return
+		return nil, err
 	}
 
-	r := bytes.NewBuffer(body)
+	packed, err := repo.loadPackedRefs()
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
 
-	for {
-		var l string
-		l, err = r.ReadString('\n')
-		if err != nil {
-			break
+	for _, r := range packed {
+		if _, ok := refs[RefPath(r)]; !ok {
+			refs[RefPath(r)] = r
 		}
+	}
 
-		_, name := split2(l[:len(l)-1], " ")
-		r, err := repo.parseRef(name)
-
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "git: could not parse ref with name %q: %v", name, err)
-			continue
+	var names []string
+	for name := range refs {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
 		}
+	}
+	sort.Strings(names)
 
-		res = append(res, r)
+	res := make([]Ref, len(names))
+	for i, name := range names {
+		res[i] = refs[name]
 	}
 
-	return
+	return res, nil
+}
+
+//listRefWithName returns all refs that match name like
+//git show-ref does, i.e. name must match the complete path
+//or the trailing path components of the ref.
+func (repo *Repository) listRefWithName(name string) ([]Ref, error) {
+	refs, err := repo.ListRefs("refs/")
+	if err != nil {
+		return nil, err
+	}
+
+	var res []Ref
+	for _, r := range refs {
+		p := RefPath(r)
+		if p == name || strings.HasSuffix(p, "/"+name) {
+			res = append(res, r)
+		}
+	}
+
+	return res, nil
 }
 
 func (repo *Repository) loadPackedRefs() ([]Ref, error) {
@@ -195,6 +345,7 @@ func (repo *Repository) loadPackedRefs() ([]Ref, error) {
 	r := bufio.NewReader(fd)
 
 	var refs []Ref
+	var last *IDRef
 	for {
 		var l string
 		l, err = r.ReadString('\n')
@@ -202,26 +353,38 @@ func (repo *Repository) loadPackedRefs() ([]Ref, error) {
 			break
 		}
 
-		head, tail := split2(l, " ")
+		if strings.HasPrefix(l, "#") {
+			//header, e.g. "# pack-refs with: peeled fully-peeled sorted"
+			continue
+		} else if strings.HasPrefix(l, "^") {
+			//peeled id of the preceding (tag) ref
+			id, err := ParseObjectID(strings.TrimSpace(l[1:]))
+			if err == nil && last != nil {
+				last.peeled, last.hasPeeled = id, true
+			}
+			continue
+		}
+
+		last = nil
+		head, tail := split2(strings.TrimRight(l, "\n"), " ")
 		if tail == "" {
-			//probably a peeled id (i.e. "^SHA1")
-			//TODO: do something with it
 			continue
 		}
 
-		name, ns, err := parseRefName(tail[:len(tail)-1])
+		name, ns, err := parseRefName(tail)
 		if err != nil {
 			//TODO: log error, panic?
 			continue
 		}
 
-		id, err := ParseSHA1(head)
+		id, err := ParseObjectID(head)
 		if err != nil {
 			//TODO: same as above
 			continue
 		}
 
-		refs = append(refs, &IDRef{ref{repo, name, ns}, id})
+		last = &IDRef{ref: ref{repo, name, ns}, id: id}
+		refs = append(refs, last)
 	}
 
 	if err != nil && err != io.EOF {
@@ -238,9 +401,39 @@ func (repo *Repository) findPackedRef(name string) (Ref, error) {
 	}
 
 	for _, ref := range refs {
-		if ref.Fullname() == name {
+		if RefPath(ref) == name {
 			return ref, nil
 		}
 	}
-	return nil, fmt.Errorf("ref with name %q not found", name)
+	return nil, fmt.Errorf("git: ref with name %q not found", name)
+}
+
+//PeelRef resolves the ref and follows annotated tags until
+//it reaches a non-tag object, whose id is returned.
+func (repo *Repository) PeelRef(r Ref) (ObjectID, error) {
+	if idref, ok := r.(*IDRef); ok {
+		if id, ok := idref.Peeled(); ok {
+			return id, nil
+		}
+	}
+
+	id, err := r.Resolve()
+	if err != nil {
+		return id, err
+	}
+
+	for {
+		obj, err := repo.OpenObject(id)
+		if err != nil {
+			return id, err
+		}
+
+		tag, ok := obj.(*Tag)
+		obj.Close()
+		if !ok {
+			return id, nil
+		}
+
+		id = tag.Object
+	}
 }
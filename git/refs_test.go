@@ -0,0 +1,189 @@
+package git
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func refPaths(refs []Ref) []string {
+	var paths []string
+	for _, r := range refs {
+		paths = append(paths, RefPath(r))
+	}
+	return paths
+}
+
+func TestListRefs(t *testing.T) {
+	tr := mkTestRepo(t)
+	defer tr.cleanup()
+
+	first := tr.commit("first")
+	tr.git("tag", "-a", "-m", "annotated", "v1.0")
+	tr.git("tag", "light")
+	tr.git("branch", "feature/a")
+	tr.git("pack-refs", "--all", "--prune")
+
+	second := tr.commit("second")
+	tr.git("branch", "other")
+
+	refs, err := tr.ListRefs("refs/")
+	if err != nil {
+		t.Fatalf("ListRefs(\"refs/\") => %v", err)
+	}
+
+	expected := "refs/heads/feature/a refs/heads/master refs/heads/other refs/tags/light refs/tags/v1.0"
+	if got := strings.Join(refPaths(refs), " "); got != expected {
+		t.Fatalf("ListRefs: expected %q, got %q", expected, got)
+	}
+
+	for _, r := range refs {
+		id, err := r.Resolve()
+		if err != nil {
+			t.Fatalf("%s: Resolve() => %v", RefPath(r), err)
+		}
+
+		// the loose ref (second) takes precedence over the packed one (first)
+		var want ObjectID
+		switch RefPath(r) {
+		case "refs/heads/master", "refs/heads/other":
+			want = second
+		case "refs/tags/v1.0":
+			want = tr.revParse("v1.0")
+		default:
+			want = first
+		}
+
+		if id != want {
+			t.Fatalf("%s: expected %s, got %s", RefPath(r), want, id)
+		}
+	}
+
+	tags, err := tr.ListRefs("refs/tags/")
+	if err != nil || len(tags) != 2 {
+		t.Fatalf("ListRefs(\"refs/tags/\") => %v, %v", refPaths(tags), err)
+	}
+
+	// the annotated tag has a peeled value in packed-refs
+	peeled, ok := tags[1].(*IDRef).Peeled()
+	if !ok || peeled != first {
+		t.Fatalf("v1.0: expected peeled %s, got %s (%t)", first, peeled, ok)
+	}
+
+	if _, ok := tags[0].(*IDRef).Peeled(); ok {
+		t.Fatalf("light: unexpected peeled value")
+	}
+
+	for _, r := range tags {
+		id, err := tr.PeelRef(r)
+		if err != nil || id != first {
+			t.Fatalf("PeelRef(%s) => %s, %v", RefPath(r), id, err)
+		}
+	}
+
+	ref, err := tr.OpenRef("feature/a")
+	if err != nil || RefPath(ref) != "refs/heads/feature/a" {
+		t.Fatalf("OpenRef(\"feature/a\") => %v, %v", ref, err)
+	}
+
+	if ok, err := tr.BranchExists("other"); !ok || err != nil {
+		t.Fatalf("BranchExists(\"other\") => %t, %v", ok, err)
+	}
+
+	if ok, err := tr.BranchExists("nope"); ok || err != nil {
+		t.Fatalf("BranchExists(\"nope\") => %t, %v", ok, err)
+	}
+}
+
+func TestListRefsWhilePacking(t *testing.T) {
+	tr := mkTestRepo(t)
+	defer tr.cleanup()
+
+	id := tr.commit("first")
+
+	for round := 0; round < 20; round++ {
+		for i := 0; i < 10; i++ {
+			name := filepath.Join(tr.Path, "refs", "heads", fmt.Sprintf("b-%d-%d", round, i))
+			if err := ioutil.WriteFile(name, []byte(id.String()+"\n"), 0666); err != nil {
+				t.Fatal(err)
+			}
+		}
+		expected := 1 + (round+1)*10
+
+		done := make(chan error)
+		go func() {
+			m := &maintenance{repo: tr.Repository, report: &MaintenanceReport{}}
+			done <- m.packRefs()
+		}()
+
+		for packing := true; packing; {
+			select {
+			case err := <-done:
+				if err != nil {
+					t.Fatalf("packRefs() => %v", err)
+				}
+				packing = false
+			default:
+			}
+
+			//every ref is either loose or packed (or both)
+			refs, err := tr.ListRefs("refs/heads/")
+			if err != nil {
+				t.Fatalf("ListRefs() => %v", err)
+			} else if len(refs) != expected {
+				t.Fatalf("round %d: expected %d refs, got %d", round, expected, len(refs))
+			}
+		}
+	}
+}
+
+func TestSymbolicRefs(t *testing.T) {
+	tr := mkTestRepo(t)
+	defer tr.cleanup()
+
+	id := tr.commit("first")
+	tr.git("symbolic-ref", "refs/heads/alias", "refs/heads/master")
+	tr.git("symbolic-ref", "refs/heads/alias2", "refs/heads/alias")
+
+	for _, name := range []string{"HEAD", "alias", "alias2"} {
+		ref, err := tr.OpenRef(name)
+		if err != nil {
+			t.Fatalf("OpenRef(%q) => %v", name, err)
+		}
+
+		if _, ok := ref.(*SymbolicRef); !ok {
+			t.Fatalf("%s: expected symbolic ref, got %T", name, ref)
+		}
+
+		got, err := ref.Resolve()
+		if err != nil || got != id {
+			t.Fatalf("%s: Resolve() => %s, %v; expected %s", name, got, err, id)
+		}
+	}
+
+	// a -> b -> a
+	for _, name := range []string{"a", "b"} {
+		target := "ref: refs/heads/b\n"
+		if name == "b" {
+			target = "ref: refs/heads/a\n"
+		}
+
+		path := filepath.Join(tr.Path, "refs", "heads", name)
+		if err := ioutil.WriteFile(path, []byte(target), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ref, err := tr.OpenRef("a")
+	if err != nil {
+		t.Fatalf("OpenRef(\"a\") => %v", err)
+	}
+
+	_, err = ref.Resolve()
+	if err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Fatalf("expected cycle error, got: %v", err)
+	}
+	t.Logf("cycle: %v", err)
+}
@@ -0,0 +1,391 @@
+package git
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//Timeouts for acquiring locks, the same defaults git uses
+//(core.filesRefLockTimeout, core.packedRefsTimeout).
+const (
+	refLockTimeout        = 100 * time.Millisecond
+	packedRefsLockTimeout = 1000 * time.Millisecond
+)
+
+//RefMismatchError is returned by UpdateRef and DeleteRef if the
+//ref does not have the expected value. A zero id means that the
+//ref does not exist.
+type RefMismatchError struct {
+	Name     string
+	Expected ObjectID
+	Actual   ObjectID
+}
+
+func (e *RefMismatchError) Error() string {
+	if e.Actual.IsZero() {
+		return fmt.Sprintf("git: ref %s does not exist, expected %s", e.Name, e.Expected)
+	} else if e.Expected.IsZero() {
+		return fmt.Sprintf("git: ref %s already exists (at %s)", e.Name, e.Actual)
+	}
+	return fmt.Sprintf("git: ref %s is at %s, expected %s", e.Name, e.Actual, e.Expected)
+}
+
+//lockFile creates the lock file path exclusively, retrying until
+//timeout if it exists already. The returned error can be checked
+//with os.IsExist if the file was locked by someone else.
+func lockFile(path string, timeout time.Duration) (*os.File, error) {
+	deadline := time.Now().Add(timeout)
+	backoff := time.Millisecond
+
+	for {
+		fd, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if err == nil || !os.IsExist(err) || time.Now().After(deadline) {
+			return fd, err
+		}
+
+		time.Sleep(backoff)
+		if backoff < 50*time.Millisecond {
+			backoff *= 2
+		}
+	}
+}
+
+//checkUpdateRefName makes sure name is a full, valid ref name
+//below "refs/" that does not conflict with existing refs, i.e.
+//there is no "refs/heads/a" if name is "refs/heads/a/b" and vice
+//versa.
+func (repo *Repository) checkUpdateRefName(name string) error {
+	if !strings.HasPrefix(name, "refs/") || !isValidRefName(name) {
+		return fmt.Errorf("git: invalid ref name: %q", name)
+	}
+
+	return repo.checkRefConflicts(name)
+}
+
+//checkRefConflicts returns an error if there is a ref, or a locked
+//ref that is about to be created, that conflicts with name. It must
+//be called again once name is locked, since a conflicting ref could
+//have been created in the meantime.
+func (repo *Repository) checkRefConflicts(name string) error {
+	refs, err := repo.ListRefs("refs/")
+	if err != nil {
+		return err
+	}
+
+	for _, r := range refs {
+		other := RefPath(r)
+		if strings.HasPrefix(other, name+"/") || strings.HasPrefix(name, other+"/") {
+			return fmt.Errorf("git: ref %s conflicts with existing ref %s", name, other)
+		}
+	}
+
+	//locks of refs that are being created: "refs/heads/a.lock" for
+	//name "refs/heads/a/b" and "refs/heads/a/b.lock" for "refs/heads/a"
+	for dir := path.Dir(name); dir != "refs"; dir = path.Dir(dir) {
+		lock := filepath.Join(repo.Path, filepath.FromSlash(dir)) + ".lock"
+		if _, err := os.Stat(lock); err == nil {
+			return fmt.Errorf("git: ref %s conflicts with locked ref %s", name, dir)
+		}
+	}
+
+	root := filepath.Join(repo.Path, filepath.FromSlash(name))
+	var locked string
+	filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil || locked != "" {
+			return filepath.SkipDir
+		} else if !info.IsDir() && strings.HasSuffix(p, ".lock") {
+			rel, _ := filepath.Rel(repo.Path, strings.TrimSuffix(p, ".lock"))
+			locked = filepath.ToSlash(rel)
+		}
+		return nil
+	})
+
+	if locked != "" {
+		return fmt.Errorf("git: ref %s conflicts with locked ref %s", name, locked)
+	}
+
+	return nil
+}
+
+//readRefValue returns the current value of the ref while it is
+//locked; the zero id if it does not exist.
+func (repo *Repository) readRefValue(name string) (ObjectID, error) {
+	id := repo.ObjectFormat().Zero()
+
+	ref, err := repo.readLooseRef(name)
+	if os.IsNotExist(err) {
+		packed, err := repo.loadPackedRefs()
+		if err != nil && !os.IsNotExist(err) {
+			return id, err
+		}
+
+		for _, r := range packed {
+			if RefPath(r) == name {
+				return r.Resolve()
+			}
+		}
+		return id, nil
+	} else if err != nil {
+		return id, err
+	}
+
+	if _, ok := ref.(*SymbolicRef); ok {
+		return id, fmt.Errorf("git: %s is a symbolic ref", name)
+	}
+
+	return ref.Resolve()
+}
+
+//UpdateRef sets the ref name (e.g. "refs/heads/master") to the
+//object new, if it currently points to old, where the zero id
+//for old means that the ref must not exist yet. The ref is locked
+//during the update (in a way that is compatible with git itself)
+//and a reflog entry with the given signature and message is
+//added. If the ref is currently locked the returned error can be
+//checked with os.IsExist; a *RefMismatchError is returned if the
+//ref did not point to old.
+func (repo *Repository) UpdateRef(name string, old, new ObjectID, who Signature, msg string) error {
+	if new.IsZero() {
+		return fmt.Errorf("git: can not update %s to the zero id, use DeleteRef", name)
+	} else if old.IsZero() {
+		//the zero id of the object format, for the reflog
+		old = repo.ObjectFormat().Zero()
+	}
+
+	if err := repo.checkUpdateRefName(name); err != nil {
+		return err
+	}
+
+	if !repo.hasObject(new) {
+		return fmt.Errorf("git: can not update %s to %s: object not found", name, new)
+	}
+
+	who, err := checkSignature(who, time.Now())
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(repo.Path, filepath.FromSlash(name))
+	lock, err := lockRef(path)
+	if err != nil {
+		return err
+	}
+
+	//removing the lock file is a no-op after the rename
+	defer os.Remove(lock.Name())
+	defer lock.Close()
+
+	if err = repo.checkRefConflicts(name); err != nil {
+		return err
+	}
+
+	current, err := repo.readRefValue(name)
+	if err != nil {
+		return err
+	} else if current != old {
+		return &RefMismatchError{Name: name, Expected: old, Actual: current}
+	}
+
+	if _, err = fmt.Fprintf(lock, "%s\n", new); err != nil {
+		return err
+	}
+
+	if err = lock.Sync(); err != nil {
+		return err
+	}
+
+	if err = lock.Close(); err != nil {
+		return err
+	}
+
+	if err = repo.appendReflog(name, old, new, who, msg); err != nil {
+		return err
+	}
+
+	//an empty directory left behind by a deleted ref "name/..."
+	if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+		os.Remove(path)
+	}
+
+	return os.Rename(lock.Name(), path)
+}
+
+//DeleteRef deletes the ref name, both the loose ref and its entry
+//in packed-refs, if it currently points to old. The zero id for
+//old skips that check. The reflog of the ref is removed as well.
+func (repo *Repository) DeleteRef(name string, old ObjectID) error {
+	if !strings.HasPrefix(name, "refs/") || !isValidRefName(name) {
+		return fmt.Errorf("git: invalid ref name: %q", name)
+	}
+
+	path := filepath.Join(repo.Path, filepath.FromSlash(name))
+	lock, err := lockRef(path)
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(lock.Name())
+	defer lock.Close()
+
+	current, err := repo.readRefValue(name)
+	if err != nil {
+		return err
+	} else if current.IsZero() {
+		return &RefMismatchError{Name: name, Expected: old}
+	} else if !old.IsZero() && current != old {
+		return &RefMismatchError{Name: name, Expected: old, Actual: current}
+	}
+
+	if err = repo.removePackedRef(name); err != nil {
+		return err
+	}
+
+	if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	logpath := filepath.Join(repo.Path, "logs", filepath.FromSlash(name))
+	err = os.Remove(logpath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	//the lock is still in the ref's directory, so that can only
+	//be pruned after it is gone
+	os.Remove(lock.Name())
+
+	//keep the namespace directories, e.g. "refs/heads"
+	ns := strings.Join(strings.SplitN(name, "/", 3)[:2], "/")
+	pruneEmptyDirs(filepath.Dir(path), filepath.Join(repo.Path, filepath.FromSlash(ns)))
+	pruneEmptyDirs(filepath.Dir(logpath), filepath.Join(repo.Path, "logs", filepath.FromSlash(ns)))
+
+	return nil
+}
+
+//lockRef creates the lock file for the ref at path, including the
+//directories leading to it.
+func lockRef(path string) (*os.File, error) {
+	var lock *os.File
+	var err error
+
+	//the directory might get pruned by a concurrent delete
+	//of another ref in between, so we try again once
+	for i := 0; i < 2; i++ {
+		if err = os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			return nil, err
+		}
+
+		lock, err = lockFile(path+".lock", refLockTimeout)
+		if !os.IsNotExist(err) {
+			break
+		}
+	}
+
+	return lock, err
+}
+
+//pruneEmptyDirs removes dir and its parents up to (but not
+//including) root, as long as they are empty.
+func pruneEmptyDirs(dir, root string) {
+	for strings.HasPrefix(dir, root+string(filepath.Separator)) {
+		if os.Remove(dir) != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+//removePackedRef rewrites packed-refs without the ref name (and
+//its peeled value).
+func (repo *Repository) removePackedRef(name string) error {
+	path := filepath.Join(repo.Path, "packed-refs")
+
+	lock, err := lockFile(path+".lock", packedRefsLockTimeout)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(lock.Name())
+	defer lock.Close()
+
+	fd, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	r := bufio.NewReader(fd)
+	w := bufio.NewWriter(lock)
+
+	found, dropping := false, false
+	for {
+		l, err := r.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		} else if l == "" {
+			break
+		}
+
+		if strings.HasPrefix(l, "^") && dropping {
+			continue
+		}
+
+		_, refname := split2(strings.TrimRight(l, "\n"), " ")
+		dropping = refname == name && !strings.HasPrefix(l, "#") && !strings.HasPrefix(l, "^")
+		if dropping {
+			found = true
+			continue
+		}
+
+		if _, err = w.WriteString(l); err != nil {
+			return err
+		}
+	}
+
+	if !found {
+		return nil
+	}
+
+	if err = w.Flush(); err != nil {
+		return err
+	}
+
+	if err = lock.Sync(); err != nil {
+		return err
+	}
+
+	if err = lock.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(lock.Name(), path)
+}
+
+//appendReflog adds an entry to the reflog of the ref name.
+func (repo *Repository) appendReflog(name string, old, new ObjectID, who Signature, msg string) error {
+	path := filepath.Join(repo.Path, "logs", filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+
+	fd, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+
+	msg = strings.TrimSpace(strings.Replace(msg, "\n", " ", -1))
+	_, err = fmt.Fprintf(fd, "%s %s %s\t%s\n", old, new, who, msg)
+
+	if cerr := fd.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
@@ -0,0 +1,235 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUpdateRef(t *testing.T) {
+	tr := mkTestRepo(t)
+	defer tr.cleanup()
+
+	first := tr.commit("first")
+	second := tr.commit("second")
+
+	var zero ObjectID
+	who := NewSignature("C O Mitter", "committer@example.com", time.Unix(1480000000, 0).In(time.FixedZone("", 3600)))
+
+	// create
+	err := tr.UpdateRef("refs/heads/feature", zero, first, who, "branch: Created from first")
+	if err != nil {
+		t.Fatalf("UpdateRef(create) => %v", err)
+	}
+
+	if id := tr.revParse("refs/heads/feature"); id != first {
+		t.Fatalf("feature: expected %s, got %s", first, id)
+	}
+
+	// create again must fail
+	err = tr.UpdateRef("refs/heads/feature", zero, second, who, "again")
+	if _, ok := err.(*RefMismatchError); !ok {
+		t.Fatalf("UpdateRef(create existing) => %v, expected mismatch", err)
+	}
+
+	// wrong old value
+	err = tr.UpdateRef("refs/heads/feature", second, second, who, "wrong old")
+	if _, ok := err.(*RefMismatchError); !ok {
+		t.Fatalf("UpdateRef(wrong old) => %v, expected mismatch", err)
+	}
+	t.Logf("%v", err)
+
+	// fast-forward
+	err = tr.UpdateRef("refs/heads/feature", first, second, who, "update:\nfast-forward")
+	if err != nil {
+		t.Fatalf("UpdateRef(update) => %v", err)
+	}
+
+	reflog := tr.git("reflog", "show", "--format=%H %gs", "refs/heads/feature")
+	expected := second.String() + " update: fast-forward\n" + first.String() + " branch: Created from first"
+	if reflog != expected {
+		t.Fatalf("unexpected reflog:\n%s\nexpected:\n%s", reflog, expected)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(tr.Path, "logs", "refs", "heads", "feature"))
+	if err != nil || !strings.Contains(string(data), "C O Mitter <committer@example.com> 1480000000 +0100\t") {
+		t.Fatalf("unexpected reflog entry: %q, %v", data, err)
+	}
+
+	// invalid names and conflicts
+	for _, name := range []string{"HEAD", "refs/heads/feature/sub", "refs/heads/a..b", "refs/heads/x.lock", "heads/x"} {
+		if err := tr.UpdateRef(name, zero, first, who, "bad"); err == nil {
+			t.Fatalf("UpdateRef(%q) => success, expected error", name)
+		}
+	}
+
+	missing, _ := ParseObjectID("42" + strings.Repeat("0", 38))
+	if err := tr.UpdateRef("refs/heads/missing", zero, missing, who, "bad"); err == nil {
+		t.Fatalf("UpdateRef(to missing object) => success, expected error")
+	}
+
+	// a locked ref
+	lock := filepath.Join(tr.Path, "refs", "heads", "feature.lock")
+	if err := ioutil.WriteFile(lock, nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	err = tr.UpdateRef("refs/heads/feature", second, first, who, "locked")
+	if !os.IsExist(err) {
+		t.Fatalf("UpdateRef(locked) => %v, expected exists error", err)
+	}
+	os.Remove(lock)
+
+	// refs that are locked while being created conflict as well
+	locks := map[string]string{
+		"refs/heads/topic/sub": "refs/heads/topic.lock",
+		"refs/heads/wip":       "refs/heads/wip/x.lock",
+	}
+	for name, l := range locks {
+		lock := filepath.Join(tr.Path, filepath.FromSlash(l))
+		os.MkdirAll(filepath.Dir(lock), 0777)
+		if err := ioutil.WriteFile(lock, nil, 0666); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := tr.UpdateRef(name, zero, first, who, "locked"); err == nil {
+			t.Fatalf("UpdateRef(%q) with %s => success, expected conflict", name, l)
+		}
+
+		os.Remove(lock)
+		pruneEmptyDirs(filepath.Dir(lock), filepath.Join(tr.Path, "refs", "heads"))
+		if err := tr.UpdateRef(name, zero, first, who, "unlocked"); err != nil {
+			t.Fatalf("UpdateRef(%q) => %v", name, err)
+		}
+		tr.DeleteRef(name, first)
+	}
+
+	tr.git("fsck", "--no-dangling")
+}
+
+func TestUpdatePackedRef(t *testing.T) {
+	tr := mkTestRepo(t)
+	defer tr.cleanup()
+
+	first := tr.commit("first")
+	tr.git("branch", "packed")
+	tr.git("tag", "-a", "-m", "tag", "v1")
+	tr.git("branch", "other")
+	tr.git("pack-refs", "--all", "--prune")
+	second := tr.commit("second")
+
+	who := NewSignature("C O Mitter", "committer@example.com", time.Now())
+
+	// update a ref that only exists in packed-refs
+	if err := tr.UpdateRef("refs/heads/packed", second, first, who, "x"); err == nil {
+		t.Fatalf("UpdateRef(packed, wrong old) => success")
+	}
+
+	if err := tr.UpdateRef("refs/heads/packed", first, second, who, "update packed"); err != nil {
+		t.Fatalf("UpdateRef(packed) => %v", err)
+	}
+
+	if id := tr.revParse("refs/heads/packed"); id != second {
+		t.Fatalf("packed: expected %s, got %s", second, id)
+	}
+
+	// delete: packed-only, then loose and packed
+	var zero ObjectID
+	if err := tr.DeleteRef("refs/heads/other", second); err == nil {
+		t.Fatalf("DeleteRef(wrong old) => success")
+	}
+
+	for _, name := range []string{"refs/heads/other", "refs/heads/packed"} {
+		if err := tr.DeleteRef(name, zero); err != nil {
+			t.Fatalf("DeleteRef(%q) => %v", name, err)
+		}
+	}
+
+	refs := tr.git("show-ref")
+	if strings.Contains(refs, "refs/heads/other") || strings.Contains(refs, "refs/heads/packed") {
+		t.Fatalf("refs still there after delete:\n%s", refs)
+	}
+
+	// the peeled value of the tag must have survived
+	packed, err := ioutil.ReadFile(filepath.Join(tr.Path, "packed-refs"))
+	if err != nil || !strings.Contains(string(packed), "refs/tags/v1\n^"+first.String()) {
+		t.Fatalf("unexpected packed-refs:\n%s", packed)
+	}
+
+	if err := tr.DeleteRef("refs/heads/other", zero); err == nil {
+		t.Fatalf("DeleteRef(deleted) => success")
+	}
+
+	// directories of deleted refs do not get in the way
+	if err := tr.UpdateRef("refs/heads/a/b", zero, first, who, "nested"); err != nil {
+		t.Fatalf("UpdateRef(a/b) => %v", err)
+	}
+
+	if err := tr.DeleteRef("refs/heads/a/b", first); err != nil {
+		t.Fatalf("DeleteRef(a/b) => %v", err)
+	}
+
+	if err := tr.UpdateRef("refs/heads/a", zero, first, who, "not nested"); err != nil {
+		t.Fatalf("UpdateRef(a) => %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(tr.Path, "refs", "heads")); err != nil {
+		t.Fatalf("refs/heads is gone: %v", err)
+	}
+
+	tr.git("fsck", "--no-dangling")
+}
+
+func TestUpdateRefConcurrent(t *testing.T) {
+	tr := mkTestRepo(t)
+	defer tr.cleanup()
+
+	base := tr.commit("base")
+
+	var candidates []ObjectID
+	for i := 0; i < 8; i++ {
+		tr.git("reset", "-q", "--hard", base.String())
+		candidates = append(candidates, tr.commit("candidate"+string('a'+rune(i))))
+	}
+
+	tr.git("update-ref", "refs/heads/race", base.String())
+	who := NewSignature("C O Mitter", "committer@example.com", time.Now())
+
+	var wg sync.WaitGroup
+	results := make(chan ObjectID, len(candidates))
+	for _, c := range candidates {
+		wg.Add(1)
+		go func(c ObjectID) {
+			defer wg.Done()
+			// retry while locked, as a client would
+			for {
+				err := tr.UpdateRef("refs/heads/race", base, c, who, "race")
+				if os.IsExist(err) {
+					continue
+				} else if err == nil {
+					results <- c
+				}
+				return
+			}
+		}(c)
+	}
+	wg.Wait()
+	close(results)
+
+	var winners []ObjectID
+	for c := range results {
+		winners = append(winners, c)
+	}
+
+	if len(winners) != 1 {
+		t.Fatalf("expected exactly one successful update, got %d", len(winners))
+	}
+
+	if id := tr.revParse("refs/heads/race"); id != winners[0] {
+		t.Fatalf("race: expected %s, got %s", winners[0], id)
+	}
+}
@@ -0,0 +1,475 @@
+package git
+
+import (
+	"bytes"
+	"hash/fnv"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+)
+
+//RenameOptions control the rename and copy detection of
+//DetectRenames.
+type RenameOptions struct {
+	//Threshold is the minimum similarity, in percent, of two
+	//files to be considered a rename (or copy).
+	Threshold int
+
+	//Copies enables the detection of copies, whose source can
+	//be any file that was deleted or modified in the same diff.
+	Copies bool
+
+	//Limit is the maximum number of sources and destinations
+	//for which the content similarity is computed, to bound the
+	//time spent on big diffs. Exact and annex renames are always
+	//detected. Zero means no limit.
+	Limit int
+
+	//MaxSize is the size limit for files to be compared by
+	//content. Zero means no limit.
+	MaxSize int64
+}
+
+//DefaultRenameOptions match the defaults of git.
+var DefaultRenameOptions = RenameOptions{
+	Threshold: 50,
+	Limit:     1000,
+	MaxSize:   16 * 1024 * 1024,
+}
+
+//renameSource is a path of the old tree that can be the source
+//of a rename or a copy.
+type renameSource struct {
+	change *TreeChange
+	path   string
+	mode   uint32
+	id     ObjectID
+	used   bool //already the source of a rename
+	copy   bool //only a copy source, the path still exists
+}
+
+//renameMatch is a possible rename of src to the destination dst.
+type renameMatch struct {
+	src   int
+	dst   int
+	score int
+	base  bool //same base name
+}
+
+type renameMatches []renameMatch
+
+func (m renameMatches) Len() int      { return len(m) }
+func (m renameMatches) Swap(i, j int) { m[i], m[j] = m[j], m[i] }
+func (m renameMatches) Less(i, j int) bool {
+	if m[i].score != m[j].score {
+		return m[i].score > m[j].score
+	} else if m[i].base != m[j].base {
+		return m[i].base
+	} else if m[i].dst != m[j].dst {
+		return m[i].dst < m[j].dst
+	}
+	return m[i].src < m[j].src
+}
+
+//DetectRenames finds renames and copies in the changes from DiffTrees.
+//Added paths are matched with deleted (or, for copies, modified) ones:
+//first by identical blob ids, then annexed files by the annex key of
+//their symlink targets and finally by the similarity of their
+//content. Renames replace the pair of delete and add with a single
+//ChangeRename, copies the add with a ChangeCopy.
+func (repo *Repository) DetectRenames(changes []TreeChange, opts RenameOptions) ([]TreeChange, error) {
+	var srcs []*renameSource
+	var dsts []int
+
+	for i := range changes {
+		c := &changes[i]
+		switch {
+		case c.Type == ChangeAdd:
+			dsts = append(dsts, i)
+		case c.Type == ChangeDelete:
+			srcs = append(srcs, &renameSource{change: c, path: c.Path, mode: uint32(c.OldMode), id: c.OldID})
+		case opts.Copies && !c.OldID.IsZero():
+			srcs = append(srcs, &renameSource{change: c, path: c.Path, mode: uint32(c.OldMode), id: c.OldID, copy: true})
+		}
+	}
+
+	if len(srcs) == 0 || len(dsts) == 0 {
+		return changes, nil
+	}
+
+	d := &renameDetector{repo: repo, opts: opts, changes: changes, srcs: srcs,
+		found: make(map[int]renameMatch)}
+
+	//exact renames first, then annexed files and content
+	remaining := d.matchExact(dsts)
+
+	remaining, err := d.matchAnnex(remaining)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Limit == 0 || (len(srcs) <= opts.Limit && len(remaining) <= opts.Limit) {
+		if err = d.matchContent(remaining); err != nil {
+			return nil, err
+		}
+	}
+
+	return d.result(), nil
+}
+
+type renameDetector struct {
+	repo    *Repository
+	opts    RenameOptions
+	changes []TreeChange
+	srcs    []*renameSource
+
+	found map[int]renameMatch //by index of the destination
+}
+
+//assign records the match, unless the destination was matched
+//already. Copies are only recorded if enabled.
+func (d *renameDetector) assign(m renameMatch) bool {
+	if _, ok := d.found[m.dst]; ok {
+		return false
+	}
+
+	src := d.srcs[m.src]
+	if (src.used || src.copy) && !d.opts.Copies {
+		return false
+	}
+
+	if !src.copy && !src.used {
+		src.used = true
+	} else {
+		//a copy is marked by a negative source index
+		m.src = -m.src - 1
+	}
+
+	d.found[m.dst] = m
+	return true
+}
+
+//candidates returns the matches of dst with srcs for which match
+//is true, sources that are not used yet and with the same base name
+//first.
+func (d *renameDetector) candidates(dst int, match func(i int, src *renameSource) bool) renameMatches {
+	var ms renameMatches
+	base := path.Base(d.changes[dst].Path)
+
+	for i, src := range d.srcs {
+		if match(i, src) {
+			ms = append(ms, renameMatch{src: i, dst: dst, score: 100, base: path.Base(src.path) == base})
+		}
+	}
+
+	sort.Stable(byRenamePreference{ms, d.srcs})
+	return ms
+}
+
+type byRenamePreference struct {
+	renameMatches
+	srcs []*renameSource
+}
+
+func (p byRenamePreference) Less(i, j int) bool {
+	a, b := p.srcs[p.renameMatches[i].src], p.srcs[p.renameMatches[j].src]
+	if a.used != b.used {
+		return !a.used
+	}
+	return p.renameMatches[i].base && !p.renameMatches[j].base
+}
+
+func (d *renameDetector) matchExact(dsts []int) []int {
+	var remaining []int
+	for _, dst := range dsts {
+		c := &d.changes[dst]
+		ms := d.candidates(dst, func(i int, src *renameSource) bool {
+			return src.id == c.NewID && fileKind(src.change.OldMode) == fileKind(c.NewMode)
+		})
+
+		matched := false
+		for _, m := range ms {
+			if matched = d.assign(m); matched {
+				break
+			}
+		}
+
+		if !matched {
+			remaining = append(remaining, dst)
+		}
+	}
+
+	return remaining
+}
+
+//annexKeyOfTarget returns the annex key if target is the target
+//of a symlink to an annexed file, in any directory.
+func annexKeyOfTarget(target string) (string, bool) {
+	target = path.Clean(target)
+	for strings.HasPrefix(target, "../") {
+		target = target[3:]
+	}
+
+	if !strings.HasPrefix(target, ".git/annex/objects/") {
+		return "", false
+	}
+
+	return path.Base(target), true
+}
+
+func (d *renameDetector) annexKey(mode uint32, id ObjectID, keys map[ObjectID]string) (string, error) {
+	if mode != 0120000 {
+		return "", nil
+	}
+
+	if key, ok := keys[id]; ok {
+		return key, nil
+	}
+
+	target, err := d.repo.Readlink(id)
+	if err != nil {
+		return "", err
+	}
+
+	key, _ := annexKeyOfTarget(target)
+	keys[id] = key
+	return key, nil
+}
+
+func (d *renameDetector) matchAnnex(dsts []int) ([]int, error) {
+	keys := make(map[ObjectID]string)
+	srcKeys := make([]string, len(d.srcs))
+
+	for i, src := range d.srcs {
+		key, err := d.annexKey(src.mode, src.id, keys)
+		if err != nil {
+			return nil, err
+		}
+		srcKeys[i] = key
+	}
+
+	var remaining []int
+	for _, dst := range dsts {
+		c := &d.changes[dst]
+		key, err := d.annexKey(uint32(c.NewMode), c.NewID, keys)
+		if err != nil {
+			return nil, err
+		}
+
+		matched := false
+		if key != "" {
+			ms := d.candidates(dst, func(i int, src *renameSource) bool {
+				return srcKeys[i] == key
+			})
+
+			for _, m := range ms {
+				if matched = d.assign(m); matched {
+					break
+				}
+			}
+		}
+
+		if !matched {
+			remaining = append(remaining, dst)
+		}
+	}
+
+	return remaining, nil
+}
+
+//similarityIndex is the content of a file, for computing the
+//similarity of two files: the number of bytes of each chunk (a
+//line, but at most 64 bytes), by hash of the chunk.
+type similarityIndex struct {
+	size   int64
+	chunks map[uint32]int64
+}
+
+func newSimilarityIndex(data []byte) *similarityIndex {
+	idx := &similarityIndex{size: int64(len(data)), chunks: make(map[uint32]int64)}
+
+	for len(data) > 0 {
+		n := bytes.IndexByte(data, '\n') + 1
+		if n == 0 || n > 64 {
+			n = 64
+		}
+		if n > len(data) {
+			n = len(data)
+		}
+
+		h := fnv.New32a()
+		h.Write(data[:n])
+		idx.chunks[h.Sum32()] += int64(n)
+		data = data[n:]
+	}
+
+	return idx
+}
+
+//score returns the similarity of the files, in percent: the bytes
+//they have in common relative to the size of the larger one.
+func (idx *similarityIndex) score(other *similarityIndex) int {
+	max := idx.size
+	if other.size > max {
+		max = other.size
+	}
+	if max == 0 {
+		return 100
+	}
+
+	var common int64
+	for h, n := range idx.chunks {
+		if m := other.chunks[h]; m < n {
+			common += m
+		} else {
+			common += n
+		}
+	}
+
+	return int(common * 100 / max)
+}
+
+func (d *renameDetector) similarityIndex(id ObjectID, cache map[ObjectID]*similarityIndex) (*similarityIndex, error) {
+	if idx, ok := cache[id]; ok {
+		return idx, nil
+	}
+
+	obj, err := d.repo.OpenObject(id)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+
+	blob, ok := obj.(*Blob)
+	if !ok {
+		cache[id] = nil
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadAll(blob)
+	if err != nil {
+		return nil, err
+	}
+
+	idx := newSimilarityIndex(data)
+	cache[id] = idx
+	return idx, nil
+}
+
+func (d *renameDetector) matchContent(dsts []int) error {
+	sizes := make(map[ObjectID]int64)
+	size := func(id ObjectID) (int64, error) {
+		if s, ok := sizes[id]; ok {
+			return s, nil
+		}
+		_, s, err := d.repo.statObject(id)
+		sizes[id] = s
+		return s, err
+	}
+
+	cache := make(map[ObjectID]*similarityIndex)
+	var ms renameMatches
+
+	for _, dst := range dsts {
+		c := &d.changes[dst]
+		if fileKind(c.NewMode) != 0100000 {
+			continue
+		}
+
+		dsize, err := size(c.NewID)
+		if err != nil {
+			return err
+		} else if d.opts.MaxSize > 0 && dsize > d.opts.MaxSize {
+			continue
+		}
+
+		for i, src := range d.srcs {
+			if fileKind(src.change.OldMode) != 0100000 || (src.used && !d.opts.Copies) {
+				continue
+			}
+
+			ssize, err := size(src.id)
+			if err != nil {
+				return err
+			} else if d.opts.MaxSize > 0 && ssize > d.opts.MaxSize {
+				continue
+			}
+
+			//the score can not be above min/max of the sizes
+			min, max := ssize, dsize
+			if min > max {
+				min, max = max, min
+			}
+			if max > 0 && min*100/max < int64(d.opts.Threshold) {
+				continue
+			}
+
+			a, err := d.similarityIndex(src.id, cache)
+			if err != nil {
+				return err
+			}
+
+			b, err := d.similarityIndex(c.NewID, cache)
+			if err != nil {
+				return err
+			}
+
+			if a == nil || b == nil {
+				continue
+			}
+
+			if score := a.score(b); score >= d.opts.Threshold {
+				ms = append(ms, renameMatch{src: i, dst: dst, score: score,
+					base: path.Base(src.path) == path.Base(c.Path)})
+			}
+		}
+	}
+
+	//the best matches win
+	sort.Sort(ms)
+	for _, m := range ms {
+		d.assign(m)
+	}
+
+	return nil
+}
+
+//result replaces the matched adds with renames and copies and
+//drops the deletes of renamed paths.
+func (d *renameDetector) result() []TreeChange {
+	renamed := make(map[*TreeChange]bool)
+	for _, src := range d.srcs {
+		if src.used {
+			renamed[src.change] = true
+		}
+	}
+
+	var res []TreeChange
+	for i, c := range d.changes {
+		if renamed[&d.changes[i]] {
+			continue
+		}
+
+		m, ok := d.found[i]
+		if !ok {
+			res = append(res, c)
+			continue
+		}
+
+		c.Type = ChangeRename
+		if m.src < 0 {
+			c.Type = ChangeCopy
+			m.src = -m.src - 1
+		}
+
+		src := d.srcs[m.src]
+		c.OldPath = src.path
+		c.OldMode = src.change.OldMode
+		c.OldID = src.id
+		c.Similarity = m.score
+		res = append(res, c)
+	}
+
+	return res
+}
@@ -0,0 +1,168 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+//rawRename formats a change like git diff-tree --raw with rename
+//detection, but without the score.
+func rawRename(c TreeChange) string {
+	switch c.Type {
+	case ChangeRename:
+		return fmt.Sprintf(":%06o %06o %s %s R\t%s\t%s", uint32(c.OldMode), uint32(c.NewMode), c.OldID, c.NewID, c.OldPath, c.Path)
+	case ChangeCopy:
+		return fmt.Sprintf(":%06o %06o %s %s C\t%s\t%s", uint32(c.OldMode), uint32(c.NewMode), c.OldID, c.NewID, c.OldPath, c.Path)
+	}
+	return rawChange(c)
+}
+
+func (tr *testRepo) diffRenames(a, b ObjectID, opts RenameOptions) []TreeChange {
+	ta, err := tr.openTree(tr.revParse(a.String() + "^{tree}"))
+	if err != nil {
+		tr.t.Fatal(err)
+	}
+
+	tb, err := tr.openTree(tr.revParse(b.String() + "^{tree}"))
+	if err != nil {
+		tr.t.Fatal(err)
+	}
+
+	changes, err := tr.DiffTrees(ta, tb)
+	if err != nil {
+		tr.t.Fatalf("DiffTrees() => %v", err)
+	}
+
+	if changes, err = tr.DetectRenames(changes, opts); err != nil {
+		tr.t.Fatalf("DetectRenames() => %v", err)
+	}
+
+	return changes
+}
+
+func TestDetectRenames(t *testing.T) {
+	tr := mkTestRepo(t)
+	defer tr.cleanup()
+
+	var long []string
+	for i := 0; i < 50; i++ {
+		long = append(long, fmt.Sprintf("line %d of a longer file", i))
+	}
+
+	tr.write("exact.txt", "moved unchanged\n")
+	tr.write("dir/same-name.txt", "same name\n")
+	tr.write("similar.txt", strings.Join(long, "\n")+"\n")
+	tr.write("source.txt", strings.Join(long[:40], "\n")+"\n")
+	tr.write("gone.txt", "nothing like it\n")
+	c1 := tr.commit("first")
+
+	os.Remove(filepath.Join(tr.work, "exact.txt"))
+	os.Remove(filepath.Join(tr.work, "dir", "same-name.txt"))
+	os.Remove(filepath.Join(tr.work, "similar.txt"))
+	os.Remove(filepath.Join(tr.work, "gone.txt"))
+	tr.write("moved/exact.txt", "moved unchanged\n")
+	tr.write("other/same-name.txt", "same name\n")
+	long[3] = "a changed line"
+	tr.write("renamed.txt", strings.Join(long, "\n")+"\n")
+	tr.write("source.txt", strings.Join(long[:39], "\n")+"\n")
+	tr.write("copy.txt", strings.Join(long[:40], "\n")+"\n")
+	tr.write("new.txt", "brand new\n")
+	c2 := tr.commit("second")
+
+	tests := []struct {
+		name string
+		opts RenameOptions
+		args []string
+	}{
+		{"renames", DefaultRenameOptions, []string{"-M"}},
+		{"copies", RenameOptions{Threshold: 50, Copies: true}, []string{"-C"}},
+		{"threshold", RenameOptions{Threshold: 99}, []string{"-M99%"}},
+	}
+
+	score := regexp.MustCompile(` ([RC])\d+\t`)
+	for _, tt := range tests {
+		var lines []string
+		for _, c := range tr.diffRenames(c1, c2, tt.opts) {
+			lines = append(lines, rawRename(c))
+		}
+
+		args := append([]string{"diff-tree", "-r", "--raw", "--abbrev=40"}, tt.args...)
+		expected := tr.git(append(args, c1.String(), c2.String())...)
+		expected = score.ReplaceAllString(expected, " $1\t")
+
+		if actual := strings.Join(lines, "\n"); actual != expected {
+			t.Fatalf("%s: changes differ from git diff-tree:\n%s\n--- expected ---\n%s", tt.name, actual, expected)
+		}
+	}
+
+	for _, c := range tr.diffRenames(c1, c2, DefaultRenameOptions) {
+		if c.Type == ChangeRename && c.Path == "renamed.txt" && (c.Similarity < 90 || c.Similarity == 100) {
+			t.Fatalf("unexpected similarity for a changed line: %d", c.Similarity)
+		} else if c.Type == ChangeRename && c.Path == "moved/exact.txt" && c.Similarity != 100 {
+			t.Fatalf("expected a similarity of 100 for an exact rename, got %d", c.Similarity)
+		}
+	}
+}
+
+func TestDetectAnnexRenames(t *testing.T) {
+	tr := mkTestRepo(t)
+	defer tr.cleanup()
+
+	key := "SHA256E-s1048576--2b0f8a3f33f8cbbf0d4ac1b27a3c6b2b0d8c2a1a0e6b8e4b6d2f3c9a1f0e7d6c5.dat"
+	other := "SHA256E-s1048576--9f1e2d3c4b5a69788796a5b4c3d2e1f00f1e2d3c4b5a69788796a5b4c3d2e1f0.dat"
+	target := func(depth int, key string) string {
+		return strings.Repeat("../", depth) + ".git/annex/objects/Xk/3q/" + key + "/" + key
+	}
+
+	link := func(name, target string) {
+		p := filepath.Join(tr.work, name)
+		os.MkdirAll(filepath.Dir(p), 0755)
+		os.Remove(p)
+		if err := os.Symlink(target, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	link("data.dat", target(0, key))
+	link("other.dat", target(0, other))
+	tr.git("add", "-A", ".")
+	tr.git("commit", "-q", "-m", "annexed files")
+	c1 := tr.revParse("HEAD")
+
+	//moving into a subdirectory changes the target, but not the key
+	os.Remove(filepath.Join(tr.work, "data.dat"))
+	os.Remove(filepath.Join(tr.work, "other.dat"))
+	link("raw/data/recording.dat", target(2, key))
+	link("unrelated.dat", target(0, "MD5E-s3--acbd18db4cc2f85cedef654fccc4a4d8.dat"))
+	tr.git("add", "-A", ".")
+	tr.git("commit", "-q", "-m", "move")
+	c2 := tr.revParse("HEAD")
+
+	changes := tr.diffRenames(c1, c2, DefaultRenameOptions)
+
+	var renames []TreeChange
+	for _, c := range changes {
+		if c.Type == ChangeRename {
+			renames = append(renames, c)
+		}
+	}
+
+	if len(renames) != 1 || len(changes) != 3 {
+		t.Fatalf("expected one rename and three changes, got %+v", changes)
+	}
+
+	r := renames[0]
+	if r.OldPath != "data.dat" || r.Path != "raw/data/recording.dat" || r.Similarity != 100 || r.OldID == r.NewID {
+		t.Fatalf("unexpected rename: %+v", r)
+	}
+
+	if k, ok := annexKeyOfTarget(target(3, key)); !ok || k != key {
+		t.Fatalf("annexKeyOfTarget() => %q, %v", k, ok)
+	} else if _, ok := annexKeyOfTarget("../README"); ok {
+		t.Fatalf("annexKeyOfTarget() accepted a plain symlink")
+	}
+}
@@ -1,39 +1,61 @@
 package git
 
 import (
-	"bufio"
-	"bytes"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 )
 
 //Repository represents an on disk git repository.
+//It is safe to share a Repository between goroutines;
+//pack files are opened on first use and kept open
+//until Close is called. Resolved delta objects are
+//cached, see SetDeltaConfig.
 type Repository struct {
 	Path string
+
+	mu     sync.Mutex
+	packs  *packRegistry
+	deltas *deltaCache
+	dcfg   *DeltaConfig
+
+	gfile       *graphFile
+	gfileLoaded bool
+
+	format       ObjectFormat
+	formatLoaded bool
+
+	mem *memObjects //objects of an in-memory view, see inMemory
 }
 
-//InitBareRepository creates a bare git repository at path.
-func InitBareRepository(path string) (*Repository, error) {
+//InitBareRepository creates a bare git repository at path,
+//whose objects are named with the given object format.
+func InitBareRepository(path string, format ObjectFormat) (*Repository, error) {
 
 	path, err := filepath.Abs(path)
 	if err != nil {
 		return nil, fmt.Errorf("Could not determine absolute path: %v", err)
 	}
 
-	cmd := exec.Command("git", "init", "--bare", path)
+	args := []string{"init", "--bare"}
+	if format != FormatSHA1 {
+		args = append(args, "--object-format="+format.String())
+	}
+
+	cmd := exec.Command("git", append(args, path)...)
 	err = cmd.Run()
 
 	if err != nil {
 		return nil, err
 	}
 
-	return &Repository{Path: path}, nil
+	return &Repository{Path: path, format: format, formatLoaded: true}, nil
 }
 
 //IsBareRepository checks if path is a bare git repository.
@@ -64,7 +86,54 @@ func OpenRepository(path string) (*Repository, error) {
 		return nil, fmt.Errorf("git: not a bare repository")
 	}
 
-	return &Repository{Path: path}, nil
+	format, err := readObjectFormat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Repository{Path: path, format: format, formatLoaded: true}, nil
+}
+
+//readObjectFormat returns the object format of the repository at
+//path, from extensions.objectFormat in its config.
+func readObjectFormat(path string) (ObjectFormat, error) {
+	data, err := ioutil.ReadFile(filepath.Join(path, "config"))
+	if os.IsNotExist(err) {
+		return FormatSHA1, nil
+	} else if err != nil {
+		return FormatSHA1, err
+	}
+
+	cfg, err := parseConfig(data)
+	if err != nil {
+		return FormatSHA1, err
+	}
+
+	//extensions are only honored by repositories of
+	//version 1, like git does
+	version, _ := cfg.Get("core", "", "repositoryformatversion")
+	name, ok := cfg.Get("extensions", "", "objectformat")
+	if !ok || strings.TrimSpace(version) != "1" {
+		return FormatSHA1, nil
+	}
+
+	return ParseObjectFormat(name)
+}
+
+//ObjectFormat returns the object format of the repository, which
+//is read from its config on first use.
+func (repo *Repository) ObjectFormat() ObjectFormat {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if !repo.formatLoaded {
+		//an unreadable config will make everything else
+		//fail as well, so we just fall back to sha1
+		repo.format, _ = readObjectFormat(repo.Path)
+		repo.formatLoaded = true
+	}
+
+	return repo.format
 }
 
 //DiscoverRepository returns the git repository that contains the
@@ -81,6 +150,82 @@ func DiscoverRepository() (*Repository, error) {
 	return &Repository{Path: path}, nil
 }
 
+//Close releases all resources, i.e. open pack files, held
+//by the repository. Objects that were opened from it must
+//not be used afterwards. The repository itself can still
+//be used, packs will be reopened as needed.
+func (repo *Repository) Close() error {
+	repo.mu.Lock()
+	packs := repo.packs
+	repo.packs = nil
+	repo.deltas = nil
+	repo.gfile, repo.gfileLoaded = nil, false
+	repo.mu.Unlock()
+
+	if packs == nil {
+		return nil
+	}
+
+	return packs.close()
+}
+
+func (repo *Repository) packRegistry() *packRegistry {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if repo.packs == nil {
+		repo.packs = newPackRegistry(repo)
+	}
+
+	return repo.packs
+}
+
+//SetDeltaConfig changes the limits for resolving delta objects
+//and the size of the delta base cache. The cache is cleared.
+func (repo *Repository) SetDeltaConfig(cfg DeltaConfig) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	repo.dcfg = &cfg
+	repo.deltas = nil
+}
+
+func (repo *Repository) deltaConfig() DeltaConfig {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if repo.dcfg == nil {
+		return DefaultDeltaConfig
+	}
+	return *repo.dcfg
+}
+
+func (repo *Repository) deltaBaseCache() *deltaCache {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	cfg := DefaultDeltaConfig
+	if repo.dcfg != nil {
+		cfg = *repo.dcfg
+	}
+
+	if repo.deltas == nil && cfg.CacheSize > 0 {
+		repo.deltas = newDeltaCache(cfg.CacheSize)
+	}
+
+	return repo.deltas
+}
+
+//DeltaCacheStats returns the statistics of the delta
+//base cache, e.g. for logging.
+func (repo *Repository) DeltaCacheStats() DeltaCacheStats {
+	cache := repo.deltaBaseCache()
+	if cache == nil {
+		return DeltaCacheStats{}
+	}
+	return cache.stats()
+}
+
 //ReadDescription returns the contents of the description file.
 func (repo *Repository) ReadDescription() string {
 	path := filepath.Join(repo.Path, "description")
@@ -108,83 +253,121 @@ func (repo *Repository) DeleteCollaborator(username string) error {
 	return os.Remove(filePath)
 }
 
-//OpenObject returns the git object for a give id (SHA1).
-func (repo *Repository) OpenObject(id SHA1) (Object, error) {
+//OpenObject returns the git object for a give id.
+func (repo *Repository) OpenObject(id ObjectID) (Object, error) {
+	obj, err := repo.openObject(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseObject(obj, repo.ObjectFormat())
+}
+
+//openObject returns the unparsed object for the id, where delta
+//objects are already resolved, i.e. the source of the object
+//always yields its (uncompressed) data.
+func (repo *Repository) openObject(id ObjectID) (gitObject, error) {
 	obj, err := repo.openRawObject(id)
 
 	if err != nil {
-		return nil, err
+		return obj, err
 	}
 
 	if IsStandardObject(obj.otype) {
-		return parseObject(obj)
+		return obj, nil
 	}
 
 	//not a standard object, *must* be a delta object,
 	// we know of no other types
 	if !IsDeltaObject(obj.otype) {
-		return nil, fmt.Errorf("git: unsupported object")
+		obj.Close()
+		return gitObject{}, fmt.Errorf("git: unsupported object")
 	}
 
 	delta, err := parseDelta(obj)
 	if err != nil {
-		return nil, err
+		return gitObject{}, err
 	}
 
+	//limits (depth, memory) are checked while
+	//building the chain, before anything is patched
 	chain, err := buildDeltaChain(delta, repo)
 
 	if err != nil {
-		return nil, err
+		return gitObject{}, err
 	}
 
-	//TODO: check depth, and especially expected memory usage
-	// beofre actually patching it
-
-	return chain.resolve()
+	return chain.resolveRaw()
 }
 
-func (repo *Repository) openRawObject(id SHA1) (gitObject, error) {
-	idstr := id.String()
-	opath := filepath.Join(repo.Path, "objects", idstr[:2], idstr[2:])
+//statObject returns type and size of the object without reading
+//its data. For delta objects only the headers in the delta chain
+//are read.
+func (repo *Repository) statObject(id ObjectID) (ObjectType, int64, error) {
+	obj, err := repo.openRawObject(id)
+	if err != nil {
+		return 0, 0, err
+	}
 
-	obj, err := openRawObject(opath)
+	if !IsDeltaObject(obj.otype) {
+		obj.Close()
+		return obj.otype, obj.size, nil
+	}
 
-	if err == nil {
-		return obj, nil
-	} else if err != nil && !os.IsNotExist(err) {
-		return obj, err
+	delta, err := parseDelta(obj)
+	if err != nil {
+		return 0, 0, err
 	}
 
-	indicies := repo.loadPackIndices()
+	size := delta.SizeTarget
+	cfg := repo.deltaConfig()
+	for depth := 1; ; depth++ {
+		if err = cfg.checkDepth(depth); err != nil {
+			delta.Close()
+			return 0, 0, err
+		}
 
-	for _, f := range indicies {
+		if delta.otype == ObjRefDelta {
+			obj, err = repo.openRawObject(delta.BaseRef)
+		} else {
+			obj, err = delta.pf.readRawObject(delta.BaseOff)
+		}
+		delta.Close()
 
-		idx, err := PackIndexOpen(f)
 		if err != nil {
-			continue
+			return 0, 0, err
+		} else if !IsDeltaObject(obj.otype) {
+			obj.Close()
+			return obj.otype, size, nil
 		}
 
-		//TODO: we should leave index files open,
-		defer idx.Close()
-
-		off, err := idx.FindOffset(id)
-
-		if err != nil {
-			continue
+		if delta, err = parseDelta(obj); err != nil {
+			return 0, 0, err
 		}
+	}
+}
 
-		pf, err := idx.OpenPackFile()
-		if err != nil {
-			return gitObject{}, err
+func (repo *Repository) openRawObject(id ObjectID) (gitObject, error) {
+	if repo.mem != nil {
+		if obj, ok := repo.mem.open(id); ok {
+			return obj, nil
 		}
+	}
 
-		obj, err := pf.readRawObject(off)
+	idstr := id.String()
+	opath := filepath.Join(repo.Path, "objects", idstr[:2], idstr[2:])
 
-		if err != nil {
-			return gitObject{}, err
-		}
+	obj, err := openRawObject(opath)
 
+	if err == nil {
 		return obj, nil
+	} else if err != nil && !os.IsNotExist(err) {
+		return obj, err
+	}
+
+	if p, off, ok := repo.packRegistry().find(id); ok {
+		defer p.release()
+		return p.pf.readRawObject(off)
 	}
 
 	// from inspecting the os.isNotExist source it
@@ -213,7 +396,10 @@ func (repo *Repository) OpenRef(name string) (Ref, error) {
 		return repo.parseRef("HEAD")
 	}
 
-	matches := repo.listRefWithName(name)
+	matches, err := repo.listRefWithName(name)
+	if err != nil {
+		return nil, err
+	}
 
 	//first search in local heads
 	var locals []Ref
@@ -242,7 +428,7 @@ func (repo *Repository) OpenRef(name string) (Ref, error) {
 }
 
 //Readlink returns the destination of a symbilc link blob object
-func (repo *Repository) Readlink(id SHA1) (string, error) {
+func (repo *Repository) Readlink(id ObjectID) (string, error) {
 
 	b, err := repo.OpenObject(id)
 	if err != nil {
@@ -268,6 +454,7 @@ func (repo *Repository) Readlink(id SHA1) (string, error) {
 //ObjectForPath will resolve the path to an object
 //for the file tree starting in the node root.
 //The root object can be either a Commit, Tree or Tag.
+//For the path of a submodule, the error is a *SubmoduleError.
 func (repo *Repository) ObjectForPath(root Object, pathstr string) (Object, error) {
 
 	var node Object
@@ -312,11 +499,12 @@ func (repo *Repository) ObjectForPath(root Object, pathstr string) (Object, erro
 			continue
 		}
 
-		var id *SHA1
+		var id *ObjectID
+		var mode os.FileMode
 		for tree.Next() {
 			entry := tree.Entry()
 			if entry.Name == comps[i] {
-				id = &entry.ID
+				id, mode = &entry.ID, entry.Mode
 				break
 			}
 		}
@@ -333,6 +521,16 @@ func (repo *Repository) ObjectForPath(root Object, pathstr string) (Object, erro
 				Op:   "find object",
 				Path: cwd,
 				Err:  os.ErrNotExist}
+		} else if fileKind(mode) == 0160000 {
+			//the commit of a submodule is not in the
+			//repository, and neither are paths below it
+			if i+1 < len(comps) {
+				return nil, &os.PathError{
+					Op:   "find object",
+					Path: strings.Join(comps[:i+2], "/"),
+					Err:  os.ErrNotExist}
+			}
+			return nil, &SubmoduleError{Path: cleaned, Commit: *id}
 		}
 
 		node, err = repo.OpenObject(*id)
@@ -349,16 +547,6 @@ func (repo *Repository) ObjectForPath(root Object, pathstr string) (Object, erro
 	return node, nil
 }
 
-// usefmt is the option string used by CommitsForRef to return a formatted git commit log.
-const usefmt = `--pretty=format:
-Commit:=%H%n
-Committer:=%cn%n
-Author:=%an%n
-Date-iso:=%ai%n
-Date-rel:=%ar%n
-Subject:=%s%n
-Changes:=`
-
 // CommitSummary represents a subset of information from a git commit.
 type CommitSummary struct {
 	Commit       string
@@ -367,97 +555,91 @@ type CommitSummary struct {
 	DateIso      string
 	DateRelative string
 	Subject      string
-	Changes      []string
+	Changes      []TreeChange
 }
 
-// CommitsForRef executes a custom git log command for the specified ref of the
-// associated git repository and returns the resulting byte array.
-func (repo *Repository) CommitsForRef(ref string) ([]CommitSummary, error) {
+// CommitsForRef returns the summaries of all commits reachable from
+// the specified ref (or range) of the associated git repository.
+// The changes of each commit are relative to its parent; like git log,
+// they are omitted for merge commits. Authors and committers are mapped
+// with mailmap, which may be nil.
+func (repo *Repository) CommitsForRef(ref string, mailmap *Mailmap) ([]CommitSummary, error) {
+	rev, err := repo.ParseRevision(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	return repo.LogSummaries(rev, LogOptions{Mailmap: mailmap})
+}
 
-	raw, err := commitsForRef(repo.Path, ref, usefmt)
+// LogSummaries returns the summaries of the commits of rev that are
+// selected by opts, see Log. Dates are formatted like git log's
+// %ai and %ar, i.e. they refer to the author date. Authors and
+// committers are mapped with opts.Mailmap.
+func (repo *Repository) LogSummaries(rev Revision, opts LogOptions) ([]CommitSummary, error) {
+	nodes, err := repo.Log(rev, opts)
 	if err != nil {
 		return nil, err
 	}
 
-	sep := ":="
-	var comList []CommitSummary
-	r := bytes.NewReader(raw)
-	br := bufio.NewReader(r)
-
-	var changesFlag bool
-	for {
-		// Consume line until newline character
-		l, err := br.ReadString('\n')
-
-		if strings.Contains(l, sep) {
-			splitList := strings.SplitN(l, sep, 2)
-
-			key := splitList[0]
-			val := splitList[1]
-			switch key {
-			case "Commit":
-				// reset non key line flags
-				changesFlag = false
-				newCommit := CommitSummary{Commit: val}
-				comList = append(comList, newCommit)
-			case "Committer":
-				comList[len(comList)-1].Committer = val
-			case "Author":
-				comList[len(comList)-1].Author = val
-			case "Date-iso":
-				comList[len(comList)-1].DateIso = val
-			case "Date-rel":
-				comList[len(comList)-1].DateRelative = val
-			case "Subject":
-				comList[len(comList)-1].Subject = val
-			case "Changes":
-				// Setting changes flag so we know, that the next lines are probably file change notification lines.
-				changesFlag = true
-			default:
-				fmt.Printf("[W] commits: unexpected key %q, value %q\n", key, strings.Trim(val, "\n"))
-			}
-		} else if changesFlag && strings.Contains(l, "\t") {
-			comList[len(comList)-1].Changes = append(comList[len(comList)-1].Changes, l)
+	now := time.Now()
+	comList := make([]CommitSummary, len(nodes))
+	for i, node := range nodes {
+		commit, err := node.Commit()
+		if err != nil {
+			return nil, err
+		}
+
+		author, _ := node.Author()
+		committer, _ := node.Committer()
+		date := author.Date.In(author.Offset)
+
+		comList[i] = CommitSummary{
+			Commit:       node.ID.String(),
+			Committer:    committer.Name,
+			Author:       author.Name,
+			DateIso:      date.Format("2006-01-02 15:04:05 -0700"),
+			DateRelative: relativeDate(date, now),
+			Subject:      commitSubject(commit.Message),
 		}
 
-		// Breaks at the latest when EOF err is raised
+		comList[i].Changes, err = repo.commitSummaryChanges(commit)
 		if err != nil {
-			break
+			return nil, err
 		}
 	}
-	if err != io.EOF && err != nil {
-		return nil, err
-	}
 
 	return comList, nil
 }
 
-// commitsForRef executes a custom git log command for the specified ref of the
-// given git repository with the specified log format string and returns the resulting byte array.
-// Function is kept private to force handling of the []byte inside the package.
-func commitsForRef(repoPath, ref, usefmt string) ([]byte, error) {
-	gdir := fmt.Sprintf("--git-dir=%s", repoPath)
+//commitSummaryChanges returns the changes of commit, with renames
+//detected like git log does, none for merges.
+func (repo *Repository) commitSummaryChanges(commit *Commit) ([]TreeChange, error) {
+	if len(commit.Parent) > 1 {
+		return nil, nil
+	}
 
-	cmd := exec.Command("git", gdir, "log", ref, usefmt, "--name-status")
-	body, err := cmd.Output()
+	changes, err := repo.CommitChanges(commit)
 	if err != nil {
-		return nil, fmt.Errorf("failed running git log: %s\n", err.Error())
+		return nil, err
 	}
-	return body, nil
+
+	return repo.DetectRenames(changes, DefaultRenameOptions)
 }
 
-// BranchExists runs the "git branch <branchname> --list" command.
-// It will return an error, if the command fails, true, if the result is not empty and false otherwise.
+// BranchExists checks if there is a local branch with the given name.
+// It will return an error, if the refs could not be read.
 func (repo *Repository) BranchExists(branch string) (bool, error) {
-	gdir := fmt.Sprintf("--git-dir=%s", repo.Path)
-
-	cmd := exec.Command("git", gdir, "branch", branch, "--list")
-	body, err := cmd.Output()
+	refs, err := repo.ListRefs("refs/heads/")
 	if err != nil {
 		return false, err
-	} else if len(body) == 0 {
-		return false, nil
 	}
 
-	return true, nil
+	for _, r := range refs {
+		if r.Name() == branch {
+			return true, nil
+		}
+	}
+
+	return false, nil
 }
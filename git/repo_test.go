@@ -1,6 +1,8 @@
 package git
 
 import (
+	"bytes"
+	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
@@ -10,7 +12,7 @@ import (
 func TestRepoBasic(t *testing.T) {
 
 	const path = "test.git"
-	repo, err := InitBareRepository(path)
+	repo, err := InitBareRepository(path, FormatSHA1)
 
 	if err != nil {
 		t.Fatalf("Creating repo failed with err: %v", err)
@@ -89,7 +91,7 @@ func TestRepoAnnexBasic(t *testing.T) {
 	}
 
 	const path = "test.git"
-	repo, err := InitBareRepository(path)
+	repo, err := InitBareRepository(path, FormatSHA1)
 	if err != nil {
 		t.Fatalf("Creating repo failed with err: %v", err)
 	}
@@ -138,7 +140,7 @@ func TestObjectForPath(t *testing.T) {
 	}
 
 	for _, tt := range ofptests {
-		oid, _ := ParseSHA1(tt.root)
+		oid, _ := ParseObjectID(tt.root)
 		root, err := repo.OpenObject(oid)
 
 		if err != nil {
@@ -165,3 +167,98 @@ func TestObjectForPath(t *testing.T) {
 	}
 
 }
+
+func TestRepoSHA256(t *testing.T) {
+	if f, err := ParseObjectFormat("SHA256"); err != nil || f != FormatSHA256 {
+		t.Fatalf("ParseObjectFormat(\"SHA256\") => %v, %v", f, err)
+	} else if _, err = ParseObjectFormat("md5"); err == nil {
+		t.Fatalf("ParseObjectFormat(\"md5\") => no error")
+	}
+
+	tr := mkTestRepoFormat(t, FormatSHA256)
+	defer tr.cleanup()
+
+	if out := tr.git("rev-parse", "--show-object-format"); out != "sha256" {
+		t.Skipf("[W] git does not support sha256 repositories (%q). Skipping test", out)
+	}
+
+	const path = "test-sha256.git"
+	bare, err := InitBareRepository(path, FormatSHA256)
+	if err != nil {
+		t.Fatalf("Creating repo failed with err: %v", err)
+	}
+	defer os.RemoveAll(bare.Path)
+
+	if bare, err = OpenRepository(bare.Path); err != nil {
+		t.Fatalf("OpenRepository() => %v", err)
+	} else if bare.ObjectFormat() != FormatSHA256 {
+		t.Fatalf("ObjectFormat() => %s, expected sha256", bare.ObjectFormat())
+	}
+
+	tr.write("README", "hello\n")
+	tr.write("data/a.txt", "a\n")
+	tr.commit("first")
+	tr.write("data/a.txt", "b\n")
+	head := tr.commit("second")
+
+	repo := tr.Repository
+	if repo.ObjectFormat() != FormatSHA256 {
+		t.Fatalf("ObjectFormat() => %s, expected sha256", repo.ObjectFormat())
+	} else if len(head.String()) != 64 || head.Format() != FormatSHA256 {
+		t.Fatalf("unexpected id %q", head)
+	}
+
+	check := func(stage string) {
+		for _, spec := range []string{head.String(), head.String()[:10], "HEAD", "HEAD~1"} {
+			id, err := repo.ResolveRevision(spec)
+			if err != nil {
+				t.Fatalf("%s: ResolveRevision(%q) => %v", stage, spec, err)
+			} else if expected := tr.revParse(spec); id != expected {
+				t.Fatalf("%s: ResolveRevision(%q) => %s, expected %s", stage, spec, id, expected)
+			}
+		}
+
+		root, err := repo.OpenObject(head)
+		if err != nil {
+			t.Fatalf("%s: OpenObject() => %v", stage, err)
+		}
+
+		obj, err := repo.ObjectForPath(root, "data/a.txt")
+		if err != nil {
+			t.Fatalf("%s: ObjectForPath() => %v", stage, err)
+		} else if obj.Type() != ObjBlob {
+			t.Fatalf("%s: expected blob, got %s", stage, obj.Type())
+		}
+		obj.Close()
+		root.Close()
+
+		report, err := repo.Fsck()
+		if err != nil {
+			t.Fatalf("%s: Fsck() => %v", stage, err)
+		} else if !report.OK() {
+			t.Fatalf("%s: expected no problems, got %v", stage, report.Problems)
+		}
+	}
+
+	check("loose")
+	tr.git("gc", "-q")
+	check("packed")
+
+	var pack bytes.Buffer
+	_, _, err = repo.WritePack(&pack, listObjects(tr), DefaultPackConfig)
+	if err != nil {
+		t.Fatalf("WritePack() => %v", err)
+	}
+
+	target := mkTargetRepo(tr, "target.git")
+	packsum, err := target.IndexPack(&pack, IndexPackConfig{})
+	if err != nil {
+		t.Fatalf("IndexPack() => %v", err)
+	} else if packsum.Format() != FormatSHA256 {
+		t.Fatalf("IndexPack() => %s, expected a sha256 checksum", packsum)
+	}
+
+	if out := tr.git("--git-dir="+target.Path, "cat-file", "-t", head.String()); out != "commit" {
+		t.Fatalf("expected commit %s in target, got %q", head, out)
+	}
+}
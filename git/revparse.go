@@ -0,0 +1,393 @@
+package git
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//minAbbrev is the minimum length of an abbreviated
+//object id, like in git
+const minAbbrev = 4
+
+//Revision is the result of parsing a revision expression.
+//For ranges ("A..B") ID is B, Exclude is A and IsRange is set.
+type Revision struct {
+	ID      ObjectID
+	Exclude ObjectID
+	IsRange bool
+}
+
+func (rev Revision) String() string {
+	if rev.IsRange {
+		return fmt.Sprintf("%s..%s", rev.Exclude, rev.ID)
+	}
+	return rev.ID.String()
+}
+
+//AmbiguousObjectError is returned if an abbreviated object id
+//matches more than one object.
+type AmbiguousObjectError struct {
+	Prefix     string
+	Candidates []ObjectID
+}
+
+func (e *AmbiguousObjectError) Error() string {
+	return fmt.Sprintf("git: short object id %s is ambiguous (%d candidates)", e.Prefix, len(e.Candidates))
+}
+
+//RevisionSyntaxError is returned for malformed revision
+//expressions.
+type RevisionSyntaxError struct {
+	Rev string
+	Msg string
+}
+
+func (e *RevisionSyntaxError) Error() string {
+	return fmt.Sprintf("git: invalid revision %q: %s", e.Rev, e.Msg)
+}
+
+//ParseRevision parses a revision expression (see gitrevisions(7))
+//and resolves it. Supported are full and abbreviated object ids,
+//ref names (dwim'ed like git does), "@" as a shortcut for HEAD,
+//the suffixes "~<n>", "^<n>" and "^{<type>}" (and "^{}"),
+//"<rev>:<path>" and ranges of the form "A..B".
+func (repo *Repository) ParseRevision(spec string) (Revision, error) {
+	if strings.Contains(spec, "...") {
+		return Revision{}, &RevisionSyntaxError{spec, "symmetric differences are not supported"}
+	}
+
+	if i := strings.Index(spec, ".."); i > -1 && !strings.Contains(spec[:i], ":") {
+		from, to := spec[:i], spec[i+2:]
+		if from == "" {
+			from = "HEAD"
+		}
+		if to == "" {
+			to = "HEAD"
+		}
+
+		exclude, err := repo.ResolveRevision(from)
+		if err != nil {
+			return Revision{}, err
+		}
+
+		id, err := repo.ResolveRevision(to)
+		if err != nil {
+			return Revision{}, err
+		}
+
+		return Revision{ID: id, Exclude: exclude, IsRange: true}, nil
+	}
+
+	id, err := repo.ResolveRevision(spec)
+	return Revision{ID: id}, err
+}
+
+//ResolveRevision resolves a revision expression that denotes a
+//single object (i.e. anything ParseRevision supports but ranges)
+//into the object id.
+func (repo *Repository) ResolveRevision(spec string) (ObjectID, error) {
+	var id ObjectID
+
+	if spec == "" {
+		return id, &RevisionSyntaxError{spec, "empty revision"}
+	} else if strings.Contains(spec, "..") && !strings.Contains(spec, ":") {
+		return id, &RevisionSyntaxError{spec, "range where a single revision was expected"}
+	}
+
+	rev, pathstr, hasPath := spec, "", false
+	if i := strings.Index(spec, ":"); i > -1 {
+		rev, pathstr, hasPath = spec[:i], spec[i+1:], true
+		if rev == "" {
+			return id, &RevisionSyntaxError{spec, "index lookups are not supported"}
+		}
+	}
+
+	base, ops := rev, ""
+	if i := strings.IndexAny(rev, "~^"); i > -1 {
+		base, ops = rev[:i], rev[i:]
+	}
+
+	if base == "" {
+		return id, &RevisionSyntaxError{spec, "missing base revision"}
+	}
+
+	id, err := repo.resolveBaseRevision(base)
+	if err != nil {
+		return id, err
+	}
+
+	for ops != "" {
+		op := ops[0]
+		ops = ops[1:]
+
+		if op == '^' && strings.HasPrefix(ops, "{") {
+			end := strings.Index(ops, "}")
+			if end < 0 {
+				return id, &RevisionSyntaxError{spec, "unterminated ^{"}
+			}
+
+			otype := ops[1:end]
+			ops = ops[end+1:]
+
+			id, err = repo.peelRevision(id, otype)
+			if err != nil {
+				return id, err
+			}
+			continue
+		}
+
+		digits := len(ops) - len(strings.TrimLeft(ops, "0123456789"))
+		n := 1
+		if digits > 0 {
+			n, err = strconv.Atoi(ops[:digits])
+			if err != nil {
+				return id, &RevisionSyntaxError{spec, err.Error()}
+			}
+			ops = ops[digits:]
+		}
+
+		switch op {
+		case '~':
+			id, err = repo.nthParent(id, 0)
+			for i := 0; i < n && err == nil; i++ {
+				id, err = repo.nthParent(id, 1)
+			}
+			if err != nil {
+				return id, err
+			}
+		case '^':
+			id, err = repo.nthParent(id, n)
+			if err != nil {
+				return id, err
+			}
+		default:
+			return id, &RevisionSyntaxError{spec, fmt.Sprintf("unexpected %q", op)}
+		}
+	}
+
+	if hasPath {
+		return repo.idForPath(id, pathstr)
+	}
+
+	return id, nil
+}
+
+//resolveBaseRevision resolves ref names and (abbreviated)
+//object ids. Like in git, full object ids take precedence
+//over ref names, which take precedence over abbreviations.
+func (repo *Repository) resolveBaseRevision(name string) (ObjectID, error) {
+	if name == "@" {
+		name = "HEAD"
+	}
+
+	if len(name) == repo.ObjectFormat().HexSize() {
+		if id, err := ParseObjectID(name); err == nil {
+			return id, nil
+		}
+	}
+
+	// the rules from git-rev-parse(1), "SPECIFYING REVISIONS"
+	candidates := []string{
+		name,
+		path.Join("refs", name),
+		path.Join("refs", "tags", name),
+		path.Join("refs", "heads", name),
+		path.Join("refs", "remotes", name),
+		path.Join("refs", "remotes", name, "HEAD"),
+	}
+
+	for _, c := range candidates {
+		ref, err := repo.parseRef(c)
+		if err != nil {
+			continue
+		}
+		return ref.Resolve()
+	}
+
+	if len(name) >= minAbbrev && isHex(name) {
+		return repo.findAbbrev(strings.ToLower(name))
+	}
+
+	return ObjectID{}, fmt.Errorf("git: unknown revision %q", name)
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return false
+		}
+	}
+	return true
+}
+
+//findAbbrev looks for objects whose id starts with prefix
+//in the loose objects and all packs.
+func (repo *Repository) findAbbrev(prefix string) (ObjectID, error) {
+	found := make(map[ObjectID]bool)
+
+	dir := filepath.Join(repo.Path, "objects", prefix[:2])
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return ObjectID{}, err
+	}
+
+	for _, fi := range entries {
+		if !strings.HasPrefix(fi.Name(), prefix[2:]) {
+			continue
+		}
+
+		id, err := ParseObjectID(prefix[:2] + fi.Name())
+		if err == nil {
+			found[id] = true
+		}
+	}
+
+	packed, err := repo.packRegistry().findPrefix(prefix)
+	if err != nil {
+		return ObjectID{}, err
+	}
+
+	for _, id := range packed {
+		found[id] = true
+	}
+
+	var names []string
+	for id := range found {
+		names = append(names, id.String())
+	}
+	sort.Strings(names)
+
+	switch len(names) {
+	case 0:
+		return ObjectID{}, fmt.Errorf("git: unknown revision %q", prefix)
+	case 1:
+		return ParseObjectID(names[0])
+	}
+
+	ids := make([]ObjectID, len(names))
+	for i, name := range names {
+		ids[i], _ = ParseObjectID(name)
+	}
+
+	return ObjectID{}, &AmbiguousObjectError{Prefix: prefix, Candidates: ids}
+}
+
+//peelRevision implements "^{<type>}": tags are followed until
+//an object of the requested type is found, commits can be peeled
+//to their tree. An empty type peels to the first non-tag object.
+func (repo *Repository) peelRevision(id ObjectID, otype string) (ObjectID, error) {
+	var target ObjectType
+	switch otype {
+	case "", "object":
+	default:
+		var err error
+		target, err = ParseObjectType(otype)
+		if err != nil || !IsStandardObject(target) {
+			return id, &RevisionSyntaxError{"^{" + otype + "}", "unknown object type"}
+		}
+	}
+
+	for {
+		obj, err := repo.OpenObject(id)
+		if err != nil {
+			return id, err
+		}
+		obj.Close()
+
+		if otype == "object" || obj.Type() == target {
+			return id, nil
+		}
+
+		switch obj := obj.(type) {
+		case *Tag:
+			id = obj.Object
+			continue
+		case *Commit:
+			if target == ObjTree {
+				return obj.Tree, nil
+			}
+		}
+
+		if otype == "" {
+			return id, nil
+		}
+
+		return id, fmt.Errorf("git: %s is a %s, not a %s", id, obj.Type(), target)
+	}
+}
+
+//nthParent implements "^<n>", where n = 0 means the commit
+//itself. Tags are peeled first.
+func (repo *Repository) nthParent(id ObjectID, n int) (ObjectID, error) {
+	id, err := repo.peelRevision(id, "commit")
+	if err != nil || n == 0 {
+		return id, err
+	}
+
+	obj, err := repo.OpenObject(id)
+	if err != nil {
+		return id, err
+	}
+	obj.Close()
+
+	commit := obj.(*Commit)
+	if n > len(commit.Parent) {
+		return id, fmt.Errorf("git: commit %s has no parent %d", id, n)
+	}
+
+	return commit.Parent[n-1], nil
+}
+
+//idForPath implements "<rev>:<path>", i.e. it returns the id
+//of the object at path in the tree of the revision id.
+func (repo *Repository) idForPath(id ObjectID, pathstr string) (ObjectID, error) {
+	id, err := repo.peelRevision(id, "tree")
+	if err != nil {
+		return id, err
+	}
+
+	cleaned := path.Clean(strings.Trim(pathstr, "/"))
+	if cleaned == "." {
+		return id, nil
+	}
+
+	for _, comp := range strings.Split(cleaned, "/") {
+		obj, err := repo.OpenObject(id)
+		if err != nil {
+			return id, err
+		}
+
+		tree, ok := obj.(*Tree)
+		if !ok {
+			obj.Close()
+			return id, &os.PathError{Op: "find object", Path: pathstr,
+				Err: fmt.Errorf("expected tree object, got %s", obj.Type())}
+		}
+
+		var found bool
+		for tree.Next() {
+			entry := tree.Entry()
+			if entry.Name == comp {
+				id, found = entry.ID, true
+				break
+			}
+		}
+
+		err = tree.Err()
+		tree.Close()
+
+		if err != nil {
+			return id, &os.PathError{Op: "find object", Path: pathstr, Err: err}
+		} else if !found {
+			return id, &os.PathError{Op: "find object", Path: pathstr, Err: os.ErrNotExist}
+		}
+	}
+
+	return id, nil
+}
@@ -0,0 +1,164 @@
+package git
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveRevision(t *testing.T) {
+	tr := mkTestRepo(t)
+	defer tr.cleanup()
+
+	tr.write("a.txt", "a\n")
+	tr.write("dir/file.txt", "file\n")
+	tr.commit("first")
+	tr.git("tag", "-a", "-m", "annotated", "v1.0")
+
+	tr.git("checkout", "-q", "-b", "side")
+	tr.write("side.txt", "side\n")
+	tr.commit("side")
+
+	tr.git("checkout", "-q", "master")
+	tr.write("a.txt", "changed\n")
+	tr.commit("second")
+	tr.git("merge", "-q", "--no-ff", "-m", "merge", "side")
+
+	// some refs packed, some loose
+	tr.git("pack-refs", "--all")
+	tr.git("repack", "-a", "-d", "-q")
+	tr.write("b.txt", "b\n")
+	tr.commit("third")
+
+	head := tr.revParse("HEAD")
+	specs := []string{
+		"HEAD", "@", "master", "heads/master", "refs/heads/master", "side",
+		head.String(), head.String()[:7], strings.ToUpper(head.String()[:10]),
+		"HEAD~1", "HEAD~2", "HEAD~", "HEAD^", "HEAD^^2", "HEAD~1^2", "HEAD^0", "HEAD~3",
+		"v1.0", "v1.0^{}", "v1.0^{commit}", "v1.0^{tree}", "v1.0^{tag}", "v1.0~0",
+		"HEAD:", "HEAD:a.txt", "HEAD:dir", "HEAD:dir/file.txt", "HEAD~1:side.txt",
+		"v1.0:a.txt", "side^{tree}:dir/",
+	}
+
+	for _, spec := range specs {
+		id, err := tr.ResolveRevision(spec)
+		if err != nil {
+			t.Fatalf("ResolveRevision(%q) => %v", spec, err)
+		}
+
+		if expected := tr.revParse(spec); id != expected {
+			t.Fatalf("ResolveRevision(%q) => %s, expected %s", spec, id, expected)
+		}
+	}
+
+	rev, err := tr.ParseRevision("HEAD~2..side")
+	if err != nil || !rev.IsRange {
+		t.Fatalf("ParseRevision(\"HEAD~2..side\") => %v, %v", rev, err)
+	}
+
+	if rev.Exclude != tr.revParse("HEAD~2") || rev.ID != tr.revParse("side") {
+		t.Fatalf("ParseRevision(\"HEAD~2..side\") => wrong range: %s", rev)
+	}
+
+	rev, err = tr.ParseRevision("side..")
+	if err != nil || rev.ID != head {
+		t.Fatalf("ParseRevision(\"side..\") => %v, %v", rev, err)
+	}
+
+	bad := []string{
+		"", "nope", "HEAD^3", "HEAD~10", "HEAD..side..x", "HEAD...side",
+		"HEAD^{nope}", "HEAD^{blob}", "HEAD:a.txt/x", ":a.txt",
+		"refs/../../config", "../HEAD", "HEAD^{",
+	}
+
+	for _, spec := range bad {
+		id, err := tr.ResolveRevision(spec)
+		if err == nil {
+			t.Fatalf("ResolveRevision(%q) => %s, expected error", spec, id)
+		}
+		t.Logf("%q: %v", spec, err)
+	}
+
+	if _, err := tr.ResolveRevision("HEAD:nope.txt"); !os.IsNotExist(err) {
+		t.Fatalf("expected not-exists error for missing path, got %v", err)
+	}
+
+	if _, err := tr.ResolveRevision("HEAD...side"); err == nil {
+		t.Fatalf("expected syntax error for symmetric difference")
+	} else if _, ok := err.(*RevisionSyntaxError); !ok {
+		t.Fatalf("expected RevisionSyntaxError, got %T", err)
+	}
+}
+
+func TestAbbrevAmbiguous(t *testing.T) {
+	tr := mkTestRepo(t)
+	defer tr.cleanup()
+
+	// find two blobs whose ids share the first four hex digits
+	seen := make(map[string]string)
+	var first, second string
+	for i := 0; first == ""; i++ {
+		content := fmt.Sprintf("blob %d\n", i)
+		sum := sha1.Sum([]byte(fmt.Sprintf("blob %d\x00%s", len(content), content)))
+		prefix := fmt.Sprintf("%x", sum[:2])
+
+		if other, ok := seen[prefix]; ok {
+			first, second = other, content
+		}
+		seen[prefix] = content
+	}
+
+	// one of them packed, the other one loose
+	tr.write("first.txt", first)
+	tr.commit("first")
+	tr.git("repack", "-a", "-d", "-q")
+	tr.git("prune-packed")
+
+	tr.write("second.txt", second)
+	tr.git("hash-object", "-w", "second.txt")
+
+	a := tr.revParse(tr.git("hash-object", "first.txt"))
+	b := tr.revParse(tr.git("hash-object", "second.txt"))
+
+	_, err := tr.ResolveRevision(a.String()[:4])
+	amb, ok := err.(*AmbiguousObjectError)
+	if !ok {
+		t.Fatalf("expected AmbiguousObjectError for %.4s, got %v", a, err)
+	}
+
+	var foundA, foundB bool
+	for _, c := range amb.Candidates {
+		foundA = foundA || c == a
+		foundB = foundB || c == b
+	}
+
+	if !foundA || !foundB {
+		t.Fatalf("candidates %v must include %s and %s", amb.Candidates, a, b)
+	}
+	t.Logf("%v", amb)
+
+	// a longer prefix is unique again
+	for _, id := range []ObjectID{a, b} {
+		for n := 5; n < 40; n++ {
+			got, err := tr.ResolveRevision(id.String()[:n])
+			if _, ok := err.(*AmbiguousObjectError); ok {
+				continue
+			} else if err != nil || got != id {
+				t.Fatalf("ResolveRevision(%q) => %s, %v", id.String()[:n], got, err)
+			}
+			break
+		}
+	}
+
+	// objects that do not exist
+	if _, err := tr.ResolveRevision("0000000"); err == nil {
+		t.Fatalf("expected error for unknown abbreviated id")
+	}
+
+	if _, err := os.Stat(filepath.Join(tr.Path, "objects", a.String()[:2], a.String()[2:])); err == nil {
+		t.Fatalf("expected first blob to be packed")
+	}
+}
@@ -0,0 +1,581 @@
+package git
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+//ObjectInfo describes an object as it is stored in the repository.
+type ObjectInfo struct {
+	ID   ObjectID
+	Type ObjectType //type of the object, also if stored as delta
+	Size int64      //size of the (resolved) object data
+
+	//DiskSize is the size of the loose object file or of the
+	//object in the pack, including its header.
+	DiskSize int64
+
+	//Pack is the name of the pack file the object is stored in,
+	//empty for loose objects.
+	Pack string
+
+	//DeltaDepth is the length of the delta chain of a packed
+	//object, 0 if it is not stored as a delta. Chains that
+	//continue outside of the pack count as one.
+	DeltaDepth int
+
+	//ModTime is the modification time of the loose object
+	//file or of the pack file.
+	ModTime time.Time
+}
+
+//ObjectVisitor is called for every object by ForEachObject.
+//Returning true stops the iteration.
+type ObjectVisitor func(info ObjectInfo) bool
+
+//ForEachObject calls fn for every packed and then every loose object.
+//Only the object headers are read, data is not inflated except for
+//the start of deltas, which records the size of the target. Objects
+//that are stored more than once, e.g. loose and in a pack, are visited
+//once for every copy.
+func (repo *Repository) ForEachObject(fn ObjectVisitor) error {
+	indices, err := filepath.Glob(filepath.Join(repo.Path, "objects", "pack", "*.idx"))
+	if err != nil {
+		return err
+	}
+
+	for _, path := range indices {
+		stop, err := repo.forEachPacked(path, fn)
+		if err != nil || stop {
+			return err
+		}
+	}
+
+	return repo.forEachLoose(fn)
+}
+
+type packEntriesByOffset []PackIndexEntry
+
+func (e packEntriesByOffset) Len() int           { return len(e) }
+func (e packEntriesByOffset) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+func (e packEntriesByOffset) Less(i, j int) bool { return e[i].Offset < e[j].Offset }
+
+//packedType is the type and delta depth of the object at an offset.
+type packedType struct {
+	otype ObjectType
+	depth int
+}
+
+//packStat determines types and delta depths of the objects in a
+//pack, remembering them by offset so every chain is followed once.
+type packStat struct {
+	repo  *Repository
+	idx   *PackIndex
+	pf    *PackFile
+	known map[int64]packedType
+}
+
+//forEachPacked visits the objects of the pack of the index at path
+//in the order of their offsets. It returns true if fn stopped.
+func (repo *Repository) forEachPacked(path string, fn ObjectVisitor) (bool, error) {
+	idx, err := PackIndexOpen(path)
+	if err != nil {
+		return false, err
+	}
+	defer idx.Close()
+
+	pf, err := idx.OpenPackFile()
+	if err != nil {
+		return false, err
+	}
+	defer pf.Close()
+
+	entries := make([]PackIndexEntry, int(idx.FO[255]))
+	for pos := range entries {
+		if err = idx.ReadObjectID(&entries[pos].ID, pos); err != nil {
+			return false, err
+		}
+
+		if entries[pos].Offset, err = idx.ReadOffset(pos); err != nil {
+			return false, err
+		}
+	}
+
+	//the size on disk is the distance to the next object
+	sort.Sort(packEntriesByOffset(entries))
+	fi, err := pf.Stat()
+	if err != nil {
+		return false, err
+	}
+	end := fi.Size() - int64(pf.Format.Size())
+
+	name := filepath.Base(strings.TrimSuffix(path, ".idx")) + ".pack"
+	s := packStat{repo: repo, idx: idx, pf: pf, known: make(map[int64]packedType, len(entries))}
+
+	for i, e := range entries {
+		next := end
+		if i+1 < len(entries) {
+			next = entries[i+1].Offset
+		}
+
+		info := ObjectInfo{ID: e.ID, DiskSize: next - e.Offset, Pack: name, ModTime: fi.ModTime()}
+		info.Type, info.Size, info.DeltaDepth, err = s.stat(e.Offset)
+		if err != nil {
+			return false, fmt.Errorf("git: %s in %s: %v", e.ID, name, err)
+		}
+
+		if fn(info) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+//stat returns type, size and delta depth of the object at off.
+func (s *packStat) stat(off int64) (ObjectType, int64, int, error) {
+	obj, err := s.pf.readRawObject(off)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	if !IsDeltaObject(obj.otype) {
+		obj.Close()
+		s.known[off] = packedType{obj.otype, 0}
+		return obj.otype, obj.size, 0, nil
+	}
+
+	delta, err := parseDelta(obj)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	delta.Close()
+
+	//marks the object as in progress, to detect cycles
+	s.known[off] = packedType{}
+
+	base, err := s.base(delta)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	s.known[off] = packedType{base.otype, base.depth + 1}
+	return base.otype, delta.SizeTarget, base.depth + 1, nil
+}
+
+//base returns type and delta depth of the base of delta.
+func (s *packStat) base(delta *Delta) (packedType, error) {
+	off := delta.BaseOff
+	if delta.otype == ObjRefDelta {
+		var err error
+		off, err = s.idx.FindOffset(delta.BaseRef)
+		if err != nil {
+			otype, _, err := s.repo.statObject(delta.BaseRef)
+			return packedType{otype, 0}, err
+		}
+	}
+
+	if known, ok := s.known[off]; ok {
+		if known.otype == 0 {
+			return known, fmt.Errorf("delta cycle at offset %d", off)
+		}
+		return known, nil
+	}
+
+	//bases of ref deltas can come after the delta
+	otype, _, depth, err := s.stat(off)
+	return packedType{otype, depth}, err
+}
+
+//forEachLoose visits the loose objects. Objects that disappear while
+//iterating, e.g. because they were packed, are skipped.
+func (repo *Repository) forEachLoose(fn ObjectVisitor) error {
+	dirs, err := ioutil.ReadDir(filepath.Join(repo.Path, "objects"))
+	if err != nil {
+		return err
+	}
+
+	for _, dir := range dirs {
+		if !dir.IsDir() || len(dir.Name()) != 2 || !isHex(dir.Name()) {
+			continue
+		}
+
+		dirpath := filepath.Join(repo.Path, "objects", dir.Name())
+		files, err := ioutil.ReadDir(dirpath)
+		if err != nil {
+			return err
+		}
+
+		for _, fi := range files {
+			id, err := ParseObjectID(dir.Name() + fi.Name())
+			if err != nil {
+				//e.g. temporary files
+				continue
+			}
+
+			obj, err := openRawObject(filepath.Join(dirpath, fi.Name()))
+			if os.IsNotExist(err) {
+				continue
+			} else if err != nil {
+				return fmt.Errorf("git: %s: %v", id, err)
+			}
+			obj.Close()
+
+			info := ObjectInfo{ID: id, Type: obj.otype, Size: obj.size, DiskSize: fi.Size(), ModTime: fi.ModTime()}
+			if fn(info) {
+				return nil
+			}
+		}
+	}
+
+	return nil
+}
+
+//SizeConfig controls what SizeReport collects.
+type SizeConfig struct {
+	//Top is the number of entries in the lists of the report.
+	Top int
+
+	//LargeFile is the size in bytes above which blobs are
+	//counted as large files.
+	LargeFile int64
+
+	//MaxPaths is the maximum number of paths that are
+	//recorded for each of the largest blobs.
+	MaxPaths int
+}
+
+//DefaultSizeConfig is used by gin-git stats.
+var DefaultSizeConfig = SizeConfig{
+	Top:       10,
+	LargeFile: 10 * 1024 * 1024,
+	MaxPaths:  5,
+}
+
+//ObjectCount is the number and total size of objects.
+type ObjectCount struct {
+	Count    int64
+	Size     int64
+	DiskSize int64
+}
+
+//BlobSize is one of the largest blobs of a SizeReport.
+type BlobSize struct {
+	ID       ObjectID
+	Size     int64
+	DiskSize int64
+
+	//Paths are paths of the blob in the history, empty
+	//if it is not reachable from any ref.
+	Paths []string
+}
+
+//TreeDepth is one of the deepest trees of a SizeReport. Depth is
+//the number of directories in Path, 0 for root trees.
+type TreeDepth struct {
+	ID    ObjectID
+	Depth int
+	Path  string
+}
+
+//DeltaDepth is one of the objects with the longest delta chains
+//of a SizeReport.
+type DeltaDepth struct {
+	ID    ObjectID
+	Type  ObjectType
+	Depth int
+}
+
+//SizeReport is the result of analyzing the size of a repository.
+//Objects are counted once, even if they are stored more than once.
+type SizeReport struct {
+	Commits ObjectCount
+	Trees   ObjectCount
+	Blobs   ObjectCount
+	Tags    ObjectCount
+
+	//Duplicates is the number of additional copies of objects,
+	//e.g. in more than one pack; they are not counted above.
+	Duplicates int64
+
+	LargestBlobs       []BlobSize
+	DeepestTrees       []TreeDepth //reachable trees only
+	LongestDeltaChains []DeltaDepth
+
+	//LargeFiles are blobs larger than SizeConfig.LargeFile, which
+	//are stored directly in git instead of in the annex.
+	LargeFiles ObjectCount
+}
+
+//Total returns the counts summed over all object types.
+func (r *SizeReport) Total() ObjectCount {
+	var total ObjectCount
+	for _, c := range []ObjectCount{r.Commits, r.Trees, r.Blobs, r.Tags} {
+		total.Count += c.Count
+		total.Size += c.Size
+		total.DiskSize += c.DiskSize
+	}
+	return total
+}
+
+func (c *ObjectCount) add(info ObjectInfo) {
+	c.Count++
+	c.Size += info.Size
+	c.DiskSize += info.DiskSize
+}
+
+//topIndex returns the position of an entry with the value v in a
+//list of length n that is sorted by val in decreasing order and
+//limited to top entries, -1 if it would not make the list. Entries
+//with the same value are kept in the order they were added.
+func topIndex(top, n int, v int64, val func(i int) int64) int {
+	i := sort.Search(n, func(i int) bool { return val(i) < v })
+	if i >= top {
+		return -1
+	}
+	return i
+}
+
+//SizeReport analyzes which objects take up the space in the
+//repository. It enumerates all objects via ForEachObject and then
+//walks the history from all refs to find the paths of the largest
+//blobs and the deepest trees.
+func (repo *Repository) SizeReport(cfg SizeConfig) (*SizeReport, error) {
+	r := &SizeReport{}
+	seen := make(map[ObjectID]bool)
+
+	err := repo.ForEachObject(func(info ObjectInfo) bool {
+		if seen[info.ID] {
+			r.Duplicates++
+			return false
+		}
+		seen[info.ID] = true
+
+		switch info.Type {
+		case ObjCommit:
+			r.Commits.add(info)
+		case ObjTree:
+			r.Trees.add(info)
+		case ObjBlob:
+			r.Blobs.add(info)
+			r.addBlob(info, cfg)
+		case ObjTag:
+			r.Tags.add(info)
+		}
+
+		if info.DeltaDepth == 0 {
+			return false
+		}
+
+		chains := r.LongestDeltaChains
+		i := topIndex(cfg.Top, len(chains), int64(info.DeltaDepth), func(i int) int64 { return int64(chains[i].Depth) })
+		if i >= 0 {
+			chains = append(chains[:i], append([]DeltaDepth{{info.ID, info.Type, info.DeltaDepth}}, chains[i:]...)...)
+			if len(chains) > cfg.Top {
+				chains = chains[:cfg.Top]
+			}
+			r.LongestDeltaChains = chains
+		}
+
+		return false
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = repo.walkSizeReport(r, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *SizeReport) addBlob(info ObjectInfo, cfg SizeConfig) {
+	if info.Size > cfg.LargeFile {
+		r.LargeFiles.add(info)
+	}
+
+	blobs := r.LargestBlobs
+	i := topIndex(cfg.Top, len(blobs), info.Size, func(i int) int64 { return blobs[i].Size })
+	if i < 0 {
+		return
+	}
+
+	blob := BlobSize{ID: info.ID, Size: info.Size, DiskSize: info.DiskSize}
+	blobs = append(blobs[:i], append([]BlobSize{blob}, blobs[i:]...)...)
+	if len(blobs) > cfg.Top {
+		blobs = blobs[:cfg.Top]
+	}
+	r.LargestBlobs = blobs
+}
+
+//sizeWalk holds the state of walking the trees for a SizeReport.
+type sizeWalk struct {
+	repo   *Repository
+	report *SizeReport
+	cfg    SizeConfig
+
+	blobs   map[ObjectID]*BlobSize //the largest blobs
+	trees   map[ObjectID]bool      //trees in DeepestTrees
+	visited map[treePath]bool
+}
+
+//treePath is a tree at a path; the same tree at another path can
+//lead to other paths of blobs, so it is visited again.
+type treePath struct {
+	id   ObjectID
+	path string
+}
+
+//walkSizeReport walks the trees of all commits that are reachable
+//from refs and HEAD, and of tags pointing to trees.
+func (repo *Repository) walkSizeReport(r *SizeReport, cfg SizeConfig) error {
+	w := &sizeWalk{
+		repo:    repo,
+		report:  r,
+		cfg:     cfg,
+		blobs:   make(map[ObjectID]*BlobSize),
+		trees:   make(map[ObjectID]bool),
+		visited: make(map[treePath]bool),
+	}
+
+	for i := range r.LargestBlobs {
+		w.blobs[r.LargestBlobs[i].ID] = &r.LargestBlobs[i]
+	}
+
+	refs, err := repo.ListRefs("refs/")
+	if err != nil {
+		return err
+	}
+
+	if head, err := repo.parseRef("HEAD"); err == nil {
+		refs = append(refs, head)
+	}
+
+	graph := NewCommitGraph(repo)
+	for _, ref := range refs {
+		id, err := repo.PeelRef(ref)
+		if err != nil {
+			//e.g. HEAD of an empty repository
+			continue
+		}
+
+		otype, _, err := repo.statObject(id)
+		if err != nil {
+			return err
+		}
+
+		switch otype {
+		case ObjCommit:
+			if _, err = graph.AddTip(id); err != nil {
+				return err
+			}
+		case ObjTree:
+			if err = w.walkTree("", id); err != nil {
+				return err
+			}
+		}
+	}
+
+	var werr error
+	err = graph.VisitCommits(func(node *CommitNode) bool {
+		werr = w.walkTree("", node.tree)
+		return werr != nil
+	})
+
+	if err != nil {
+		return err
+	}
+	return werr
+}
+
+func (w *sizeWalk) walkTree(path string, id ObjectID) error {
+	key := treePath{id, path}
+	if w.visited[key] {
+		return nil
+	}
+	w.visited[key] = true
+
+	w.addTree(path, id)
+
+	obj, err := w.repo.OpenObject(id)
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+
+	tree, ok := obj.(*Tree)
+	if !ok {
+		return fmt.Errorf("git: %s is a %s, not a tree", id, obj.Type())
+	}
+
+	for tree.Next() {
+		entry := tree.Entry()
+		epath := entry.Name
+		if path != "" {
+			epath = path + "/" + entry.Name
+		}
+
+		switch entry.Mode {
+		case 040000:
+			if err = w.walkTree(epath, entry.ID); err != nil {
+				return err
+			}
+		case 0160000:
+			//submodule commits live in other repositories
+		default:
+			w.addPath(epath, entry.ID)
+		}
+	}
+
+	return tree.Err()
+}
+
+//addTree records the tree if it is one of the deepest ones.
+func (w *sizeWalk) addTree(path string, id ObjectID) {
+	if w.trees[id] {
+		return
+	}
+
+	depth := 0
+	if path != "" {
+		depth = strings.Count(path, "/") + 1
+	}
+
+	trees := w.report.DeepestTrees
+	i := topIndex(w.cfg.Top, len(trees), int64(depth), func(i int) int64 { return int64(trees[i].Depth) })
+	if i < 0 {
+		return
+	}
+
+	trees = append(trees[:i], append([]TreeDepth{{id, depth, path}}, trees[i:]...)...)
+	if len(trees) > w.cfg.Top {
+		delete(w.trees, trees[w.cfg.Top].ID)
+		trees = trees[:w.cfg.Top]
+	}
+	w.trees[id] = true
+	w.report.DeepestTrees = trees
+}
+
+//addPath records path as a path of the blob, if it is one of the
+//largest blobs.
+func (w *sizeWalk) addPath(path string, id ObjectID) {
+	blob, ok := w.blobs[id]
+	if !ok || len(blob.Paths) >= w.cfg.MaxPaths {
+		return
+	}
+
+	for _, p := range blob.Paths {
+		if p == path {
+			return
+		}
+	}
+	blob.Paths = append(blob.Paths, path)
+}
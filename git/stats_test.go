@@ -0,0 +1,156 @@
+package git
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestForEachObject(t *testing.T) {
+	tr, _ := mkDeltaRepo(t, 20)
+	defer tr.cleanup()
+
+	tr.write("loose.txt", "loose\n")
+	tr.commit("loose")
+
+	expected := make(map[ObjectID]ObjectInfo)
+	out := tr.git("cat-file", "--batch-all-objects", "--batch-check=%(objectname) %(objecttype) %(objectsize) %(objectsize:disk)")
+	for _, l := range strings.Split(out, "\n") {
+		var id, otype string
+		var info ObjectInfo
+		fmt.Sscan(l, &id, &otype, &info.Size, &info.DiskSize)
+		info.ID, _ = ParseObjectID(id)
+		info.Type, _ = ParseObjectType(otype)
+		expected[info.ID] = info
+	}
+
+	//the chain lengths of the packed objects
+	indices, _ := filepath.Glob(filepath.Join(tr.Path, "objects", "pack", "*.idx"))
+	if len(indices) != 1 {
+		t.Fatalf("expected one pack, got %v", indices)
+	}
+
+	depths := make(map[ObjectID]int)
+	out = tr.git("verify-pack", "-v", indices[0])
+	for _, l := range strings.Split(out, "\n") {
+		//id type size size-in-pack offset [depth base-id]
+		f := strings.Fields(l)
+		if len(f) != 5 && len(f) != 7 {
+			continue
+		}
+
+		id, _ := ParseObjectID(f[0])
+		depths[id] = 0
+		if len(f) == 7 {
+			depths[id], _ = strconv.Atoi(f[5])
+		}
+	}
+
+	found := make(map[ObjectID]bool)
+	err := tr.ForEachObject(func(info ObjectInfo) bool {
+		if found[info.ID] {
+			t.Errorf("%s visited twice", info.ID)
+		}
+		found[info.ID] = true
+
+		exp, ok := expected[info.ID]
+		if !ok {
+			t.Errorf("unexpected object %s", info.ID)
+			return false
+		}
+
+		exp.Pack = info.Pack
+		exp.DeltaDepth = depths[info.ID]
+		exp.ModTime = info.ModTime
+		if info != exp {
+			t.Errorf("ForEachObject: got %+v, expected %+v", info, exp)
+		}
+
+		if info.ModTime.IsZero() {
+			t.Errorf("%s: no modification time", info.ID)
+		} else if _, packed := depths[info.ID]; packed == (info.Pack == "") {
+			t.Errorf("%s: unexpected pack %q", info.ID, info.Pack)
+		}
+		return false
+	})
+
+	if err != nil {
+		t.Fatalf("ForEachObject() => %v", err)
+	} else if len(found) != len(expected) {
+		t.Fatalf("ForEachObject: visited %d objects, expected %d", len(found), len(expected))
+	}
+
+	n := 0
+	err = tr.ForEachObject(func(info ObjectInfo) bool {
+		n++
+		return true
+	})
+	if err != nil || n != 1 {
+		t.Fatalf("ForEachObject: expected to stop after one object, got %d, %v", n, err)
+	}
+}
+
+func TestSizeReport(t *testing.T) {
+	tr, _ := mkDeltaRepo(t, 20)
+	defer tr.cleanup()
+
+	big := strings.Repeat("0123456789abcdef\n", 10000)
+	tr.write("deep/a/b/c/big.bin", big)
+	tr.commit("big file")
+	tr.write("copy.bin", big)
+	tr.commit("copy of the big file")
+	tr.git("tag", "-a", "-m", "a tag", "v1")
+
+	cfg := SizeConfig{Top: 3, LargeFile: 100000, MaxPaths: 5}
+	report, err := tr.SizeReport(cfg)
+	if err != nil {
+		t.Fatalf("SizeReport() => %v", err)
+	}
+
+	objects := strings.Split(tr.git("cat-file", "--batch-all-objects", "--batch-check"), "\n")
+	if total := report.Total(); total.Count != int64(len(objects)) || report.Duplicates != 0 {
+		t.Errorf("expected %d objects, got %+v", len(objects), total)
+	}
+
+	if report.Commits.Count != 22 || report.Tags.Count != 1 {
+		t.Errorf("unexpected counts: %+v commits, %+v tags", report.Commits, report.Tags)
+	}
+
+	if len(report.LargestBlobs) != cfg.Top {
+		t.Fatalf("expected %d largest blobs, got %+v", cfg.Top, report.LargestBlobs)
+	}
+
+	blob := report.LargestBlobs[0]
+	sort.Strings(blob.Paths)
+	if blob.ID != tr.revParse("HEAD:copy.bin") || blob.Size != int64(len(big)) {
+		t.Errorf("unexpected largest blob %+v", blob)
+	} else if paths := []string{"copy.bin", "deep/a/b/c/big.bin"}; !reflect.DeepEqual(blob.Paths, paths) {
+		t.Errorf("largest blob: got paths %q, expected %q", blob.Paths, paths)
+	}
+
+	if report.LargeFiles.Count != 1 || report.LargeFiles.Size != int64(len(big)) {
+		t.Errorf("unexpected large files %+v", report.LargeFiles)
+	}
+
+	expected := []TreeDepth{
+		{tr.revParse("HEAD:deep/a/b/c"), 4, "deep/a/b/c"},
+		{tr.revParse("HEAD:deep/a/b"), 3, "deep/a/b"},
+		{tr.revParse("HEAD:deep/a"), 2, "deep/a"},
+	}
+	if !reflect.DeepEqual(report.DeepestTrees, expected) {
+		t.Errorf("DeepestTrees: got %+v, expected %+v", report.DeepestTrees, expected)
+	}
+
+	if len(report.LongestDeltaChains) == 0 {
+		t.Fatalf("expected delta chains")
+	}
+	for i, c := range report.LongestDeltaChains {
+		if c.Type != ObjBlob || i > 0 && c.Depth > report.LongestDeltaChains[i-1].Depth {
+			t.Errorf("unexpected delta chains %+v", report.LongestDeltaChains)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+//Submodule is a repository that is included in a tree as gitlink,
+//a tree entry with mode 0160000 that records a commit of it. The
+//commit is not an object of the including repository.
+type Submodule struct {
+	Name   string //name in .gitmodules, empty if there is none
+	Path   string
+	URL    string
+	Branch string
+
+	Commit ObjectID //the commit of the gitlink
+}
+
+//SubmoduleError is the error of ObjectForPath for the path of a
+//submodule, whose commit is not in the repository.
+type SubmoduleError struct {
+	Path   string
+	Commit ObjectID
+}
+
+func (e *SubmoduleError) Error() string {
+	return fmt.Sprintf("git: %s is a submodule at commit %s", e.Path, e.Commit)
+}
+
+//ParseGitmodules parses the content of a .gitmodules file, see
+//gitmodules(5). Submodules without a path are skipped, like git
+//does. The Commit of the submodules is zero.
+func ParseGitmodules(data []byte) ([]Submodule, error) {
+	cfg, err := parseConfig(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var mods []Submodule
+	for _, name := range cfg.subsections("submodule") {
+		p, _ := cfg.Get("submodule", name, "path")
+		p = strings.Trim(path.Clean("/"+p), "/")
+		if p == "" {
+			continue
+		}
+
+		url, _ := cfg.Get("submodule", name, "url")
+		branch, _ := cfg.Get("submodule", name, "branch")
+		mods = append(mods, Submodule{Name: name, Path: p, URL: url, Branch: branch})
+	}
+
+	return mods, nil
+}
+
+//Submodules returns the submodules in the tree of id, which can be
+//a commit, a tag or a tree, as described by the .gitmodules file
+//in its root. Entries of the file without a gitlink in the tree
+//are skipped.
+func (repo *Repository) Submodules(id ObjectID) ([]Submodule, error) {
+	tree, err := repo.peelRevision(id, "tree")
+	if err != nil {
+		return nil, err
+	}
+
+	return repo.treeSubmodules(tree)
+}
+
+func (repo *Repository) treeSubmodules(tree ObjectID) ([]Submodule, error) {
+	entry, err := repo.lookupPath(tree, []string{".gitmodules"})
+	if err != nil || fileKind(entry.Mode) != 0100000 {
+		return nil, err
+	}
+
+	data, big, err := repo.changeData(uint32(entry.Mode), entry.ID, 16*1024*1024)
+	if err != nil {
+		return nil, err
+	} else if big {
+		return nil, fmt.Errorf("git: .gitmodules too large")
+	}
+
+	mods, err := ParseGitmodules(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []Submodule
+	for _, mod := range mods {
+		entry, err := repo.lookupPath(tree, strings.Split(mod.Path, "/"))
+		if err != nil {
+			return nil, err
+		} else if fileKind(entry.Mode) != 0160000 {
+			continue
+		}
+
+		mod.Commit = entry.ID
+		res = append(res, mod)
+	}
+
+	return res, nil
+}
+
+//SubmoduleForPath returns the submodule at the path in the tree of
+//id. The Name, URL and Branch are empty if .gitmodules has no
+//entry for the path.
+func (repo *Repository) SubmoduleForPath(id ObjectID, pathstr string) (Submodule, error) {
+	tree, err := repo.peelRevision(id, "tree")
+	if err != nil {
+		return Submodule{}, err
+	}
+
+	pathstr = strings.Trim(path.Clean("/"+pathstr), "/")
+	entry, err := repo.lookupPath(tree, strings.Split(pathstr, "/"))
+	if err != nil {
+		return Submodule{}, err
+	} else if fileKind(entry.Mode) != 0160000 {
+		return Submodule{}, &os.PathError{Op: "find submodule", Path: pathstr, Err: os.ErrNotExist}
+	}
+
+	mods, err := repo.treeSubmodules(tree)
+	if err != nil {
+		return Submodule{}, err
+	}
+
+	for _, mod := range mods {
+		if mod.Path == pathstr {
+			return mod, nil
+		}
+	}
+
+	return Submodule{Path: pathstr, Commit: entry.ID}, nil
+}
@@ -0,0 +1,174 @@
+package git
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+const testGitmodules = `# submodules
+[submodule "lib/shared"]
+	path = lib/shared
+	url = ../shared.git
+	branch = main
+[submodule "Tools"] ; tools
+	path = tools/
+	url = "git@example.com:alice/tools.git" # comment
+[submodule "stale"]
+	path = stale
+	url = https://example.com/stale.git
+[submodule "nopath"]
+	url = https://example.com/nopath.git
+`
+
+var configTests = []struct {
+	section, subsection, key string
+}{
+	{"core", "", "bare"},
+	{"core", "", "editor"},
+	{"remote", "origin", "url"},
+	{"remote", "origin", "fetch"},
+	{"remote", "Up", "url"},
+	{"user", "", "name"},
+	{"section", "sub", "key"},
+}
+
+const testConfig = `[core]
+	bare = false
+	editor = "vim -c \"set tw=72\"" ; the editor
+[remote "origin"]
+	url = https://example.com/a.git
+	fetch = +refs/heads/*:refs/remotes/origin/* # the refspec
+	fetch = +refs/tags/*:refs/tags/*
+[Remote "Up"] url = git@example.com:up.git
+[user]
+	name = A \
+U   Thor
+[section.sub]
+	key
+`
+
+func TestConfig(t *testing.T) {
+	tr := mkTestRepo(t)
+	defer tr.cleanup()
+
+	tr.write("test.config", testConfig)
+
+	cfg, err := parseConfig([]byte(testConfig))
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+
+	for _, tt := range configTests {
+		got, ok := cfg.Get(tt.section, tt.subsection, tt.key)
+		if !ok {
+			t.Errorf("Get(%q, %q, %q): not found", tt.section, tt.subsection, tt.key)
+			continue
+		}
+
+		name := tt.section + "." + tt.key
+		if tt.subsection != "" {
+			name = tt.section + "." + tt.subsection + "." + tt.key
+		}
+
+		expected := tr.git("config", "-f", "test.config", "--get-all", name)
+		if tt.key == "fetch" {
+			expected = "+refs/tags/*:refs/tags/*"
+		} else if tt.key == "key" {
+			expected = tr.git("config", "-f", "test.config", "--bool", name)
+		}
+
+		if got != expected {
+			t.Errorf("Get(%q, %q, %q): got %q, expected %q", tt.section, tt.subsection, tt.key, got, expected)
+		}
+	}
+
+	for _, bad := range []string{"key = value\n", "[core\n", "[core]\nkey = \"open\n", "[core]\nkey = a\\x\n"} {
+		if _, err := parseConfig([]byte(bad)); err == nil {
+			t.Errorf("parseConfig(%q): expected error", bad)
+		}
+	}
+}
+
+func TestSubmodules(t *testing.T) {
+	tr := mkTestRepo(t)
+	defer tr.cleanup()
+
+	tr.write("README", "submodules\n")
+	tr.write(".gitmodules", testGitmodules)
+	shared := tr.commit("first")
+
+	tools := tr.revParse("HEAD^{tree}")
+	tr.git("update-index", "--add", "--cacheinfo", "160000,"+shared.String()+",lib/shared")
+	tr.git("update-index", "--add", "--cacheinfo", "160000,"+tools.String()+",tools")
+	tr.git("update-index", "--add", "--cacheinfo", "160000,"+tools.String()+",other")
+	tr.git("commit", "-q", "-m", "submodules")
+	commit := tr.revParse("HEAD")
+
+	mods, err := tr.Submodules(commit)
+	if err != nil {
+		t.Fatalf("Submodules: %v", err)
+	}
+
+	expected := []Submodule{
+		{Name: "lib/shared", Path: "lib/shared", URL: "../shared.git", Branch: "main", Commit: shared},
+		{Name: "Tools", Path: "tools", URL: "git@example.com:alice/tools.git", Commit: tools},
+	}
+
+	if !reflect.DeepEqual(mods, expected) {
+		t.Errorf("Submodules: got %+v, expected %+v", mods, expected)
+	}
+
+	mod, err := tr.SubmoduleForPath(commit, "/tools/")
+	if err != nil {
+		t.Fatalf("SubmoduleForPath(tools): %v", err)
+	} else if mod != expected[1] {
+		t.Errorf("SubmoduleForPath(tools): got %+v, expected %+v", mod, expected[1])
+	}
+
+	//gitlinks without an entry in .gitmodules
+	mod, err = tr.SubmoduleForPath(commit, "other")
+	if err != nil {
+		t.Fatalf("SubmoduleForPath(other): %v", err)
+	} else if mod != (Submodule{Path: "other", Commit: tools}) {
+		t.Errorf("SubmoduleForPath(other): got %+v", mod)
+	}
+
+	if _, err = tr.SubmoduleForPath(commit, "README"); !os.IsNotExist(err) {
+		t.Errorf("SubmoduleForPath(README): expected not exist error, got %v", err)
+	}
+
+	root, err := tr.OpenObject(commit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = tr.ObjectForPath(root, "lib/shared")
+	if serr, ok := err.(*SubmoduleError); !ok {
+		t.Errorf("ObjectForPath(lib/shared): expected SubmoduleError, got %v", err)
+	} else if serr.Path != "lib/shared" || serr.Commit != shared {
+		t.Errorf("ObjectForPath(lib/shared): got %+v", serr)
+	}
+
+	if _, err = tr.ObjectForPath(root, "lib/shared/README"); !os.IsNotExist(err) {
+		t.Errorf("ObjectForPath(lib/shared/README): expected not exist error, got %v", err)
+	}
+
+	obj, err := tr.ObjectForPath(root, "lib")
+	if err != nil {
+		t.Fatalf("ObjectForPath(lib): %v", err)
+	}
+
+	tree := obj.(*Tree)
+	for tree.Next() {
+		if entry := tree.Entry(); entry.Name == "shared" && entry.Type != ObjCommit {
+			t.Errorf("gitlink entry has type %s", entry.Type)
+		}
+	}
+	tree.Close()
+
+	//no .gitmodules at all
+	if mods, err = tr.Submodules(shared); err != nil || len(mods) != 0 {
+		t.Errorf("Submodules(first): got %v, %v", mods, err)
+	}
+}
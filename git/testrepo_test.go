@@ -0,0 +1,125 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//testRepo is a temporary, non-bare git repository that is
+//created and modified via the git binary. The embedded
+//Repository points to its git dir.
+type testRepo struct {
+	*Repository
+
+	t     *testing.T
+	work  string
+	clock int64
+}
+
+func mkTestRepo(t *testing.T) *testRepo {
+	return mkTestRepoFormat(t, FormatSHA1)
+}
+
+//mkTestRepoFormat creates a test repository whose objects
+//are in the given format.
+func mkTestRepoFormat(t *testing.T, format ObjectFormat) *testRepo {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("[W] Could not find git binary. Skipping test")
+	}
+
+	dir, err := ioutil.TempDir("", "gin-repo-test")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+
+	tr := &testRepo{t: t, work: dir, clock: 1480000000}
+	if format == FormatSHA1 {
+		tr.git("init", "-q", ".")
+	} else {
+		tr.git("init", "-q", "--object-format="+format.String(), ".")
+	}
+	tr.Repository = &Repository{Path: filepath.Join(dir, ".git")}
+
+	return tr
+}
+
+func (tr *testRepo) cleanup() {
+	tr.Close()
+	err := os.RemoveAll(tr.work)
+	if err != nil {
+		tr.t.Logf("[W] Could not remove test repo: %q", tr.work)
+	}
+}
+
+//git runs the git command in the working tree and returns
+//its output with trailing newlines removed.
+func (tr *testRepo) git(args ...string) string {
+	out := tr.gitRaw(nil, args...)
+	return strings.TrimRight(string(out), "\n")
+}
+
+//gitRaw runs the git command in the working tree with the
+//given input and returns its unmodified output.
+func (tr *testRepo) gitRaw(stdin io.Reader, args ...string) []byte {
+	tr.clock += 60
+	date := fmt.Sprintf("%d +0100", tr.clock)
+
+	cmd := exec.Command("git", args...)
+	cmd.Dir = tr.work
+	cmd.Stdin = stdin
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=A U Thor",
+		"GIT_AUTHOR_EMAIL=author@example.com",
+		"GIT_AUTHOR_DATE="+date,
+		"GIT_COMMITTER_NAME=C O Mitter",
+		"GIT_COMMITTER_EMAIL=committer@example.com",
+		"GIT_COMMITTER_DATE="+date,
+		"GIT_CONFIG_NOSYSTEM=1",
+		"HOME="+tr.work,
+	)
+
+	out, err := cmd.Output()
+	if err != nil {
+		msg := ""
+		if ee, ok := err.(*exec.ExitError); ok {
+			msg = string(ee.Stderr)
+		}
+		tr.t.Fatalf("git %s failed: %v\n%s", strings.Join(args, " "), err, msg)
+	}
+
+	return out
+}
+
+func (tr *testRepo) write(name, content string) {
+	path := filepath.Join(tr.work, name)
+	err := os.MkdirAll(filepath.Dir(path), 0777)
+	if err == nil {
+		err = ioutil.WriteFile(path, []byte(content), 0666)
+	}
+
+	if err != nil {
+		tr.t.Fatalf("could not write %q: %v", name, err)
+	}
+}
+
+//commit stages everything in the working tree and
+//commits it, returning the id of the new commit.
+func (tr *testRepo) commit(msg string) ObjectID {
+	tr.git("add", "-A", ".")
+	tr.git("commit", "-q", "--allow-empty", "-m", msg)
+	return tr.revParse("HEAD")
+}
+
+func (tr *testRepo) revParse(rev string) ObjectID {
+	id, err := ParseObjectID(tr.git("rev-parse", rev))
+	if err != nil {
+		tr.t.Fatalf("could not parse rev-parse output for %q: %v", rev, err)
+	}
+	return id
+}
@@ -11,11 +11,15 @@ func readUntilNul(r io.Reader) (string, error) {
 	buf := bytes.NewBuffer(make([]byte, 0))
 	for {
 		var b [1]byte
-		_, err := r.Read(b[:])
-		if err != nil {
-			return "", err
-		} else if b[0] == 0 {
+		n, err := r.Read(b[:])
+		if n == 1 && b[0] == 0 {
+			//the reader might return io.EOF together
+			//with the last byte, e.g. for empty blobs
 			break
+		} else if err != nil {
+			return "", err
+		} else if n == 0 {
+			continue
 		}
 		buf.WriteByte(b[0])
 	}
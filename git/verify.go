@@ -0,0 +1,376 @@
+package git
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	pgperrors "golang.org/x/crypto/openpgp/errors"
+	"golang.org/x/crypto/openpgp/packet"
+	"golang.org/x/crypto/ssh"
+)
+
+//SignatureStatus is the result of verifying the signature of a
+//commit or tag.
+type SignatureStatus int
+
+//SignatureStatus values. A signature is SignatureUnverified if it
+//does not match the object, could not be checked at all or was made
+//with a key that does not belong to the email of the committer or
+//tagger, and SignatureUnknownKey if none of the known keys made it.
+const (
+	SignatureNone SignatureStatus = iota
+	SignatureVerified
+	SignatureUnverified
+	SignatureUnknownKey
+)
+
+func (s SignatureStatus) String() string {
+	switch s {
+	case SignatureVerified:
+		return "verified"
+	case SignatureUnverified:
+		return "unverified"
+	case SignatureUnknownKey:
+		return "unknown-key"
+	}
+	return "unsigned"
+}
+
+//SigningKeys are the public keys signatures are verified with,
+//and the owners of the keys.
+type SigningKeys struct {
+	OpenPGP openpgp.EntityList
+
+	//OpenPGPOwners maps the fingerprints of the primary keys in
+	//OpenPGP, as uppercase hex, to their owners.
+	OpenPGPOwners map[string]string
+
+	//SSHKeyOwner returns the owner of the SSH key, false if the
+	//key is unknown. It can be nil.
+	SSHKeyOwner func(key ssh.PublicKey) (KeyOwner, bool)
+}
+
+//KeyOwner is the user an SSH key belongs to. Signatures made with
+//the key are only verified for commits and tags of that email.
+type KeyOwner struct {
+	User  string
+	Email string
+}
+
+//Verification is the result of verifying a signature.
+type Verification struct {
+	Status SignatureStatus
+	Format string //"openpgp", "ssh" or "x509"
+	Key    string //OpenPGP key id in hex or SSH key fingerprint
+	Signer string //owner of the key, for verified signatures
+}
+
+//signature start lines, see gpg-interface.c
+var signatureFormats = []struct {
+	start  string
+	format string
+}{
+	{"-----BEGIN PGP SIGNATURE-----", "openpgp"},
+	{"-----BEGIN PGP MESSAGE-----", "openpgp"},
+	{"-----BEGIN SSH SIGNATURE-----", "ssh"},
+	{"-----BEGIN SIGNED MESSAGE-----", "x509"},
+}
+
+func signatureFormat(line []byte) string {
+	for _, f := range signatureFormats {
+		if bytes.HasPrefix(line, []byte(f.start)) {
+			return f.format
+		}
+	}
+	return ""
+}
+
+//commitSignatureHeaders are the headers of commit signatures by
+//object format, the signature is made over the commit in that format.
+var commitSignatureHeaders = map[ObjectFormat]string{
+	FormatSHA1:   "gpgsig",
+	FormatSHA256: "gpgsig-sha256",
+}
+
+//splitCommitSignature returns the commit data without the signature
+//headers, and the signature in the header for the format, nil if
+//there is none.
+func splitCommitSignature(data []byte, format ObjectFormat) ([]byte, []byte) {
+	var payload, sig []byte
+	insig, other := false, false
+
+	for len(data) > 0 {
+		n := bytes.IndexByte(data, '\n') + 1
+		if n == 0 {
+			n = len(data)
+		}
+		line := data[:n]
+		data = data[n:]
+
+		if (insig || other) && bytes.HasPrefix(line, []byte(" ")) {
+			if insig {
+				sig = append(sig, line[1:]...)
+			}
+			continue
+		}
+		insig, other = false, false
+
+		for f, header := range commitSignatureHeaders {
+			if bytes.HasPrefix(line, []byte(header+" ")) {
+				insig, other = f == format, f != format
+				if insig {
+					sig = append(sig, line[len(header)+1:]...)
+				}
+			}
+		}
+
+		if insig || other {
+			continue
+		}
+
+		payload = append(payload, line...)
+		if len(line) == 1 && line[0] == '\n' {
+			//end of the headers
+			payload = append(payload, data...)
+			break
+		}
+	}
+
+	return payload, sig
+}
+
+//splitTagSignature returns the tag data up to the last line that
+//starts a signature, and the signature, nil if there is none.
+func splitTagSignature(data []byte) ([]byte, []byte) {
+	start := -1
+	for off := 0; off < len(data); {
+		if signatureFormat(data[off:]) != "" {
+			start = off
+		}
+
+		n := bytes.IndexByte(data[off:], '\n')
+		if n == -1 {
+			break
+		}
+		off += n + 1
+	}
+
+	if start == -1 {
+		return data, nil
+	}
+	return data[:start], data[start:]
+}
+
+//VerifySignature verifies the signature of the commit or tag with
+//the id against keys. The Status is SignatureNone for objects that
+//are not signed.
+func (repo *Repository) VerifySignature(id ObjectID, keys *SigningKeys) (Verification, error) {
+	otype, _, err := repo.statObject(id)
+	if err != nil {
+		return Verification{}, err
+	}
+
+	data, err := repo.readObjectData(id)
+	if err != nil {
+		return Verification{}, err
+	}
+
+	var payload, sig []byte
+	var email string
+	obj := gitObject{otype, int64(len(data)), ioutil.NopCloser(bytes.NewReader(data))}
+	switch otype {
+	case ObjCommit:
+		commit, err := parseCommit(obj)
+		if err != nil {
+			return Verification{}, err
+		}
+		email = commit.Committer.Email
+		payload, sig = splitCommitSignature(data, id.Format())
+	case ObjTag:
+		tag, err := parseTag(obj)
+		if err != nil {
+			return Verification{}, err
+		}
+		email = tag.Tagger.Email
+		payload, sig = splitTagSignature(data)
+	default:
+		return Verification{}, fmt.Errorf("git: %s is a %s, not a commit or tag", id, otype)
+	}
+
+	if sig == nil {
+		return Verification{Status: SignatureNone}, nil
+	}
+
+	if keys == nil {
+		keys = &SigningKeys{}
+	}
+
+	switch format := signatureFormat(sig); format {
+	case "openpgp":
+		return verifyOpenPGP(payload, sig, email, keys), nil
+	case "ssh":
+		return verifySSH(payload, sig, email, keys), nil
+	default:
+		return Verification{Status: SignatureUnverified, Format: format}, nil
+	}
+}
+
+//verifyOpenPGP verifies an armored, detached OpenPGP signature. The
+//signature is only verified if the key has an identity for email,
+//otherwise anyone could vouch for commits of others with a key
+//of their own.
+func verifyOpenPGP(payload, sig []byte, email string, keys *SigningKeys) Verification {
+	v := Verification{Status: SignatureUnverified, Format: "openpgp"}
+
+	block, err := armor.Decode(bytes.NewReader(sig))
+	if err != nil {
+		return v
+	}
+
+	p, err := packet.Read(block.Body)
+	if err != nil {
+		return v
+	}
+
+	switch p := p.(type) {
+	case *packet.Signature:
+		if p.IssuerKeyId != nil {
+			v.Key = fmt.Sprintf("%016X", *p.IssuerKeyId)
+		}
+	case *packet.SignatureV3:
+		v.Key = fmt.Sprintf("%016X", p.IssuerKeyId)
+	}
+
+	signer, err := openpgp.CheckArmoredDetachedSignature(keys.OpenPGP, bytes.NewReader(payload), bytes.NewReader(sig))
+	if err == pgperrors.ErrUnknownIssuer {
+		v.Status = SignatureUnknownKey
+		return v
+	} else if err != nil || !hasIdentity(signer, email) {
+		return v
+	}
+
+	v.Status = SignatureVerified
+	v.Signer = keys.OpenPGPOwners[fmt.Sprintf("%X", signer.PrimaryKey.Fingerprint)]
+	return v
+}
+
+//hasIdentity returns true if one of the identities of the key has
+//the email address.
+func hasIdentity(key *openpgp.Entity, email string) bool {
+	for _, ident := range key.Identities {
+		if ident.UserId != nil && strings.EqualFold(ident.UserId.Email, email) {
+			return true
+		}
+	}
+	return false
+}
+
+//sshSignature is an SSH signature, without the "SSHSIG" magic,
+//see PROTOCOL.sshsig of OpenSSH.
+type sshSignature struct {
+	Version       uint32
+	PublicKey     []byte
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Signature     []byte
+}
+
+//sshSignedData is the data an SSH signature signs, after the magic.
+type sshSignedData struct {
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Hash          []byte
+}
+
+const sshSigMagic = "SSHSIG"
+
+//decodeSSHArmor returns the data of an armored SSH signature.
+func decodeSSHArmor(sig []byte) ([]byte, error) {
+	var b64 strings.Builder
+	for _, line := range strings.Split(string(sig), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "-----") {
+			continue
+		}
+		b64.WriteString(line)
+	}
+
+	return base64.StdEncoding.DecodeString(b64.String())
+}
+
+//verifySSH verifies an armored SSH signature with the namespace
+//"git", like ssh-keygen -Y verify does. Like for OpenPGP, the owner
+//of the key must have the email.
+func verifySSH(payload, armored []byte, email string, keys *SigningKeys) Verification {
+	v := Verification{Status: SignatureUnverified, Format: "ssh"}
+
+	data, err := decodeSSHArmor(armored)
+	if err != nil || !bytes.HasPrefix(data, []byte(sshSigMagic)) {
+		return v
+	}
+
+	var sig sshSignature
+	if err = ssh.Unmarshal(data[len(sshSigMagic):], &sig); err != nil || sig.Version != 1 {
+		return v
+	}
+
+	pub, err := ssh.ParsePublicKey(sig.PublicKey)
+	if err != nil {
+		return v
+	}
+	v.Key = ssh.FingerprintSHA256(pub)
+
+	var h hash.Hash
+	switch sig.HashAlgorithm {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return v
+	}
+
+	var signature ssh.Signature
+	if err = ssh.Unmarshal(sig.Signature, &signature); err != nil || sig.Namespace != "git" {
+		return v
+	}
+
+	h.Write(payload)
+	signed := append([]byte(sshSigMagic), ssh.Marshal(sshSignedData{
+		Namespace:     sig.Namespace,
+		Reserved:      sig.Reserved,
+		HashAlgorithm: sig.HashAlgorithm,
+		Hash:          h.Sum(nil),
+	})...)
+
+	if err = pub.Verify(signed, &signature); err != nil {
+		return v
+	}
+
+	var owner KeyOwner
+	ok := false
+	if keys.SSHKeyOwner != nil {
+		owner, ok = keys.SSHKeyOwner(pub)
+	}
+
+	if !ok {
+		v.Status = SignatureUnknownKey
+		return v
+	} else if owner.Email == "" || !strings.EqualFold(owner.Email, email) {
+		return v
+	}
+
+	v.Status = SignatureVerified
+	v.Signer = owner.User
+	return v
+}
@@ -0,0 +1,233 @@
+package git
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/ssh"
+)
+
+//mkGPGHome creates a temporary GNUPGHOME with a signing key for
+//each of the user ids and returns it with the armored public keys.
+func mkGPGHome(t *testing.T, uids ...string) (string, []byte) {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("[W] Could not find gpg binary. Skipping test")
+	}
+
+	home, err := ioutil.TempDir("", "gin-repo-gpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gpg := func(args ...string) []byte {
+		cmd := exec.Command("gpg", append([]string{"--batch", "--homedir", home}, args...)...)
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("gpg %s failed: %v", strings.Join(args, " "), err)
+		}
+		return out
+	}
+
+	for _, uid := range uids {
+		gpg("--passphrase", "", "--pinentry-mode", "loopback", "--quick-gen-key", uid, "rsa2048", "sign", "never")
+	}
+	return home, gpg("--armor", "--export")
+}
+
+func killGPGAgent(home string) {
+	cmd := exec.Command("gpgconf", "--homedir", home, "--kill", "gpg-agent")
+	cmd.Run()
+	os.RemoveAll(home)
+}
+
+func TestVerifyOpenPGP(t *testing.T) {
+	home, pubkey := mkGPGHome(t, "C O Mitter <committer@example.com>", "Mallory <mallory@example.com>")
+	defer killGPGAgent(home)
+
+	tr := mkTestRepo(t)
+	defer tr.cleanup()
+
+	os.Setenv("GNUPGHOME", home)
+	defer os.Unsetenv("GNUPGHOME")
+
+	tr.write("README", "signed\n")
+	tr.git("add", "README")
+	tr.git("-c", "user.signingkey=committer@example.com", "commit", "-q", "-S", "-m", "signed")
+	signed := tr.revParse("HEAD")
+
+	tr.git("-c", "user.signingkey=committer@example.com", "tag", "-s", "-m", "release\n\nwith a body", "v1.0")
+	tag := tr.revParse("v1.0")
+
+	tr.write("README", "unsigned\n")
+	unsigned := tr.commit("unsigned")
+
+	//a commit of the committer, signed with the key of another
+	tr.write("README", "impersonated\n")
+	tr.git("add", "README")
+	tr.git("-c", "user.signingkey=mallory@example.com", "commit", "-q", "-S", "-m", "impersonated")
+	impersonated := tr.revParse("HEAD")
+
+	keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(pubkey))
+	if err != nil {
+		t.Fatalf("could not read public key: %v", err)
+	}
+
+	fingerprint := strings.ToUpper(tr.git("log", "-1", "--format=%GF", signed.String()))
+	mallory := strings.ToUpper(tr.git("log", "-1", "--format=%GF", impersonated.String()))
+	keys := &SigningKeys{OpenPGP: keyring, OpenPGPOwners: map[string]string{fingerprint: "committer", mallory: "mallory"}}
+
+	for _, id := range []ObjectID{signed, tag} {
+		v, err := tr.VerifySignature(id, keys)
+		if err != nil {
+			t.Fatalf("VerifySignature(%s): %v", id, err)
+		}
+
+		expected := Verification{SignatureVerified, "openpgp", strings.ToUpper(tr.git("log", "-1", "--format=%GK", signed.String())), "committer"}
+		if v != expected {
+			t.Errorf("VerifySignature(%s): got %+v, expected %+v", id, v, expected)
+		}
+
+		v, err = tr.VerifySignature(id, nil)
+		if err != nil {
+			t.Fatalf("VerifySignature(%s): %v", id, err)
+		} else if v.Status != SignatureUnknownKey {
+			t.Errorf("VerifySignature(%s) without keys: got %v, expected unknown key", id, v.Status)
+		}
+	}
+
+	if v, err := tr.VerifySignature(unsigned, keys); err != nil {
+		t.Fatalf("VerifySignature(unsigned): %v", err)
+	} else if v.Status != SignatureNone {
+		t.Errorf("VerifySignature(unsigned): got %v", v.Status)
+	}
+
+	v, err := tr.VerifySignature(impersonated, keys)
+	if err != nil {
+		t.Fatalf("VerifySignature(impersonated): %v", err)
+	}
+
+	expected := Verification{SignatureUnverified, "openpgp", strings.ToUpper(tr.git("log", "-1", "--format=%GK", impersonated.String())), ""}
+	if v != expected {
+		t.Errorf("VerifySignature(impersonated): got %+v, expected %+v", v, expected)
+	}
+
+	//a forged commit with the signature of another
+	data, err := tr.readObjectData(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = bytes.Replace(data, []byte("\nsigned\n"), []byte("\nforged\n"), 1)
+
+	forged, err := tr.WriteObject(ObjCommit, int64(len(data)), bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("could not write forged commit: %v", err)
+	}
+
+	if v, err := tr.VerifySignature(forged, keys); err != nil {
+		t.Fatalf("VerifySignature(forged): %v", err)
+	} else if v.Status != SignatureUnverified {
+		t.Errorf("VerifySignature(forged): got %v, expected unverified", v.Status)
+	}
+
+	if _, err := tr.VerifySignature(tr.revParse("HEAD^{tree}"), keys); err == nil {
+		t.Errorf("VerifySignature(tree): expected error")
+	}
+}
+
+func TestVerifySSH(t *testing.T) {
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("[W] Could not find ssh-keygen binary. Skipping test")
+	}
+
+	tr := mkTestRepo(t)
+	defer tr.cleanup()
+
+	keyfile := filepath.Join(tr.work, "..", filepath.Base(tr.work)+".key")
+	defer os.Remove(keyfile)
+	defer os.Remove(keyfile + ".pub")
+
+	out, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-C", "alice", "-f", keyfile).CombinedOutput()
+	if err != nil {
+		t.Fatalf("ssh-keygen failed: %v\n%s", err, out)
+	}
+
+	data, err := ioutil.ReadFile(keyfile + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr.write("README", "signed\n")
+	tr.git("add", "README")
+	tr.git("-c", "gpg.format=ssh", "-c", "user.signingkey="+keyfile, "commit", "-q", "-S", "-m", "signed")
+	signed := tr.revParse("HEAD")
+
+	tr.git("-c", "gpg.format=ssh", "-c", "user.signingkey="+keyfile, "tag", "-s", "-m", "release", "v1.0")
+	tag := tr.revParse("v1.0")
+
+	owners := map[string]KeyOwner{ssh.FingerprintSHA256(pub): {"alice", "committer@example.com"}}
+	keys := &SigningKeys{
+		SSHKeyOwner: func(key ssh.PublicKey) (KeyOwner, bool) {
+			owner, ok := owners[ssh.FingerprintSHA256(key)]
+			return owner, ok
+		},
+	}
+
+	for _, id := range []ObjectID{signed, tag} {
+		v, err := tr.VerifySignature(id, keys)
+		if err != nil {
+			t.Fatalf("VerifySignature(%s): %v", id, err)
+		}
+
+		expected := Verification{SignatureVerified, "ssh", ssh.FingerprintSHA256(pub), "alice"}
+		if v != expected {
+			t.Errorf("VerifySignature(%s): got %+v, expected %+v", id, v, expected)
+		}
+
+		if v, _ = tr.VerifySignature(id, &SigningKeys{}); v.Status != SignatureUnknownKey {
+			t.Errorf("VerifySignature(%s) without keys: got %v, expected unknown key", id, v.Status)
+		}
+	}
+
+	//the key of a user with another email
+	owners[ssh.FingerprintSHA256(pub)] = KeyOwner{"mallory", "mallory@example.com"}
+	for _, id := range []ObjectID{signed, tag} {
+		v, err := tr.VerifySignature(id, keys)
+		if err != nil {
+			t.Fatalf("VerifySignature(%s): %v", id, err)
+		}
+
+		expected := Verification{SignatureUnverified, "ssh", ssh.FingerprintSHA256(pub), ""}
+		if v != expected {
+			t.Errorf("VerifySignature(%s) with another email: got %+v, expected %+v", id, v, expected)
+		}
+	}
+	owners[ssh.FingerprintSHA256(pub)] = KeyOwner{"alice", "committer@example.com"}
+
+	data, err = tr.readObjectData(tag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = bytes.Replace(data, []byte("tag v1.0\n"), []byte("tag v2.0\n"), 1)
+
+	forged, err := tr.WriteObject(ObjTag, int64(len(data)), bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("could not write forged tag: %v", err)
+	}
+
+	if v, err := tr.VerifySignature(forged, keys); err != nil {
+		t.Fatalf("VerifySignature(forged): %v", err)
+	} else if v.Status != SignatureUnverified {
+		t.Errorf("VerifySignature(forged): got %v, expected unverified", v.Status)
+	}
+}
@@ -45,6 +45,20 @@ func (c *Commit) WriteTo(writer io.Writer) (int64, error) {
 		return n, err
 	}
 
+	x, err := c.writeBody(w)
+	n += x
+	if err != nil {
+		return n, err
+	}
+
+	err = w.Flush()
+	return n, err
+}
+
+//writeBody writes the commit without the object header.
+func (c *Commit) writeBody(w *bufio.Writer) (int64, error) {
+	var n int64
+
 	x, err := w.WriteString(fmt.Sprintf("tree %s\n", c.Tree))
 	n += int64(x)
 	if err != nil {
@@ -83,11 +97,6 @@ func (c *Commit) WriteTo(writer io.Writer) (int64, error) {
 
 	x, err = w.WriteString(fmt.Sprintf("\n%s", c.Message))
 	n += int64(x)
-	if err != nil {
-		return n, err
-	}
-
-	err = w.Flush()
 	return n, err
 }
 
@@ -103,7 +112,7 @@ func (t *Tree) WriteTo(writer io.Writer) (int64, error) {
 	}
 
 	for t.Next() {
-		//format is: [mode{ASCII, octal}][space][name][\0][SHA1]
+		//format is: [mode{ASCII, octal}][space][name][\0][id]
 		entry := t.Entry()
 		line := fmt.Sprintf("%o %s", entry.Mode, entry.Name)
 		x, err := w.WriteString(line)
@@ -118,7 +127,7 @@ func (t *Tree) WriteTo(writer io.Writer) (int64, error) {
 		}
 		n++
 
-		x, err = w.Write(entry.ID[:])
+		x, err = w.Write(entry.ID.Bytes())
 		n += int64(x)
 		if err != nil {
 			return n, err
@@ -163,6 +172,20 @@ func (t *Tag) WriteTo(writer io.Writer) (int64, error) {
 		return n, err
 	}
 
+	x, err := t.writeBody(w)
+	n += x
+	if err != nil {
+		return n, err
+	}
+
+	err = w.Flush()
+	return n, err
+}
+
+//writeBody writes the tag without the object header.
+func (t *Tag) writeBody(w *bufio.Writer) (int64, error) {
+	var n int64
+
 	x, err := w.WriteString(fmt.Sprintf("object %s\n", t.Object))
 	n += int64(x)
 	if err != nil {
@@ -195,12 +218,7 @@ func (t *Tag) WriteTo(writer io.Writer) (int64, error) {
 	if t.GPGSig != "" {
 		x, err = w.WriteString(fmt.Sprintf("%s\n", t.GPGSig))
 		n += int64(x)
-		if err != nil {
-			return n, err
-		}
-
 	}
 
-	err = w.Flush()
 	return n, err
 }
@@ -13,8 +13,8 @@ import (
 
 func TestWriteCommit(t *testing.T) {
 
-	tree, _ := ParseSHA1("55fc4f1f438ee7f1299afa564e124834f7f7641f")
-	parent, _ := ParseSHA1("07f2bbad7e34a1efcde59ebe230b0942cf7957b6")
+	tree, _ := ParseObjectID("55fc4f1f438ee7f1299afa564e124834f7f7641f")
+	parent, _ := ParseObjectID("07f2bbad7e34a1efcde59ebe230b0942cf7957b6")
 	author := Signature{
 		Name:   "Christian Kellner",
 		Email:  "christian@kellner.me",
@@ -32,7 +32,7 @@ func TestWriteCommit(t *testing.T) {
 	c := Commit{
 		gitObject: gitObject{otype: ObjCommit, size: 273},
 		Tree:      tree,
-		Parent:    []SHA1{parent},
+		Parent:    []ObjectID{parent},
 		Author:    author,
 		Committer: committer,
 		Message:   "[git] annex: Astat() fix non-error condition\n",
@@ -137,7 +137,7 @@ func TestWriteBlob(t *testing.T) {
 }
 
 func TestWriteTag(t *testing.T) {
-	tobj, _ := ParseSHA1("cd119b179d4be4629d8a2e605a8386a7b6fc2afa")
+	tobj, _ := ParseObjectID("cd119b179d4be4629d8a2e605a8386a7b6fc2afa")
 	tagger := Signature{
 		Name:   "gin repo",
 		Email:  "gin-repo@g-node.org",
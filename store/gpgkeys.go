@@ -0,0 +1,190 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+var fingerprintChecker = regexp.MustCompile("^[0-9A-F]{40}$")
+
+// GPGKeyStore is the server-side keyring of the OpenPGP public keys
+// users uploaded to have their signatures verified. Each key is kept
+// armored in gpgkeys/<uid>/<fingerprint>.asc below the base path.
+type GPGKeyStore struct {
+	Path string
+}
+
+// NewGPGKeyStore returns the keyring in basePath, creating it if needed.
+func NewGPGKeyStore(basePath string) (*GPGKeyStore, error) {
+	store := GPGKeyStore{Path: filepath.Join(basePath, "gpgkeys")}
+
+	err := os.MkdirAll(store.Path, 0777)
+	if err != nil {
+		return nil, err
+	}
+
+	return &store, nil
+}
+
+// KeyFingerprint returns the fingerprint of the primary key of an
+// entity as uppercase hex, which identifies keys in the store.
+func KeyFingerprint(e *openpgp.Entity) string {
+	return fmt.Sprintf("%X", e.PrimaryKey.Fingerprint)
+}
+
+func (store *GPGKeyStore) userPath(uid string) (string, error) {
+	if uid == "" || uid != filepath.Base(uid) || strings.HasPrefix(uid, ".") {
+		return "", fmt.Errorf("invalid user id: %q", uid)
+	}
+	return filepath.Join(store.Path, uid), nil
+}
+
+// KeyChallenge returns the text the key of a user must sign, as
+// detached signature, to be added by AddKey. Only who holds the
+// private key can make that signature, and it binds the key to the
+// user, so nobody can claim the keys of others.
+func KeyChallenge(uid string) string {
+	return fmt.Sprintf("gin-repo: add OpenPGP key for user %s\n", uid)
+}
+
+// AddKey adds the armored public key to the keys of the user and
+// returns it. The armored signature must be a detached signature of
+// KeyChallenge(uid) made with the key. Keys that another user already
+// added are rejected.
+func (store *GPGKeyStore) AddKey(uid string, r io.Reader, signature io.Reader) (*openpgp.Entity, error) {
+	dir, err := store.userPath(uid)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := openpgp.ReadArmoredKeyRing(r)
+	if err != nil {
+		return nil, err
+	} else if len(keys) != 1 {
+		return nil, fmt.Errorf("expected a single key, got %d", len(keys))
+	}
+
+	key := keys[0]
+	fingerprint := KeyFingerprint(key)
+	if key.PrivateKey != nil {
+		return nil, fmt.Errorf("key %s is a private key", fingerprint)
+	}
+
+	challenge := strings.NewReader(KeyChallenge(uid))
+	if _, err = openpgp.CheckArmoredDetachedSignature(keys, challenge, signature); err != nil {
+		return nil, fmt.Errorf("key %s: invalid signature of the challenge: %v", fingerprint, err)
+	}
+
+	_, owners, err := store.Keyring()
+	if err != nil {
+		return nil, err
+	} else if owner, ok := owners[fingerprint]; ok && owner != uid {
+		return nil, fmt.Errorf("key %s belongs to another user", fingerprint)
+	}
+
+	err = os.MkdirAll(dir, 0777)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = key.Serialize(w); err != nil {
+		return nil, err
+	} else if err = w.Close(); err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(dir, fingerprint+".asc")
+	if err = ioutil.WriteFile(path, buf.Bytes(), 0666); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// ListKeys returns the keys of the user.
+func (store *GPGKeyStore) ListKeys(uid string) (openpgp.EntityList, error) {
+	dir, err := store.userPath(uid)
+	if err != nil {
+		return nil, err
+	}
+
+	names, _ := filepath.Glob(filepath.Join(dir, "*.asc"))
+
+	var keys openpgp.EntityList
+	for _, name := range names {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+
+		el, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[W] Skipping %s, parse error: %v\n", name, err)
+			continue
+		}
+
+		keys = append(keys, el...)
+	}
+
+	return keys, nil
+}
+
+// DeleteKey removes the key with the fingerprint from the keys of
+// the user.
+func (store *GPGKeyStore) DeleteKey(uid, fingerprint string) error {
+	dir, err := store.userPath(uid)
+	if err != nil {
+		return err
+	}
+
+	fingerprint = strings.ToUpper(fingerprint)
+	if !fingerprintChecker.MatchString(fingerprint) {
+		return fmt.Errorf("invalid fingerprint: %q", fingerprint)
+	}
+
+	return os.Remove(filepath.Join(dir, fingerprint+".asc"))
+}
+
+// Keyring returns the keys of all users, and their owners by
+// fingerprint.
+func (store *GPGKeyStore) Keyring() (openpgp.EntityList, map[string]string, error) {
+	dirs, err := ioutil.ReadDir(store.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var keyring openpgp.EntityList
+	owners := make(map[string]string)
+	for _, fi := range dirs {
+		if !fi.IsDir() {
+			continue
+		}
+
+		keys, err := store.ListKeys(fi.Name())
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, key := range keys {
+			owners[KeyFingerprint(key)] = fi.Name()
+		}
+		keyring = append(keyring, keys...)
+	}
+
+	return keyring, owners, nil
+}
@@ -0,0 +1,115 @@
+package store
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+func armoredKey(t *testing.T, e *openpgp.Entity, private bool) *bytes.Buffer {
+	var buf bytes.Buffer
+	btype := openpgp.PublicKeyType
+	if private {
+		btype = openpgp.PrivateKeyType
+	}
+
+	w, err := armor.Encode(&buf, btype, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if private {
+		err = e.SerializePrivate(w, nil)
+	} else {
+		err = e.Serialize(w)
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	return &buf
+}
+
+func challengeSignature(t *testing.T, e *openpgp.Entity, uid string) *bytes.Buffer {
+	var buf bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&buf, e, strings.NewReader(KeyChallenge(uid)), nil); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestGPGKeyStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gin-repo-gpgkeys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keys, err := NewGPGKeyStore(dir)
+	if err != nil {
+		t.Fatalf("NewGPGKeyStore: %v", err)
+	}
+
+	alice, err := openpgp.NewEntity("Alice", "", "alice@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bob, err := openpgp.NewEntity("Bob", "", "bob@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = keys.AddKey("alice", armoredKey(t, alice, true), challengeSignature(t, alice, "alice")); err == nil {
+		t.Fatalf("AddKey: expected error for private key")
+	}
+
+	added, err := keys.AddKey("alice", armoredKey(t, alice, false), challengeSignature(t, alice, "alice"))
+	if err != nil {
+		t.Fatalf("AddKey: %v", err)
+	} else if KeyFingerprint(added) != KeyFingerprint(alice) {
+		t.Fatalf("AddKey: unexpected key %v", added)
+	}
+
+	//without the private key of alice, bob can not claim her key
+	for _, signer := range []*openpgp.Entity{alice, bob} {
+		sig := challengeSignature(t, signer, "alice")
+		if signer == bob {
+			sig = challengeSignature(t, signer, "bob")
+		}
+
+		if _, err = keys.AddKey("bob", armoredKey(t, alice, false), sig); err == nil {
+			t.Errorf("AddKey: expected error for key of alice signed by %s", signer.PrimaryKey.KeyIdString())
+		}
+	}
+
+	if _, err = keys.AddKey("bob", armoredKey(t, alice, false), challengeSignature(t, alice, "bob")); err == nil {
+		t.Errorf("AddKey: expected error for key of another user")
+	}
+
+	if _, err = keys.AddKey("../alice", armoredKey(t, alice, false), challengeSignature(t, alice, "../alice")); err == nil {
+		t.Errorf("AddKey: expected error for invalid user")
+	}
+
+	keyring, owners, err := keys.Keyring()
+	if err != nil {
+		t.Fatalf("Keyring: %v", err)
+	} else if len(keyring) != 1 || owners[KeyFingerprint(alice)] != "alice" {
+		t.Errorf("Keyring: got %v, %v", keyring, owners)
+	}
+
+	if err = keys.DeleteKey("alice", KeyFingerprint(alice)); err != nil {
+		t.Fatalf("DeleteKey: %v", err)
+	}
+
+	if list, err := keys.ListKeys("alice"); err != nil || len(list) != 0 {
+		t.Errorf("ListKeys after delete: got %v, %v", list, err)
+	}
+}
@@ -2,6 +2,7 @@ package store
 
 import (
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -35,7 +36,13 @@ func (store *LocalUserStore) loadUser(uid string) (User, error) {
 		i++
 	}
 
-	user := User{Uid: uid, Keys: keys}
+	// the email address is optional
+	email, err := ioutil.ReadFile(filepath.Join(base, "email"))
+	if err != nil && !os.IsNotExist(err) {
+		return User{}, err
+	}
+
+	user := User{Uid: uid, Keys: keys, Email: strings.TrimSpace(string(email))}
 	return user, nil
 }
 
@@ -5,11 +5,14 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/G-Node/gin-repo/git"
 )
@@ -57,6 +60,42 @@ func RepoIdFromPath(path string) (RepoId, error) {
 	return RepoId{uid, name}, nil
 }
 
+// RepoIdFromURL returns the RepoId and host of a remote URL, e.g. of
+// a submodule, which can be an URL ("ssh://host/owner/name.git"),
+// scp-like ("git@host:owner/name.git") or, with an empty host,
+// relative to the repository base ("../name.git").
+func RepoIdFromURL(rawurl string, base RepoId) (RepoId, string, error) {
+	if strings.HasPrefix(rawurl, "./") || strings.HasPrefix(rawurl, "../") {
+		id, err := RepoIdParse(path.Join("/", base.Owner, base.Name, rawurl))
+		return id, "", err
+	}
+
+	if strings.Contains(rawurl, "://") {
+		u, err := url.Parse(rawurl)
+		if err != nil {
+			return RepoId{}, "", err
+		} else if u.Scheme == "file" || u.Host == "" {
+			return RepoId{}, "", fmt.Errorf("Not a remote URL: %q", rawurl)
+		}
+
+		id, err := RepoIdParse(u.Path)
+		return id, u.Hostname(), err
+	}
+
+	colon := strings.IndexByte(rawurl, ':')
+	if colon < 1 || strings.Contains(rawurl[:colon], "/") {
+		return RepoId{}, "", fmt.Errorf("Not a remote URL: %q", rawurl)
+	}
+
+	host := rawurl[:colon]
+	if at := strings.LastIndexByte(host, '@'); at != -1 {
+		host = host[at+1:]
+	}
+
+	id, err := RepoIdParse(rawurl[colon+1:])
+	return id, host, err
+}
+
 type AccessLevel int
 
 const (
@@ -120,6 +159,29 @@ func ParseAccessLevel(str string) (AccessLevel, error) {
 
 type RepoStore struct {
 	Path string
+
+	// opened git repositories, shared between all callers
+	// of OpenGitRepo so pack files are kept open
+	mu    sync.Mutex
+	repos map[RepoId]*gitRepoEntry
+	open  map[*git.Repository]*gitRepoEntry // cached or in use
+}
+
+// Limits of the git repository cache of RepoStore. Unused
+// repositories are closed after gitRepoIdleTime, and the least
+// recently used ones once there are more than maxGitRepos.
+const (
+	maxGitRepos     = 64
+	gitRepoIdleTime = 10 * time.Minute
+)
+
+// gitRepoEntry is an opened git repository and its users.
+type gitRepoEntry struct {
+	id       RepoId
+	repo     *git.Repository
+	users    int
+	lastUsed time.Time
+	cached   bool // false once it was dropped from the cache
 }
 
 func (store *RepoStore) gitPath() string {
@@ -147,7 +209,9 @@ func (store *RepoStore) RepoExists(id RepoId) (bool, error) {
 	return true, nil
 }
 
-func (store *RepoStore) CreateRepo(id RepoId) (*git.Repository, error) {
+// CreateRepo creates the bare repository id, whose objects are
+// named with the given object format.
+func (store *RepoStore) CreateRepo(id RepoId, format git.ObjectFormat) (*git.Repository, error) {
 	path := store.IdToPath(id)
 
 	_, err := os.Stat(path)
@@ -158,7 +222,7 @@ func (store *RepoStore) CreateRepo(id RepoId) (*git.Repository, error) {
 		return nil, err
 	}
 
-	repo, err := git.InitBareRepository(path)
+	repo, err := git.InitBareRepository(path, format)
 	if err != nil {
 		return nil, err
 	}
@@ -294,9 +358,119 @@ func (store *RepoStore) ListPublicRepos() ([]RepoId, error) {
 	return repos, nil
 }
 
+// OpenGitRepo returns the git repository for the RepoId. Repositories
+// are cached and the same *git.Repository is returned to all callers,
+// as long as it still exists on disk. Callers must call ReleaseGitRepo
+// once they are done with the repository.
 func (store *RepoStore) OpenGitRepo(id RepoId) (*git.Repository, error) {
 	path := store.IdToPath(id)
-	return git.OpenRepository(path)
+
+	store.mu.Lock()
+	now := time.Now()
+	store.evictGitRepos(now)
+
+	if e, ok := store.repos[id]; ok {
+		if _, err := os.Stat(e.repo.Path); err == nil {
+			e.users++
+			e.lastUsed = now
+			store.mu.Unlock()
+			return e.repo, nil
+		}
+		store.forgetGitRepo(e)
+	}
+	store.mu.Unlock()
+
+	repo, err := git.OpenRepository(path)
+	if err != nil {
+		return nil, err
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	// somebody else might have been faster
+	if e, ok := store.repos[id]; ok {
+		e.users++
+		e.lastUsed = time.Now()
+		return e.repo, nil
+	}
+
+	if store.repos == nil {
+		store.repos = make(map[RepoId]*gitRepoEntry)
+		store.open = make(map[*git.Repository]*gitRepoEntry)
+	}
+
+	e := &gitRepoEntry{id: id, repo: repo, users: 1, lastUsed: time.Now(), cached: true}
+	store.repos[id] = e
+	store.open[repo] = e
+
+	return repo, nil
+}
+
+// ReleaseGitRepo marks a repository returned by OpenGitRepo as no
+// longer used by the caller. Repositories that were dropped from the
+// cache are closed when their last user releases them.
+func (store *RepoStore) ReleaseGitRepo(repo *git.Repository) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	e, ok := store.open[repo]
+	if !ok {
+		return
+	}
+
+	e.users--
+	e.lastUsed = time.Now()
+	if e.users == 0 && !e.cached {
+		store.closeGitRepo(e)
+	}
+}
+
+// evictGitRepos drops idle repositories from the cache, and the least
+// recently used ones beyond maxGitRepos. Repositories in use are never
+// evicted. It must be called with store.mu held.
+func (store *RepoStore) evictGitRepos(now time.Time) {
+	for _, e := range store.repos {
+		if e.users == 0 && now.Sub(e.lastUsed) > gitRepoIdleTime {
+			store.forgetGitRepo(e)
+		}
+	}
+
+	for len(store.repos) > maxGitRepos {
+		var lru *gitRepoEntry
+		for _, e := range store.repos {
+			if e.users == 0 && (lru == nil || e.lastUsed.Before(lru.lastUsed)) {
+				lru = e
+			}
+		}
+
+		if lru == nil {
+			return
+		}
+		store.forgetGitRepo(lru)
+	}
+}
+
+// forgetGitRepo drops the repository from the cache. It is closed
+// right away if it is not in use, otherwise by the last call of
+// ReleaseGitRepo. It must be called with store.mu held.
+func (store *RepoStore) forgetGitRepo(e *gitRepoEntry) {
+	if store.repos[e.id] == e {
+		delete(store.repos, e.id)
+	}
+	e.cached = false
+
+	if e.users == 0 {
+		store.closeGitRepo(e)
+	}
+}
+
+// closeGitRepo closes the repository, which must not be used anymore.
+func (store *RepoStore) closeGitRepo(e *gitRepoEntry) {
+	delete(store.open, e.repo)
+	if err := e.repo.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "[W] error closing %q: %v\n", e.repo.Path, err)
+	}
 }
 
 // RepoShared returns true in case a repository contains entries in the gin sharing folder
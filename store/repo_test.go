@@ -2,12 +2,15 @@ package store
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/G-Node/gin-repo/git"
 	"github.com/G-Node/gin-repo/internal/testbed"
 )
 
@@ -66,6 +69,40 @@ func TestParseRepoId(t *testing.T) {
 	}
 }
 
+var repourls = []struct {
+	in   string
+	host string
+	out  *RepoId
+}{
+	{"../bar.git", "", defaultRepo},
+	{"./../../foo/bar", "", defaultRepo},
+	{"git@gin.example.com:foo/bar.git", "gin.example.com", defaultRepo},
+	{"gin.example.com:/~/foo/bar", "gin.example.com", defaultRepo},
+	{"ssh://git@gin.example.com:2222/foo/bar.git", "gin.example.com", defaultRepo},
+	{"https://gin.example.com/foo/bar", "gin.example.com", defaultRepo},
+
+	{"./sub", "", nil},
+	{"/srv/git/foo/bar.git", "", nil},
+	{"file:///foo/bar.git", "", nil},
+	{"https://gin.example.com/foo/bar/se", "", nil},
+	{"foo/bar:baz", "", nil},
+}
+
+func TestRepoIdFromURL(t *testing.T) {
+	base := RepoId{"foo", "baz"}
+
+	for _, tt := range repourls {
+		out, host, err := RepoIdFromURL(tt.in, base)
+		if err != nil && tt.out != nil {
+			t.Errorf("RepoIdFromURL(%q) => error %v, want: %v", tt.in, err, *tt.out)
+		} else if err == nil && tt.out == nil {
+			t.Errorf("RepoIdFromURL(%q) => %v, want error", tt.in, out)
+		} else if err == nil && (out != *tt.out || host != tt.host) {
+			t.Errorf("RepoIdFromURL(%q) => %v, %q, want %v, %q", tt.in, out, host, *tt.out, tt.host)
+		}
+	}
+}
+
 // TestMain sets up a temporary user store for store method tests.
 // Currently the temporary files created by this function are not cleaned up.
 func TestMain(m *testing.M) {
@@ -204,3 +241,83 @@ func TestRepoStore_RepoShared(t *testing.T) {
 		t.Fatalf("Expected success when opening %v\n", rid)
 	}
 }
+
+func TestRepoStore_GitRepoCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gin-repo-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err = os.MkdirAll(filepath.Join(dir, "repos", "git"), 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewRepoStore(dir)
+	if err != nil {
+		t.Fatalf("could not create repo store: %v", err)
+	}
+
+	a, b := RepoId{"alice", "a"}, RepoId{"alice", "b"}
+	for _, id := range []RepoId{a, b} {
+		if _, err = store.CreateRepo(id, git.FormatSHA1); err != nil {
+			t.Fatalf("could not create %v: %v", id, err)
+		}
+	}
+
+	repo, err := store.OpenGitRepo(a)
+	if err != nil {
+		t.Fatalf("OpenGitRepo(%v) => %v", a, err)
+	}
+
+	again, err := store.OpenGitRepo(a)
+	if err != nil || again != repo {
+		t.Fatalf("OpenGitRepo(%v) => %p, %v, expected the cached repository", a, again, err)
+	}
+	store.ReleaseGitRepo(again)
+
+	// repositories in use are not evicted
+	store.mu.Lock()
+	store.repos[a].lastUsed = time.Now().Add(-2 * gitRepoIdleTime)
+	store.mu.Unlock()
+
+	other, err := store.OpenGitRepo(b)
+	if err != nil {
+		t.Fatalf("OpenGitRepo(%v) => %v", b, err)
+	}
+	store.ReleaseGitRepo(other)
+
+	if _, ok := store.repos[a]; !ok {
+		t.Fatalf("repository in use was evicted")
+	}
+
+	// idle ones are
+	store.ReleaseGitRepo(repo)
+	store.mu.Lock()
+	store.repos[a].lastUsed = time.Now().Add(-2 * gitRepoIdleTime)
+	store.mu.Unlock()
+
+	if other, err = store.OpenGitRepo(b); err != nil {
+		t.Fatalf("OpenGitRepo(%v) => %v", b, err)
+	}
+
+	if _, ok := store.repos[a]; ok {
+		t.Fatalf("idle repository was not evicted")
+	} else if _, ok := store.open[repo]; ok {
+		t.Fatalf("evicted repository was not closed")
+	}
+
+	// a forgotten repository is closed once released
+	store.mu.Lock()
+	store.forgetGitRepo(store.repos[b])
+	store.mu.Unlock()
+
+	if _, ok := store.open[other]; !ok {
+		t.Fatalf("repository in use was closed")
+	}
+
+	store.ReleaseGitRepo(other)
+	if _, ok := store.open[other]; ok {
+		t.Fatalf("released repository was not closed")
+	}
+}
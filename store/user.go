@@ -14,6 +14,11 @@ import (
 type User struct {
 	Uid  string
 	Keys []ssh.Key
+
+	// Email is the address of the user, empty if the store does
+	// not know it. SSH signatures are only verified for commits
+	// and tags of that address.
+	Email string
 }
 
 type UserStore interface {
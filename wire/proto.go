@@ -10,27 +10,86 @@ type RepoAccessInfo struct {
 	Push bool
 }
 
+// CreateRepo is the request to create a repository. ObjectFormat is
+// the hash function for the object ids, "sha1" (the default) or
+// "sha256".
 type CreateRepo struct {
-	Name        string
-	Description string
-	Public      bool
+	Name         string
+	Description  string
+	Public       bool
+	ObjectFormat string `json:",omitempty"`
 }
 
 // Repo is used to export basic information about a repository.
 // Public states whether a repository is publicly available.
 // Shared states whether a repository is shared with a collaborator.
 type Repo struct {
-	Name        string
-	Owner       string
-	Description string
-	Head        string
-	Public      bool
-	Shared      bool
+	Name         string
+	Owner        string
+	Description  string
+	Head         string
+	Public       bool
+	Shared       bool
+	ObjectFormat string
 }
 
+// Branch is a branch of a repository. Base is only set if the
+// request asked for a comparison with a base revision.
 type Branch struct {
 	Name   string
 	Commit string
+	Base   *BranchBase `json:",omitempty"`
+}
+
+// BranchBase compares a branch to the base revision Commit. Ahead is
+// the number of commits of the branch that are not in the base,
+// Behind the number of commits of the base that are not in the branch.
+type BranchBase struct {
+	Commit string
+	Ahead  int
+	Behind int
+}
+
+// Tag is a tag of a repository. Object is the id of the tagged
+// object and Commit the id of the commit it ultimately points to,
+// which differ for annotated tags. Signature is only set for signed
+// tags.
+type Tag struct {
+	Name      string
+	Object    string
+	Commit    string
+	Signature *Verification `json:",omitempty"`
+}
+
+// Verification is the result of verifying the signature of a commit or
+// tag. Status is one of "verified", "unverified" (the signature does not
+// match, cannot be checked or the key does not belong to the email of
+// the committer or tagger) and "unknown-key"; Format is "openpgp",
+// "ssh" or "x509". Signer is the user owning the key of a verified
+// signature.
+type Verification struct {
+	Status string `json:"status"`
+	Format string `json:"format"`
+	Key    string `json:"key,omitempty"`
+	Signer string `json:"signer,omitempty"`
+}
+
+// GPGKey is an OpenPGP public key a user uploaded. Created is in
+// RFC 3339 format.
+type GPGKey struct {
+	Fingerprint string   `json:"fingerprint"`
+	KeyID       string   `json:"keyid"`
+	Identities  []string `json:"identities"`
+	Created     string   `json:"created"`
+}
+
+// GPGKeyUpload adds an armored OpenPGP public key to the keys of a
+// user. Signature is an armored detached signature, made with the
+// key, of the challenge of the user, which proves that the user
+// holds the private key.
+type GPGKeyUpload struct {
+	Key       string `json:"key"`
+	Signature string `json:"signature"`
 }
 
 type GitHook struct {
@@ -54,5 +113,156 @@ type CommitSummary struct {
 	DateIso      string   `json:"dateiso"`
 	DateRelative string   `json:"daterel"`
 	Subject      string   `json:"subject"`
-	Changes      []string `json:"changes"`
+	Changes      []Change `json:"changes"`
+}
+
+// Contributor is an author of commits and their number. First and Last
+// are the dates of the first and the last commit, in RFC 3339 format.
+type Contributor struct {
+	Name    string `json:"name"`
+	Email   string `json:"email"`
+	Commits int    `json:"commits"`
+	First   string `json:"first"`
+	Last    string `json:"last"`
+}
+
+// Submodule is a repository included in a tree at a commit. Repo is
+// the "owner/name" of the gin-repo repository the URL points to, if
+// it is one on this server the user can read.
+type Submodule struct {
+	Name   string `json:"name,omitempty"`
+	Path   string `json:"path"`
+	URL    string `json:"url,omitempty"`
+	Branch string `json:"branch,omitempty"`
+	Commit string `json:"commit"`
+	Repo   string `json:"repo,omitempty"`
+}
+
+// Change is the change of a single path of a commit. Type is one of
+// "add", "delete", "modify", "mode" (only the mode changed), "type"
+// (e.g. a file was replaced by a symlink), "rename" and "copy". Modes are
+// octal strings as used by git, e.g. "100644"; old mode and id are empty
+// for added paths, new mode and id for deleted ones. Renames and copies
+// also carry the old path and the similarity of the content in percent.
+type Change struct {
+	Type       string `json:"type"`
+	Path       string `json:"path"`
+	OldPath    string `json:"oldpath,omitempty"`
+	Similarity int    `json:"similarity,omitempty"`
+	OldMode    string `json:"oldmode,omitempty"`
+	NewMode    string `json:"newmode,omitempty"`
+	OldID      string `json:"oldid,omitempty"`
+	NewID      string `json:"newid,omitempty"`
+}
+
+// FileDiff is the line diff of a single path. Binary files and annexed
+// files have no hunks; for the latter the change of the annex key is
+// given instead.
+type FileDiff struct {
+	Change
+	Binary  bool         `json:"binary,omitempty"`
+	Annex   *AnnexChange `json:"annex,omitempty"`
+	Added   int          `json:"added"`
+	Deleted int          `json:"deleted"`
+	Hunks   []Hunk       `json:"hunks,omitempty"`
+}
+
+// AnnexChange is the change of the key of an annexed file. Old or New
+// is missing for added or deleted files.
+type AnnexChange struct {
+	Old *AnnexKey `json:"old,omitempty"`
+	New *AnnexKey `json:"new,omitempty"`
+}
+
+// AnnexKey is an annex key and the size of the file it refers to,
+// if the key contains it.
+type AnnexKey struct {
+	Key  string `json:"key"`
+	Size int64  `json:"size,omitempty"`
+}
+
+// Hunk is a group of changed lines with their context, like in a
+// unified diff. Section is the text shown after the range, typically
+// the enclosing function.
+type Hunk struct {
+	OldStart int        `json:"oldstart"`
+	OldLines int        `json:"oldlines"`
+	NewStart int        `json:"newstart"`
+	NewLines int        `json:"newlines"`
+	Section  string     `json:"section,omitempty"`
+	Lines    []DiffLine `json:"lines"`
+}
+
+// DiffLine is a single line of a hunk. Op is "+", "-" or " ".
+type DiffLine struct {
+	Op        string `json:"op"`
+	Text      string `json:"text"`
+	NoNewline bool   `json:"nonewline,omitempty"`
+}
+
+// Commit holds the details of a single commit, including the changes
+// relative to its first parent. Signature is only set for signed
+// commits.
+type Commit struct {
+	Commit    string   `json:"commit"`
+	Tree      string   `json:"tree"`
+	Parents   []string `json:"parents"`
+	Author    string   `json:"author"`
+	Committer string   `json:"committer"`
+	DateIso   string   `json:"dateiso"`
+	Message   string   `json:"message"`
+	Changes   []Change `json:"changes"`
+
+	Signature *Verification `json:"signature,omitempty"`
+}
+
+// ObjectCount is a number of objects and their size in bytes, as
+// uncompressed data and on disk.
+type ObjectCount struct {
+	Count    int64 `json:"count"`
+	Size     int64 `json:"size"`
+	DiskSize int64 `json:"disksize"`
+}
+
+// BlobSize is one of the largest blobs of a repository. Paths are the
+// paths of the blob in the history, empty if it is unreachable.
+type BlobSize struct {
+	ID       string   `json:"id"`
+	Size     int64    `json:"size"`
+	DiskSize int64    `json:"disksize"`
+	Paths    []string `json:"paths"`
+}
+
+// TreeDepth is one of the deepest trees of a repository. Depth is the
+// number of directories in Path.
+type TreeDepth struct {
+	ID    string `json:"id"`
+	Depth int    `json:"depth"`
+	Path  string `json:"path"`
+}
+
+// DeltaChain is one of the objects with the longest delta chains.
+type DeltaChain struct {
+	ID    string `json:"id"`
+	Type  string `json:"type"`
+	Depth int    `json:"depth"`
+}
+
+// RepoStats describes which objects take up the space of a repository.
+// LargeFiles are the files larger than LargeFileSize that are stored in
+// git directly instead of in the annex.
+type RepoStats struct {
+	Commits    ObjectCount `json:"commits"`
+	Trees      ObjectCount `json:"trees"`
+	Blobs      ObjectCount `json:"blobs"`
+	Tags       ObjectCount `json:"tags"`
+	Total      ObjectCount `json:"total"`
+	Duplicates int64       `json:"duplicates"`
+
+	LargeFileSize int64       `json:"largefilesize"`
+	LargeFiles    ObjectCount `json:"largefiles"`
+
+	LargestBlobs       []BlobSize   `json:"largestblobs"`
+	DeepestTrees       []TreeDepth  `json:"deepesttrees"`
+	LongestDeltaChains []DeltaChain `json:"longestdeltachains"`
 }